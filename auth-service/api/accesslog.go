@@ -0,0 +1,110 @@
+package api
+
+import (
+	"encoding/json"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"time"
+)
+
+const (
+	accessLogText = "text"
+	accessLogJSON = "json"
+	accessLogOff  = "off"
+)
+
+var (
+	//accessLogFormat is how each request is logged, "text" for key=value pairs, "json" for one object per line, or "off"
+	accessLogFormat = accessLogText
+	//accessLogger writes the access log to stdout, apart from the error log on stderr
+	accessLogger = log.New(os.Stdout, "", 0)
+)
+
+//SetAccessLogOutput sends the access log to w, e.g. a file in main or a buffer in tests
+func SetAccessLogOutput(w io.Writer) {
+	accessLogger = log.New(w, "", 0)
+}
+
+//AccessLogEntry is what the access log records about a request. The path is logged without its query,
+//which can carry verification and reset tokens.
+type AccessLogEntry struct {
+	Time      time.Time `json:"time"`
+	Method    string    `json:"method"`
+	Path      string    `json:"path"`
+	Status    int       `json:"status"`
+	LatencyMS float64   `json:"latencyMs"`
+	ClientIP  string    `json:"clientIp"`
+	RequestID string    `json:"requestId"`
+}
+
+//statusRecorder remembers the status code a handler answered with
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+//WriteHeader records statusCode before passing it on
+func (recorder *statusRecorder) WriteHeader(statusCode int) {
+	if recorder.status == 0 {
+		recorder.status = statusCode
+	}
+	recorder.ResponseWriter.WriteHeader(statusCode)
+}
+
+//Write records the implicit 200 of a handler that writes a body without calling WriteHeader
+func (recorder *statusRecorder) Write(body []byte) (int, error) {
+	if recorder.status == 0 {
+		recorder.status = http.StatusOK
+	}
+	return recorder.ResponseWriter.Write(body)
+}
+
+//accessLogMiddleware logs every request once it has been answered, in accessLogFormat.
+//It runs inside requestIDMiddleware so each line carries the request ID that error logs use too.
+func accessLogMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if accessLogFormat == accessLogOff {
+			next.ServeHTTP(w, r)
+			return
+		}
+		start := time.Now()
+		recorder := &statusRecorder{ResponseWriter: w}
+		next.ServeHTTP(recorder, r)
+		if recorder.status == 0 {
+			recorder.status = http.StatusOK
+		}
+		logAccess(AccessLogEntry{
+			Time:      start.UTC(),
+			Method:    r.Method,
+			Path:      r.URL.Path,
+			Status:    recorder.status,
+			LatencyMS: float64(time.Since(start).Microseconds()) / 1000,
+			ClientIP:  clientIP(r),
+			RequestID: requestIDFromContext(r.Context()),
+		})
+	})
+}
+
+//logAccess writes entry to the access log as one line, with the same field names in both formats.
+//The path and request ID come from the client, so the text format quotes them.
+func logAccess(entry AccessLogEntry) {
+	if accessLogFormat == accessLogJSON {
+		line, err := json.Marshal(entry)
+		if err != nil {
+			log.Print("error encoding access log entry: " + err.Error())
+			return
+		}
+		accessLogger.Print(string(line))
+		return
+	}
+	accessLogger.Print("time=" + entry.Time.Format(time.RFC3339Nano) +
+		" method=" + entry.Method +
+		" path=" + strconv.Quote(entry.Path) +
+		" status=" + strconv.Itoa(entry.Status) +
+		" latencyMs=" + strconv.FormatFloat(entry.LatencyMS, 'f', 3, 64) +
+		" clientIp=" + entry.ClientIP +
+		" requestId=" + strconv.Quote(entry.RequestID))
+}
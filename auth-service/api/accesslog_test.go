@@ -0,0 +1,62 @@
+package api_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/BearCloud/fa20-project-dev/backend/auth-service/api"
+	"github.com/BearCloud/fa20-project-dev/backend/auth-service/apitest"
+)
+
+//logRequest sends one request with a known request ID and client address under format and returns the access log
+func logRequest(t *testing.T, format string, path string) string {
+	t.Helper()
+	env := apitest.NewWithConfig(t, func(cfg *api.Config) {
+		cfg.AccessLogFormat = format
+	})
+	var buf bytes.Buffer
+	api.SetAccessLogOutput(&buf)
+	defer api.SetAccessLogOutput(os.Stdout)
+
+	req := env.Request(http.MethodGet, path, nil)
+	req.RemoteAddr = "198.51.100.7:4321"
+	req.Header.Set("X-Request-ID", "access-log-test")
+	env.Send(req)
+	return buf.String()
+}
+
+func TestAccessLogJSON(t *testing.T) {
+	output := logRequest(t, "json", "/nowhere?token=r_secret")
+	if strings.Count(output, "\n") != 1 {
+		t.Fatalf("access log for one request: got %q, want one line", output)
+	}
+	var entry api.AccessLogEntry
+	err := json.Unmarshal([]byte(output), &entry)
+	if err != nil {
+		t.Fatalf("access log line %q isn't JSON: %v", output, err)
+	}
+	if entry.Method != http.MethodGet || entry.Path != "/nowhere" || entry.Status != http.StatusNotFound ||
+		entry.ClientIP != "198.51.100.7" || entry.RequestID != "access-log-test" || entry.LatencyMS < 0 || entry.Time.IsZero() {
+		t.Fatalf("access log entry: got %+v", entry)
+	}
+	if strings.Contains(output, "r_secret") {
+		t.Fatalf("access log %q contains the query", output)
+	}
+}
+
+func TestAccessLogText(t *testing.T) {
+	output := logRequest(t, "text", "/api/auth/policy")
+	for _, field := range []string{"time=", " method=GET ", ` path="/api/auth/policy" `, " status=200 ", " latencyMs=", " clientIp=198.51.100.7 ", ` requestId="access-log-test"`} {
+		if !strings.Contains(output, field) {
+			t.Errorf("access log %q is missing %q", output, field)
+		}
+	}
+
+	if output := logRequest(t, "off", "/api/auth/policy"); output != "" {
+		t.Fatalf("access log with ACCESS_LOG_FORMAT=off: got %q, want nothing", output)
+	}
+}
@@ -0,0 +1,238 @@
+package api
+
+import (
+	"database/sql"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+)
+
+const (
+	//auditRetentionAnonymize keeps a purged user's audit entries but replaces their userId
+	auditRetentionAnonymize = "anonymize"
+	//auditRetentionDelete removes every audit entry a purged user performed or was the target of
+	auditRetentionDelete = "delete"
+	//auditRetentionKeep leaves a purged user's audit entries untouched
+	auditRetentionKeep = "keep"
+
+	//anonymizedUserID replaces the userId of a purged account wherever a record of it is kept
+	anonymizedUserID = "deleted-user"
+)
+
+var (
+	//accountDeletionGrace is how long a deleted account can still be reactivated before it is purged
+	accountDeletionGrace = 30 * 1440 * time.Minute
+	//auditRetention controls what happens to a user's audit entries when their account is purged
+	auditRetention = auditRetentionAnonymize
+)
+
+//deletionExpired reports whether an account soft-deleted at deletedAt is past the grace window
+func deletionExpired(deletedAt sql.NullTime) bool {
+	return deletedAt.Valid && clock.Now().After(deletedAt.Time.Add(accountDeletionGrace))
+}
+
+//purgedAddresses matches the primary and secondary addresses of the account being purged that no other account has
+const purgedAddresses = "(email IN (SELECT email FROM users WHERE userId = ?) OR email IN (SELECT email FROM emails WHERE userId = ?))" +
+	" AND email NOT IN (SELECT email FROM users WHERE userId <> ? AND email IS NOT NULL) AND email NOT IN (SELECT email FROM emails WHERE userId <> ?)"
+
+//purgeAccount permanently removes the account with userID along with every other row holding its personal data.
+//Audit entries are handled according to auditRetention.
+func purgeAccount(userID string) error {
+	tx, err := DB.Begin()
+	if err != nil {
+		return err
+	}
+
+	statements := []string{
+		//failed signins and delivery reports are kept per address, for every address the account had unless
+		//another account still has it
+		"DELETE FROM login_attempts WHERE " + purgedAddresses + ";",
+		"DELETE FROM email_deliveries WHERE " + purgedAddresses + ";",
+		"DELETE FROM reset_tokens WHERE userId = ?;",
+		"DELETE FROM sessions WHERE userId = ?;",
+		"DELETE FROM backup_codes WHERE userId = ?;",
+		"DELETE FROM emails WHERE userId = ?;",
+		"DELETE FROM verified_emails WHERE userId = ?;",
+		//invites stay so admins can still account for them, but lose the invitee's email and id
+		"UPDATE invites SET email = NULL, usedBy = '" + anonymizedUserID + "' WHERE usedBy = ?;",
+		"UPDATE invites SET createdBy = '" + anonymizedUserID + "' WHERE createdBy = ?;",
+	}
+	switch auditRetention {
+	case auditRetentionAnonymize:
+		statements = append(statements,
+			"UPDATE audit_log SET actorId = '"+anonymizedUserID+"' WHERE actorId = ?;",
+			"UPDATE audit_log SET targetId = '"+anonymizedUserID+"' WHERE targetId = ?;")
+	case auditRetentionDelete:
+		statements = append(statements,
+			"DELETE FROM audit_log WHERE actorId = ?;",
+			"DELETE FROM audit_log WHERE targetId = ?;")
+	}
+	statements = append(statements, "DELETE FROM users WHERE userId = ?;")
+
+	for _, statement := range statements {
+		args := make([]interface{}, strings.Count(statement, "?"))
+		for i := range args {
+			args[i] = userID
+		}
+		_, err = tx.Exec(statement, args...)
+		if err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+	_, err = tx.Exec("DELETE FROM login_attempts WHERE email = ?;", reauthLockoutKey(userID))
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
+
+//PurgeDeletedAccounts permanently removes every account deleted longer ago than the grace window
+func PurgeDeletedAccounts() (int64, error) {
+	rows, err := DB.Query("SELECT userId FROM users WHERE deletedAt IS NOT NULL AND deletedAt < ?;", clock.Now().Add(-accountDeletionGrace))
+	if err != nil {
+		return 0, err
+	}
+	var userIDs []string
+	for rows.Next() {
+		var userID string
+		err = rows.Scan(&userID)
+		if err != nil {
+			rows.Close()
+			return 0, err
+		}
+		userIDs = append(userIDs, userID)
+	}
+	rows.Close()
+	if err = rows.Err(); err != nil {
+		return 0, err
+	}
+
+	var purged int64
+	for _, userID := range userIDs {
+		err = purgeAccount(userID)
+		if err != nil {
+			return purged, err
+		}
+		purged++
+	}
+	return purged, nil
+}
+
+func deleteAccount(w http.ResponseWriter, r *http.Request) {
+
+	if (*r).Method == "OPTIONS" {
+		return
+	}
+
+	claims, _ := claimsFromContext(r.Context())
+
+	//Mark the account deleted, it is only purged once the grace window passes. Bumping the token version with it
+	//makes every access token already handed out fail RequireAuth, even after the account is reactivated.
+	_, err := DB.Exec("UPDATE users SET deletedAt = ?, tokenVersion = tokenVersion + 1 WHERE userId = ? AND deletedAt IS NULL;", clock.Now(), claims.UserID)
+	if err != nil {
+		internalError(w, r, "error deleting account", err)
+		return
+	}
+
+	//Nothing should be able to sign in as the account while it waits to be purged
+	_, err = DB.Exec("DELETE FROM sessions WHERE userId = ?;", claims.UserID)
+	if err == nil {
+		_, err = DB.Exec("DELETE FROM reset_tokens WHERE userId = ?;", claims.UserID)
+	}
+	if err != nil {
+		log.Print(err.Error())
+	}
+
+	//Sign the user out everywhere this browser is concerned
+	clearTokenCookies(w)
+
+	writeJSONSuccess(w, http.StatusOK, "account deleted, sign in and reactivate within the grace period to restore it")
+}
+
+//reactivate restores an account deleted within the grace window. It answers unknown emails and wrong passwords
+//with the same 401 as signin, and shares signin's lockout and backoff, so it can't be used to find accounts or
+//guess passwords around them.
+func reactivate(w http.ResponseWriter, r *http.Request) {
+
+	if (*r).Method == "OPTIONS" {
+		return
+	}
+
+	credentials := Credentials{}
+	err := decodeJSON(r.Body, &credentials)
+	if err != nil {
+		writeJSONError(w, r, http.StatusBadRequest, decodeErrorMessage(err, "issue retrieving credentials"))
+		log.Print(err.Error())
+		return
+	}
+	credentials.normalize()
+
+	if !limitBypassed(r) {
+		lockedUntil, err := lockedOut(credentials.Email)
+		if err != nil {
+			internalError(w, r, "error checking sign in lockout", err)
+			return
+		}
+		if !lockedUntil.IsZero() {
+			signinLockedOut(w, r, lockedUntil)
+			return
+		}
+	}
+
+	var user User
+	err = withRetry(func() (err error) {
+		user, err = userStore.GetBySigninEmail(credentials.Email)
+		return err
+	})
+	if err != nil {
+		if err == ErrNotFound {
+			compareDummyPassword(credentials.Password)
+			signinFailed(w, r, credentials.Email)
+		} else {
+			internalError(w, r, "error retrieving information with this email", err)
+		}
+		return
+	}
+
+	err = comparePassword(string(user.HashedPassword), credentials.Password)
+	if err != nil {
+		signinFailed(w, r, credentials.Email)
+		return
+	}
+	_, ok := checkSecondFactor(w, r, user.UserID, credentials)
+	if !ok {
+		return
+	}
+
+	//Too late to restore, finish the deletion instead
+	if deletionExpired(user.DeletedAt) {
+		err = purgeAccount(user.UserID)
+		if err != nil {
+			log.Print(err.Error())
+		}
+		signinFailed(w, r, credentials.Email)
+		return
+	}
+
+	err = clearLoginFailures(credentials.Email)
+	if err != nil {
+		log.Print(err.Error())
+	}
+	signinBackoff.clear(signinBackoffKey(clientIP(r), credentials.Email))
+
+	if !user.DeletedAt.Valid {
+		writeJSONError(w, r, http.StatusConflict, "this account is not scheduled for deletion")
+		return
+	}
+
+	_, err = DB.Exec("UPDATE users SET deletedAt = NULL WHERE userId = ?;", user.UserID)
+	if err != nil {
+		internalError(w, r, "error reactivating account", err)
+		return
+	}
+
+	writeJSONSuccess(w, http.StatusOK, "account reactivated, you can sign in again")
+}
@@ -0,0 +1,105 @@
+package api_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/BearCloud/fa20-project-dev/backend/auth-service/api"
+	"github.com/BearCloud/fa20-project-dev/backend/auth-service/apitest"
+)
+
+func TestDeleteAccountRevokesAccessTokens(t *testing.T) {
+	env := apitest.New(t)
+	creds := api.Credentials{Username: "bear", Email: "bear@berkeley.edu", Password: "pw"}
+	signUpVerified(t, env, creds)
+	access, _ := signIn(t, env, creds)
+
+	res := env.Do(http.MethodDelete, "/api/auth/account", nil, access)
+	if res.Code != http.StatusOK {
+		t.Fatalf("delete: got %d %s", res.Code, res.Body.String())
+	}
+	res = env.Do(http.MethodGet, "/api/auth/me", nil, access)
+	if res.Code != http.StatusUnauthorized {
+		t.Fatalf("access token after deleting the account: got %d, want 401", res.Code)
+	}
+
+	res = env.Do(http.MethodPost, "/api/auth/reactivate", creds)
+	if res.Code != http.StatusOK {
+		t.Fatalf("reactivate: got %d %s", res.Code, res.Body.String())
+	}
+	res = env.Do(http.MethodGet, "/api/auth/me", nil, access)
+	if res.Code != http.StatusUnauthorized {
+		t.Fatalf("access token from before the deletion after reactivating: got %d, want 401", res.Code)
+	}
+	signIn(t, env, creds)
+}
+
+func TestReactivateDoesNotRevealAccounts(t *testing.T) {
+	env := apitest.NewWithConfig(t, func(cfg *api.Config) {
+		cfg.MaxLoginAttempts = 2
+	})
+	creds := api.Credentials{Username: "bear", Email: "bear@berkeley.edu", Password: "pw"}
+	signUpVerified(t, env, creds)
+	access, _ := signIn(t, env, creds)
+	env.Do(http.MethodDelete, "/api/auth/account", nil, access)
+
+	unknown := api.Credentials{Email: "nobody@berkeley.edu", Password: "pw"}
+	wrong := api.Credentials{Email: creds.Email, Password: "wrong"}
+	var bodies []api.ErrorResponse
+	for _, attempt := range []api.Credentials{unknown, wrong} {
+		res := env.Do(http.MethodPost, "/api/auth/reactivate", attempt)
+		if res.Code != http.StatusUnauthorized {
+			t.Fatalf("reactivate %s with %q: got %d, want 401", attempt.Email, attempt.Password, res.Code)
+		}
+		var body api.ErrorResponse
+		json.NewDecoder(res.Body).Decode(&body)
+		bodies = append(bodies, body)
+	}
+	if bodies[0].Message != bodies[1].Message {
+		t.Fatalf("unknown email answered %q, wrong password %q", bodies[0].Message, bodies[1].Message)
+	}
+	if env.Sleeper.Last() == 0 {
+		t.Fatalf("failed reactivation wasn't delayed")
+	}
+
+	//a second failure for the email locks it out, even with the right password
+	env.Do(http.MethodPost, "/api/auth/reactivate", wrong)
+	res := env.Do(http.MethodPost, "/api/auth/reactivate", creds)
+	if res.Code != http.StatusTooManyRequests {
+		t.Fatalf("reactivate during the lockout: got %d, want 429", res.Code)
+	}
+	res = env.Do(http.MethodPost, "/api/auth/signin", creds)
+	if res.Code != http.StatusTooManyRequests {
+		t.Fatalf("signin during a lockout from reactivate: got %d, want 429", res.Code)
+	}
+}
+
+func TestDeletedAccountPurgedAfterGrace(t *testing.T) {
+	clock := apitest.NewFakeClock(time.Now())
+	env := apitest.NewWithConfig(t, func(cfg *api.Config) {
+		cfg.Clock = clock
+		cfg.DeletionGrace = 24 * time.Hour
+	})
+	creds := api.Credentials{Username: "bear", Email: "bear@berkeley.edu", Password: "pw"}
+	signUpVerified(t, env, creds)
+	access, _ := signIn(t, env, creds)
+	env.Do(http.MethodDelete, "/api/auth/account", nil, access)
+
+	res := env.Do(http.MethodPost, "/api/auth/signin", creds)
+	var body api.ErrorResponse
+	json.NewDecoder(res.Body).Decode(&body)
+	if res.Code != http.StatusForbidden || body.Hint != "reactivate" {
+		t.Fatalf("signin within the grace window: got %d %+v, want 403 with the reactivate hint", res.Code, body)
+	}
+
+	clock.Advance(25 * time.Hour)
+	res = env.Do(http.MethodPost, "/api/auth/reactivate", creds)
+	if res.Code != http.StatusUnauthorized {
+		t.Fatalf("reactivate past the grace window: got %d, want 401", res.Code)
+	}
+	if n := countRows(t, env, "users", "email", creds.Email); n != 0 {
+		t.Fatalf("account past the grace window: %d users rows left, want it purged", n)
+	}
+}
@@ -0,0 +1,112 @@
+package api
+
+import (
+	"database/sql"
+	"net/http"
+
+	"github.com/gorilla/mux"
+)
+
+const (
+	//roleMaxLength is the size of the role columns
+	roleMaxLength = 20
+)
+
+var (
+	//defaultRole is the role of new accounts whose invite doesn't name one
+	defaultRole = "user"
+)
+
+//validRole reports whether role is a usable role name: lowercase letters, digits, "_" and "-", starting with a letter
+func validRole(role string) bool {
+	if role == "" || len(role) > roleMaxLength || role[0] < 'a' || role[0] > 'z' {
+		return false
+	}
+	for _, c := range role {
+		if (c < 'a' || c > 'z') && (c < '0' || c > '9') && c != '_' && c != '-' {
+			return false
+		}
+	}
+	return true
+}
+
+//requireRole rejects authenticated requests whose user doesn't have role. It must run after RequireAuth.
+func requireRole(role string) mux.MiddlewareFunc {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.Method == http.MethodOptions {
+				next.ServeHTTP(w, r)
+				return
+			}
+			claims, ok := claimsFromContext(r.Context())
+			if !ok {
+				writeJSONError(w, r, http.StatusUnauthorized, "missing access token")
+				return
+			}
+			var userRole string
+			err := withRetry(func() error {
+				return DB.QueryRow("SELECT role FROM users WHERE userId = ?;", claims.UserID).Scan(&userRole)
+			})
+			if err != nil && err != sql.ErrNoRows {
+				writeJSONError(w, r, http.StatusInternalServerError, internalErrorMessage(r, "error checking user role", err))
+				return
+			}
+			if userRole != role {
+				writeJSONError(w, r, http.StatusForbidden, "this action requires the "+role+" role")
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+func resendVerification(w http.ResponseWriter, r *http.Request) {
+
+	if (*r).Method == "OPTIONS" {
+		return
+	}
+
+	userID := mux.Vars(r)["userId"]
+
+	//Look up the user so we can tell unknown and already verified accounts apart
+	var email string
+	var verified sql.NullBool
+	err := DB.QueryRow("SELECT email, verified FROM users WHERE userId = ?;", userID).Scan(&email, &verified)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			writeJSONError(w, r, http.StatusNotFound, "this user does not exist")
+		} else {
+			internalError(w, r, "error retrieving user", err)
+		}
+		return
+	}
+
+	if verified.Bool {
+		writeJSONError(w, r, http.StatusConflict, "this user is already verified")
+		return
+	}
+	if emailLimited(w, r, email) {
+		return
+	}
+
+	//Replace the verification token so any earlier email stops working
+	newToken, err := storeUniqueToken(tokenPurposeVerify, verifyTokenSize, func(tokenHash string) error {
+		_, err := DB.Exec("UPDATE users SET verifiedToken = ? WHERE userId = ?;", tokenHash, userID)
+		return err
+	})
+	if err != nil {
+		internalError(w, r, "error setting verifiedToken", err)
+		return
+	}
+
+	err = SendEmail(r.Context(), email, "user-signup.html", map[string]interface{}{"Token": newToken})
+	if err != nil {
+		internalError(w, r, "error sending verification email", err)
+		return
+	}
+
+	claims, _ := claimsFromContext(r.Context())
+	recordAudit(claims.UserID, auditResendVerification, userID)
+
+	writeJSONSuccess(w, http.StatusOK, "verification email re-sent")
+}
@@ -0,0 +1,69 @@
+package api_test
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/BearCloud/fa20-project-dev/backend/auth-service/api"
+	"github.com/BearCloud/fa20-project-dev/backend/auth-service/apitest"
+)
+
+func TestAdminResendsVerification(t *testing.T) {
+	env := apitest.New(t)
+	admin := signInAdmin(t, env, api.Credentials{Username: "oski", Email: "oski@berkeley.edu", Password: "pw"})
+	bear := api.Credentials{Username: "bear", Email: "bear@berkeley.edu", Password: "pw"}
+	env.Do(http.MethodPost, "/api/auth/signup", bear)
+	first, _ := env.Mailer.LastFrom(bear.Email, "user-signup.html")
+	var userID string
+	env.DB.QueryRow("SELECT userId FROM users WHERE username = ?;", "bear").Scan(&userID)
+
+	res := env.Do(http.MethodPost, "/api/auth/admin/users/"+userID+"/verification", nil, admin)
+	if res.Code != http.StatusOK {
+		t.Fatalf("resending: got %d %s", res.Code, res.Body.String())
+	}
+	second, _ := env.Mailer.LastFrom(bear.Email, "user-signup.html")
+	if second.Token() == first.Token() {
+		t.Fatalf("resending reused the verification token")
+	}
+	var audited int
+	env.DB.QueryRow("SELECT COUNT(*) FROM audit_log WHERE action = ? AND targetId = ?;", "resend_verification", userID).Scan(&audited)
+	if audited != 1 {
+		t.Fatalf("resending: got %d audit entries, want 1", audited)
+	}
+
+	res = env.Do(http.MethodPost, "/api/auth/verify?token="+first.Token(), nil)
+	if res.Code != http.StatusNotFound {
+		t.Fatalf("verifying with the replaced token: got %d, want 404", res.Code)
+	}
+	res = env.Do(http.MethodPost, "/api/auth/verify?token="+second.Token(), nil)
+	if res.Code != http.StatusOK {
+		t.Fatalf("verifying with the new token: got %d %s", res.Code, res.Body.String())
+	}
+
+	res = env.Do(http.MethodPost, "/api/auth/admin/users/"+userID+"/verification", nil, admin)
+	if res.Code != http.StatusConflict {
+		t.Fatalf("resending to a verified user: got %d, want 409", res.Code)
+	}
+	res = env.Do(http.MethodPost, "/api/auth/admin/users/nobody/verification", nil, admin)
+	if res.Code != http.StatusNotFound {
+		t.Fatalf("resending to an unknown user: got %d, want 404", res.Code)
+	}
+}
+
+func TestAdminEndpointsNeedAdminRole(t *testing.T) {
+	env := apitest.New(t)
+	bear := api.Credentials{Username: "bear", Email: "bear@berkeley.edu", Password: "pw"}
+	signUpVerified(t, env, bear)
+	access, _ := signIn(t, env, bear)
+	var userID string
+	env.DB.QueryRow("SELECT userId FROM users WHERE username = ?;", "bear").Scan(&userID)
+
+	res := env.Do(http.MethodPost, "/api/auth/admin/users/"+userID+"/verification", nil, access)
+	if res.Code != http.StatusForbidden {
+		t.Fatalf("resending as a regular user: got %d, want 403", res.Code)
+	}
+	res = env.Do(http.MethodPost, "/api/auth/admin/users/"+userID+"/verification", nil)
+	if res.Code != http.StatusUnauthorized {
+		t.Fatalf("resending signed out: got %d, want 401", res.Code)
+	}
+}
@@ -2,19 +2,13 @@ package api
 
 import (
 	"database/sql"
-	"encoding/json"
 	"errors"
 	"log"
 	"net/http"
-	"os"
-	"time"
+	"strings"
 
-	"github.com/dgrijalva/jwt-go"
 	"github.com/google/uuid"
 	"github.com/gorilla/mux"
-	"github.com/joho/godotenv"
-	"github.com/sendgrid/sendgrid-go"
-	"golang.org/x/crypto/bcrypt"
 )
 
 const (
@@ -24,20 +18,70 @@ const (
 
 // RegisterRoutes initializes the api endpoints and maps the requests to specific functions
 func RegisterRoutes(router *mux.Router) error {
-	router.HandleFunc("/api/auth/signup", signup).Methods(http.MethodPost, http.MethodOptions)
-	router.HandleFunc("/api/auth/signin", signin).Methods(http.MethodPost, http.MethodOptions)
-	router.HandleFunc("/api/auth/logout", logout).Methods(http.MethodPost, http.MethodOptions)
-	router.HandleFunc("/api/auth/verify", verify).Methods(http.MethodPost, http.MethodOptions)
-	router.HandleFunc("/api/auth/sendreset", sendReset).Methods(http.MethodPost, http.MethodOptions)
-	router.HandleFunc("/api/auth/resetpw", resetPassword).Methods(http.MethodPost, http.MethodOptions)
-	// Load sendgrid credentials
-	err := godotenv.Load()
-	if err != nil {
-		return err
-	}
+	//Handlers query DB directly, so refuse to start rather than nil-panic on the first request
+	if DB == nil {
+		return errors.New("database connection is not initialized, call InitDB before RegisterRoutes")
+	}
+
+	//Public signing keys for services verifying tokens themselves
+	router.HandleFunc("/.well-known/jwks.json", jwks).Methods(http.MethodGet)
+
+	//Liveness for load balancers and orchestrators
+	router.HandleFunc("/healthz", healthz).Methods(http.MethodGet, http.MethodHead)
+
+	//Delivery events from SendGrid, which calls server to server so neither CORS nor the per IP rate limit apply
+	if sendgridWebhookKey != nil {
+		router.HandleFunc("/api/auth/webhooks/sendgrid", sendgridWebhook).Methods(http.MethodPost)
+	}
+
+	//Admin-only endpoints for support staff, with their own CORS policy
+	admin := router.PathPrefix("/api/auth/admin").Subrouter()
+	admin.Use(adminCORS.Middleware, RequireAuth, requireRole(roleAdmin))
+	admin.HandleFunc("/users", listUsers).Methods(http.MethodGet, http.MethodOptions)
+	admin.HandleFunc("/users/{userId}/verification", resendVerification).Methods(http.MethodPost, http.MethodOptions)
+	admin.HandleFunc("/invites", createInvite).Methods(http.MethodPost, http.MethodOptions)
+	admin.HandleFunc("/invites", listInvites).Methods(http.MethodGet, http.MethodOptions)
+	admin.HandleFunc("/invites/{code}", revokeInvite).Methods(http.MethodDelete, http.MethodOptions)
+	admin.HandleFunc("/bounces", listBounces).Methods(http.MethodGet, http.MethodOptions)
+	admin.HandleFunc("/audit", listAudit).Methods(http.MethodGet, http.MethodOptions)
+	admin.HandleFunc("/cleanup", cleanupStats).Methods(http.MethodGet, http.MethodOptions)
+	admin.HandleFunc("/maintenance", maintenanceStatus).Methods(http.MethodGet, http.MethodOptions)
+	admin.HandleFunc("/maintenance", startMaintenance).Methods(http.MethodPut, http.MethodOptions)
+	admin.HandleFunc("/maintenance", endMaintenance).Methods(http.MethodDelete, http.MethodOptions)
+	//Moving accounts between deployments hands out password hashes, so it asks for the admin's password again
+	admin.Handle("/migration/users", requireRecentAuth(http.HandlerFunc(exportUsers))).Methods(http.MethodGet, http.MethodOptions)
+	if migrationImport {
+		admin.Handle("/migration/users", requireRecentAuth(http.HandlerFunc(importUsers))).Methods(http.MethodPost, http.MethodOptions)
+	}
+
+	//Public endpoints used by the frontend
+	public := router.NewRoute().Subrouter()
+	public.Use(publicCORS.Middleware, publicRateLimit.Middleware)
+	public.HandleFunc("/api/auth/policy", policy).Methods(http.MethodGet, http.MethodOptions)
+	public.Handle("/api/auth/signup", idempotent(http.HandlerFunc(signup))).Methods(http.MethodPost, http.MethodOptions)
+	public.HandleFunc("/api/auth/signin", signin).Methods(http.MethodPost, http.MethodOptions)
+	public.HandleFunc("/api/auth/logout", logout).Methods(http.MethodPost, http.MethodOptions)
+	public.Handle("/api/auth/logout-all", RequireAuth(http.HandlerFunc(logoutAll))).Methods(http.MethodPost, http.MethodOptions)
+	public.HandleFunc("/api/auth/verify", verify).Methods(http.MethodGet, http.MethodPost, http.MethodOptions)
+	public.HandleFunc("/api/auth/sendreset", sendReset).Methods(http.MethodPost, http.MethodOptions)
+	public.HandleFunc("/api/auth/resetpw", resetPassword).Methods(http.MethodPost, http.MethodOptions)
+	public.HandleFunc("/api/auth/resetpw/validate", validateResetToken).Methods(http.MethodGet, http.MethodPost, http.MethodOptions)
+	public.Handle("/api/auth/2fa/backup", RequireAuth(requireRecentAuth(http.HandlerFunc(regenerateBackupCodes)))).Methods(http.MethodPost, http.MethodOptions)
+	public.HandleFunc("/api/auth/reactivate", reactivate).Methods(http.MethodPost, http.MethodOptions)
+	public.HandleFunc("/api/auth/session/renew", renewSession).Methods(http.MethodPost, http.MethodOptions)
+	public.Handle("/api/auth/reauth", RequireAuth(http.HandlerFunc(reauthenticate))).Methods(http.MethodPost, http.MethodOptions)
+	public.Handle("/api/auth/account", RequireAuth(requireRecentAuth(http.HandlerFunc(deleteAccount)))).Methods(http.MethodDelete, http.MethodOptions)
+	public.Handle("/api/auth/export", RequireAuth(http.HandlerFunc(exportAccount))).Methods(http.MethodGet, http.MethodOptions)
+	public.Handle("/api/auth/security", RequireAuth(http.HandlerFunc(securitySummary))).Methods(http.MethodGet, http.MethodOptions)
+	public.Handle("/api/auth/me", RequireAuth(http.HandlerFunc(me))).Methods(http.MethodGet, http.MethodOptions)
+	public.Handle("/api/auth/me", RequireAuth(verifiedOnly(http.HandlerFunc(updateProfile)))).Methods(http.MethodPatch, http.MethodOptions)
+	public.Handle("/api/auth/me/displayname", RequireAuth(verifiedOnly(http.HandlerFunc(setDisplayName)))).Methods(http.MethodPut, http.MethodOptions)
+	public.Handle("/api/auth/emails", RequireAuth(http.HandlerFunc(listEmails))).Methods(http.MethodGet, http.MethodOptions)
+	public.Handle("/api/auth/emails", RequireAuth(verifiedOnly(http.HandlerFunc(addEmail)))).Methods(http.MethodPost, http.MethodOptions)
+	public.Handle("/api/auth/emails/{email}", RequireAuth(verifiedOnly(http.HandlerFunc(removeEmail)))).Methods(http.MethodDelete, http.MethodOptions)
+	public.Handle("/api/auth/emails/{email}/primary", RequireAuth(verifiedOnly(requireRecentAuth(http.HandlerFunc(makePrimaryEmail))))).Methods(http.MethodPost, http.MethodOptions)
+	public.HandleFunc("/api/auth/emails/verify", verifyEmail).Methods(http.MethodGet, http.MethodPost, http.MethodOptions)
 
-	sendgridKey = os.Getenv("SENDGRID_KEY")
-	sendgridClient = sendgrid.NewSendClient(sendgridKey)
 	return nil
 }
 
@@ -47,71 +91,94 @@ func signup(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	if signupMode == signupModeClosed {
+		writeJSONError(w, r, http.StatusForbidden, "signups are closed")
+		return
+	}
+
 	//Obtain the credentials from the request body
 	// YOUR CODE HERE
 	//username := r.URL.Query().Get("username")
 	//email := r.URL.Query().Get("email")
 	//password := r.URL.Query().Get("password")
 	credentials := Credentials{}
-	err := json.NewDecoder(r.Body).Decode(&credentials)
+	err := decodeJSON(r.Body, &credentials)
 
 	//Check for errors in storing credentials
 	if err != nil {
-		http.Error(w, errors.New("issue storing credentials").Error(), http.StatusInternalServerError)
+		writeJSONError(w, r, http.StatusBadRequest, decodeErrorMessage(err, "issue storing credentials"))
 		log.Print(err.Error())
 		return
 	}
+	credentials.normalize()
 
-	//Check if the username already exists
-	var exists bool
-	err = DB.QueryRow("SELECT EXISTS(SELECT * FROM users WHERE username = ?);", credentials.Username).Scan(&exists)
-	
-	//Check for error
+	if credentials.Username == "" {
+		writeJSONError(w, r, http.StatusBadRequest, "username is required")
+		return
+	}
+	credentials.Username, err = cleanText("username", credentials.Username, usernameMaxLength)
+	if err == nil {
+		credentials.Email, err = cleanText("email", credentials.Email, emailMaxLength)
+	}
 	if err != nil {
-		http.Error(w, errors.New("error checking if username exists").Error(), http.StatusInternalServerError)
-		log.Print(err.Error())
+		writeJSONError(w, r, http.StatusBadRequest, err.Error())
+		return
+	}
+	displayName, err := validateDisplayName(credentials.DisplayName)
+	if err != nil {
+		writeJSONError(w, r, http.StatusBadRequest, err.Error())
+		return
+	}
+	err = validatePassword(credentials.Password)
+	if err != nil {
+		writeJSONError(w, r, http.StatusBadRequest, err.Error())
+		return
+	}
+	phone, channel, err := signupChannel(credentials)
+	if err != nil {
+		writeJSONError(w, r, http.StatusBadRequest, err.Error())
 		return
 	}
 
-	//Check boolean returned from query
-	if exists == true {
-		http.Error(w, errors.New("this username is taken").Error(), http.StatusConflict)
+	if !emailDomainAllowed(credentials.Email) {
+		writeJSONError(w, r, http.StatusForbidden, "signups from this email domain are not allowed")
 		return
 	}
 
-	//Check if the email already exists
-	err = DB.QueryRow("SELECT EXISTS(SELECT * FROM users WHERE email = ?);", credentials.Email).Scan(&exists)
-	
+	//Check if the username or the email already exist in one round trip, the email as the verified primary or
+	//secondary address of any account. Unverified signups don't block it, see verify.
+	var usernameTaken, emailTaken bool
+	err = withRetry(func() (err error) {
+		usernameTaken, emailTaken, err = userStore.IdentityTaken(credentials.Username, credentials.Email)
+		return err
+	})
+
 	//Check for error
-	// YOUR CODE HERE
 	if err != nil {
-		http.Error(w, errors.New("error checking if email exists").Error(), http.StatusInternalServerError)
-		log.Print(err.Error())
+		internalError(w, r, "error checking if username or email exists", err)
 		return
 	}
 
-	//Check boolean returned from query
-	// YOUR CODE HERE
-	if exists == true {
-		http.Error(w, errors.New("this email is taken").Error(), http.StatusConflict)
+	//Check booleans returned from query
+	if usernameTaken || emailTaken {
+		writeSignupConflict(w, r, usernameTaken, emailTaken)
 		return
 	}
 
 	//Hash the password using bcrypt and store the hashed password in a variable
 	// YOUR CODE HERE
-	hashed, err := bcrypt.GenerateFromPassword([]byte(credentials.Password), bcrypt.DefaultCost)
+	hashed, err := hashPassword(credentials.Password)
 
 	//Check for errors during hashing process
 	// YOUR CODE HERE
 	if err != nil {
-		http.Error(w, errors.New("error encrypting password").Error(), http.StatusInternalServerError)
-		log.Print(err.Error())
+		internalError(w, r, "error encrypting password", err)
 		return
 	}
 
-	err = bcrypt.CompareHashAndPassword(hashed, []byte(credentials.Password))
+	err = comparePassword(string(hashed), credentials.Password)
 	if err != nil {
-		http.Error(w, errors.New("hashed password does not match original").Error(), http.StatusConflict)
+		writeJSONError(w, r, http.StatusConflict, "hashed password does not match original")
 		log.Print(err.Error())
 		return
 	}
@@ -120,91 +187,79 @@ func signup(w http.ResponseWriter, r *http.Request) {
 	// YOUR CODE HERE
 	newUUID := uuid.New().String()
 
-	//Create new verification token with the default token size (look at GetRandomBase62 and our constants)
-	// YOUR CODE HERE
-	newToken := GetRandomBase62(verifyTokenSize)
-
-	//Store credentials in database
-	_, err = DB.Query("INSERT INTO users (username, email, hashedPassword, verifiedToken, userId) VALUES (?, ?, ?, ?, ?);", credentials.Username, credentials.Email, hashed, newToken, newUUID)
-	
-	//Check for errors in storing the credentials
-	// YOUR CODE HERE
-	if err != nil {
-		http.Error(w, errors.New("issue storing credentials").Error(), http.StatusInternalServerError)
-		log.Print(err.Error())
-		return
+	//Invite-only signups use up their invite code. With open signups an invite is optional but still
+	//used up when given, since it may grant a role.
+	role := defaultRole
+	usesInvite := signupMode == signupModeInvite || credentials.InviteCode != ""
+	if usesInvite {
+		inviteRole, err := consumeInvite(credentials.InviteCode, credentials.Email, newUUID)
+		if err != nil {
+			if err == errInvalidInvite {
+				writeJSONError(w, r, http.StatusForbidden, err.Error())
+			} else {
+				internalError(w, r, "error checking invite code", err)
+			}
+			return
+		}
+		if inviteRole != "" {
+			role = inviteRole
+		}
 	}
 
-	//Generate an access token, expiry dates are in Unix time
-	accessExpiresAt := time.Now().Add(DefaultAccessJWTExpiry)
-	var accessToken string
-	accessToken, err = setClaims(AuthClaims{
-		UserID: newUUID,
-		StandardClaims: jwt.StandardClaims{
-			Subject:   "access",
-			ExpiresAt: accessExpiresAt.Unix(),
-			Issuer:    defaultJWTIssuer,
-			IssuedAt:  time.Now().Unix(),
-		},
+	//Store credentials in database with a new verification token, keeping only its hash
+	newToken, err := storeUniqueToken(tokenPurposeVerify, verifyTokenSize, func(tokenHash string) error {
+		return userStore.CreateUser(User{
+			UserID:         newUUID,
+			Username:       credentials.Username,
+			DisplayName:    displayName,
+			Email:          credentials.Email,
+			Phone:          phone,
+			HashedPassword: hashed,
+			Role:           role,
+			CreatedAt:      clock.Now(),
+		}, tokenHash)
 	})
 	
-	//Check for error in generating an access token
+	//Check for errors in storing the credentials
 	// YOUR CODE HERE
 	if err != nil {
-		http.Error(w, errors.New("error generating access token").Error(), http.StatusInternalServerError)
-		log.Print(err.Error())
+		internalError(w, r, "issue storing credentials", err)
+		if usesInvite {
+			err = releaseInvite(credentials.InviteCode)
+			if err != nil {
+				log.Print(err.Error())
+			}
+		}
 		return
 	}
 
-
-	//Set the cookie, name it "access_token"
-	http.SetCookie(w, &http.Cookie{
-		Name:    "access_token",
-		Value:   accessToken,
-		Expires: accessExpiresAt,
-		// Leave these next three values commented for now
-		// Secure: true,
-		// HttpOnly: true,
-		// SameSite: http.SameSiteNoneMode,
-		Path: "/",
-	})
-
-	//Generate refresh token
-	var refreshExpiresAt = time.Now().Add(DefaultRefreshJWTExpiry)
-	var refreshToken string
-	refreshToken, err = setClaims(AuthClaims{
-		UserID: newUUID,
-		StandardClaims: jwt.StandardClaims{
-			Subject:   "refresh",
-			ExpiresAt: refreshExpiresAt.Unix(),
-			Issuer:    defaultJWTIssuer,
-			IssuedAt:  time.Now().Unix(),
-		},
-	})
-
+	//Generate the access and refresh tokens and set them as cookies
+	_, err = issueTokens(w, r, newUUID, clock.Now(), []string{amrPassword}, false)
 	if err != nil {
-		http.Error(w, errors.New("error creating refreshToken").Error(), http.StatusInternalServerError)
-		log.Print(err.Error())
+		tokenError(w, r, err)
 		return
 	}
 
-	//set the refresh token ("refresh_token") as a cookie
-	http.SetCookie(w, &http.Cookie{
-		Name:    "refresh_token",
-		Value:   refreshToken,
-		Expires: refreshExpiresAt,
-		Path: "/",
-	})
+	publishEvent(eventUserCreated, newUUID, credentials.Email)
 
-	// Send verification email
-	err = SendEmail(credentials.Email, "Email Verification", "user-signup.html", map[string]interface{}{"Token": newToken})
+	// Send verification email, or text message when the signup asked for SMS
+	message := "account created, check your email to verify it"
+	if channel == channelSMS {
+		message = "account created, check your phone to verify it"
+		err = sendSMS(r.Context(), phone, "Verify your {brand} account: "+verifyLink(newToken))
+	} else {
+		err = SendEmail(r.Context(), credentials.Email, "user-signup.html", map[string]interface{}{"Token": newToken})
+	}
 	if err != nil {
-		http.Error(w, errors.New("error sending verification email").Error(), http.StatusInternalServerError)
-		log.Print(err.Error())
+		internalError(w, r, "error sending verification", err)
 		return
 	}
 
-	w.WriteHeader(http.StatusCreated)
+	w.Header().Set("Location", "/api/auth/users/"+newUUID)
+	writeJSON(w, http.StatusCreated, SignupResponse{
+		SuccessResponse: SuccessResponse{Status: "ok", Message: message},
+		UserID:          newUUID,
+	})
 	return
 }
 
@@ -217,101 +272,128 @@ func signin(w http.ResponseWriter, r *http.Request) {
 	//Store the credentials in a instance of Credentials
 	// "YOUR CODE HERE"
 	credentials := Credentials{}
-	err := json.NewDecoder(r.Body).Decode(&credentials)
+	err := decodeJSON(r.Body, &credentials)
 
 	//Check for errors in storing credentials
 	// "YOUR CODE HERE"
 	if err != nil {
-		http.Error(w, errors.New("issue storing credentials").Error(), http.StatusInternalServerError)
+		writeJSONError(w, r, http.StatusBadRequest, decodeErrorMessage(err, "issue storing credentials"))
 		log.Print(err.Error())
 		return
 	}
+	credentials.normalize()
+
+	//Locked out emails are refused before the password is checked, so guessing can't continue during the lockout.
+	//Clients from LIMIT_BYPASS_CIDRS are never locked out.
+	if !limitBypassed(r) {
+		lockedUntil, err := lockedOut(credentials.Email)
+		if err != nil {
+			internalError(w, r, "error checking sign in lockout", err)
+			return
+		}
+		if !lockedUntil.IsZero() {
+			signinLockedOut(w, r, lockedUntil)
+			return
+		}
+	}
 
-	//Get the hashedPassword and userId of the user
-	var hashedPassword, userID string
-	err = DB.QueryRow("SELECT hashedPassword, userId FROM users WHERE email = ?;", credentials.Email).Scan(&hashedPassword, &userID)
+	//Get the hashedPassword, userId and deletion time of the user, who can sign in with any verified address
+	var user User
+	err = withRetry(func() (err error) {
+		user, err = userStore.GetBySigninEmail(credentials.Email)
+		return err
+	})
+	hashedPassword, userID, deletedAt := string(user.HashedPassword), user.UserID, user.DeletedAt
 	// process errors associated with emails
 	if err != nil {
 		if err == sql.ErrNoRows {
-			http.Error(w, errors.New("this email is not associated with an account").Error(), http.StatusNotFound)
+			//spend as long as checking a password would, so the answer's timing doesn't tell the email is unknown
+			compareDummyPassword(credentials.Password)
+			signinFailed(w, r, credentials.Email)
 		} else {
-			http.Error(w, errors.New("error retrieving information with this email").Error(), http.StatusInternalServerError)
-			log.Print(err.Error())
+			internalError(w, r, "error retrieving information with this email", err)
 		}
 		return
 	}
 
 	// Check if hashed password matches the one corresponding to the email
 	// "YOUR CODE HERE"
-	err = bcrypt.CompareHashAndPassword([]byte(hashedPassword), []byte(credentials.Password))
+	err = comparePassword(hashedPassword, credentials.Password)
 
 	//Check error in comparing hashed passwords
 	// "YOUR CODE HERE"
 	if err != nil {
-		http.Error(w, errors.New("incorrect password").Error(), http.StatusInternalServerError)
-		log.Print(err.Error())
+		signinFailed(w, r, credentials.Email)
 		return
 	}
 
-	//Generate an access token and set it as a cookie (Look at signup and feel free to copy paste!)
-	// "YOUR CODE HERE"
-	accessExpiresAt := time.Now().Add(DefaultAccessJWTExpiry)
-	var accessToken string
-	accessToken, err = setClaims(AuthClaims{
-		UserID: userID,
-		StandardClaims: jwt.StandardClaims{
-			Subject:   "access",
-			ExpiresAt: accessExpiresAt.Unix(),
-			Issuer:    defaultJWTIssuer,
-			IssuedAt:  time.Now().Unix(),
-		},
-	})
+	//Accounts with two-factor authentication also need a code from the authenticator app or a backup code
+	amr, ok := checkSecondFactor(w, r, userID, credentials)
+	if !ok {
+		return
+	}
 
-	//Check for error in generating an access token
+	err = clearLoginFailures(credentials.Email)
 	if err != nil {
-		http.Error(w, errors.New("error generating access token").Error(), http.StatusInternalServerError)
 		log.Print(err.Error())
-		return
 	}
+	signinBackoff.clear(signinBackoffKey(clientIP(r), credentials.Email))
 
-	//Set the cookie, name it "access_token"
-	http.SetCookie(w, &http.Cookie{
-		Name:    "access_token",
-		Value:   accessToken,
-		Expires: accessExpiresAt,
-		// Leave these next three values commented for now
-		// Secure: true,
-		// HttpOnly: true,
-		// SameSite: http.SameSiteNoneMode,
-		Path: "/",
-	})
-
-	//Generate a refresh token and set it as a cookie (Look at signup and feel free to copy paste!)
-	// "YOUR CODE HERE"
-	var refreshExpiresAt = time.Now().Add(DefaultRefreshJWTExpiry)
-	var refreshToken string
-	refreshToken, err = setClaims(AuthClaims{
-		UserID: userID,
-		StandardClaims: jwt.StandardClaims{
-			Subject:   "refresh",
-			ExpiresAt: refreshExpiresAt.Unix(),
-			Issuer:    defaultJWTIssuer,
-			IssuedAt:  time.Now().Unix(),
-		},
-	})
+	//Move the stored hash to the configured algorithm and parameters now that the password is known,
+	//a failure only means trying again at the next signin
+	err = upgradePasswordHash(userID, hashedPassword, credentials.Password)
+	if err != nil {
+		log.Print("error upgrading password hash: " + err.Error())
+	}
+
+	//Deleted accounts can't sign in, but can be reactivated until the grace window passes
+	if deletedAt.Valid {
+		if deletionExpired(deletedAt) {
+			err = purgeAccount(userID)
+			if err != nil {
+				log.Print(err.Error())
+			}
+			signinFailed(w, r, credentials.Email)
+			return
+		}
+		writeJSON(w, http.StatusForbidden, ErrorResponse{
+			Status:        "error",
+			Message:       "this account has been deleted",
+			Hint:          "reactivate",
+			CorrelationID: requestIDFromContext(r.Context()),
+		})
+		return
+	}
+
+	//Check for a new device before issueTokens records this one
+	ipAddress, device := clientIP(r), deviceFingerprint(r.UserAgent())
+	newDevice := false
+	if newSigninAlerts {
+		newDevice, err = isNewDevice(userID, ipAddress, device)
+		if err != nil {
+			log.Print("error checking for a new signin device: " + err.Error())
+		}
+	}
 
+	//Generate the access and refresh tokens and set them as cookies, the refresh token lasting longer if asked to remember the user
+	signedInAt := clock.Now()
+	expiry, err := issueTokens(w, r, userID, signedInAt, amr, credentials.RememberMe)
 	if err != nil {
-		http.Error(w, errors.New("error creating refreshToken").Error(), http.StatusInternalServerError)
-		log.Print(err.Error())
+		tokenError(w, r, err)
 		return
 	}
+	err = recordSignin(userID)
+	if err != nil {
+		log.Print(err.Error())
+	}
+	if newDevice {
+		sendNewSigninAlert(userID, ipAddress, device, signedInAt)
+	}
 
-	//set the refresh token ("refresh_token") as a cookie
-	http.SetCookie(w, &http.Cookie{
-		Name:    "refresh_token",
-		Value:   refreshToken,
-		Expires: refreshExpiresAt,
-		Path: "/",
+	writeJSON(w, http.StatusOK, SigninResponse{
+		SuccessResponse: SuccessResponse{Status: "ok", Message: "signed in"},
+		UserID:          userID,
+		TokenExpiry:     expiry,
 	})
 }
 
@@ -321,12 +403,35 @@ func logout(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	//Tell the client which of its token cookies are cleared, both are expired either way
+	response := LogoutResponse{ClearedCookies: []string{}}
+	for _, name := range []string{accessCookieName(), refreshCookieName()} {
+		if cookie, err := r.Cookie(name); err == nil && cookie.Value != "" {
+			response.ClearedCookies = append(response.ClearedCookies, name)
+		}
+	}
+
 	// logging out causes expiration time of cookie to be set to now
+	clearTokenCookies(w)
+
+	//Clearing the cookie doesn't stop a copy of the refresh token from being used, so its session is revoked too.
+	//A missing or invalid refresh token has nothing to revoke and still logs out.
+	if cookie, err := r.Cookie(refreshCookieName()); err == nil && cookie.Value != "" {
+		claims, err := getClaims(cookie.Value)
+		if err == nil && claims.Subject == "refresh" && claims.Id != "" {
+			response.SessionRevoked, err = endSession(claims.Id)
+			if err != nil {
+				internalError(w, r, "error revoking session", err)
+				return
+			}
+		}
+	}
 
-	//Set the access_token and refresh_token to have an empty value and set their expiration date to anytime in the past
-	var expiresAt = time.Now()
-	http.SetCookie(w, &http.Cookie{Name: "access_token", Value: "", Expires: expiresAt.Add(-DefaultAccessJWTExpiry)})
-	http.SetCookie(w, &http.Cookie{Name: "refresh_token", Value: "", Expires: expiresAt.Add(-DefaultRefreshJWTExpiry)})
+	response.SuccessResponse = SuccessResponse{Status: "ok", Message: "logged out"}
+	if !response.SessionRevoked {
+		response.Message = "no active session, cookies cleared"
+	}
+	writeJSON(w, http.StatusOK, response)
 	return
 }
 
@@ -336,30 +441,76 @@ func verify(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	token, ok := r.URL.Query()["token"]
+	token := tokenParam(r)
 	// check that valid token exists
-	if !ok || len(token[0]) < 1 {
-		http.Error(w, errors.New("url Param 'token' is missing").Error(), http.StatusInternalServerError)
-		log.Print(errors.New("url Param 'token' is missing").Error())
+	if token == "" {
+		verifyFailed(w, r, http.StatusBadRequest, verifyReasonMissingToken, "url Param 'token' is missing")
+		return
+	}
+	if err := tokenPurposeError(token, tokenPurposeVerify); err != nil {
+		verifyFailed(w, r, http.StatusBadRequest, verifyReasonWrongToken, err.Error())
 		return
 	}
 
-	//Obtain the user with the verifiedToken from the query parameter and set their verification status to the integer "1"
-	rows, err := DB.Exec("UPDATE users SET verified = ? WHERE verifiedToken = ?;", 1, token)
+	var user User
+	err := withRetry(func() (err error) {
+		user, err = userStore.GetByVerifyToken(hashToken(token))
+		return err
+	})
+	if err == ErrNotFound {
+		verifyFailed(w, r, http.StatusNotFound, verifyReasonNotFound, "verification token not found")
+		return
+	}
+	if err != nil {
+		verifyError(w, r, "error looking up verified user", err)
+		return
+	}
 
-	if rows == nil {
-		http.Error(w, errors.New("invalid token").Error(), http.StatusBadRequest)
-		log.Print(err.Error())
+	//Obtain the user with the verifiedToken from the query parameter and set their verification status to the integer "1"
+	//Only unverified users match, so the update reports exactly the first verification
+	//The update only ever sets verified, so retrying it is safe
+	//Pending signups can share an email, but only the first of them to verify gets it
+	var firstVerification bool
+	err = withRetry(func() (err error) {
+		firstVerification, err = userStore.MarkVerified(hashToken(token))
+		return err
+	})
+	if err == ErrEmailTaken {
+		verifyFailed(w, r, http.StatusConflict, verifyReasonEmailTaken, err.Error())
 		return
 	}
 
 	//Check for errors in executing the previous query
 	// "YOUR CODE HERE"
 	if err != nil {
-		http.Error(w, errors.New("issue storing credentials").Error(), http.StatusBadRequest)
+		verifyFailed(w, r, http.StatusBadRequest, verifyReasonInvalidToken, "invalid token")
 		log.Print(err.Error())
 		return
 	}
+
+	userID := user.UserID
+	if firstVerification {
+		publishEvent(eventUserVerified, userID, user.Email)
+	}
+
+	if welcomeEmailEnabled && firstVerification {
+		sendWelcomeEmail(hashToken(token))
+	}
+
+	//The token only flips verified once, so a link that leaks later can't be replayed to sign in
+	if verifyAutoSignIn && firstVerification {
+		//The user authenticated just now by following the link, but the amr tells requireRecentAuth no password
+		//was entered, so sensitive operations still ask for one
+		_, err = issueTokens(w, r, userID, clock.Now(), []string{amrEmail}, false)
+		if err != nil {
+			verifyError(w, r, "error generating tokens", err)
+			return
+		}
+		verifySucceeded(w, r, "email verified, signed in")
+		return
+	}
+
+	verifySucceeded(w, r, "email verified")
 	return
 }
 
@@ -372,47 +523,80 @@ func sendReset(w http.ResponseWriter, r *http.Request) {
 	//Get the email from the body (decode into an instance of Credentials)
 	// "YOUR CODE HERE"
 	credentials := Credentials{}
-	err := json.NewDecoder(r.Body).Decode(&credentials)
+	err := decodeJSON(r.Body, &credentials)
 
 	//check for errors decoding the object
 	// "YOUR CODE HERE"
 	if err != nil {
-		http.Error(w, errors.New("issue retrieving email").Error(), http.StatusInternalServerError)
+		writeJSONError(w, r, http.StatusBadRequest, decodeErrorMessage(err, "issue retrieving email"))
 		log.Print(err.Error())
 		return
 	}
+	credentials.normalize()
 
 	//check for other miscellaneous errors that may occur
 	//what is considered an invalid input for an email?
 	// "YOUR CODE HERE"
 	if credentials.Email == "" {
-		http.Error(w, errors.New("invalid email address").Error(), http.StatusNotAcceptable)
-		log.Print(err.Error())
+		writeJSONError(w, r, http.StatusNotAcceptable, "invalid email address")
+		return
+	}
+
+	//Limit by the email as typed, registered or not, so being refused gives nothing away
+	if emailLimited(w, r, credentials.Email) {
+		return
+	}
+
+	//Obtain the user with the specified email
+	var user User
+	err = withRetry(func() (err error) {
+		user, err = userStore.GetByEmail(credentials.Email)
+		return err
+	})
+	userID := user.UserID
+	if err == ErrNotFound {
+		//there is no account to reset, so there is nothing worth emailing
+		writeJSONSuccess(w, http.StatusOK, "password reset email sent")
+		return
+	}
+	if err != nil {
+		internalError(w, r, "error retrieving user", err)
 		return
 	}
 
+	//in rotate mode a new link invalidates the earlier ones, otherwise they stay valid until they expire
+	if resetTokenMode == resetModeRotate {
+		err = userStore.ClearResetTokens(userID)
+		if err != nil {
+			internalError(w, r, "error clearing resetToken", err)
+			return
+		}
+	}
 
-	//generate reset token
-	token := GetRandomBase62(resetTokenSize)
+	//generate reset token and store its hash
+	token, err := storeUniqueToken(tokenPurposeReset, resetTokenSize, func(tokenHash string) error {
+		return userStore.SetResetToken(tokenHash, userID, clock.Now().Add(DefaultResetTokenExpiry))
+	})
 
-	//Obtain the user with the specified email and set their resetToken to the token we generated
-	_, err = DB.Query("UPDATE users SET resetToken = ? WHERE email = ?;", token, credentials.Email)
-	
 	//Check for errors executing the queries
 	// "YOUR CODE HERE"
 	if err != nil {
-		http.Error(w, errors.New("error setting resetToken").Error(), http.StatusInternalServerError)
-		log.Print(err.Error())
+		internalError(w, r, "error setting resetToken", err)
 		return
 	}
 
-	// Send verification email
-	err = SendEmail(credentials.Email, "BearChat Password Reset", "password-reset.html", map[string]interface{}{"Token": token})
+	// Send the reset link by text message if asked for and the account has a phone, by email otherwise
+	if credentials.Channel == channelSMS && user.Phone != "" && smsSender != nil {
+		err = sendSMS(r.Context(), user.Phone, "Reset your {brand} password: "+resetLink(token))
+	} else {
+		err = SendEmail(r.Context(), credentials.Email, "password-reset.html", map[string]interface{}{"Token": token, "Link": resetLink(token)})
+	}
 	if err != nil {
-		http.Error(w, errors.New("error sending verification email").Error(), http.StatusInternalServerError)
-		log.Print(err.Error())
+		internalError(w, r, "error sending verification email", err)
 		return
 	}
+
+	writeJSONSuccess(w, http.StatusOK, "password reset email sent")
 	return
 }
 
@@ -422,85 +606,142 @@ func resetPassword(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 	
-	//get token from query params
-	token := r.URL.Query().Get("token")
-
-	//get the username, email, and password from the body
+	//get the token and the new password from the body
 	// "YOUR CODE HERE"
-	credentials := Credentials{}
-	err := json.NewDecoder(r.Body).Decode(&credentials)
+	reset := PasswordReset{}
+	err := decodeJSON(r.Body, &reset)
 
 	//Check for errors decoding the body
 	// "YOUR CODE HERE"
 	if err != nil {
-		http.Error(w, errors.New("issue retrieving credentials").Error(), http.StatusInternalServerError)
+		writeJSONError(w, r, http.StatusBadRequest, decodeErrorMessage(err, "issue retrieving the new password"))
 		log.Print(err.Error())
 		return
 	}
 
+	//the token identifies the account, it comes in the body or in the query of the reset link
+	token := strings.TrimSpace(reset.Token)
+	if token == "" {
+		token = tokenParam(r)
+	}
+	if token == "" {
+		writeJSONError(w, r, http.StatusBadRequest, "reset token is missing")
+		return
+	}
+	if wrongTokenPurpose(w, r, token, tokenPurposeReset) {
+		return
+	}
+	if resetRequiresEmail && strings.TrimSpace(reset.Email) == "" {
+		writeJSONError(w, r, http.StatusBadRequest, "email is required")
+		return
+	}
+
 	//Check for invalid inputs, return an error if input is invalid
 	// "YOUR CODE HERE"
-	if credentials.Username == "" {
-		http.Error(w, errors.New("invalid username").Error(), http.StatusNotAcceptable)
-		log.Print(err.Error())
+	if reset.NewPassword == "" {
+		writeJSONError(w, r, http.StatusNotAcceptable, "invalid password")
 		return
 	}
-
-	if credentials.Email == "" {
-		http.Error(w, errors.New("invalid email address").Error(), http.StatusNotAcceptable)
-		log.Print(err.Error())
+	if reset.NewPassword != reset.ConfirmPassword {
+		writeJSONError(w, r, http.StatusBadRequest, "passwords do not match")
 		return
 	}
-
-	if credentials.Password == "" {
-		http.Error(w, errors.New("invalid password").Error(), http.StatusNotAcceptable)
-		log.Print(err.Error())
+	err = validatePassword(reset.NewPassword)
+	if err != nil {
+		writeJSONError(w, r, http.StatusNotAcceptable, err.Error())
 		return
 	}
 
-	email := credentials.Email
-	username := credentials.Username
-	password := credentials.Password
-	var exists bool
-	//check if the username and token pair exist
-	err = DB.QueryRow("SELECT EXISTS(SELECT * FROM users WHERE username = ? AND resetToken = ?);", username, token).Scan(&exists)
+	password := reset.NewPassword
+	var resetToken ResetToken
+	//find the account the token belongs to
+	err = withRetry(func() (err error) {
+		resetToken, err = userStore.GetResetToken(hashToken(token))
+		return err
+	})
+	userID, email, expiresAt := resetToken.UserID, resetToken.Email, resetToken.ExpiresAt
+
+	//Call an error if the token doesn't exist or has expired. A token sent with another account's email
+	//gets the same answer as a missing one, before its expiry is looked at, so it can't reveal anything.
+	if err == ErrNotFound || (err == nil && !resetAccountMatches(reset.Email, email)) {
+		writeJSONError(w, r, http.StatusNotFound, errResetLinkInvalid.Error())
+		return
+	}
 
 	//Check for errors executing the query
 	// "YOUR CODE HERE"
 	if err != nil {
-		http.Error(w, errors.New("issue retrieving username and token pair").Error(), http.StatusInternalServerError)
-		log.Print(err.Error())
+		internalError(w, r, "issue retrieving reset token", err)
 		return
 	}
-
-	//Check exists boolean. Call an error if the username-token pair doesn't exist
-	// "YOUR CODE HERE"
-	if !exists {
-		http.Error(w, errors.New("username and token pair does not exist").Error(), http.StatusNotFound)
-		log.Print(err.Error())
+	if !clock.Now().Before(expiresAt) {
+		writeJSONError(w, r, http.StatusGone, "this reset link has expired")
 		return
 	}
 
 	//Hash the new password
 	// "YOUR CODE HERE"
-	hashed, hashError := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	hashed, hashError := hashPassword(password)
 
 	//Check for errors in hashing the new password
 	// "YOUR CODE HERE"
 	if hashError != nil {
-		http.Error(w, errors.New("error encrypting password").Error(), http.StatusInternalServerError)
-		log.Print(err.Error())
+		internalError(w, r, "error encrypting password", hashError)
 		return
 	}
 
-	//input new password and clear the reset token (set the token equal to empty string)
-	_, err = DB.Exec("UPDATE users SET resetToken = ?, password = ? WHERE email = ?;", "", hashed, email)
+	//input new password and clear the user's reset tokens, using the token up in the same step
+	err = userStore.ResetPassword(hashToken(token), userID, hashed)
+	if err == ErrNotFound {
+		writeJSONError(w, r, http.StatusNotFound, errResetLinkInvalid.Error())
+		return
+	}
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
-		log.Print(err.Error())
+		internalError(w, r, "error resetting password", err)
+		return
 	}
+	publishEvent(eventPasswordReset, userID, email)
 
 	//put the user in the redis cache to invalidate all current sessions (NOT IN SCOPE FOR PROJECT), leave this comment for future reference
 
+	writeJSONSuccess(w, http.StatusOK, "password reset")
 	return
-}
\ No newline at end of file
+}
+
+func validateResetToken(w http.ResponseWriter, r *http.Request) {
+
+	if (*r).Method == "OPTIONS" {
+		return
+	}
+
+	token := tokenParam(r)
+	if token == "" {
+		writeJSONError(w, r, http.StatusBadRequest, "url Param 'token' is missing")
+		return
+	}
+	if wrongTokenPurpose(w, r, token, tokenPurposeReset) {
+		return
+	}
+
+	//Only look the token up, it stays usable for resetPassword
+	var resetToken ResetToken
+	err := withRetry(func() (err error) {
+		resetToken, err = userStore.GetResetToken(hashToken(token))
+		return err
+	})
+	expiresAt := resetToken.ExpiresAt
+	if err == ErrNotFound {
+		writeJSONError(w, r, http.StatusNotFound, "this reset link is invalid")
+		return
+	}
+	if err != nil {
+		internalError(w, r, "error checking reset token", err)
+		return
+	}
+	if !clock.Now().Before(expiresAt) {
+		writeJSONError(w, r, http.StatusGone, "this reset link has expired")
+		return
+	}
+
+	writeJSONSuccess(w, http.StatusOK, "reset token is valid")
+}
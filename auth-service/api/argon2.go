@@ -0,0 +1,93 @@
+package api
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+
+	"golang.org/x/crypto/argon2"
+)
+
+const (
+	//argon2Prefix starts every Argon2id hash, bcrypt hashes start with "$2a$" or "$2b$" instead
+	argon2Prefix   = "$argon2id$"
+	argon2SaltSize = 16
+	argon2KeySize  = 32
+)
+
+var (
+	//argon2Memory is the memory each Argon2id hash uses in KiB
+	argon2Memory uint32 = 19456
+	//argon2Time is the number of passes Argon2id makes over its memory
+	argon2Time uint32 = 2
+	//argon2Threads is the number of lanes Argon2id hashes in parallel
+	argon2Threads uint8 = 1
+)
+
+//errArgon2Hash is returned for a stored Argon2id hash that can't be parsed
+var errArgon2Hash = errors.New("malformed argon2id hash")
+
+//argon2Params are the parameters an Argon2id hash was made with, which are stored in the hash itself
+type argon2Params struct {
+	memory  uint32
+	time    uint32
+	threads uint8
+}
+
+//hashArgon2 hashes password with Argon2id and a random salt, encoded in the usual PHC string format,
+//e.g. $argon2id$v=19$m=19456,t=2,p=1$<salt>$<key>
+func hashArgon2(password string) ([]byte, error) {
+	salt := make([]byte, argon2SaltSize)
+	_, err := rand.Read(salt)
+	if err != nil {
+		return nil, err
+	}
+	key := argon2.IDKey([]byte(password), salt, argon2Time, argon2Memory, argon2Threads, argon2KeySize)
+	encoded := fmt.Sprintf("%sv=%d$m=%d,t=%d,p=%d$%s$%s", argon2Prefix, argon2.Version, argon2Memory, argon2Time, argon2Threads,
+		base64.RawStdEncoding.EncodeToString(salt), base64.RawStdEncoding.EncodeToString(key))
+	return []byte(encoded), nil
+}
+
+//parseArgon2 splits an encoded Argon2id hash into its parameters, salt and key
+func parseArgon2(hashed string) (argon2Params, []byte, []byte, error) {
+	var params argon2Params
+	parts := strings.Split(hashed, "$")
+	if len(parts) != 6 || parts[1] != "argon2id" {
+		return params, nil, nil, errArgon2Hash
+	}
+	var version int
+	_, err := fmt.Sscanf(parts[2], "v=%d", &version)
+	if err != nil || version != argon2.Version {
+		return params, nil, nil, errArgon2Hash
+	}
+	_, err = fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &params.memory, &params.time, &params.threads)
+	if err != nil || params.time == 0 || params.threads == 0 {
+		return params, nil, nil, errArgon2Hash
+	}
+	salt, err := base64.RawStdEncoding.DecodeString(parts[4])
+	if err != nil {
+		return params, nil, nil, errArgon2Hash
+	}
+	key, err := base64.RawStdEncoding.DecodeString(parts[5])
+	if err != nil || len(key) == 0 {
+		return params, nil, nil, errArgon2Hash
+	}
+	return params, salt, key, nil
+}
+
+//compareArgon2 checks password against an encoded Argon2id hash, using the parameters stored in the hash
+//so hashes made before the parameters were changed keep working
+func compareArgon2(hashed string, password string) error {
+	params, salt, key, err := parseArgon2(hashed)
+	if err != nil {
+		return err
+	}
+	candidate := argon2.IDKey([]byte(password), salt, params.time, params.memory, params.threads, uint32(len(key)))
+	if subtle.ConstantTimeCompare(candidate, key) != 1 {
+		return errPasswordMismatch
+	}
+	return nil
+}
@@ -0,0 +1,182 @@
+package api
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	//defaultAuditPageSize is how many entries an audit page holds when no limit is given
+	defaultAuditPageSize = 50
+	//maxAuditPageSize caps the limit a client can ask for
+	maxAuditPageSize = 200
+
+	//auditResendVerification is recorded when an admin re-sends a user's verification email
+	auditResendVerification = "resend_verification"
+	//auditCreateInvite is recorded when an admin creates an invite code
+	auditCreateInvite = "create_invite"
+	//auditRevokeInvite is recorded when an admin revokes an invite code
+	auditRevokeInvite = "revoke_invite"
+	//auditAddEmail is recorded when a user adds a secondary email
+	auditAddEmail = "add_email"
+	//auditRemoveEmail is recorded when a user removes a secondary email
+	auditRemoveEmail = "remove_email"
+	//auditChangePrimaryEmail is recorded when a user makes a secondary email their primary one
+	auditChangePrimaryEmail = "change_primary_email"
+	//auditExportUsers is recorded when an admin exports a page of accounts for migration, targeting the page's cursor
+	auditExportUsers = "export_users"
+	//auditImportUser is recorded for every account an admin imports from another deployment
+	auditImportUser = "import_user"
+	//auditStartMaintenance is recorded when an admin starts maintenance mode, targeting when it ends
+	auditStartMaintenance = "start_maintenance"
+	//auditEndMaintenance is recorded when an admin ends maintenance mode early
+	auditEndMaintenance = "end_maintenance"
+)
+
+//recordAudit stores an audit log entry for an action actorID took on targetID.
+//Failures are logged rather than returned so a broken audit write never fails the request itself.
+func recordAudit(actorID string, action string, targetID string) {
+	_, err := DB.Exec("INSERT INTO audit_log (actorId, action, targetId, createdAt) VALUES (?, ?, ?, ?);", actorID, action, targetID, clock.Now())
+	if err != nil {
+		log.Print("error recording audit entry: " + err.Error())
+	}
+}
+
+//AuditEntry is a single audit log record
+type AuditEntry struct {
+	ID        int64     `json:"id"`
+	ActorID   string    `json:"actorId"`
+	Action    string    `json:"action"`
+	TargetID  string    `json:"targetId"`
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+//AuditPageResponse is the JSON body returned when listing the audit log.
+//NextCursor is empty on the last page.
+type AuditPageResponse struct {
+	SuccessResponse
+	Entries    []AuditEntry `json:"entries"`
+	NextCursor string       `json:"nextCursor,omitempty"`
+}
+
+//parseAuditTime parses an optional RFC 3339 query parameter
+func parseAuditTime(r *http.Request, name string) (*time.Time, error) {
+	value := r.URL.Query().Get(name)
+	if value == "" {
+		return nil, nil
+	}
+	parsed, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		return nil, errors.New(name + " must be an RFC 3339 time like 2020-11-01T15:04:05Z")
+	}
+	return &parsed, nil
+}
+
+func listAudit(w http.ResponseWriter, r *http.Request) {
+
+	if (*r).Method == "OPTIONS" {
+		return
+	}
+
+	query := r.URL.Query()
+	conditions := []string{}
+	args := []interface{}{}
+
+	//userId matches entries the user either performed or was the target of
+	if userID := query.Get("userId"); userID != "" {
+		conditions = append(conditions, "(actorId = ? OR targetId = ?)")
+		args = append(args, userID, userID)
+	}
+	if eventType := query.Get("eventType"); eventType != "" {
+		conditions = append(conditions, "action = ?")
+		args = append(args, eventType)
+	}
+
+	from, err := parseAuditTime(r, "from")
+	if err != nil {
+		writeJSONError(w, r, http.StatusBadRequest, err.Error())
+		return
+	}
+	to, err := parseAuditTime(r, "to")
+	if err != nil {
+		writeJSONError(w, r, http.StatusBadRequest, err.Error())
+		return
+	}
+	if from != nil && to != nil && from.After(*to) {
+		writeJSONError(w, r, http.StatusBadRequest, "from must not be after to")
+		return
+	}
+	if from != nil {
+		conditions = append(conditions, "createdAt >= ?")
+		args = append(args, *from)
+	}
+	if to != nil {
+		conditions = append(conditions, "createdAt <= ?")
+		args = append(args, *to)
+	}
+
+	//the cursor is the id of the last entry on the previous page, ids only grow so newer entries never shift pages
+	if cursor := query.Get("cursor"); cursor != "" {
+		id, err := strconv.ParseInt(cursor, 10, 64)
+		if err != nil || id < 1 {
+			writeJSONError(w, r, http.StatusBadRequest, "invalid cursor")
+			return
+		}
+		conditions = append(conditions, "id < ?")
+		args = append(args, id)
+	}
+
+	limit := defaultAuditPageSize
+	if value := query.Get("limit"); value != "" {
+		limit, err = strconv.Atoi(value)
+		if err != nil || limit < 1 {
+			writeJSONError(w, r, http.StatusBadRequest, "limit must be a positive number")
+			return
+		}
+		if limit > maxAuditPageSize {
+			limit = maxAuditPageSize
+		}
+	}
+
+	statement := "SELECT id, actorId, action, targetId, createdAt FROM audit_log"
+	if len(conditions) > 0 {
+		statement += " WHERE " + strings.Join(conditions, " AND ")
+	}
+	//fetch one extra row to learn whether there is another page
+	statement += " ORDER BY id DESC LIMIT ?;"
+	args = append(args, limit+1)
+
+	rows, err := DB.Query(statement, args...)
+	if err != nil {
+		internalError(w, r, "error retrieving audit log", err)
+		return
+	}
+	defer rows.Close()
+
+	entries := []AuditEntry{}
+	for rows.Next() {
+		var entry AuditEntry
+		err = rows.Scan(&entry.ID, &entry.ActorID, &entry.Action, &entry.TargetID, &entry.CreatedAt)
+		if err != nil {
+			internalError(w, r, "error retrieving audit log", err)
+			return
+		}
+		entries = append(entries, entry)
+	}
+	if err = rows.Err(); err != nil {
+		internalError(w, r, "error retrieving audit log", err)
+		return
+	}
+
+	response := AuditPageResponse{SuccessResponse: SuccessResponse{Status: "ok", Message: "audit log retrieved"}}
+	if len(entries) > limit {
+		entries = entries[:limit]
+		response.NextCursor = strconv.FormatInt(entries[limit-1].ID, 10)
+	}
+	response.Entries = entries
+	writeJSON(w, http.StatusOK, response)
+}
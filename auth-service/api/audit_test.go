@@ -0,0 +1,83 @@
+package api_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/BearCloud/fa20-project-dev/backend/auth-service/api"
+	"github.com/BearCloud/fa20-project-dev/backend/auth-service/apitest"
+)
+
+//listAudit fetches a page of the audit log with query as admin
+func listAudit(t *testing.T, env *apitest.Env, admin *http.Cookie, query string) api.AuditPageResponse {
+	t.Helper()
+	res := env.Do(http.MethodGet, "/api/auth/admin/audit?"+query, nil, admin)
+	if res.Code != http.StatusOK {
+		t.Fatalf("audit log with %q: got %d %s", query, res.Code, res.Body.String())
+	}
+	var page api.AuditPageResponse
+	json.NewDecoder(res.Body).Decode(&page)
+	return page
+}
+
+func TestAuditLogFilters(t *testing.T) {
+	start := time.Date(2020, 11, 1, 12, 0, 0, 0, time.UTC)
+	clock := apitest.NewFakeClock(start)
+	env := apitest.NewWithConfig(t, func(cfg *api.Config) {
+		cfg.Clock = clock
+	})
+	admin := signInAdmin(t, env, api.Credentials{Username: "oski", Email: "oski@berkeley.edu", Password: "pw"})
+
+	var codes []string
+	for i := 0; i < 3; i++ {
+		codes = append(codes, createInvite(t, env, admin, api.InviteRequest{}).Code)
+		clock.Advance(time.Minute)
+	}
+	env.Do(http.MethodDelete, "/api/auth/admin/invites/"+codes[0], nil, admin)
+
+	page := listAudit(t, env, admin, "eventType=create_invite")
+	if len(page.Entries) != 3 || page.Entries[0].TargetID != codes[2] || page.Entries[2].TargetID != codes[0] {
+		t.Fatalf("create_invite entries: got %+v, want the three invites newest first", page.Entries)
+	}
+
+	//both ends of the range are inclusive
+	page = listAudit(t, env, admin, "eventType=create_invite&from=2020-11-01T12:01:00Z&to=2020-11-01T12:02:00Z")
+	if len(page.Entries) != 2 || page.Entries[0].TargetID != codes[2] || page.Entries[1].TargetID != codes[1] {
+		t.Fatalf("create_invite entries from 12:01 to 12:02: got %+v, want the second and third invites", page.Entries)
+	}
+	page = listAudit(t, env, admin, "from=2020-11-01T12:03:00Z")
+	if len(page.Entries) != 1 || page.Entries[0].Action != "revoke_invite" {
+		t.Fatalf("entries from 12:03: got %+v, want only the revocation", page.Entries)
+	}
+
+	res := env.Do(http.MethodGet, "/api/auth/admin/audit?from=2020-11-01T13:00:00Z&to=2020-11-01T12:00:00Z", nil, admin)
+	if res.Code != http.StatusBadRequest {
+		t.Fatalf("audit log with from after to: got %d, want 400", res.Code)
+	}
+	res = env.Do(http.MethodGet, "/api/auth/admin/audit?from=yesterday", nil, admin)
+	if res.Code != http.StatusBadRequest {
+		t.Fatalf("audit log with an unparseable from: got %d, want 400", res.Code)
+	}
+}
+
+func TestAuditLogPages(t *testing.T) {
+	env := apitest.New(t)
+	admin := signInAdmin(t, env, api.Credentials{Username: "oski", Email: "oski@berkeley.edu", Password: "pw"})
+	for i := 0; i < 3; i++ {
+		createInvite(t, env, admin, api.InviteRequest{})
+	}
+
+	first := listAudit(t, env, admin, "limit=2")
+	if len(first.Entries) != 2 || first.NextCursor == "" {
+		t.Fatalf("first page of 2: got %d entries and cursor %q, want 2 and a cursor", len(first.Entries), first.NextCursor)
+	}
+	second := listAudit(t, env, admin, "limit=2&cursor="+first.NextCursor)
+	if len(second.Entries) != 1 || second.NextCursor != "" {
+		t.Fatalf("last page: got %d entries and cursor %q, want 1 and no cursor", len(second.Entries), second.NextCursor)
+	}
+	if second.Entries[0].ID >= first.Entries[1].ID {
+		t.Fatalf("second page entry %d isn't older than the first page's %d", second.Entries[0].ID, first.Entries[1].ID)
+	}
+}
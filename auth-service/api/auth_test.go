@@ -0,0 +1,131 @@
+package api_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/BearCloud/fa20-project-dev/backend/auth-service/api"
+	"github.com/BearCloud/fa20-project-dev/backend/auth-service/apitest"
+)
+
+//getMe asks for the profile with authorization as the Authorization header, unless it is "", and cookies
+func getMe(env *apitest.Env, authorization string, cookies ...*http.Cookie) *httptest.ResponseRecorder {
+	req := env.Request(http.MethodGet, "/api/auth/me", nil, cookies...)
+	if authorization != "" {
+		req.Header.Set("Authorization", authorization)
+	}
+	return env.Send(req)
+}
+
+//profileUsername returns the username of the profile in res, failing the test unless res is a 200
+func profileUsername(t *testing.T, step string, res *httptest.ResponseRecorder) string {
+	t.Helper()
+	if res.Code != http.StatusOK {
+		t.Fatalf("%s: got %d %s", step, res.Code, res.Body.String())
+	}
+	var profile api.Profile
+	json.NewDecoder(res.Body).Decode(&profile)
+	return profile.Username
+}
+
+func TestBearerAndCookieAuth(t *testing.T) {
+	env := apitest.New(t)
+	bear := api.Credentials{Username: "bear", Email: "bear@berkeley.edu", Password: "pw"}
+	tree := api.Credentials{Username: "tree", Email: "tree@stanford.edu", Password: "pw"}
+	signUpVerified(t, env, bear)
+	signUpVerified(t, env, tree)
+	bearAccess, _ := signIn(t, env, bear)
+	treeAccess, _ := signIn(t, env, tree)
+
+	if got := profileUsername(t, "bearer only", getMe(env, "Bearer "+bearAccess.Value)); got != "bear" {
+		t.Fatalf("bearer only: got %q, want bear", got)
+	}
+	if got := profileUsername(t, "cookie only", getMe(env, "", bearAccess)); got != "bear" {
+		t.Fatalf("cookie only: got %q, want bear", got)
+	}
+	//the Authorization header is checked first
+	if got := profileUsername(t, "both", getMe(env, "Bearer "+treeAccess.Value, bearAccess)); got != "tree" {
+		t.Fatalf("bearer and cookie: got %q, want the bearer's tree", got)
+	}
+	if res := getMe(env, "Bearer not-a-token", bearAccess); res.Code != http.StatusUnauthorized {
+		t.Fatalf("invalid bearer with a valid cookie: got %d, want 401", res.Code)
+	}
+	if res := getMe(env, ""); res.Code != http.StatusUnauthorized {
+		t.Fatalf("neither: got %d, want 401", res.Code)
+	}
+}
+
+func TestMalformedAuthorizationRejected(t *testing.T) {
+	env := apitest.New(t)
+	creds := api.Credentials{Username: "bear", Email: "bear@berkeley.edu", Password: "pw"}
+	signUpVerified(t, env, creds)
+	access, _ := signIn(t, env, creds)
+	token := access.Value
+
+	for header, want := range map[string]string{
+		token:                                 "the Authorization header must read \"Bearer <token>\"",
+		"Basic " + token:                      "the Authorization header must read \"Bearer <token>\"",
+		"Bearer":                              "the Authorization header has no token",
+		"Bearer ":                             "the Authorization header has no token",
+		"Bearer  " + token:                    "the Authorization header has extra whitespace around the token",
+		"Bearer " + token + " ":               "the Authorization header has extra whitespace around the token",
+		"Bearer " + token + " " + token:       "the Authorization header must hold a single token",
+		"Bearer " + token + "," + token:       "the Authorization header must hold a single token",
+		"Bearer " + token + "\"":              "the Authorization header token contains invalid characters",
+		"Bearer " + strings.Repeat("a", 5000): "the Authorization header is too long",
+	} {
+		//a malformed header wins over a valid cookie
+		res := getMe(env, header, access)
+		var body api.ErrorResponse
+		json.NewDecoder(res.Body).Decode(&body)
+		if res.Code != http.StatusUnauthorized || body.Message != want {
+			t.Errorf("Authorization %.40q: got %d %q, want 401 %q", header, res.Code, body.Message, want)
+		}
+	}
+
+	req := env.Request(http.MethodGet, "/api/auth/me", nil)
+	req.Header.Add("Authorization", "Bearer "+token)
+	req.Header.Add("Authorization", "Bearer "+token)
+	res := env.Send(req)
+	var body api.ErrorResponse
+	json.NewDecoder(res.Body).Decode(&body)
+	if res.Code != http.StatusUnauthorized || body.Message != "send a single Authorization header" {
+		t.Fatalf("two Authorization headers: got %d %q, want 401", res.Code, body.Message)
+	}
+
+	//the scheme is case-insensitive
+	profileUsername(t, "lowercase bearer", getMe(env, "bearer "+token))
+}
+
+func TestTokenLeewayForClockSkew(t *testing.T) {
+	clock := apitest.NewFakeClock(time.Now())
+	env := apitest.NewWithConfig(t, func(cfg *api.Config) {
+		cfg.Clock = clock
+		cfg.AccessTokenTTL = 15 * time.Minute
+		cfg.TokenLeeway = 30 * time.Second
+	})
+	creds := api.Credentials{Username: "bear", Email: "bear@berkeley.edu", Password: "pw"}
+	signUpVerified(t, env, creds)
+	issued := clock.Now()
+	access, _ := signIn(t, env, creds)
+
+	for _, check := range []struct {
+		offset time.Duration
+		want   int
+	}{
+		{-10 * time.Second, http.StatusOK},
+		{-time.Minute, http.StatusUnauthorized},
+		{15*time.Minute + 10*time.Second, http.StatusOK},
+		{15*time.Minute + time.Minute, http.StatusUnauthorized},
+	} {
+		clock.Set(issued.Add(check.offset))
+		res := env.Do(http.MethodGet, "/api/auth/me", nil, access)
+		if res.Code != check.want {
+			t.Errorf("access token checked %s after it was issued: got %d, want %d", check.offset, res.Code, check.want)
+		}
+	}
+}
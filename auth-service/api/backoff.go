@@ -0,0 +1,113 @@
+package api
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+//backoffState counts a client's failures in a row
+type backoffState struct {
+	failures int
+	last     time.Time
+}
+
+//failureBackoff works out how long to hold back the answer to a failure, starting at base and doubling with each
+//failure in a row up to max. Failures are forgotten after forget without another one. A base of 0 turns it off.
+type failureBackoff struct {
+	base   time.Duration
+	max    time.Duration
+	forget time.Duration
+
+	mu        sync.Mutex
+	clients   map[string]*backoffState
+	lastSweep time.Time
+}
+
+//signinBackoff slows down failed signins for each email and client IP pair, independent of the lockout
+var signinBackoff = &failureBackoff{base: 250 * time.Millisecond, max: 5 * time.Second, forget: 15 * time.Minute}
+
+//Sleeper waits for d, or until ctx is done
+type Sleeper func(ctx context.Context, d time.Duration)
+
+//signinSleeper holds back the answer to a failed signin, replaced in tests so they don't actually wait
+var signinSleeper Sleeper = sleepContext
+
+//SetSigninSleeper replaces how a failed signin waits out its delay, e.g. with one that records it in tests
+func SetSigninSleeper(sleeper Sleeper) {
+	signinSleeper = sleeper
+}
+
+//sleepContext waits for d, returning early if ctx is done first
+func sleepContext(ctx context.Context, d time.Duration) {
+	if d <= 0 {
+		return
+	}
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+	case <-ctx.Done():
+	}
+}
+
+//delay is how long to wait after failures in a row
+func (backoff *failureBackoff) delay(failures int) time.Duration {
+	if backoff.base <= 0 || failures <= 0 {
+		return 0
+	}
+	delay := backoff.base
+	//doubling stops at max, so it can't overflow
+	for i := 1; i < failures && delay < backoff.max; i++ {
+		delay *= 2
+	}
+	if delay > backoff.max {
+		return backoff.max
+	}
+	return delay
+}
+
+//fail counts a failure from client and returns how long to wait before answering it
+func (backoff *failureBackoff) fail(client string) time.Duration {
+	if backoff.base <= 0 {
+		return 0
+	}
+	backoff.mu.Lock()
+	defer backoff.mu.Unlock()
+
+	now := clock.Now()
+	if backoff.clients == nil {
+		backoff.clients = map[string]*backoffState{}
+	}
+	//drop clients that stopped failing now and then, so they don't pile up
+	if now.Sub(backoff.lastSweep) > backoff.forget {
+		for key, state := range backoff.clients {
+			if now.Sub(state.last) >= backoff.forget {
+				delete(backoff.clients, key)
+			}
+		}
+		backoff.lastSweep = now
+	}
+
+	state, ok := backoff.clients[client]
+	if !ok || now.Sub(state.last) >= backoff.forget {
+		state = &backoffState{}
+		backoff.clients[client] = state
+	}
+	state.failures++
+	state.last = now
+	return backoff.delay(state.failures)
+}
+
+//clear forgets the failures of client after it succeeds
+func (backoff *failureBackoff) clear(client string) {
+	backoff.mu.Lock()
+	defer backoff.mu.Unlock()
+	delete(backoff.clients, client)
+}
+
+//signinBackoffKey is the client a failed signin for email counts against, so a guesser elsewhere
+//can't slow down the account owner
+func signinBackoffKey(ip string, email string) string {
+	return email + " " + ip
+}
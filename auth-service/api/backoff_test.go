@@ -0,0 +1,103 @@
+package api_test
+
+import (
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/BearCloud/fa20-project-dev/backend/auth-service/api"
+	"github.com/BearCloud/fa20-project-dev/backend/auth-service/apitest"
+)
+
+func TestSigninBackoffGrowsAndResetsOnSuccess(t *testing.T) {
+	env := apitest.NewWithConfig(t, func(cfg *api.Config) {
+		cfg.Clock = apitest.NewFakeClock(time.Now())
+		cfg.MaxLoginAttempts = 0
+		cfg.SigninBackoffBase = 100 * time.Millisecond
+		cfg.SigninBackoffMax = 400 * time.Millisecond
+	})
+	creds := api.Credentials{Username: "bear", Email: "bear@berkeley.edu", Password: "pw"}
+	signUpVerified(t, env, creds)
+	wrong := api.Credentials{Email: creds.Email, Password: "wrong"}
+
+	for i := 0; i < 4; i++ {
+		failedSignin(t, env, wrong, http.StatusUnauthorized)
+	}
+	if got := fmt.Sprint(env.Sleeper.Delays()); got != "[100ms 200ms 400ms 400ms]" {
+		t.Fatalf("four wrong passwords: got delays %s, want them doubling up to 400ms", got)
+	}
+
+	signIn(t, env, creds)
+	if got := len(env.Sleeper.Delays()); got != 4 {
+		t.Fatalf("a successful signin was delayed, got delays %v", env.Sleeper.Delays())
+	}
+	failedSignin(t, env, wrong, http.StatusUnauthorized)
+	if got := env.Sleeper.Last(); got != 100*time.Millisecond {
+		t.Fatalf("wrong password after a successful signin: got a %s delay, want 100ms", got)
+	}
+}
+
+func TestSigninBackoffPerEmailAndIP(t *testing.T) {
+	env := apitest.NewWithConfig(t, func(cfg *api.Config) {
+		cfg.Clock = apitest.NewFakeClock(time.Now())
+		cfg.MaxLoginAttempts = 0
+		cfg.SigninBackoffBase = 100 * time.Millisecond
+		cfg.SigninBackoffMax = 400 * time.Millisecond
+	})
+	bear := api.Credentials{Username: "bear", Email: "bear@berkeley.edu", Password: "pw"}
+	tree := api.Credentials{Username: "tree", Email: "tree@stanford.edu", Password: "pw"}
+	signUpVerified(t, env, bear)
+	signUpVerified(t, env, tree)
+
+	for i := 0; i < 3; i++ {
+		signinVia(env, "203.0.113.1:4000", "", api.Credentials{Email: bear.Email, Password: "wrong"})
+	}
+	signinVia(env, "198.51.100.7:4000", "", api.Credentials{Email: bear.Email, Password: "wrong"})
+	if got := env.Sleeper.Last(); got != 100*time.Millisecond {
+		t.Fatalf("the owner's wrong password from another IP: got a %s delay, want 100ms", got)
+	}
+	signinVia(env, "203.0.113.1:4000", "", api.Credentials{Email: tree.Email, Password: "wrong"})
+	if got := env.Sleeper.Last(); got != 100*time.Millisecond {
+		t.Fatalf("another account's wrong password from the guessing IP: got a %s delay, want 100ms", got)
+	}
+}
+
+func TestSigninBackoffForgottenAfterQuietPeriod(t *testing.T) {
+	clock := apitest.NewFakeClock(time.Now())
+	env := apitest.NewWithConfig(t, func(cfg *api.Config) {
+		cfg.Clock = clock
+		cfg.MaxLoginAttempts = 0
+		cfg.SigninBackoffBase = 100 * time.Millisecond
+		cfg.SigninBackoffMax = 400 * time.Millisecond
+	})
+	creds := api.Credentials{Username: "bear", Email: "bear@berkeley.edu", Password: "pw"}
+	signUpVerified(t, env, creds)
+	wrong := api.Credentials{Email: creds.Email, Password: "wrong"}
+
+	failedSignin(t, env, wrong, http.StatusUnauthorized)
+	failedSignin(t, env, wrong, http.StatusUnauthorized)
+	clock.Advance(15 * time.Minute)
+	failedSignin(t, env, wrong, http.StatusUnauthorized)
+	if got := env.Sleeper.Last(); got != 100*time.Millisecond {
+		t.Fatalf("wrong password after 15 quiet minutes: got a %s delay, want 100ms", got)
+	}
+}
+
+func TestSigninBackoffOff(t *testing.T) {
+	env := apitest.NewWithConfig(t, func(cfg *api.Config) {
+		cfg.MaxLoginAttempts = 0
+		cfg.SigninBackoffBase = 0
+	})
+	creds := api.Credentials{Username: "bear", Email: "bear@berkeley.edu", Password: "pw"}
+	signUpVerified(t, env, creds)
+
+	for i := 0; i < 3; i++ {
+		failedSignin(t, env, api.Credentials{Email: creds.Email, Password: "wrong"}, http.StatusUnauthorized)
+	}
+	for _, delay := range env.Sleeper.Delays() {
+		if delay != 0 {
+			t.Fatalf("SIGNIN_BACKOFF_BASE=0: got delays %v, want none", env.Sleeper.Delays())
+		}
+	}
+}
@@ -0,0 +1,146 @@
+package api
+
+import (
+	"context"
+	"database/sql/driver"
+	"log"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-sql-driver/mysql"
+)
+
+const (
+	breakerClosed   = "closed"
+	breakerOpen     = "open"
+	breakerHalfOpen = "half-open"
+)
+
+//circuitBreaker stops sending requests to the database after threshold failures in a row, a threshold of 0
+//turns it off. Once cooldown has passed a single probe decides whether it closes again or stays open.
+type circuitBreaker struct {
+	threshold int
+	cooldown  time.Duration
+
+	mu       sync.Mutex
+	failures int
+	openedAt time.Time
+	probing  bool
+}
+
+//dbBreaker guards the database
+var dbBreaker = &circuitBreaker{threshold: 5, cooldown: 30 * time.Second}
+
+//isDBUnavailable reports whether err means the database couldn't be reached at all, as opposed to
+//a query failing on a database that answered
+func isDBUnavailable(err error) bool {
+	if err == driver.ErrBadConn || err == mysql.ErrInvalidConn {
+		return true
+	}
+	if _, ok := err.(net.Error); ok {
+		return true
+	}
+	message := err.Error()
+	return strings.Contains(message, "connection refused") || strings.Contains(message, "sql: database is closed")
+}
+
+//record counts the outcome of a database ping, see success and failure
+func (breaker *circuitBreaker) record(err error) {
+	if err != nil && isDBUnavailable(err) {
+		breaker.failure()
+		return
+	}
+	breaker.success()
+}
+
+//success closes the breaker, any answer from the database shows it is reachable
+func (breaker *circuitBreaker) success() {
+	breaker.mu.Lock()
+	defer breaker.mu.Unlock()
+	if !breaker.openedAt.IsZero() {
+		log.Print("database circuit breaker closed, the database is reachable again")
+	}
+	breaker.failures, breaker.openedAt, breaker.probing = 0, time.Time{}, false
+}
+
+//failure counts a request that failed to reach the database, opening the breaker at threshold or when a probe fails.
+//Handlers report these through internalError, so a request retried by withRetry still counts once.
+func (breaker *circuitBreaker) failure() {
+	breaker.mu.Lock()
+	defer breaker.mu.Unlock()
+	breaker.failures++
+	if breaker.threshold <= 0 {
+		return
+	}
+	if breaker.probing || (breaker.openedAt.IsZero() && breaker.failures >= breaker.threshold) {
+		if breaker.openedAt.IsZero() {
+			log.Printf("database circuit breaker opened after %d failures in a row", breaker.failures)
+		}
+		breaker.openedAt, breaker.probing = clock.Now(), false
+	}
+}
+
+//state returns closed, open or half-open, the latter once the cooldown is over and a probe is due
+func (breaker *circuitBreaker) state() string {
+	breaker.mu.Lock()
+	defer breaker.mu.Unlock()
+	switch {
+	case breaker.openedAt.IsZero():
+		return breakerClosed
+	case since(breaker.openedAt) < breaker.cooldown:
+		return breakerOpen
+	default:
+		return breakerHalfOpen
+	}
+}
+
+//admit reports whether a request may use the database and, if it may, whether it is the probe that must check
+//the database first. A refused request is told how long to wait.
+func (breaker *circuitBreaker) admit() (time.Duration, bool, bool) {
+	breaker.mu.Lock()
+	defer breaker.mu.Unlock()
+	if breaker.threshold <= 0 || breaker.openedAt.IsZero() {
+		return 0, true, false
+	}
+	if wait := breaker.cooldown - since(breaker.openedAt); wait > 0 {
+		return wait, false, false
+	}
+	if breaker.probing {
+		return time.Second, false, false
+	}
+	breaker.probing = true
+	return 0, true, true
+}
+
+//breakerMiddleware answers with a 503 straight away while dbBreaker is open, instead of letting every request
+//hang on a database that is down. The first request after the cooldown pings the database and is served if
+//the ping succeeds, which closes the breaker. /healthz always gets through, it reports the breaker's state.
+func breakerMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/healthz" || r.Method == http.MethodOptions {
+			next.ServeHTTP(w, r)
+			return
+		}
+		wait, ok, probe := dbBreaker.admit()
+		if ok && probe {
+			ctx, cancel := context.WithTimeout(r.Context(), healthCheckTimeout)
+			err := DB.PingContext(ctx)
+			cancel()
+			dbBreaker.record(err)
+			if err != nil {
+				log.Print("database circuit breaker probe failed: " + err.Error())
+				ok, wait = false, dbBreaker.cooldown
+			}
+		}
+		if !ok {
+			w.Header().Set("Retry-After", strconv.Itoa(int(wait.Seconds())+1))
+			writeJSONError(w, r, http.StatusServiceUnavailable, "the database is unavailable, try again shortly")
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
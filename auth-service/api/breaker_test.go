@@ -0,0 +1,68 @@
+package api_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/BearCloud/fa20-project-dev/backend/auth-service/api"
+	"github.com/BearCloud/fa20-project-dev/backend/auth-service/apitest"
+)
+
+//breakerState returns the status code of /healthz and the database circuit breaker state it reports
+func breakerState(env *apitest.Env) (int, string) {
+	res := env.Do(http.MethodGet, "/healthz", nil)
+	var health api.HealthResponse
+	json.NewDecoder(res.Body).Decode(&health)
+	return res.Code, health.Breaker
+}
+
+func TestBreakerOpensAndClosesAfterRecovery(t *testing.T) {
+	clock := apitest.NewFakeClock(time.Now())
+	env := apitest.NewWithConfig(t, func(cfg *api.Config) {
+		cfg.Clock = clock
+		cfg.BreakerThreshold = 3
+		cfg.BreakerCooldown = 30 * time.Second
+	})
+	creds := api.Credentials{Email: "bear@berkeley.edu", Password: "pw"}
+	if code, state := breakerState(env); code != http.StatusOK || state != "closed" {
+		t.Fatalf("healthz with the database up: got %d with the breaker %q, want 200 and closed", code, state)
+	}
+
+	env.DB.Close()
+	for i := 0; i < 3; i++ {
+		if res := env.Do(http.MethodPost, "/api/auth/signin", creds); res.Code != http.StatusInternalServerError {
+			t.Fatalf("signin %d with the database down: got %d, want 500", i+1, res.Code)
+		}
+	}
+	res := env.Do(http.MethodPost, "/api/auth/signin", creds)
+	if res.Code != http.StatusServiceUnavailable || res.Header().Get("Retry-After") != "31" {
+		t.Fatalf("signin with the breaker open: got %d with Retry-After %q, want 503 for the cooldown", res.Code, res.Header().Get("Retry-After"))
+	}
+	if code, state := breakerState(env); code != http.StatusServiceUnavailable || state != "open" {
+		t.Fatalf("healthz with the database down: got %d with the breaker %q, want 503 and open", code, state)
+	}
+
+	//a probe that still can't reach the database keeps the breaker open for another cooldown
+	clock.Advance(31 * time.Second)
+	if res := env.Do(http.MethodPost, "/api/auth/signin", creds); res.Code != http.StatusServiceUnavailable {
+		t.Fatalf("probe with the database still down: got %d, want 503", res.Code)
+	}
+	if res := env.Do(http.MethodPost, "/api/auth/signin", creds); res.Code != http.StatusServiceUnavailable || res.Header().Get("Retry-After") != "31" {
+		t.Fatalf("signin after a failed probe: got %d with Retry-After %q, want 503 for a new cooldown", res.Code, res.Header().Get("Retry-After"))
+	}
+
+	//the database comes back, the breaker stays open until the cooldown is over
+	api.DB = apitest.NewDB(t)
+	if res := env.Do(http.MethodPost, "/api/auth/signin", creds); res.Code != http.StatusServiceUnavailable {
+		t.Fatalf("signin within the cooldown: got %d, want 503", res.Code)
+	}
+	clock.Advance(31 * time.Second)
+	if res := env.Do(http.MethodPost, "/api/auth/signin", creds); res.Code != http.StatusUnauthorized {
+		t.Fatalf("probe once the database is back: got %d, want the signin served", res.Code)
+	}
+	if code, state := breakerState(env); code != http.StatusOK || state != "closed" {
+		t.Fatalf("healthz after recovery: got %d with the breaker %q, want 200 and closed", code, state)
+	}
+}
@@ -0,0 +1,72 @@
+package api
+
+import (
+	"sort"
+	"strings"
+)
+
+//customClaimAttributes are the user columns that may be copied into access tokens
+var customClaimAttributes = map[string]bool{"username": true, "displayName": true, "locale": true, "email": true, "role": true, "verified": true}
+
+var (
+	//customClaims maps each custom claim name to the user attribute it carries, set from CUSTOM_CLAIMS
+	customClaims = map[string]string{}
+)
+
+//parseCustomClaims parses "claim=attribute" pairs separated by commas, e.g. "tier=role,name=username"
+func parseCustomClaims(list string) (map[string]string, []string) {
+	claims := map[string]string{}
+	var problems []string
+	for _, pair := range splitList(list) {
+		parts := strings.SplitN(pair, "=", 2)
+		if len(parts) != 2 || strings.TrimSpace(parts[0]) == "" {
+			problems = append(problems, "CUSTOM_CLAIMS entries must look like claim=attribute, got \""+pair+"\"")
+			continue
+		}
+		name, attribute := strings.TrimSpace(parts[0]), strings.TrimSpace(parts[1])
+		if !customClaimAttributes[attribute] {
+			problems = append(problems, "CUSTOM_CLAIMS can't carry the user attribute \""+attribute+"\"")
+			continue
+		}
+		claims[name] = attribute
+	}
+	return claims, problems
+}
+
+//loadCustomClaims reads the configured custom claims for userID, nil if none are configured
+func loadCustomClaims(userID string) (map[string]interface{}, error) {
+	if len(customClaims) == 0 {
+		return nil, nil
+	}
+	names := make([]string, 0, len(customClaims))
+	for name := range customClaims {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	//attributes are checked against customClaimAttributes when the config loads, so they are safe to use as columns
+	columns := make([]string, len(names))
+	values := make([]interface{}, len(names))
+	targets := make([]interface{}, len(names))
+	for i, name := range names {
+		columns[i] = customClaims[name]
+		targets[i] = &values[i]
+	}
+	err := withRetry(func() error {
+		return DB.QueryRow("SELECT "+strings.Join(columns, ", ")+" FROM users WHERE userId = ?;", userID).Scan(targets...)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	claims := map[string]interface{}{}
+	for i, name := range names {
+		//MySQL hands back text columns as bytes
+		if text, ok := values[i].([]byte); ok {
+			claims[name] = string(text)
+		} else {
+			claims[name] = values[i]
+		}
+	}
+	return claims, nil
+}
@@ -0,0 +1,59 @@
+package api_test
+
+import (
+	"net/http"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/BearCloud/fa20-project-dev/backend/auth-service/api"
+	"github.com/BearCloud/fa20-project-dev/backend/auth-service/apitest"
+	"github.com/dgrijalva/jwt-go"
+)
+
+//tokenClaims verifies the access token in cookie with the apitest secret and returns its claims
+func tokenClaims(t *testing.T, cookie *http.Cookie) api.AuthClaims {
+	t.Helper()
+	var claims api.AuthClaims
+	_, err := jwt.ParseWithClaims(cookie.Value, &claims, func(token *jwt.Token) (interface{}, error) {
+		return []byte("apitest-secret"), nil
+	})
+	if err != nil {
+		t.Fatalf("verifying the access token: %v", err)
+	}
+	return claims
+}
+
+func TestCustomClaimsRoundTrip(t *testing.T) {
+	env := apitest.NewWithConfig(t, func(cfg *api.Config) {
+		cfg.CustomClaims = map[string]string{"tier": "role", "name": "username"}
+	})
+	creds := api.Credentials{Username: "bear", Email: "bear@berkeley.edu", Password: "pw"}
+	signUpVerified(t, env, creds)
+	access, refresh := signIn(t, env, creds)
+
+	claims := tokenClaims(t, access)
+	if claims.Custom["tier"] != "user" || claims.Custom["name"] != "bear" || claims.UserID == "" {
+		t.Fatalf("access token: got custom claims %v, want tier user and name bear", claims.Custom)
+	}
+	profileUsername(t, "access token with custom claims", getMe(env, "", access))
+
+	//renewing reads the attributes again
+	env.DB.Exec("UPDATE users SET role = ? WHERE email = ?;", "admin", creds.Email)
+	res := env.Do(http.MethodPost, "/api/auth/session/renew", nil, refresh)
+	if res.Code != http.StatusOK {
+		t.Fatalf("renew: got %d %s", res.Code, res.Body.String())
+	}
+	if tier := tokenClaims(t, apitest.Cookie(res, "access_token")).Custom["tier"]; tier != "admin" {
+		t.Fatalf("renewed access token: got tier %v, want the new role", tier)
+	}
+}
+
+func TestCustomClaimsLimitedToSafeAttributes(t *testing.T) {
+	os.Setenv("CUSTOM_CLAIMS", "hash=hashedPassword,tier")
+	defer os.Unsetenv("CUSTOM_CLAIMS")
+	err := api.LoadConfig().Validate()
+	if err == nil || !strings.Contains(err.Error(), "hashedPassword") || !strings.Contains(err.Error(), "\"tier\"") {
+		t.Fatalf("CUSTOM_CLAIMS with a password hash and a malformed entry: got %v, want both rejected", err)
+	}
+}
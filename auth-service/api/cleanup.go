@@ -0,0 +1,120 @@
+package api
+
+import (
+	"log"
+	"net/http"
+	"sync"
+	"time"
+)
+
+var (
+	//cleanupInterval is how often expired rows are swept, 0 sweeps only once at startup
+	cleanupInterval = time.Hour
+	//cleanupLeader marks the one instance that sweeps, so replicas don't all delete the same rows
+	cleanupLeader = true
+)
+
+//CleanupStats counts what the cleanup sweeps removed since the service started
+type CleanupStats struct {
+	Runs          int64      `json:"runs"`
+	Failures      int64      `json:"failures"`
+	LastRun       *time.Time `json:"lastRun,omitempty"`
+	ResetTokens   int64      `json:"resetTokens"`
+	Sessions      int64      `json:"sessions"`
+	Accounts      int64      `json:"accounts"`
+	LoginAttempts int64      `json:"loginAttempts"`
+}
+
+var (
+	cleanupMu    sync.Mutex
+	cleanupTotal CleanupStats
+)
+
+//sweepExpired deletes reset tokens, sessions and failed signin counts that have expired, then purges accounts
+//whose deletion grace window has passed. It returns what this sweep removed.
+func sweepExpired() (CleanupStats, error) {
+	var swept CleanupStats
+	now := clock.Now()
+
+	result, err := DB.Exec("DELETE FROM reset_tokens WHERE expiresAt < ?;", now)
+	if err != nil {
+		return swept, err
+	}
+	swept.ResetTokens, _ = result.RowsAffected()
+
+	//a refresh token is still accepted for tokenLeeway after it expires, so its session has to outlive it by as much
+	result, err = DB.Exec("DELETE FROM sessions WHERE expiresAt < ?;", now.Add(-tokenLeeway))
+	if err != nil {
+		return swept, err
+	}
+	swept.Sessions, _ = result.RowsAffected()
+
+	//failed signins are forgotten once they are older than a lockout and no lockout is running
+	result, err = DB.Exec("DELETE FROM login_attempts WHERE updatedAt < ? AND (lockedUntil IS NULL OR lockedUntil < ?);", now.Add(-lockoutDuration), now)
+	if err != nil {
+		return swept, err
+	}
+	swept.LoginAttempts, _ = result.RowsAffected()
+
+	swept.Accounts, err = PurgeDeletedAccounts()
+	return swept, err
+}
+
+//runCleanup sweeps once and adds the result to the running totals
+func runCleanup() {
+	swept, err := sweepExpired()
+
+	cleanupMu.Lock()
+	now := clock.Now()
+	cleanupTotal.Runs++
+	cleanupTotal.LastRun = &now
+	cleanupTotal.ResetTokens += swept.ResetTokens
+	cleanupTotal.Sessions += swept.Sessions
+	cleanupTotal.Accounts += swept.Accounts
+	cleanupTotal.LoginAttempts += swept.LoginAttempts
+	if err != nil {
+		cleanupTotal.Failures++
+	}
+	cleanupMu.Unlock()
+
+	if err != nil {
+		log.Println("error cleaning up expired rows: " + err.Error())
+	}
+	if swept.ResetTokens > 0 || swept.Sessions > 0 || swept.Accounts > 0 || swept.LoginAttempts > 0 {
+		log.Printf("cleanup purged %d reset tokens, %d sessions, %d deleted accounts and %d failed signin counts", swept.ResetTokens, swept.Sessions, swept.Accounts, swept.LoginAttempts)
+	}
+}
+
+//StartCleanup sweeps expired rows now and then every cleanupInterval in the background.
+//Instances started with CLEANUP_LEADER="false" never sweep.
+func StartCleanup() {
+	if !cleanupLeader {
+		log.Println("not the cleanup leader, skipping expired row cleanup")
+		return
+	}
+	runCleanup()
+	if cleanupInterval <= 0 {
+		return
+	}
+	go func() {
+		ticker := time.NewTicker(cleanupInterval)
+		defer ticker.Stop()
+		for range ticker.C {
+			runCleanup()
+		}
+	}()
+}
+
+//cleanupStats reports the totals of every sweep this instance ran
+func cleanupStats(w http.ResponseWriter, r *http.Request) {
+
+	if (*r).Method == "OPTIONS" {
+		return
+	}
+
+	cleanupMu.Lock()
+	stats := cleanupTotal
+	cleanupMu.Unlock()
+
+	writeJSON(w, http.StatusOK, stats)
+}
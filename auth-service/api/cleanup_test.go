@@ -0,0 +1,82 @@
+package api_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/BearCloud/fa20-project-dev/backend/auth-service/api"
+	"github.com/BearCloud/fa20-project-dev/backend/auth-service/apitest"
+)
+
+//seedExpiring inserts one expired and one live row into each table the cleanup sweeps
+func seedExpiring(t *testing.T, env *apitest.Env, now time.Time) {
+	t.Helper()
+	for _, seed := range []struct {
+		statement string
+		args      []interface{}
+	}{
+		{"INSERT INTO reset_tokens (tokenHash, userId, expiresAt) VALUES (?, ?, ?);", []interface{}{"expired", "bear", now.Add(-time.Minute)}},
+		{"INSERT INTO reset_tokens (tokenHash, userId, expiresAt) VALUES (?, ?, ?);", []interface{}{"live", "bear", now.Add(time.Minute)}},
+		{"INSERT INTO sessions (jti, userId, createdAt, expiresAt) VALUES (?, ?, ?, ?);", []interface{}{"expired", "bear", now.Add(-time.Hour), now.Add(-time.Hour)}},
+		{"INSERT INTO sessions (jti, userId, createdAt, expiresAt) VALUES (?, ?, ?, ?);", []interface{}{"live", "bear", now.Add(-time.Hour), now.Add(time.Hour)}},
+		{"INSERT INTO login_attempts (email, failures, updatedAt) VALUES (?, ?, ?);", []interface{}{"expired", 1, now.Add(-24 * time.Hour)}},
+		{"INSERT INTO login_attempts (email, failures, updatedAt) VALUES (?, ?, ?);", []interface{}{"live", 1, now}},
+	} {
+		_, err := env.DB.Exec(seed.statement, seed.args...)
+		if err != nil {
+			t.Fatalf("seeding %q: %v", seed.statement, err)
+		}
+	}
+}
+
+func TestCleanupSweepsOnlyExpiredRows(t *testing.T) {
+	clock := apitest.NewFakeClock(time.Now())
+	env := apitest.NewWithConfig(t, func(cfg *api.Config) {
+		cfg.Clock = clock
+		cfg.CleanupInterval = 0
+		cfg.CleanupLeader = true
+	})
+	admin := signInAdmin(t, env, api.Credentials{Username: "oski", Email: "oski@berkeley.edu", Password: "pw"})
+	var before api.CleanupStats
+	json.NewDecoder(env.Do(http.MethodGet, "/api/auth/admin/cleanup", nil, admin).Body).Decode(&before)
+	seedExpiring(t, env, clock.Now())
+
+	api.StartCleanup()
+
+	for _, table := range []struct{ name, column string }{
+		{"reset_tokens", "tokenHash"},
+		{"sessions", "jti"},
+		{"login_attempts", "email"},
+	} {
+		if countRows(t, env, table.name, table.column, "expired") != 0 {
+			t.Errorf("expired %s row survived the sweep", table.name)
+		}
+		if countRows(t, env, table.name, table.column, "live") != 1 {
+			t.Errorf("live %s row was swept", table.name)
+		}
+	}
+
+	var after api.CleanupStats
+	json.NewDecoder(env.Do(http.MethodGet, "/api/auth/admin/cleanup", nil, admin).Body).Decode(&after)
+	if after.Runs != before.Runs+1 || after.ResetTokens != before.ResetTokens+1 || after.Sessions != before.Sessions+1 || after.LoginAttempts != before.LoginAttempts+1 {
+		t.Fatalf("cleanup stats: went from %+v to %+v, want one more run removing one row of each", before, after)
+	}
+}
+
+func TestCleanupSkippedWhenNotLeader(t *testing.T) {
+	clock := apitest.NewFakeClock(time.Now())
+	env := apitest.NewWithConfig(t, func(cfg *api.Config) {
+		cfg.Clock = clock
+		cfg.CleanupInterval = 0
+		cfg.CleanupLeader = false
+	})
+	seedExpiring(t, env, clock.Now())
+
+	api.StartCleanup()
+
+	if countRows(t, env, "reset_tokens", "tokenHash", "expired") != 1 || countRows(t, env, "sessions", "jti", "expired") != 1 {
+		t.Fatalf("an instance with CLEANUP_LEADER=false swept expired rows")
+	}
+}
@@ -0,0 +1,75 @@
+package api_test
+
+import (
+	"net"
+	"net/http"
+	"testing"
+
+	"github.com/BearCloud/fa20-project-dev/backend/auth-service/api"
+	"github.com/BearCloud/fa20-project-dev/backend/auth-service/apitest"
+)
+
+//getPolicyVia asks for the password policy from remoteAddr with forwardedFor as X-Forwarded-For and returns the status
+func getPolicyVia(env *apitest.Env, remoteAddr string, forwardedFor string) int {
+	req := env.Request(http.MethodGet, "/api/auth/policy", nil)
+	req.RemoteAddr = remoteAddr
+	if forwardedFor != "" {
+		req.Header.Set("X-Forwarded-For", forwardedFor)
+	}
+	return env.Send(req).Code
+}
+
+func TestRateLimitByForwardedClientBehindTrustedProxy(t *testing.T) {
+	_, proxies, _ := net.ParseCIDR("10.0.0.0/8")
+	env := apitest.NewWithConfig(t, func(cfg *api.Config) {
+		cfg.RateLimit = 1
+		cfg.TrustedProxies = []*net.IPNet{proxies}
+	})
+
+	if code := getPolicyVia(env, "10.0.0.2:4000", "198.51.100.7"); code != http.StatusOK {
+		t.Fatalf("first request from 198.51.100.7: got %d, want 200", code)
+	}
+	if code := getPolicyVia(env, "10.0.0.3:4000", "198.51.100.8, 10.0.0.9"); code != http.StatusOK {
+		t.Fatalf("first request from 198.51.100.8 through two proxies: got %d, want 200", code)
+	}
+	if code := getPolicyVia(env, "10.0.0.2:4000", "198.51.100.7"); code != http.StatusTooManyRequests {
+		t.Fatalf("second request from 198.51.100.7: got %d, want 429", code)
+	}
+	//a client can prepend whatever it likes, only the hop the trusted proxy appended counts
+	if code := getPolicyVia(env, "10.0.0.2:4000", "203.0.113.1, 198.51.100.7"); code != http.StatusTooManyRequests {
+		t.Fatalf("spoofed hop in front of 198.51.100.7: got %d, want 429", code)
+	}
+}
+
+func TestForwardedForIgnoredFromUntrustedClients(t *testing.T) {
+	env := apitest.NewWithConfig(t, func(cfg *api.Config) {
+		cfg.RateLimit = 1
+	})
+
+	if code := getPolicyVia(env, "198.51.100.7:4000", "203.0.113.1"); code != http.StatusOK {
+		t.Fatalf("first request: got %d, want 200", code)
+	}
+	if code := getPolicyVia(env, "198.51.100.7:4000", "203.0.113.2"); code != http.StatusTooManyRequests {
+		t.Fatalf("second request claiming another address: got %d, want 429", code)
+	}
+}
+
+func TestBypassGoesByForwardedClient(t *testing.T) {
+	_, proxies, _ := net.ParseCIDR("10.0.0.0/8")
+	_, office, _ := net.ParseCIDR("198.51.100.0/24")
+	env := apitest.NewWithConfig(t, func(cfg *api.Config) {
+		cfg.RateLimit = 1
+		cfg.TrustedProxies = []*net.IPNet{proxies}
+		cfg.BypassNetworks = []*net.IPNet{office}
+	})
+
+	for i := 0; i < 3; i++ {
+		if code := getPolicyVia(env, "10.0.0.2:4000", "198.51.100.7"); code != http.StatusOK {
+			t.Fatalf("request %d from the bypass network: got %d, want 200", i+1, code)
+		}
+	}
+	getPolicyVia(env, "10.0.0.2:4000", "203.0.113.1")
+	if code := getPolicyVia(env, "10.0.0.2:4000", "203.0.113.1"); code != http.StatusTooManyRequests {
+		t.Fatalf("second request from outside the bypass network: got %d, want 429", code)
+	}
+}
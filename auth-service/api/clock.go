@@ -0,0 +1,30 @@
+package api
+
+import "time"
+
+//Clock tells the api what time it is. Expiries, cooldowns, lockouts and every timestamp the api stores read it,
+//so tests can move time forward instead of sleeping.
+type Clock interface {
+	Now() time.Time
+}
+
+//RealClock is the Clock of a running service
+type RealClock struct{}
+
+//Now returns time.Now()
+func (RealClock) Now() time.Time {
+	return time.Now()
+}
+
+//clock is the Clock the api reads, set from Config.Clock
+var clock Clock = RealClock{}
+
+//since is time.Since on clock
+func since(t time.Time) time.Duration {
+	return clock.Now().Sub(t)
+}
+
+//until is time.Until on clock
+func until(t time.Time) time.Duration {
+	return t.Sub(clock.Now())
+}
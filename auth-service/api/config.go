@@ -0,0 +1,812 @@
+package api
+
+import (
+	"crypto/ecdsa"
+	"errors"
+	"log"
+	"net"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/sendgrid/sendgrid-go/helpers/mail"
+	"golang.org/x/crypto/bcrypt"
+)
+
+const (
+	//appEnvProduction requires every external service to be configured
+	appEnvProduction = "production"
+	//appEnvDev relaxes settings that local development can do without, such as the SendGrid key
+	appEnvDev = "dev"
+
+	//resetModeRotate generates a fresh reset token on every sendReset call
+	resetModeRotate = "rotate"
+	//resetModeKeep sends a fresh reset token but keeps earlier ones valid until they expire. Only token hashes are
+	//stored, so an earlier token can't be sent again.
+	resetModeKeep = "keep"
+)
+
+var (
+	//resetTokenMode controls how sendReset treats a reset token that is still valid
+	resetTokenMode = resetModeKeep
+	//bcryptCost is the work factor used when hashing passwords
+	bcryptCost = bcrypt.DefaultCost
+	//verifyAutoSignIn signs the user in when they follow their verification link for the first time
+	verifyAutoSignIn = false
+)
+
+//Config holds the service settings read from the environment
+type Config struct {
+	AppEnv             string
+	JWTSecret          string
+	JWTPrivateKey      string
+	JWTPreviousSecrets []string
+	JWTPreviousKeys    []string
+	AccessTokenTTL     time.Duration
+	RefreshTokenTTL    time.Duration
+	RememberMeTTL      time.Duration
+	ResetTokenTTL      time.Duration
+	DeletionGrace      time.Duration
+	UnverifiedGrace    time.Duration
+	AuditRetention     string
+	TokenLeeway        time.Duration
+	ReauthWindow       time.Duration
+	RequestTimeout     time.Duration
+	MaxInFlight        int
+	CleanupInterval    time.Duration
+	CleanupLeader      bool
+	ResetTokenMode     string
+	SignupMode         string
+	DefaultRole        string
+	AllowedDomains     []string
+	DeniedDomains      []string
+	CustomClaims       map[string]string
+	BcryptCost         int
+	HashAlgorithm      string
+	Argon2Memory       int
+	Argon2Time         int
+	Argon2Threads      int
+	MaxSessions        int
+	MaxLoginAttempts   int
+	LockoutDuration    time.Duration
+	SigninBackoffBase  time.Duration
+	SigninBackoffMax   time.Duration
+	DisplayNameMax     int
+	BannedPasswords    map[string]struct{}
+	SendGridWebhookKey *ecdsa.PublicKey
+	PasswordMinLength  int
+	PasswordClasses    []string
+	IdentityCooldown   time.Duration
+	RateLimit          int
+	RateLimitWindow    time.Duration
+	EmailIPLimit       int
+	EmailAccountLimit  int
+	EmailLimitWindow   time.Duration
+	NewSigninAlerts    bool
+	MigrationImport    bool
+	RequireVerified    bool
+	MaintenanceUntil   time.Time
+	IdempotencyTTL     time.Duration
+	BreakerThreshold   int
+	BreakerCooldown    time.Duration
+	ResetRequiresEmail bool
+	SigninAlertLimit   int
+	SigninAlertWindow  time.Duration
+	BypassNetworks     []*net.IPNet
+	TrustedProxies     []*net.IPNet
+	DBMaxOpenConns     int
+	DBMaxIdleConns     int
+	DBConnLifetime     time.Duration
+	DBMaxRetries       int
+	SendGridKey        string
+	SendGridBaseURL    string
+	TwilioAccountSID   string
+	TwilioAuthToken    string
+	TwilioFromNumber   string
+	TwilioBaseURL      string
+	SendGridTimeout    time.Duration
+	SenderName         string
+	SenderEmail        string
+	FrontendBaseURL    string
+	ResetLinkFormat    string
+	VerifySuccessURL   string
+	VerifyFailureURL   string
+	BrandName          string
+	EmailSubjects      map[string]string
+	EmailFromNames     map[string]string
+	SupportEmail       string
+	BrandLogoURL       string
+	EventTopic         string
+	EventTimeout       time.Duration
+	CORSOrigins        []string
+	AdminCORSOrigins   []string
+	CORSMaxAge         int
+	SecurityHeaders    map[string]string
+	CookiePrefix       string
+	WelcomeEmail       bool
+	VerifyAutoSignIn   bool
+	DebugErrors        bool
+	RequireHTTPS       bool
+	StrictJSON         bool
+	TrailingSlash      bool
+	NormalizeInput     bool
+	AccessLogFormat    string
+	//Clock is where the api reads the time, RealClock unless a test passes a fake one
+	Clock Clock
+
+	//problems collects values that could not be parsed while loading
+	problems []string
+	//effective maps every setting read while loading to the value in use, defaults included
+	effective map[string]string
+}
+
+//defaults holds what LoadConfig falls back to for unset settings, the values the settings' variables start with.
+//It is filled in once as the package is initialized, before ApplyConfig changes any of those variables, so a
+//configuration applied earlier never becomes the default of one loaded later.
+var defaults = Config{
+	AppEnv:             appEnvProduction,
+	ResetTokenMode:     resetTokenMode,
+	ResetRequiresEmail: resetRequiresEmail,
+	SignupMode:         signupMode,
+	DefaultRole:        defaultRole,
+	AuditRetention:     auditRetention,
+	SendGridBaseURL:    sendgridBaseURL,
+	TwilioBaseURL:      twilioBaseURL,
+	SenderName:         defaultSender.Name,
+	SenderEmail:        defaultSender.Address,
+	FrontendBaseURL:    frontendBaseURL,
+	ResetLinkFormat:    resetLinkTemplate,
+	BrandName:          brandName,
+	SupportEmail:       supportEmail,
+	BrandLogoURL:       brandLogoURL,
+	EventTopic:         eventTopic,
+	CookiePrefix:       cookiePrefix,
+	AccessTokenTTL:     DefaultAccessJWTExpiry,
+	RefreshTokenTTL:    DefaultRefreshJWTExpiry,
+	RememberMeTTL:      rememberMeRefreshExpiry,
+	ResetTokenTTL:      DefaultResetTokenExpiry,
+	DeletionGrace:      accountDeletionGrace,
+	UnverifiedGrace:    unverifiedGrace,
+	TokenLeeway:        tokenLeeway,
+	ReauthWindow:       reauthWindow,
+	RequestTimeout:     requestTimeout,
+	MaxInFlight:        maxInFlight,
+	IdempotencyTTL:     idempotencyTTL,
+	BreakerThreshold:   dbBreaker.threshold,
+	BreakerCooldown:    dbBreaker.cooldown,
+	CleanupInterval:    cleanupInterval,
+	SendGridTimeout:    emailSendTimeout,
+	EventTimeout:       eventPublishTimeout,
+	BcryptCost:         bcryptCost,
+	HashAlgorithm:      hashAlgorithm,
+	Argon2Memory:       int(argon2Memory),
+	Argon2Time:         int(argon2Time),
+	Argon2Threads:      int(argon2Threads),
+	MaxSessions:        maxSessionsPerUser,
+	MaxLoginAttempts:   maxLoginAttempts,
+	LockoutDuration:    lockoutDuration,
+	SigninBackoffBase:  signinBackoff.base,
+	SigninBackoffMax:   signinBackoff.max,
+	DisplayNameMax:     displayNameMaxLength,
+	PasswordMinLength:  passwordMinLength,
+	IdentityCooldown:   identityChangeCooldown,
+	DBMaxOpenConns:     dbMaxOpenConns,
+	DBMaxIdleConns:     dbMaxIdleConns,
+	DBConnLifetime:     dbConnMaxLifetime,
+	DBMaxRetries:       dbMaxRetries,
+	RateLimit:          publicRateLimit.limit,
+	RateLimitWindow:    publicRateLimit.window,
+	EmailIPLimit:       emailIPRateLimit.limit,
+	EmailAccountLimit:  emailAccountRateLimit.limit,
+	EmailLimitWindow:   emailIPRateLimit.window,
+	NewSigninAlerts:    newSigninAlerts,
+	MigrationImport:    migrationImport,
+	RequireVerified:    requireVerifiedEmail,
+	SigninAlertLimit:   newSigninAlertLimit.limit,
+	SigninAlertWindow:  newSigninAlertLimit.window,
+	CORSMaxAge:         publicCORS.MaxAge,
+	WelcomeEmail:       welcomeEmailEnabled,
+	VerifyAutoSignIn:   verifyAutoSignIn,
+	DebugErrors:        debugErrors,
+	RequireHTTPS:       requireHTTPS,
+	StrictJSON:         strictJSON,
+	TrailingSlash:      ignoreTrailingSlash,
+	NormalizeInput:     normalizeCredentials,
+	AccessLogFormat:    accessLogFormat,
+	CleanupLeader:      cleanupLeader,
+	EmailSubjects:      emailTemplateDefaults(func(t *emailTemplate) string { return t.subject }),
+	EmailFromNames:     emailTemplateDefaults(func(t *emailTemplate) string { return t.fromName }),
+	CORSOrigins:        publicCORS.AllowedOrigins,
+	SecurityHeaders:    securityHeaderValues,
+	Clock:              RealClock{},
+}
+
+//emailTemplateDefaults returns value of every registered email template, by the template's path
+func emailTemplateDefaults(value func(t *emailTemplate) string) map[string]string {
+	values := map[string]string{}
+	for _, t := range emailTemplates {
+		values[t.path] = value(t)
+	}
+	return values
+}
+
+//LoadConfig reads the configuration from environment variables, falling back to defaults for unset optional values
+func LoadConfig() Config {
+	cfg := Config{}
+	cfg.AppEnv = cfg.text("APP_ENV", defaults.AppEnv)
+	cfg.JWTSecret = cfg.env("JWT_SECRET")
+	cfg.JWTPrivateKey = cfg.env("JWT_PRIVATE_KEY_FILE")
+	cfg.JWTPreviousSecrets = splitList(cfg.env("JWT_PREVIOUS_SECRETS"))
+	cfg.JWTPreviousKeys = splitList(cfg.env("JWT_PREVIOUS_KEY_FILES"))
+	cfg.AllowedDomains = splitList(cfg.env("SIGNUP_ALLOWED_DOMAINS"))
+	cfg.DeniedDomains = splitList(cfg.env("SIGNUP_DENIED_DOMAINS"))
+	cfg.ResetTokenMode = cfg.text("RESET_TOKEN_MODE", defaults.ResetTokenMode)
+	cfg.ResetRequiresEmail = cfg.boolean("RESET_REQUIRE_EMAIL", defaults.ResetRequiresEmail)
+	cfg.SignupMode = cfg.text("SIGNUP_MODE", defaults.SignupMode)
+	cfg.DefaultRole = cfg.text("DEFAULT_ROLE", defaults.DefaultRole)
+	cfg.AuditRetention = cfg.text("AUDIT_RETENTION", defaults.AuditRetention)
+	cfg.SendGridKey = cfg.env("SENDGRID_KEY")
+	cfg.SendGridBaseURL = cfg.text("SENDGRID_BASE_URL", defaults.SendGridBaseURL)
+	cfg.TwilioAccountSID = cfg.env("TWILIO_ACCOUNT_SID")
+	cfg.TwilioAuthToken = cfg.env("TWILIO_AUTH_TOKEN")
+	cfg.TwilioFromNumber = cfg.env("TWILIO_FROM_NUMBER")
+	cfg.TwilioBaseURL = cfg.text("TWILIO_BASE_URL", defaults.TwilioBaseURL)
+	cfg.SenderName = cfg.text("SENDER_NAME", defaults.SenderName)
+	cfg.SenderEmail = cfg.text("SENDER_EMAIL", defaults.SenderEmail)
+	cfg.FrontendBaseURL = cfg.text("FRONTEND_BASE_URL", defaults.FrontendBaseURL)
+	cfg.ResetLinkFormat = cfg.text("RESET_LINK_TEMPLATE", defaults.ResetLinkFormat)
+	cfg.VerifySuccessURL = cfg.env("VERIFY_SUCCESS_REDIRECT_URL")
+	cfg.VerifyFailureURL = cfg.env("VERIFY_FAILURE_REDIRECT_URL")
+	cfg.BrandName = cfg.text("BRAND_NAME", defaults.BrandName)
+	cfg.EmailSubjects, cfg.EmailFromNames = map[string]string{}, map[string]string{}
+	for _, t := range emailTemplates {
+		cfg.EmailSubjects[t.path] = cfg.text("EMAIL_SUBJECT_"+t.setting, defaults.EmailSubjects[t.path])
+		cfg.EmailFromNames[t.path] = cfg.text("EMAIL_FROM_NAME_"+t.setting, defaults.EmailFromNames[t.path])
+	}
+	cfg.SupportEmail = cfg.text("SUPPORT_EMAIL", defaults.SupportEmail)
+	cfg.BrandLogoURL = cfg.text("BRAND_LOGO_URL", defaults.BrandLogoURL)
+	cfg.EventTopic = cfg.text("EVENTS_TOPIC", defaults.EventTopic)
+	cfg.CookiePrefix = cfg.text("COOKIE_PREFIX", defaults.CookiePrefix)
+	cfg.AccessTokenTTL = cfg.duration("ACCESS_TOKEN_TTL", defaults.AccessTokenTTL)
+	cfg.RefreshTokenTTL = cfg.duration("REFRESH_TOKEN_TTL", defaults.RefreshTokenTTL)
+	cfg.RememberMeTTL = cfg.duration("REMEMBER_ME_TTL", defaults.RememberMeTTL)
+	cfg.ResetTokenTTL = cfg.duration("RESET_TOKEN_TTL", defaults.ResetTokenTTL)
+	cfg.DeletionGrace = cfg.duration("ACCOUNT_DELETION_GRACE", defaults.DeletionGrace)
+	cfg.UnverifiedGrace = cfg.duration("UNVERIFIED_GRACE", defaults.UnverifiedGrace)
+	cfg.TokenLeeway = cfg.duration("TOKEN_LEEWAY", defaults.TokenLeeway)
+	cfg.ReauthWindow = cfg.duration("REAUTH_WINDOW", defaults.ReauthWindow)
+	cfg.RequestTimeout = cfg.duration("REQUEST_TIMEOUT", defaults.RequestTimeout)
+	cfg.MaxInFlight = cfg.integer("MAX_IN_FLIGHT_REQUESTS", defaults.MaxInFlight)
+	cfg.IdempotencyTTL = cfg.duration("IDEMPOTENCY_TTL", defaults.IdempotencyTTL)
+	cfg.BreakerThreshold = cfg.integer("DB_BREAKER_THRESHOLD", defaults.BreakerThreshold)
+	cfg.BreakerCooldown = cfg.duration("DB_BREAKER_COOLDOWN", defaults.BreakerCooldown)
+	cfg.CleanupInterval = cfg.duration("CLEANUP_INTERVAL", defaults.CleanupInterval)
+	cfg.SendGridTimeout = cfg.duration("SENDGRID_TIMEOUT", defaults.SendGridTimeout)
+	cfg.EventTimeout = cfg.duration("EVENTS_PUBLISH_TIMEOUT", defaults.EventTimeout)
+	cfg.BcryptCost = cfg.integer("BCRYPT_COST", defaults.BcryptCost)
+	cfg.HashAlgorithm = strings.ToLower(cfg.text("HASH_ALGORITHM", defaults.HashAlgorithm))
+	cfg.Argon2Memory = cfg.integer("ARGON2_MEMORY", defaults.Argon2Memory)
+	cfg.Argon2Time = cfg.integer("ARGON2_TIME", defaults.Argon2Time)
+	cfg.Argon2Threads = cfg.integer("ARGON2_PARALLELISM", defaults.Argon2Threads)
+	cfg.MaxSessions = cfg.integer("MAX_SESSIONS_PER_USER", defaults.MaxSessions)
+	cfg.MaxLoginAttempts = cfg.integer("MAX_LOGIN_ATTEMPTS", defaults.MaxLoginAttempts)
+	cfg.LockoutDuration = cfg.duration("LOCKOUT_DURATION", defaults.LockoutDuration)
+	cfg.SigninBackoffBase = cfg.duration("SIGNIN_BACKOFF_BASE", defaults.SigninBackoffBase)
+	cfg.SigninBackoffMax = cfg.duration("SIGNIN_BACKOFF_MAX", defaults.SigninBackoffMax)
+	cfg.DisplayNameMax = cfg.integer("DISPLAY_NAME_MAX_LENGTH", defaults.DisplayNameMax)
+	cfg.PasswordMinLength = cfg.integer("PASSWORD_MIN_LENGTH", defaults.PasswordMinLength)
+	cfg.PasswordClasses = splitList(strings.ToLower(cfg.env("PASSWORD_REQUIRED_CLASSES")))
+	cfg.IdentityCooldown = cfg.duration("IDENTITY_CHANGE_COOLDOWN", defaults.IdentityCooldown)
+	cfg.DBMaxOpenConns = cfg.integer("DB_MAX_OPEN_CONNS", defaults.DBMaxOpenConns)
+	cfg.DBMaxIdleConns = cfg.integer("DB_MAX_IDLE_CONNS", defaults.DBMaxIdleConns)
+	cfg.DBConnLifetime = cfg.duration("DB_CONN_MAX_LIFETIME", defaults.DBConnLifetime)
+	cfg.DBMaxRetries = cfg.integer("DB_MAX_RETRIES", defaults.DBMaxRetries)
+	cfg.RateLimit = cfg.integer("RATE_LIMIT", defaults.RateLimit)
+	cfg.RateLimitWindow = cfg.duration("RATE_LIMIT_WINDOW", defaults.RateLimitWindow)
+	cfg.EmailIPLimit = cfg.integer("EMAIL_RATE_LIMIT_IP", defaults.EmailIPLimit)
+	cfg.EmailAccountLimit = cfg.integer("EMAIL_RATE_LIMIT_ACCOUNT", defaults.EmailAccountLimit)
+	cfg.EmailLimitWindow = cfg.duration("EMAIL_RATE_LIMIT_WINDOW", defaults.EmailLimitWindow)
+	cfg.NewSigninAlerts = cfg.boolean("NEW_SIGNIN_ALERTS", defaults.NewSigninAlerts)
+	cfg.MigrationImport = cfg.boolean("MIGRATION_IMPORT_ENABLED", defaults.MigrationImport)
+	cfg.RequireVerified = cfg.boolean("REQUIRE_VERIFIED_EMAIL", defaults.RequireVerified)
+	cfg.SigninAlertLimit = cfg.integer("NEW_SIGNIN_ALERT_LIMIT", defaults.SigninAlertLimit)
+	cfg.SigninAlertWindow = cfg.duration("NEW_SIGNIN_ALERT_WINDOW", defaults.SigninAlertWindow)
+	cfg.CORSMaxAge = cfg.integer("CORS_MAX_AGE", defaults.CORSMaxAge)
+	cfg.WelcomeEmail = cfg.boolean("WELCOME_EMAIL_ENABLED", defaults.WelcomeEmail)
+	cfg.VerifyAutoSignIn = cfg.boolean("VERIFY_AUTO_SIGNIN", defaults.VerifyAutoSignIn)
+	cfg.DebugErrors = cfg.boolean("DEBUG_ERRORS", defaults.DebugErrors)
+	cfg.RequireHTTPS = cfg.boolean("REQUIRE_HTTPS", defaults.RequireHTTPS)
+	cfg.StrictJSON = cfg.boolean("STRICT_JSON", defaults.StrictJSON)
+	cfg.TrailingSlash = cfg.boolean("IGNORE_TRAILING_SLASH", defaults.TrailingSlash)
+	cfg.NormalizeInput = cfg.boolean("NORMALIZE_CREDENTIALS", defaults.NormalizeInput)
+	cfg.AccessLogFormat = cfg.text("ACCESS_LOG_FORMAT", defaults.AccessLogFormat)
+	cfg.CleanupLeader = cfg.boolean("CLEANUP_LEADER", defaults.CleanupLeader)
+	var claimProblems []string
+	cfg.CustomClaims, claimProblems = parseCustomClaims(cfg.env("CUSTOM_CLAIMS"))
+	cfg.problems = append(cfg.problems, claimProblems...)
+	var err error
+	cfg.BannedPasswords, err = loadBannedPasswords(cfg.env("BANNED_PASSWORDS_FILE"))
+	if err != nil {
+		cfg.problems = append(cfg.problems, "BANNED_PASSWORDS_FILE can't be read: "+err.Error())
+	}
+	if value := cfg.env("MAINTENANCE_UNTIL"); value != "" {
+		cfg.MaintenanceUntil, err = time.Parse(time.RFC3339, value)
+		if err != nil {
+			cfg.problems = append(cfg.problems, "MAINTENANCE_UNTIL must be empty or a time like \"2026-01-02T15:04:05Z\", got \""+value+"\"")
+		}
+	}
+	cfg.SendGridWebhookKey, err = parseWebhookKey(cfg.env("SENDGRID_WEBHOOK_KEY"))
+	if err != nil {
+		cfg.problems = append(cfg.problems, "SENDGRID_WEBHOOK_KEY must be the base64 ECDSA verification key SendGrid shows: "+err.Error())
+	}
+	var networkProblems []string
+	cfg.BypassNetworks, networkProblems = parseNetworks("LIMIT_BYPASS_CIDRS", cfg.env("LIMIT_BYPASS_CIDRS"))
+	cfg.problems = append(cfg.problems, networkProblems...)
+	cfg.TrustedProxies, networkProblems = parseNetworks("TRUSTED_PROXIES", cfg.env("TRUSTED_PROXIES"))
+	cfg.problems = append(cfg.problems, networkProblems...)
+	cfg.CORSOrigins = parseOrigins(os.Getenv("CORS_ALLOWED_ORIGINS"))
+	if len(cfg.CORSOrigins) == 0 {
+		cfg.CORSOrigins = defaults.CORSOrigins
+	}
+	cfg.record("CORS_ALLOWED_ORIGINS", strings.Join(cfg.CORSOrigins, ","))
+	cfg.AdminCORSOrigins = parseOrigins(os.Getenv("ADMIN_CORS_ALLOWED_ORIGINS"))
+	if len(cfg.AdminCORSOrigins) == 0 {
+		cfg.AdminCORSOrigins = cfg.CORSOrigins
+	}
+	cfg.record("ADMIN_CORS_ALLOWED_ORIGINS", strings.Join(cfg.AdminCORSOrigins, ","))
+	//each header can be overridden by its name in upper snake case, "off" disables it
+	cfg.SecurityHeaders = map[string]string{}
+	for header, value := range defaults.SecurityHeaders {
+		value = cfg.text(strings.ToUpper(strings.ReplaceAll(header, "-", "_")), value)
+		if value == "off" {
+			value = ""
+		}
+		cfg.SecurityHeaders[header] = value
+	}
+	cfg.Clock = defaults.Clock
+	return cfg
+}
+
+//Validate checks every setting and reports all of the problems found at once, one per line
+func (cfg Config) Validate() error {
+	problems := append([]string{}, cfg.problems...)
+	if _, err := cfg.signingKeys(); err != nil {
+		problems = append(problems, err.Error())
+	}
+	if cfg.AppEnv != appEnvProduction && cfg.AppEnv != appEnvDev {
+		problems = append(problems, "APP_ENV must be \""+appEnvProduction+"\" or \""+appEnvDev+"\", got \""+cfg.AppEnv+"\"")
+	}
+	//in dev an unset key logs emails instead of sending them, see InitMailer
+	if cfg.SendGridKey == "" && cfg.AppEnv != appEnvDev {
+		problems = append(problems, "SENDGRID_KEY is required unless APP_ENV is \""+appEnvDev+"\"")
+	}
+	if base, err := url.Parse(cfg.SendGridBaseURL); err != nil || base.Scheme == "" || base.Host == "" {
+		problems = append(problems, "SENDGRID_BASE_URL must be an absolute URL, got \""+cfg.SendGridBaseURL+"\"")
+	}
+	if cfg.TwilioAccountSID != "" {
+		if cfg.TwilioAuthToken == "" {
+			problems = append(problems, "TWILIO_AUTH_TOKEN is required when TWILIO_ACCOUNT_SID is set")
+		}
+		if !phonePattern.MatchString(cfg.TwilioFromNumber) {
+			problems = append(problems, "TWILIO_FROM_NUMBER must be a number in international format like +15105550100 when TWILIO_ACCOUNT_SID is set, got \""+cfg.TwilioFromNumber+"\"")
+		}
+	}
+	if base, err := url.Parse(cfg.TwilioBaseURL); err != nil || base.Scheme == "" || base.Host == "" {
+		problems = append(problems, "TWILIO_BASE_URL must be an absolute URL, got \""+cfg.TwilioBaseURL+"\"")
+	}
+	if cfg.SenderEmail == "" || !strings.Contains(cfg.SenderEmail, "@") {
+		problems = append(problems, "SENDER_EMAIL must be an email address, got \""+cfg.SenderEmail+"\"")
+	}
+	if base, err := url.Parse(cfg.FrontendBaseURL); err != nil || base.Scheme == "" || base.Host == "" {
+		problems = append(problems, "FRONTEND_BASE_URL must be an absolute URL, got \""+cfg.FrontendBaseURL+"\"")
+	}
+	if !strings.Contains(cfg.ResetLinkFormat, "{token}") {
+		problems = append(problems, "RESET_LINK_TEMPLATE must contain a {token} placeholder, got \""+cfg.ResetLinkFormat+"\"")
+	}
+	redirects := []struct {
+		name string
+		url  string
+	}{
+		{"VERIFY_SUCCESS_REDIRECT_URL", cfg.VerifySuccessURL},
+		{"VERIFY_FAILURE_REDIRECT_URL", cfg.VerifyFailureURL},
+	}
+	for _, redirect := range redirects {
+		if target, err := url.Parse(redirect.url); redirect.url != "" && (err != nil || target.Scheme == "" || target.Host == "") {
+			problems = append(problems, redirect.name+" must be empty or an absolute URL, got \""+redirect.url+"\"")
+		}
+	}
+	if strings.TrimSpace(cfg.BrandName) == "" {
+		problems = append(problems, "BRAND_NAME can't be blank")
+	}
+	for _, t := range emailTemplates {
+		if _, err := cleanText("EMAIL_SUBJECT_"+t.setting, cfg.EmailSubjects[t.path], maxEmailSubjectLength); err != nil {
+			problems = append(problems, err.Error())
+		}
+		if _, err := cleanText("EMAIL_FROM_NAME_"+t.setting, cfg.EmailFromNames[t.path], maxEmailSubjectLength); err != nil {
+			problems = append(problems, err.Error())
+		}
+	}
+	if cfg.SupportEmail != "" && !strings.Contains(cfg.SupportEmail, "@") {
+		problems = append(problems, "SUPPORT_EMAIL must be empty or an email address, got \""+cfg.SupportEmail+"\"")
+	}
+	if logo, err := url.Parse(cfg.BrandLogoURL); err != nil || logo.Scheme == "" || logo.Host == "" {
+		problems = append(problems, "BRAND_LOGO_URL must be an absolute URL, got \""+cfg.BrandLogoURL+"\"")
+	}
+	if !validCookiePrefix(cfg.CookiePrefix) {
+		problems = append(problems, "COOKIE_PREFIX may only contain letters, digits, \"_\", \"-\" and \".\", got \""+cfg.CookiePrefix+"\"")
+	}
+	if strings.TrimSpace(cfg.EventTopic) == "" {
+		problems = append(problems, "EVENTS_TOPIC can't be blank")
+	}
+	if cfg.ResetTokenMode != resetModeRotate && cfg.ResetTokenMode != resetModeKeep {
+		problems = append(problems, "RESET_TOKEN_MODE must be \""+resetModeRotate+"\" or \""+resetModeKeep+"\", got \""+cfg.ResetTokenMode+"\"")
+	}
+	if cfg.SignupMode != signupModeOpen && cfg.SignupMode != signupModeInvite && cfg.SignupMode != signupModeClosed {
+		problems = append(problems, "SIGNUP_MODE must be \""+signupModeOpen+"\", \""+signupModeInvite+"\" or \""+signupModeClosed+"\", got \""+cfg.SignupMode+"\"")
+	}
+	if cfg.AccessLogFormat != accessLogText && cfg.AccessLogFormat != accessLogJSON && cfg.AccessLogFormat != accessLogOff {
+		problems = append(problems, "ACCESS_LOG_FORMAT must be \""+accessLogText+"\", \""+accessLogJSON+"\" or \""+accessLogOff+"\", got \""+cfg.AccessLogFormat+"\"")
+	}
+	if !validRole(cfg.DefaultRole) {
+		problems = append(problems, "DEFAULT_ROLE must be lowercase letters, digits, \"_\" or \"-\", up to "+strconv.Itoa(roleMaxLength)+" characters, got \""+cfg.DefaultRole+"\"")
+	}
+	if cfg.AuditRetention != auditRetentionAnonymize && cfg.AuditRetention != auditRetentionDelete && cfg.AuditRetention != auditRetentionKeep {
+		problems = append(problems, "AUDIT_RETENTION must be \""+auditRetentionAnonymize+"\", \""+auditRetentionDelete+"\" or \""+auditRetentionKeep+"\", got \""+cfg.AuditRetention+"\"")
+	}
+	if cfg.HashAlgorithm != hashBcrypt && cfg.HashAlgorithm != hashArgon2id {
+		problems = append(problems, "HASH_ALGORITHM must be \""+hashBcrypt+"\" or \""+hashArgon2id+"\", got \""+cfg.HashAlgorithm+"\"")
+	}
+	if cfg.Argon2Threads < 1 || cfg.Argon2Threads > 255 {
+		problems = append(problems, "ARGON2_PARALLELISM must be between 1 and 255")
+	}
+	if cfg.Argon2Memory < 8*cfg.Argon2Threads || cfg.Argon2Memory > 4<<20 {
+		problems = append(problems, "ARGON2_MEMORY must be between 8 KiB per thread of ARGON2_PARALLELISM and 4 GiB, in KiB")
+	}
+	if cfg.Argon2Time < 1 {
+		problems = append(problems, "ARGON2_TIME must be at least 1")
+	}
+	if cfg.BcryptCost < bcrypt.MinCost || cfg.BcryptCost > bcrypt.MaxCost {
+		problems = append(problems, "BCRYPT_COST must be between "+strconv.Itoa(bcrypt.MinCost)+" and "+strconv.Itoa(bcrypt.MaxCost))
+	}
+	if cfg.TokenLeeway < 0 {
+		problems = append(problems, "TOKEN_LEEWAY can't be negative")
+	}
+	if cfg.CORSMaxAge < 0 {
+		problems = append(problems, "CORS_MAX_AGE can't be negative")
+	}
+	if cfg.MaxSessions < 0 {
+		problems = append(problems, "MAX_SESSIONS_PER_USER must be 0 (unlimited) or more")
+	}
+	if cfg.MaxLoginAttempts < 0 {
+		problems = append(problems, "MAX_LOGIN_ATTEMPTS must be 0 (no lockout) or more")
+	}
+	if cfg.SigninBackoffBase < 0 {
+		problems = append(problems, "SIGNIN_BACKOFF_BASE can't be negative")
+	}
+	if cfg.SigninBackoffMax < cfg.SigninBackoffBase {
+		problems = append(problems, "SIGNIN_BACKOFF_MAX can't be shorter than SIGNIN_BACKOFF_BASE")
+	}
+	if cfg.PasswordMinLength < 1 || cfg.PasswordMinLength > 72 {
+		problems = append(problems, "PASSWORD_MIN_LENGTH must be between 1 and 72, bcrypt ignores anything past 72 bytes")
+	}
+	for _, class := range cfg.PasswordClasses {
+		if _, ok := passwordClasses[class]; !ok {
+			problems = append(problems, "PASSWORD_REQUIRED_CLASSES may only list lower, upper, digit and symbol, got \""+class+"\"")
+		}
+	}
+	if cfg.DisplayNameMax < 1 || cfg.DisplayNameMax > 255 {
+		problems = append(problems, "DISPLAY_NAME_MAX_LENGTH must be between 1 and 255")
+	}
+	if cfg.DBMaxOpenConns < 0 {
+		problems = append(problems, "DB_MAX_OPEN_CONNS must be 0 (unlimited) or more")
+	}
+	if cfg.DBMaxIdleConns < 0 {
+		problems = append(problems, "DB_MAX_IDLE_CONNS can't be negative")
+	}
+	if cfg.DBConnLifetime < 0 {
+		problems = append(problems, "DB_CONN_MAX_LIFETIME must be 0 (forever) or more")
+	}
+	if cfg.UnverifiedGrace < 0 {
+		problems = append(problems, "UNVERIFIED_GRACE must be 0 (no limit) or more")
+	}
+	if cfg.RequestTimeout < 0 {
+		problems = append(problems, "REQUEST_TIMEOUT must be 0 (no limit) or more")
+	}
+	if cfg.MaxInFlight < 0 {
+		problems = append(problems, "MAX_IN_FLIGHT_REQUESTS must be 0 (no limit) or more")
+	}
+	if cfg.IdempotencyTTL < 0 {
+		problems = append(problems, "IDEMPOTENCY_TTL must be 0 (keys ignored) or more")
+	}
+	if cfg.BreakerThreshold < 0 {
+		problems = append(problems, "DB_BREAKER_THRESHOLD must be 0 (no breaker) or more")
+	}
+	if cfg.IdentityCooldown < 0 {
+		problems = append(problems, "IDENTITY_CHANGE_COOLDOWN must be 0 (no cooldown) or more")
+	}
+	if cfg.CleanupInterval < 0 {
+		problems = append(problems, "CLEANUP_INTERVAL must be 0 (only at startup) or more")
+	}
+	if cfg.DBMaxRetries < 0 {
+		problems = append(problems, "DB_MAX_RETRIES must be 0 (no retries) or more")
+	}
+	if cfg.RateLimit < 0 {
+		problems = append(problems, "RATE_LIMIT must be 0 (off) or more")
+	}
+	if cfg.EmailIPLimit < 0 {
+		problems = append(problems, "EMAIL_RATE_LIMIT_IP must be 0 (off) or more")
+	}
+	if cfg.EmailAccountLimit < 0 {
+		problems = append(problems, "EMAIL_RATE_LIMIT_ACCOUNT must be 0 (off) or more")
+	}
+	if cfg.SigninAlertLimit < 0 {
+		problems = append(problems, "NEW_SIGNIN_ALERT_LIMIT must be 0 (no limit) or more")
+	}
+	if cfg.RememberMeTTL < cfg.RefreshTokenTTL {
+		problems = append(problems, "REMEMBER_ME_TTL must not be shorter than REFRESH_TOKEN_TTL")
+	}
+	ttls := []struct {
+		name string
+		ttl  time.Duration
+	}{
+		{"ACCESS_TOKEN_TTL", cfg.AccessTokenTTL},
+		{"REFRESH_TOKEN_TTL", cfg.RefreshTokenTTL},
+		{"REMEMBER_ME_TTL", cfg.RememberMeTTL},
+		{"RESET_TOKEN_TTL", cfg.ResetTokenTTL},
+		{"ACCOUNT_DELETION_GRACE", cfg.DeletionGrace},
+		{"RATE_LIMIT_WINDOW", cfg.RateLimitWindow},
+		{"EMAIL_RATE_LIMIT_WINDOW", cfg.EmailLimitWindow},
+		{"NEW_SIGNIN_ALERT_WINDOW", cfg.SigninAlertWindow},
+		{"REAUTH_WINDOW", cfg.ReauthWindow},
+		{"DB_BREAKER_COOLDOWN", cfg.BreakerCooldown},
+		{"LOCKOUT_DURATION", cfg.LockoutDuration},
+		{"SENDGRID_TIMEOUT", cfg.SendGridTimeout},
+		{"EVENTS_PUBLISH_TIMEOUT", cfg.EventTimeout},
+	}
+	for _, t := range ttls {
+		if t.ttl <= 0 {
+			problems = append(problems, t.name+" must be positive")
+		}
+	}
+	if len(problems) == 0 {
+		return nil
+	}
+	return errors.New("invalid configuration:\n  - " + strings.Join(problems, "\n  - "))
+}
+
+//InitConfig loads and validates the service configuration, then applies it to the package settings.
+//Variables that look like misspelled settings are warned about, and the settings in use are logged with secrets redacted.
+func InitConfig() error {
+	cfg := LoadConfig()
+	for _, warning := range cfg.unknownSettings(os.Environ()) {
+		log.Print(warning)
+	}
+	err := ApplyConfig(cfg)
+	if err != nil {
+		return err
+	}
+	cfg.logEffective()
+	return nil
+}
+
+//ApplyConfig validates cfg and applies it to the package settings
+func ApplyConfig(cfg Config) error {
+	err := cfg.Validate()
+	if err != nil {
+		return err
+	}
+
+	signingKeys, err = cfg.signingKeys()
+	if err != nil {
+		return err
+	}
+	clock = cfg.Clock
+	if clock == nil {
+		clock = RealClock{}
+	}
+	DefaultAccessJWTExpiry = cfg.AccessTokenTTL
+	DefaultRefreshJWTExpiry = cfg.RefreshTokenTTL
+	rememberMeRefreshExpiry = cfg.RememberMeTTL
+	DefaultResetTokenExpiry = cfg.ResetTokenTTL
+	accountDeletionGrace = cfg.DeletionGrace
+	unverifiedGrace = cfg.UnverifiedGrace
+	auditRetention = cfg.AuditRetention
+	tokenLeeway = cfg.TokenLeeway
+	reauthWindow = cfg.ReauthWindow
+	requestTimeout = cfg.RequestTimeout
+	maxInFlight = cfg.MaxInFlight
+	idempotencyTTL = cfg.IdempotencyTTL
+	dbBreaker = &circuitBreaker{threshold: cfg.BreakerThreshold, cooldown: cfg.BreakerCooldown}
+	cleanupInterval = cfg.CleanupInterval
+	cleanupLeader = cfg.CleanupLeader
+	resetTokenMode = cfg.ResetTokenMode
+	resetRequiresEmail = cfg.ResetRequiresEmail
+	signupMode = cfg.SignupMode
+	defaultRole = cfg.DefaultRole
+	allowedEmailDomains = cfg.AllowedDomains
+	deniedEmailDomains = cfg.DeniedDomains
+	customClaims = cfg.CustomClaims
+	bcryptCost = cfg.BcryptCost
+	hashAlgorithm = cfg.HashAlgorithm
+	argon2Memory = uint32(cfg.Argon2Memory)
+	argon2Time = uint32(cfg.Argon2Time)
+	argon2Threads = uint8(cfg.Argon2Threads)
+	forgetDummyHash()
+	maxSessionsPerUser = cfg.MaxSessions
+	maxLoginAttempts = cfg.MaxLoginAttempts
+	lockoutDuration = cfg.LockoutDuration
+	signinBackoff = &failureBackoff{base: cfg.SigninBackoffBase, max: cfg.SigninBackoffMax, forget: cfg.LockoutDuration}
+	displayNameMaxLength = cfg.DisplayNameMax
+	bannedPasswords = cfg.BannedPasswords
+	sendgridWebhookKey = cfg.SendGridWebhookKey
+	passwordMinLength = cfg.PasswordMinLength
+	passwordRequiredClasses = cfg.PasswordClasses
+	identityChangeCooldown = cfg.IdentityCooldown
+	dbMaxOpenConns = cfg.DBMaxOpenConns
+	dbMaxIdleConns = cfg.DBMaxIdleConns
+	dbConnMaxLifetime = cfg.DBConnLifetime
+	dbMaxRetries = cfg.DBMaxRetries
+	publicRateLimit = &rateLimiter{limit: cfg.RateLimit, window: cfg.RateLimitWindow}
+	emailIPRateLimit = &rateLimiter{limit: cfg.EmailIPLimit, window: cfg.EmailLimitWindow}
+	emailAccountRateLimit = &rateLimiter{limit: cfg.EmailAccountLimit, window: cfg.EmailLimitWindow}
+	newSigninAlerts = cfg.NewSigninAlerts
+	migrationImport = cfg.MigrationImport
+	requireVerifiedEmail = cfg.RequireVerified
+	setMaintenance(cfg.MaintenanceUntil)
+	newSigninAlertLimit = &rateLimiter{limit: cfg.SigninAlertLimit, window: cfg.SigninAlertWindow}
+	limitBypassNetworks = cfg.BypassNetworks
+	trustedProxies = cfg.TrustedProxies
+	sendgridKey = cfg.SendGridKey
+	sendgridBaseURL = cfg.SendGridBaseURL
+	twilioAccountSID, twilioAuthToken, twilioFromNumber = cfg.TwilioAccountSID, cfg.TwilioAuthToken, cfg.TwilioFromNumber
+	twilioBaseURL = cfg.TwilioBaseURL
+	emailSendTimeout = cfg.SendGridTimeout
+	defaultSender = mail.NewEmail(cfg.SenderName, cfg.SenderEmail)
+	frontendBaseURL = cfg.FrontendBaseURL
+	resetLinkTemplate = cfg.ResetLinkFormat
+	verifySuccessRedirect = cfg.VerifySuccessURL
+	verifyFailureRedirect = cfg.VerifyFailureURL
+	brandName = cfg.BrandName
+	for _, t := range emailTemplates {
+		t.subject, t.fromName = cfg.EmailSubjects[t.path], cfg.EmailFromNames[t.path]
+	}
+	supportEmail = cfg.SupportEmail
+	brandLogoURL = cfg.BrandLogoURL
+	eventTopic = cfg.EventTopic
+	eventPublishTimeout = cfg.EventTimeout
+	welcomeEmailEnabled = cfg.WelcomeEmail
+	verifyAutoSignIn = cfg.VerifyAutoSignIn
+	debugErrors = cfg.DebugErrors
+	strictJSON = cfg.StrictJSON
+	ignoreTrailingSlash = cfg.TrailingSlash
+	normalizeCredentials = cfg.NormalizeInput
+	accessLogFormat = cfg.AccessLogFormat
+	//local development usually runs without TLS, so dev never enforces HTTPS
+	requireHTTPS = cfg.RequireHTTPS && cfg.AppEnv != appEnvDev
+	publicCORS.AllowedOrigins = cfg.CORSOrigins
+	adminCORS.AllowedOrigins = cfg.AdminCORSOrigins
+	publicCORS.MaxAge = cfg.CORSMaxAge
+	adminCORS.MaxAge = cfg.CORSMaxAge
+	securityHeaderValues = cfg.SecurityHeaders
+	cookiePrefix = cfg.CookiePrefix
+	return nil
+}
+
+//signingKeys loads the token signing keys. The primary key is an RSA key from JWT_PRIVATE_KEY_FILE if set
+//and JWT_SECRET otherwise. JWT_PREVIOUS_SECRETS and JWT_PREVIOUS_KEY_FILES are only trusted for verification.
+func (cfg Config) signingKeys() ([]signingKey, error) {
+	var keys []signingKey
+	if cfg.JWTPrivateKey != "" {
+		key, err := loadRSAKey(cfg.JWTPrivateKey)
+		if err != nil {
+			return nil, errors.New("JWT_PRIVATE_KEY_FILE must be a PEM encoded RSA private key: " + err.Error())
+		}
+		keys = append(keys, key)
+	} else if cfg.JWTSecret != "" {
+		keys = append(keys, hmacKey([]byte(cfg.JWTSecret)))
+	} else {
+		return nil, errors.New("JWT_SECRET is required unless JWT_PRIVATE_KEY_FILE is set")
+	}
+
+	//with RS256 as primary the current JWT_SECRET is still trusted, so switching algorithms is a rotation too
+	if cfg.JWTPrivateKey != "" && cfg.JWTSecret != "" {
+		keys = append(keys, hmacKey([]byte(cfg.JWTSecret)))
+	}
+	for _, secret := range cfg.JWTPreviousSecrets {
+		keys = append(keys, hmacKey([]byte(secret)))
+	}
+	for _, path := range cfg.JWTPreviousKeys {
+		key, err := loadRSAVerificationKey(path)
+		if err != nil {
+			return nil, errors.New("JWT_PREVIOUS_KEY_FILES must list PEM encoded RSA keys, " + path + ": " + err.Error())
+		}
+		keys = append(keys, key)
+	}
+	return keys, nil
+}
+
+//splitList splits a comma separated setting, dropping blank entries
+func splitList(value string) []string {
+	var list []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			list = append(list, item)
+		}
+	}
+	return list
+}
+
+//record notes the value in use for the setting name
+func (cfg *Config) record(name string, value string) {
+	if cfg.effective == nil {
+		cfg.effective = map[string]string{}
+	}
+	cfg.effective[name] = value
+}
+
+//env returns the environment variable name as is
+func (cfg *Config) env(name string) string {
+	value := os.Getenv(name)
+	cfg.record(name, value)
+	return value
+}
+
+//text returns the environment variable name, or fallback if it is unset
+func (cfg *Config) text(name string, fallback string) string {
+	value := os.Getenv(name)
+	if value == "" {
+		value = fallback
+	}
+	cfg.record(name, value)
+	return value
+}
+
+//duration parses the environment variable name as a time.Duration, recording a problem if it is malformed
+func (cfg *Config) duration(name string, fallback time.Duration) time.Duration {
+	value := os.Getenv(name)
+	parsed := fallback
+	if value != "" {
+		var err error
+		parsed, err = time.ParseDuration(value)
+		if err != nil {
+			cfg.problems = append(cfg.problems, name+" must be a duration like \"15m\" or \"24h\", got \""+value+"\"")
+			parsed = fallback
+		}
+	}
+	cfg.record(name, parsed.String())
+	return parsed
+}
+
+//integer parses the environment variable name as an int, recording a problem if it is malformed
+func (cfg *Config) integer(name string, fallback int) int {
+	value := os.Getenv(name)
+	parsed := fallback
+	if value != "" {
+		var err error
+		parsed, err = strconv.Atoi(value)
+		if err != nil {
+			cfg.problems = append(cfg.problems, name+" must be a whole number, got \""+value+"\"")
+			parsed = fallback
+		}
+	}
+	cfg.record(name, strconv.Itoa(parsed))
+	return parsed
+}
+
+//boolean parses the environment variable name as a bool, recording a problem if it is malformed
+func (cfg *Config) boolean(name string, fallback bool) bool {
+	value := os.Getenv(name)
+	parsed := fallback
+	if value != "" {
+		var err error
+		parsed, err = strconv.ParseBool(value)
+		if err != nil {
+			cfg.problems = append(cfg.problems, name+" must be true or false, got \""+value+"\"")
+			parsed = fallback
+		}
+	}
+	cfg.record(name, strconv.FormatBool(parsed))
+	return parsed
+}
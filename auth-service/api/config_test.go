@@ -0,0 +1,116 @@
+package api_test
+
+import (
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/BearCloud/fa20-project-dev/backend/auth-service/api"
+	"github.com/BearCloud/fa20-project-dev/backend/auth-service/apitest"
+)
+
+//setEnv sets the environment variables in vars until the test ends, restoring their earlier values
+func setEnv(t *testing.T, vars map[string]string) {
+	for name, value := range vars {
+		name := name
+		saved, set := os.LookupEnv(name)
+		os.Setenv(name, value)
+		t.Cleanup(func() {
+			if set {
+				os.Setenv(name, saved)
+			} else {
+				os.Unsetenv(name)
+			}
+		})
+	}
+}
+
+func TestConfigValidateListsEveryProblem(t *testing.T) {
+	setEnv(t, map[string]string{
+		"APP_ENV":          "production",
+		"JWT_SECRET":       "",
+		"SENDGRID_KEY":     "",
+		"ACCESS_TOKEN_TTL": "soon",
+		"SENDER_EMAIL":     "nobody",
+	})
+
+	err := api.LoadConfig().Validate()
+	if err == nil {
+		t.Fatalf("Validate: got no error")
+	}
+	for _, problem := range []string{"JWT_SECRET", "SENDGRID_KEY", "ACCESS_TOKEN_TTL", "SENDER_EMAIL"} {
+		if !strings.Contains(err.Error(), problem) {
+			t.Errorf("Validate doesn't mention %s:\n%v", problem, err)
+		}
+	}
+}
+
+func TestConfigParsesTypedSettings(t *testing.T) {
+	setEnv(t, map[string]string{
+		"ACCESS_TOKEN_TTL": "90m",
+		"STRICT_JSON":      "true",
+		"REQUIRE_HTTPS":    "0",
+		"RATE_LIMIT":       "42",
+	})
+
+	cfg := api.LoadConfig()
+	if cfg.AccessTokenTTL != 90*time.Minute || !cfg.StrictJSON || cfg.RequireHTTPS || cfg.RateLimit != 42 {
+		t.Fatalf("LoadConfig: got ACCESS_TOKEN_TTL %s, STRICT_JSON %t, REQUIRE_HTTPS %t and RATE_LIMIT %d", cfg.AccessTokenTTL, cfg.StrictJSON, cfg.RequireHTTPS, cfg.RateLimit)
+	}
+	effective := cfg.Effective()
+	if effective["ACCESS_TOKEN_TTL"] != "1h30m0s" || effective["STRICT_JSON"] != "true" || effective["RATE_LIMIT"] != "42" {
+		t.Fatalf("Effective: got %q, %q and %q, want the parsed values", effective["ACCESS_TOKEN_TTL"], effective["STRICT_JSON"], effective["RATE_LIMIT"])
+	}
+}
+
+func TestConfigRejectsMalformedValues(t *testing.T) {
+	setEnv(t, map[string]string{
+		"JWT_SECRET":        "config-test-secret",
+		"SENDGRID_KEY":      "config-test-key",
+		"REFRESH_TOKEN_TTL": "7 days",
+		"STRICT_JSON":       "yes please",
+		"RATE_LIMIT":        "lots",
+		"LOCKOUT_DURATION":  "-5m",
+	})
+
+	err := api.LoadConfig().Validate()
+	if err == nil {
+		t.Fatalf("Validate with malformed values: got no error")
+	}
+	for _, problem := range []string{
+		`REFRESH_TOKEN_TTL must be a duration like "15m" or "24h", got "7 days"`,
+		`STRICT_JSON must be true or false, got "yes please"`,
+		`RATE_LIMIT must be a whole number, got "lots"`,
+		"LOCKOUT_DURATION must be positive",
+	} {
+		if !strings.Contains(err.Error(), problem) {
+			t.Errorf("Validate doesn't report %s:\n%v", problem, err)
+		}
+	}
+}
+
+func TestConfigEffectiveRedactsSecrets(t *testing.T) {
+	setEnv(t, map[string]string{
+		"JWT_SECRET":   "do-not-log-me",
+		"SENDGRID_KEY": "",
+		"SENDER_EMAIL": "noreply@mixtape.com",
+	})
+
+	effective := api.LoadConfig().Effective()
+	if effective["JWT_SECRET"] != "[redacted]" || effective["SENDGRID_KEY"] != "" || effective["SENDER_EMAIL"] != "noreply@mixtape.com" {
+		t.Fatalf("Effective: got JWT_SECRET %q, SENDGRID_KEY %q and SENDER_EMAIL %q", effective["JWT_SECRET"], effective["SENDGRID_KEY"], effective["SENDER_EMAIL"])
+	}
+}
+
+func TestConfigDefaultsSurviveAppliedConfig(t *testing.T) {
+	apitest.NewWithConfig(t, func(cfg *api.Config) {
+		cfg.RateLimit = 2
+		cfg.AccessTokenTTL = time.Minute
+	})
+
+	cfg := api.LoadConfig()
+	if cfg.RateLimit != 60 || cfg.AccessTokenTTL != 24*time.Hour {
+		t.Fatalf("LoadConfig after applying RATE_LIMIT 2 and ACCESS_TOKEN_TTL 1m: got %d and %s, want the defaults 60 and 24h", cfg.RateLimit, cfg.AccessTokenTTL)
+	}
+}
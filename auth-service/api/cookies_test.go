@@ -0,0 +1,62 @@
+package api_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/BearCloud/fa20-project-dev/backend/auth-service/api"
+	"github.com/BearCloud/fa20-project-dev/backend/auth-service/apitest"
+)
+
+func TestCookieNamesPrefixed(t *testing.T) {
+	env := apitest.NewWithConfig(t, func(cfg *api.Config) {
+		cfg.CookiePrefix = "mixtape_"
+	})
+	creds := api.Credentials{Username: "bear", Email: "bear@berkeley.edu", Password: "pw"}
+	signUpVerified(t, env, creds)
+
+	res := env.Do(http.MethodPost, "/api/auth/signin", creds)
+	access, refresh := apitest.Cookie(res, "mixtape_access_token"), apitest.Cookie(res, "mixtape_refresh_token")
+	if access == nil || refresh == nil {
+		t.Fatalf("signin with COOKIE_PREFIX=mixtape_: got cookies %v", res.Result().Cookies())
+	}
+	if apitest.Cookie(res, "access_token") != nil || apitest.Cookie(res, "refresh_token") != nil {
+		t.Fatalf("signin with COOKIE_PREFIX=mixtape_ also set the unprefixed cookies")
+	}
+
+	profileUsername(t, "me with the prefixed cookie", getMe(env, "", access))
+	unprefixed := &http.Cookie{Name: "access_token", Value: access.Value}
+	if res := getMe(env, "", unprefixed); res.Code != http.StatusUnauthorized {
+		t.Fatalf("me with the unprefixed cookie: got %d, want 401", res.Code)
+	}
+
+	res = env.Do(http.MethodPost, "/api/auth/session/renew", nil, refresh)
+	if res.Code != http.StatusOK || apitest.Cookie(res, "mixtape_access_token") == nil || apitest.Cookie(res, "mixtape_refresh_token") == nil {
+		t.Fatalf("renew with the prefixed cookie: got %d with cookies %v", res.Code, res.Result().Cookies())
+	}
+	access, refresh = apitest.Cookie(res, "mixtape_access_token"), apitest.Cookie(res, "mixtape_refresh_token")
+
+	res = env.Do(http.MethodPost, "/api/auth/logout", nil, access, refresh)
+	var body api.LogoutResponse
+	json.NewDecoder(res.Body).Decode(&body)
+	if !reflect.DeepEqual(body.ClearedCookies, []string{"mixtape_access_token", "mixtape_refresh_token"}) || !body.SessionRevoked {
+		t.Fatalf("logout with the prefixed cookies: got %+v, want both cleared and the session revoked", body)
+	}
+	if cleared := apitest.Cookie(res, "mixtape_access_token"); cleared == nil || cleared.Value != "" {
+		t.Fatalf("logout didn't clear the prefixed access token cookie")
+	}
+}
+
+func TestCookiePrefixValidated(t *testing.T) {
+	cfg := api.LoadConfig()
+	cfg.JWTSecret = "cookie-test-secret"
+	cfg.SendGridKey = "cookie-test-key"
+	cfg.CookiePrefix = "mix tape;"
+	err := cfg.Validate()
+	if err == nil || !strings.Contains(err.Error(), "COOKIE_PREFIX") {
+		t.Fatalf("COOKIE_PREFIX with a space and a semicolon: got %v, want it rejected", err)
+	}
+}
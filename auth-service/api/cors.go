@@ -0,0 +1,112 @@
+package api
+
+import (
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+//defaultOrigin is the frontend allowed when CORS_ALLOWED_ORIGINS is unset
+const defaultOrigin = "http://18.209.20.242:3000"
+
+//CORSPolicy describes which browser origins may call a group of routes
+type CORSPolicy struct {
+	//AllowedOrigins holds exact origins and wildcard patterns (e.g. "https://*.mixtape.com")
+	AllowedOrigins []string
+	AllowedMethods string
+	AllowedHeaders string
+	//MaxAge is how many seconds browsers may cache a preflight response, 0 leaves it to the browser
+	MaxAge int
+}
+
+var (
+	//publicCORS applies to the endpoints used by the frontend
+	publicCORS = CORSPolicy{
+		AllowedOrigins: []string{defaultOrigin},
+		AllowedMethods: "GET, POST, PUT, PATCH, DELETE, OPTIONS",
+		AllowedHeaders: "Content-Type, Authorization",
+		MaxAge:         600,
+	}
+	//adminCORS applies to the admin endpoints and can be narrowed to internal tools
+	adminCORS = publicCORS
+)
+
+//parseOrigins splits a comma-separated origin list, dropping empty entries and a bare "*"
+func parseOrigins(list string) []string {
+	origins := []string{}
+	for _, origin := range strings.Split(list, ",") {
+		origin = strings.TrimSpace(origin)
+		if origin == "" {
+			continue
+		}
+		// Credentials are allowed, so browsers reject "*" and we never reflect it
+		if origin == "*" {
+			log.Println("ignoring \"*\" in allowed CORS origins since credentials are allowed")
+			continue
+		}
+		origins = append(origins, strings.TrimSuffix(origin, "/"))
+	}
+	return origins
+}
+
+//originAllowed reports whether origin matches one of the allowed origins.
+//A pattern like "*.mixtape.com" or "https://*.mixtape.com" matches any subdomain of mixtape.com.
+func originAllowed(origin string, allowed []string) bool {
+	if origin == "" {
+		return false
+	}
+	scheme, host := "", origin
+	if i := strings.Index(origin, "://"); i >= 0 {
+		scheme, host = origin[:i], origin[i+3:]
+	}
+	for _, pattern := range allowed {
+		if pattern == origin {
+			return true
+		}
+		patternScheme, patternHost := "", pattern
+		if i := strings.Index(pattern, "://"); i >= 0 {
+			patternScheme, patternHost = pattern[:i], pattern[i+3:]
+		}
+		if !strings.HasPrefix(patternHost, "*.") {
+			continue
+		}
+		if patternScheme != "" && patternScheme != scheme {
+			continue
+		}
+		if strings.HasSuffix(host, patternHost[1:]) && len(host) > len(patternHost)-1 {
+			return true
+		}
+	}
+	return false
+}
+
+//Middleware sets the CORS headers for policy and answers preflight requests.
+//Attach it to the router or subrouter whose routes the policy covers.
+func (policy CORSPolicy) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+
+		// Set headers
+		w.Header().Set("Access-Control-Allow-Headers", policy.AllowedHeaders)
+		w.Header().Add("Vary", "Origin")
+		if origin := r.Header.Get("Origin"); originAllowed(origin, policy.AllowedOrigins) {
+			w.Header().Set("Access-Control-Allow-Origin", origin)
+		}
+		w.Header().Set("Access-Control-Allow-Methods", policy.AllowedMethods)
+		w.Header().Set("Access-Control-Allow-Credentials", "true")
+
+		//Answer preflight requests here, they never reach the handlers
+		if r.Method == "OPTIONS" {
+			w.Header().Set("Allow", policy.AllowedMethods)
+			if policy.MaxAge > 0 {
+				w.Header().Set("Access-Control-Max-Age", strconv.Itoa(policy.MaxAge))
+			}
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+
+		// Next
+		next.ServeHTTP(w, r)
+		return
+	})
+}
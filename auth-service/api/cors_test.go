@@ -0,0 +1,110 @@
+package api_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+
+	"github.com/BearCloud/fa20-project-dev/backend/auth-service/api"
+	"github.com/BearCloud/fa20-project-dev/backend/auth-service/apitest"
+)
+
+//sendFrom sends a request with an Origin header and returns the response
+func sendFrom(env *apitest.Env, method string, path string, origin string, cookies ...*http.Cookie) *httptest.ResponseRecorder {
+	req := env.Request(method, path, nil, cookies...)
+	req.Header.Set("Origin", origin)
+	return env.Send(req)
+}
+
+func TestCORSAllowedOrigins(t *testing.T) {
+	env := apitest.NewWithConfig(t, func(cfg *api.Config) {
+		cfg.CORSOrigins = []string{"https://mixtape.com", "https://*.mixtape.com"}
+	})
+
+	for origin, allowed := range map[string]bool{
+		"https://mixtape.com":         true,
+		"https://admin.mixtape.com":   true,
+		"https://pr-12.mixtape.com":   true,
+		"http://admin.mixtape.com":    false,
+		"https://evilmixtape.com":     false,
+		"https://mixtape.com.evil.io": false,
+		"https://elsewhere.io":        false,
+	} {
+		res := sendFrom(env, http.MethodGet, "/api/auth/policy", origin)
+		got := res.Header().Get("Access-Control-Allow-Origin")
+		if allowed && got != origin {
+			t.Errorf("%s: got Access-Control-Allow-Origin %q, want it reflected", origin, got)
+		}
+		if !allowed && got != "" {
+			t.Errorf("%s: got Access-Control-Allow-Origin %q, want none", origin, got)
+		}
+		if res.Header().Get("Access-Control-Allow-Credentials") != "true" {
+			t.Errorf("%s: credentials aren't allowed", origin)
+		}
+	}
+}
+
+func TestCORSNeverAllowsAnyOrigin(t *testing.T) {
+	os.Setenv("CORS_ALLOWED_ORIGINS", "*, https://mixtape.com")
+	defer os.Unsetenv("CORS_ALLOWED_ORIGINS")
+	cfg := api.LoadConfig()
+	if len(cfg.CORSOrigins) != 1 || cfg.CORSOrigins[0] != "https://mixtape.com" {
+		t.Fatalf("CORS_ALLOWED_ORIGINS with \"*\": got %q, want only https://mixtape.com", cfg.CORSOrigins)
+	}
+}
+
+func TestCORSPolicyPerRouteGroup(t *testing.T) {
+	env := apitest.NewWithConfig(t, func(cfg *api.Config) {
+		cfg.CORSOrigins = []string{"https://mixtape.com"}
+		cfg.AdminCORSOrigins = []string{"https://tools.internal"}
+	})
+
+	for _, check := range []struct {
+		path    string
+		origin  string
+		allowed bool
+	}{
+		{"/api/auth/policy", "https://mixtape.com", true},
+		{"/api/auth/policy", "https://tools.internal", false},
+		{"/api/auth/admin/users", "https://tools.internal", true},
+		{"/api/auth/admin/users", "https://mixtape.com", false},
+	} {
+		res := sendFrom(env, http.MethodOptions, check.path, check.origin)
+		got := res.Header().Get("Access-Control-Allow-Origin")
+		if check.allowed && got != check.origin {
+			t.Errorf("preflight for %s from %s: got Access-Control-Allow-Origin %q, want it reflected", check.path, check.origin, got)
+		}
+		if !check.allowed && got != "" {
+			t.Errorf("preflight for %s from %s: got Access-Control-Allow-Origin %q, want none", check.path, check.origin, got)
+		}
+	}
+}
+
+func TestPreflightAnsweredWithNoContent(t *testing.T) {
+	env := apitest.NewWithConfig(t, func(cfg *api.Config) {
+		cfg.CORSOrigins = []string{"https://mixtape.com"}
+		cfg.CORSMaxAge = 3600
+	})
+
+	for _, path := range []string{"/api/auth/signin", "/api/auth/me"} {
+		res := sendFrom(env, http.MethodOptions, path, "https://mixtape.com")
+		if res.Code != http.StatusNoContent || res.Body.Len() != 0 {
+			t.Fatalf("preflight for %s: got %d %q, want 204 with no body", path, res.Code, res.Body.String())
+		}
+		if got := res.Header().Get("Access-Control-Max-Age"); got != "3600" {
+			t.Fatalf("preflight for %s: got Access-Control-Max-Age %q, want 3600", path, got)
+		}
+		if res.Header().Get("Allow") == "" || res.Header().Get("Access-Control-Allow-Methods") == "" {
+			t.Fatalf("preflight for %s: missing Allow or Access-Control-Allow-Methods", path)
+		}
+	}
+
+	env = apitest.NewWithConfig(t, func(cfg *api.Config) {
+		cfg.CORSMaxAge = 0
+	})
+	res := sendFrom(env, http.MethodOptions, "/api/auth/signin", "https://mixtape.com")
+	if _, ok := res.Header()["Access-Control-Max-Age"]; ok {
+		t.Fatalf("preflight with CORS_MAX_AGE=0 still sent Access-Control-Max-Age")
+	}
+}
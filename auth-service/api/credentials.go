@@ -1,8 +1,49 @@
 package api
 
+import "strings"
+
+//normalizeCredentials trims usernames and emails and lowercases emails before they are looked up or stored
+var normalizeCredentials = true
+
 //Credentials represents the user login object
 type Credentials struct {
 	Username string `json:"username"`
 	Email    string `json:"email"`
 	Password string `json:"password"`
-}
\ No newline at end of file
+	//DisplayName is optional and only read by signup
+	DisplayName string `json:"displayName,omitempty"`
+	//InviteCode is only read by signup when SIGNUP_MODE is "invite"
+	InviteCode string `json:"inviteCode,omitempty"`
+	//Phone is optional and only read by signup, it is where links go by SMS
+	Phone string `json:"phone,omitempty"`
+	//Channel is read by signup and sendReset, "sms" sends the link to the account's phone instead of its email
+	Channel string `json:"channel,omitempty"`
+	//RememberMe is only read by signin, it gives the refresh token REMEMBER_ME_TTL instead of REFRESH_TOKEN_TTL
+	RememberMe bool `json:"rememberMe,omitempty"`
+	//Code is only read by signin for accounts with two-factor authentication, an authenticator app code or a backup code
+	Code string `json:"code,omitempty"`
+}
+
+//normalize trims the username and email and lowercases the email when normalizeCredentials is set, so a pasted
+//" Bear@Berkeley.edu " finds bear@berkeley.edu. The password is never touched, its whitespace is part of it.
+func (credentials *Credentials) normalize() {
+	if !normalizeCredentials {
+		return
+	}
+	credentials.Username = strings.TrimSpace(credentials.Username)
+	credentials.Email = normalizeEmail(credentials.Email)
+}
+
+//normalizeEmail trims and lowercases email
+func normalizeEmail(email string) string {
+	return strings.ToLower(strings.TrimSpace(email))
+}
+
+//PasswordReset is the body of resetPassword. The reset token identifies the account, so the email is only needed
+//with RESET_REQUIRE_EMAIL. Token may be left out when the request keeps the token query parameter of the reset link.
+type PasswordReset struct {
+	Token           string `json:"token"`
+	Email           string `json:"email"`
+	NewPassword     string `json:"newPassword"`
+	ConfirmPassword string `json:"confirmPassword"`
+}
@@ -0,0 +1,71 @@
+package api_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"github.com/BearCloud/fa20-project-dev/backend/auth-service/api"
+	"github.com/BearCloud/fa20-project-dev/backend/auth-service/apitest"
+)
+
+func TestCredentialsNormalized(t *testing.T) {
+	env := apitest.New(t)
+	res := env.Do(http.MethodPost, "/api/auth/signup", api.Credentials{Username: "  bear ", Email: " Bear@Berkeley.edu\t", Password: " pw "})
+	if res.Code != http.StatusCreated {
+		t.Fatalf("signup: got %d %s", res.Code, res.Body.String())
+	}
+	var username, email string
+	env.DB.QueryRow("SELECT username, email FROM users;").Scan(&username, &email)
+	if username != "bear" || email != "bear@berkeley.edu" {
+		t.Fatalf("stored username %q and email %q, want them trimmed and the email lowercased", username, email)
+	}
+
+	signIn(t, env, api.Credentials{Email: "  BEAR@berkeley.edu ", Password: " pw "})
+	res = env.Do(http.MethodPost, "/api/auth/signin", api.Credentials{Email: "bear@berkeley.edu", Password: "pw"})
+	if res.Code != http.StatusUnauthorized {
+		t.Fatalf("signin with the password trimmed: got %d, want 401", res.Code)
+	}
+
+	res = env.Do(http.MethodPost, "/api/auth/sendreset", api.Credentials{Email: " Bear@berkeley.edu "})
+	if res.Code != http.StatusOK {
+		t.Fatalf("sendreset: got %d %s", res.Code, res.Body.String())
+	}
+	reset, ok := env.Mailer.LastFrom("bear@berkeley.edu", "password-reset.html")
+	if !ok {
+		t.Fatalf("no reset email to the normalized address")
+	}
+	res = env.Do(http.MethodPost, "/api/auth/resetpw", api.PasswordReset{Token: " " + reset.Token() + " ", NewPassword: " new pw ", ConfirmPassword: " new pw "})
+	if res.Code != http.StatusOK {
+		t.Fatalf("resetpw: got %d %s", res.Code, res.Body.String())
+	}
+	signIn(t, env, api.Credentials{Email: "bear@berkeley.edu", Password: " new pw "})
+}
+
+func TestSendResetBlankEmail(t *testing.T) {
+	env := apitest.New(t)
+	res := env.Do(http.MethodPost, "/api/auth/sendreset", api.Credentials{Email: "   "})
+	if res.Code != http.StatusNotAcceptable {
+		t.Fatalf("sendreset with a blank email: got %d %s, want 406", res.Code, res.Body.String())
+	}
+	var body api.ErrorResponse
+	err := json.NewDecoder(res.Body).Decode(&body)
+	if err != nil || body.Message != "invalid email address" || body.CorrelationID == "" {
+		t.Fatalf("sendreset with a blank email: got body %+v (%v), want a JSON error with a correlationId", body, err)
+	}
+}
+
+func TestCredentialsKeptAsSentWhenNormalizingOff(t *testing.T) {
+	env := apitest.NewWithConfig(t, func(cfg *api.Config) {
+		cfg.NormalizeInput = false
+	})
+	res := env.Do(http.MethodPost, "/api/auth/signup", api.Credentials{Username: "bear", Email: "Bear@Berkeley.edu", Password: "pw"})
+	if res.Code != http.StatusCreated {
+		t.Fatalf("signup: got %d %s", res.Code, res.Body.String())
+	}
+	var email string
+	env.DB.QueryRow("SELECT email FROM users;").Scan(&email)
+	if email != "Bear@Berkeley.edu" {
+		t.Fatalf("stored email %q with NORMALIZE_CREDENTIALS off, want it as sent", email)
+	}
+}
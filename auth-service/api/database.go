@@ -12,8 +12,22 @@ import (
 //DB represents the connection to the MySQL database
 var (
 	DB *sql.DB
+
+	//dbMaxOpenConns caps the connections open to MySQL at once, 0 means unlimited
+	dbMaxOpenConns = 25
+	//dbMaxIdleConns is how many idle connections are kept around for reuse
+	dbMaxIdleConns = 25
+	//dbConnMaxLifetime closes connections after this long so MySQL's wait_timeout never kills one in use, 0 keeps them forever
+	dbConnMaxLifetime = 5 * time.Minute
 )
 
+//configurePool applies the connection pool settings to db
+func configurePool(db *sql.DB) {
+	db.SetMaxOpenConns(dbMaxOpenConns)
+	db.SetMaxIdleConns(dbMaxIdleConns)
+	db.SetConnMaxLifetime(dbConnMaxLifetime)
+}
+
 //InitDB creates the MySQL database connection
 func InitDB() *sql.DB {
 
@@ -28,7 +42,7 @@ func InitDB() *sql.DB {
 	username := "root"
 	password := "root"
 	ipAddress := "tcp(172.28.1.2:3306)"
-	dbName := "/auth"
+	dbName := "/auth?parseTime=true"
 	// "YOUR CODE HERE"
 	// sql.Open("mysql", "theUser:thePassword@/theDbName")
 	DB, err = sql.Open(dbType, username + ":" + password + "@" + ipAddress + dbName)
@@ -46,5 +60,6 @@ func InitDB() *sql.DB {
 		DB, err = sql.Open(dbType, username + ":" + password + "@" + ipAddress + dbName)
 	}
 
+	configurePool(DB)
 	return DB
 }
\ No newline at end of file
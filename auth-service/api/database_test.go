@@ -0,0 +1,49 @@
+package api
+
+import (
+	"context"
+	"database/sql"
+	"testing"
+	"time"
+
+	//SQLite driver
+	_ "github.com/mattn/go-sqlite3"
+)
+
+func TestConfigurePool(t *testing.T) {
+	cfg := LoadConfig()
+	if cfg.DBMaxOpenConns != 25 || cfg.DBMaxIdleConns != 25 || cfg.DBConnLifetime != 5*time.Minute {
+		t.Fatalf("pool defaults: got %d open, %d idle, %s lifetime", cfg.DBMaxOpenConns, cfg.DBMaxIdleConns, cfg.DBConnLifetime)
+	}
+
+	savedOpen, savedIdle, savedLifetime := dbMaxOpenConns, dbMaxIdleConns, dbConnMaxLifetime
+	dbMaxOpenConns, dbMaxIdleConns, dbConnMaxLifetime = 3, 1, time.Hour
+	defer func() { dbMaxOpenConns, dbMaxIdleConns, dbConnMaxLifetime = savedOpen, savedIdle, savedLifetime }()
+
+	db, err := sql.Open("sqlite3", ":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	configurePool(db)
+
+	var conns []*sql.Conn
+	for i := 0; i < 3; i++ {
+		conn, err := db.Conn(context.Background())
+		if err != nil {
+			t.Fatal(err)
+		}
+		conns = append(conns, conn)
+	}
+	for _, conn := range conns {
+		conn.Close()
+	}
+
+	stats := db.Stats()
+	if stats.MaxOpenConnections != 3 {
+		t.Fatalf("DB_MAX_OPEN_CONNS=3: got a limit of %d", stats.MaxOpenConnections)
+	}
+	if stats.Idle != 1 || stats.MaxIdleClosed != 2 {
+		t.Fatalf("DB_MAX_IDLE_CONNS=1: got %d idle with %d closed, want 1 idle and the other 2 closed", stats.Idle, stats.MaxIdleClosed)
+	}
+}
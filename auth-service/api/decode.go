@@ -0,0 +1,34 @@
+package api
+
+import (
+	"encoding/json"
+	"io"
+	"strings"
+)
+
+var (
+	//strictJSON rejects request bodies with fields the handler doesn't read, so a typo like "passwrod" isn't
+	//silently ignored. It is off by default since clients sending newer fields would then break.
+	strictJSON = false
+)
+
+//unknownFieldPrefix starts the error encoding/json returns for a field the target doesn't have
+const unknownFieldPrefix = "json: unknown field "
+
+//decodeJSON decodes body into v, failing on unknown fields when strictJSON is on
+func decodeJSON(body io.Reader, v interface{}) error {
+	decoder := json.NewDecoder(body)
+	if strictJSON {
+		decoder.DisallowUnknownFields()
+	}
+	return decoder.Decode(v)
+}
+
+//decodeErrorMessage returns what a client sees when decoding its body failed: the offending field for
+//an unknown field, so the client can fix it, and fallback for anything else
+func decodeErrorMessage(err error, fallback string) string {
+	if strings.HasPrefix(err.Error(), unknownFieldPrefix) {
+		return "unknown field " + strings.TrimPrefix(err.Error(), unknownFieldPrefix)
+	}
+	return fallback
+}
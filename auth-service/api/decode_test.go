@@ -0,0 +1,47 @@
+package api_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/BearCloud/fa20-project-dev/backend/auth-service/api"
+	"github.com/BearCloud/fa20-project-dev/backend/auth-service/apitest"
+)
+
+//signupWithTypo is a valid signup body with a misspelled extra field
+const signupWithTypo = `{"username":"bear","email":"bear@berkeley.edu","password":"pw","passwrod":"pw"}`
+
+func TestStrictJSONRejectsUnknownFields(t *testing.T) {
+	env := apitest.NewWithConfig(t, func(cfg *api.Config) {
+		cfg.StrictJSON = true
+	})
+
+	res := env.Do(http.MethodPost, "/api/auth/signup", signupWithTypo)
+	var body api.ErrorResponse
+	json.NewDecoder(res.Body).Decode(&body)
+	if res.Code != http.StatusBadRequest || !strings.Contains(body.Message, `unknown field "passwrod"`) {
+		t.Fatalf("signup with a typo under STRICT_JSON: got %d %q, want 400 naming the field", res.Code, body.Message)
+	}
+
+	creds := api.Credentials{Username: "bear", Email: "bear@berkeley.edu", Password: "pw"}
+	signUpVerified(t, env, creds)
+	access, _ := signIn(t, env, creds)
+	res = env.Do(http.MethodPatch, "/api/auth/me", `{"displayName":"Oski","nickname":"Oski"}`, access)
+	json.NewDecoder(res.Body).Decode(&body)
+	if res.Code != http.StatusBadRequest || !strings.Contains(body.Message, `unknown field "nickname"`) {
+		t.Fatalf("PATCH with an unknown field under STRICT_JSON: got %d %q, want 400 naming the field", res.Code, body.Message)
+	}
+}
+
+func TestLenientJSONIgnoresUnknownFields(t *testing.T) {
+	env := apitest.NewWithConfig(t, func(cfg *api.Config) {
+		cfg.StrictJSON = false
+	})
+
+	res := env.Do(http.MethodPost, "/api/auth/signup", signupWithTypo)
+	if res.Code != http.StatusCreated {
+		t.Fatalf("signup with a typo without STRICT_JSON: got %d %s, want 201", res.Code, res.Body.String())
+	}
+}
@@ -0,0 +1,229 @@
+package api
+
+import (
+	"crypto/ecdsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"database/sql"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	//sendgridSignatureHeader carries the base64 ECDSA signature of a signed SendGrid event webhook
+	sendgridSignatureHeader = "X-Twilio-Email-Event-Webhook-Signature"
+	//sendgridTimestampHeader carries the Unix time SendGrid signed along with the body
+	sendgridTimestampHeader = "X-Twilio-Email-Event-Webhook-Timestamp"
+	//maxWebhookBody caps the size of a batch of events, SendGrid batches stay well below it
+	maxWebhookBody = 5 << 20
+	//webhookTolerance is how far a webhook's timestamp may be from now, so a captured request can't be replayed later
+	webhookTolerance = 10 * time.Minute
+	//maxDeliveryReasonLength matches the email_deliveries.reason column
+	maxDeliveryReasonLength = 255
+)
+
+const (
+	deliveryDelivered = "delivered"
+	deliveryBounced   = "bounced"
+	deliveryDropped   = "dropped"
+)
+
+//deliveryStatuses maps the SendGrid events that say whether an email arrived to the status recorded for them.
+//Other events, like processed or open, are ignored.
+var deliveryStatuses = map[string]string{
+	"delivered": deliveryDelivered,
+	"bounce":    deliveryBounced,
+	"dropped":   deliveryDropped,
+}
+
+//sendgridWebhookKey verifies signed SendGrid event webhooks, nil leaves the webhook endpoint unregistered
+var sendgridWebhookKey *ecdsa.PublicKey
+
+//parseWebhookKey parses the verification key SendGrid shows for a signed event webhook,
+//base64 encoded DER with or without the PEM header. An empty value means no key.
+func parseWebhookKey(value string) (*ecdsa.PublicKey, error) {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return nil, nil
+	}
+	der, err := base64.StdEncoding.DecodeString(value)
+	if block, _ := pem.Decode([]byte(value)); block != nil {
+		der, err = block.Bytes, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	key, err := x509.ParsePKIXPublicKey(der)
+	if err != nil {
+		return nil, err
+	}
+	ecdsaKey, ok := key.(*ecdsa.PublicKey)
+	if !ok {
+		return nil, errors.New("not an ECDSA public key")
+	}
+	return ecdsaKey, nil
+}
+
+//sendgridEvent is the part of a SendGrid event webhook entry the service uses
+type sendgridEvent struct {
+	Email     string `json:"email"`
+	Event     string `json:"event"`
+	Timestamp int64  `json:"timestamp"`
+	Reason    string `json:"reason"`
+}
+
+//validSendgridSignature reports whether signature is SendGrid's signature of timestamp followed by body
+func validSendgridSignature(signature string, timestamp string, body []byte) bool {
+	decoded, err := base64.StdEncoding.DecodeString(signature)
+	if err != nil {
+		return false
+	}
+	hash := sha256.Sum256(append([]byte(timestamp), body...))
+	return ecdsa.VerifyASN1(sendgridWebhookKey, hash[:], decoded)
+}
+
+//recordDelivery stores status as the latest delivery status of email, unless a later event is already stored.
+//SendGrid doesn't promise to send events in order, so an older event must not overwrite a newer one.
+func recordDelivery(email string, status string, reason string, eventAt time.Time) error {
+	now := clock.Now()
+	result, err := DB.Exec("UPDATE email_deliveries SET status = ?, reason = ?, eventAt = ?, updatedAt = ? WHERE email = ? AND eventAt <= ?;", status, reason, eventAt, now, email, eventAt)
+	if err != nil {
+		return err
+	}
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if affected > 0 {
+		return nil
+	}
+	_, err = DB.Exec("INSERT INTO email_deliveries (email, status, reason, eventAt, updatedAt) VALUES (?, ?, ?, ?, ?);", email, status, reason, eventAt, now)
+	//the row exists with a later event, or a concurrent event inserted it first, either way it is up to date
+	if err != nil && isDuplicateKey(err) {
+		return nil
+	}
+	return err
+}
+
+//sendgridWebhook ingests SendGrid's event webhook and records whether each email was delivered, bounced or dropped
+func sendgridWebhook(w http.ResponseWriter, r *http.Request) {
+	body, err := ioutil.ReadAll(http.MaxBytesReader(w, r.Body, maxWebhookBody))
+	if err != nil {
+		writeJSONError(w, r, http.StatusBadRequest, "issue reading events")
+		return
+	}
+
+	//the signature covers the raw body, so it is checked before anything is parsed
+	timestamp := r.Header.Get(sendgridTimestampHeader)
+	if !validSendgridSignature(r.Header.Get(sendgridSignatureHeader), timestamp, body) {
+		writeJSONError(w, r, http.StatusUnauthorized, "invalid webhook signature")
+		return
+	}
+	signedAt, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil || since(time.Unix(signedAt, 0)) > webhookTolerance || until(time.Unix(signedAt, 0)) > webhookTolerance {
+		writeJSONError(w, r, http.StatusUnauthorized, "webhook timestamp is missing or out of range")
+		return
+	}
+
+	//events carry many fields the service doesn't use, so they aren't decoded with decodeJSON, which STRICT_JSON makes refuse them
+	var events []sendgridEvent
+	err = json.Unmarshal(body, &events)
+	if err != nil {
+		writeJSONError(w, r, http.StatusBadRequest, "issue decoding events")
+		return
+	}
+
+	for _, event := range events {
+		status, ok := deliveryStatuses[event.Event]
+		if !ok || event.Email == "" {
+			continue
+		}
+		reason := event.Reason
+		if len(reason) > maxDeliveryReasonLength {
+			reason = reason[:maxDeliveryReasonLength]
+		}
+		err = recordDelivery(strings.ToLower(event.Email), status, reason, time.Unix(event.Timestamp, 0))
+		if err != nil {
+			//a 500 makes SendGrid retry the whole batch, which is safe since older events never overwrite newer ones
+			internalError(w, r, "error recording email delivery", err)
+			return
+		}
+	}
+
+	writeJSONSuccess(w, http.StatusOK, "events recorded")
+}
+
+//Delivery is the latest delivery status SendGrid reported for an email address
+type Delivery struct {
+	Email   string     `json:"email"`
+	Status  string     `json:"status"`
+	Reason  string     `json:"reason,omitempty"`
+	EventAt *time.Time `json:"eventAt,omitempty"`
+}
+
+//BouncesResponse is the JSON body returned when listing undeliverable addresses
+type BouncesResponse struct {
+	SuccessResponse
+	Bounces []Delivery `json:"bounces"`
+}
+
+//listBounces lists the addresses whose latest email bounced or was dropped, newest first, so support can reach out
+func listBounces(w http.ResponseWriter, r *http.Request) {
+
+	if (*r).Method == "OPTIONS" {
+		return
+	}
+
+	limit := defaultUserPageSize
+	if value := r.URL.Query().Get("limit"); value != "" {
+		var err error
+		limit, err = strconv.Atoi(value)
+		if err != nil || limit < 1 {
+			writeJSONError(w, r, http.StatusBadRequest, "limit must be a positive number")
+			return
+		}
+		if limit > maxUserPageSize {
+			limit = maxUserPageSize
+		}
+	}
+
+	rows, err := DB.Query("SELECT email, status, reason, eventAt FROM email_deliveries WHERE status IN (?, ?) ORDER BY eventAt DESC LIMIT ?;", deliveryBounced, deliveryDropped, limit)
+	if err != nil {
+		internalError(w, r, "error retrieving bounces", err)
+		return
+	}
+	defer rows.Close()
+
+	bounces := []Delivery{}
+	for rows.Next() {
+		var delivery Delivery
+		var reason sql.NullString
+		var eventAt sql.NullTime
+		err = rows.Scan(&delivery.Email, &delivery.Status, &reason, &eventAt)
+		if err != nil {
+			internalError(w, r, "error retrieving bounces", err)
+			return
+		}
+		delivery.Reason = reason.String
+		if eventAt.Valid {
+			delivery.EventAt = &eventAt.Time
+		}
+		bounces = append(bounces, delivery)
+	}
+	if err = rows.Err(); err != nil {
+		internalError(w, r, "error retrieving bounces", err)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, BouncesResponse{
+		SuccessResponse: SuccessResponse{Status: "ok", Message: "bounces retrieved"},
+		Bounces:         bounces,
+	})
+}
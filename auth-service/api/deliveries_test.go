@@ -0,0 +1,151 @@
+package api_test
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/BearCloud/fa20-project-dev/backend/auth-service/api"
+	"github.com/BearCloud/fa20-project-dev/backend/auth-service/apitest"
+)
+
+//webhookKey generates a key to sign SendGrid events with
+func webhookKey(t *testing.T) *ecdsa.PrivateKey {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return key
+}
+
+//signEvents returns the base64 signature SendGrid sends for body signed at timestamp
+func signEvents(t *testing.T, key *ecdsa.PrivateKey, timestamp string, body string) string {
+	t.Helper()
+	hash := sha256.Sum256([]byte(timestamp + body))
+	signature, err := ecdsa.SignASN1(rand.Reader, key, hash[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+	return base64.StdEncoding.EncodeToString(signature)
+}
+
+//sendEvents sends body to the SendGrid webhook with the given signature and timestamp headers
+func sendEvents(env *apitest.Env, signature string, timestamp string, body string) *httptest.ResponseRecorder {
+	req := env.Request(http.MethodPost, "/api/auth/webhooks/sendgrid", body)
+	req.Header.Set("X-Twilio-Email-Event-Webhook-Signature", signature)
+	req.Header.Set("X-Twilio-Email-Event-Webhook-Timestamp", timestamp)
+	return env.Send(req)
+}
+
+//postEvents sends body to the SendGrid webhook signed with key at signedAt, as SendGrid would
+func postEvents(t *testing.T, env *apitest.Env, key *ecdsa.PrivateKey, signedAt time.Time, body string) *httptest.ResponseRecorder {
+	t.Helper()
+	timestamp := strconv.FormatInt(signedAt.Unix(), 10)
+	return sendEvents(env, signEvents(t, key, timestamp, body), timestamp, body)
+}
+
+//sendgridEvents is a webhook body with one event per "email event" pair, all at eventAt
+func sendgridEvents(eventAt time.Time, pairs ...string) string {
+	var events []string
+	for _, pair := range pairs {
+		fields := strings.Fields(pair)
+		events = append(events, fmt.Sprintf(`{"email":%q,"event":%q,"timestamp":%d,"reason":"550 mailbox unavailable","sg_event_id":"x"}`, fields[0], fields[1], eventAt.Unix()))
+	}
+	return "[" + strings.Join(events, ",") + "]"
+}
+
+//listBounces returns the bounced and dropped addresses an admin sees
+func listBounces(t *testing.T, env *apitest.Env, admin *http.Cookie) []api.Delivery {
+	t.Helper()
+	res := env.Do(http.MethodGet, "/api/auth/admin/bounces", nil, admin)
+	if res.Code != http.StatusOK {
+		t.Fatalf("listing bounces: got %d %s", res.Code, res.Body.String())
+	}
+	var body api.BouncesResponse
+	json.NewDecoder(res.Body).Decode(&body)
+	return body.Bounces
+}
+
+func TestSignedDeliveryEventsRecorded(t *testing.T) {
+	clock := apitest.NewFakeClock(time.Date(2020, 10, 1, 9, 0, 0, 0, time.UTC))
+	key := webhookKey(t)
+	env := apitest.NewWithConfig(t, func(cfg *api.Config) {
+		cfg.Clock = clock
+		cfg.SendGridWebhookKey = &key.PublicKey
+	})
+	admin := signInAdmin(t, env, api.Credentials{Username: "oski", Email: "oski@berkeley.edu", Password: "pw"})
+
+	body := sendgridEvents(clock.Now(), "bear@berkeley.edu delivered", "Tree@Stanford.edu bounce", "golden@bears.org open")
+	expectSuccess(t, "signed delivered and bounced events", postEvents(t, env, key, clock.Now(), body), http.StatusOK, "events recorded")
+
+	bounces := listBounces(t, env, admin)
+	if len(bounces) != 1 || bounces[0].Email != "tree@stanford.edu" || bounces[0].Status != "bounced" || bounces[0].Reason != "550 mailbox unavailable" {
+		t.Fatalf("bounces: got %+v, want only tree's bounce with its reason", bounces)
+	}
+	var status string
+	env.DB.QueryRow("SELECT status FROM email_deliveries WHERE email = ?;", "bear@berkeley.edu").Scan(&status)
+	if status != "delivered" {
+		t.Fatalf("bear's delivery: got status %q, want delivered", status)
+	}
+
+	//a delivery that SendGrid reports late doesn't hide the newer bounce
+	older := sendgridEvents(clock.Now().Add(-time.Minute), "tree@stanford.edu delivered")
+	expectSuccess(t, "an older delivered event", postEvents(t, env, key, clock.Now(), older), http.StatusOK, "events recorded")
+	if bounces := listBounces(t, env, admin); len(bounces) != 1 {
+		t.Fatalf("bounces after an older delivered event: got %+v, want tree's bounce kept", bounces)
+	}
+}
+
+func TestUnsignedDeliveryEventsRejected(t *testing.T) {
+	clock := apitest.NewFakeClock(time.Date(2020, 10, 1, 9, 0, 0, 0, time.UTC))
+	key := webhookKey(t)
+	env := apitest.NewWithConfig(t, func(cfg *api.Config) {
+		cfg.Clock = clock
+		cfg.SendGridWebhookKey = &key.PublicKey
+	})
+	body := sendgridEvents(clock.Now(), "tree@stanford.edu bounce")
+	timestamp := strconv.FormatInt(clock.Now().Unix(), 10)
+
+	for _, check := range []struct {
+		step string
+		res  *httptest.ResponseRecorder
+	}{
+		{"events signed with another key", postEvents(t, env, webhookKey(t), clock.Now(), body)},
+		{"events changed after signing", sendEvents(env, signEvents(t, key, timestamp, body), timestamp, strings.Replace(body, "tree", "bear", 1))},
+		{"a signature for another timestamp", sendEvents(env, signEvents(t, key, timestamp, body), strconv.FormatInt(clock.Now().Unix()+1, 10), body)},
+		{"events without a signature", sendEvents(env, "", timestamp, body)},
+		{"events signed an hour ago", postEvents(t, env, key, clock.Now().Add(-time.Hour), body)},
+		{"events signed an hour ahead", postEvents(t, env, key, clock.Now().Add(time.Hour), body)},
+	} {
+		if check.res.Code != http.StatusUnauthorized {
+			t.Errorf("%s: got %d %s, want 401", check.step, check.res.Code, check.res.Body.String())
+		}
+	}
+
+	var count int
+	env.DB.QueryRow("SELECT COUNT(*) FROM email_deliveries;").Scan(&count)
+	if count != 0 {
+		t.Fatalf("rejected events were recorded: got %d deliveries", count)
+	}
+}
+
+func TestDeliveryWebhookOffWithoutKey(t *testing.T) {
+	env := apitest.NewWithConfig(t, func(cfg *api.Config) {
+		cfg.SendGridWebhookKey = nil
+	})
+	body := sendgridEvents(time.Now(), "tree@stanford.edu bounce")
+	if res := postEvents(t, env, webhookKey(t), time.Now(), body); res.Code != http.StatusNotFound {
+		t.Fatalf("webhook without SENDGRID_WEBHOOK_KEY: got %d, want 404", res.Code)
+	}
+}
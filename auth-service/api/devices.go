@@ -0,0 +1,111 @@
+package api
+
+import (
+	"context"
+	"log"
+	"strings"
+	"time"
+)
+
+var (
+	//newSigninAlerts emails users when they sign in from a device and IP address none of their sessions used before
+	newSigninAlerts = false
+	//newSigninAlertLimit caps how many new signin alerts each account is sent, so a roaming user isn't flooded
+	newSigninAlertLimit = &rateLimiter{limit: 3, window: 24 * time.Hour}
+)
+
+//unknownDevice is the device name of a user agent deviceFingerprint can't place
+const unknownDevice = "Unknown device"
+
+//userAgentMatch pairs a name with the user agent substrings that identify it
+type userAgentMatch struct {
+	name    string
+	markers []string
+}
+
+//userAgentBrowsers are checked in order, since e.g. Edge and Opera also claim to be Chrome, and Chrome to be Safari
+var userAgentBrowsers = []userAgentMatch{
+	{"Edge", []string{"Edg/", "EdgA/", "EdgiOS/"}},
+	{"Opera", []string{"OPR/", "Opera"}},
+	{"Firefox", []string{"Firefox/", "FxiOS/"}},
+	{"Chrome", []string{"Chrome/", "CriOS/"}},
+	{"Safari", []string{"Safari/"}},
+}
+
+//userAgentSystems are checked in order, since e.g. Android also claims to be Linux and iOS to be like Mac OS X
+var userAgentSystems = []userAgentMatch{
+	{"Windows", []string{"Windows"}},
+	{"iOS", []string{"iPhone", "iPad", "iPod"}},
+	{"Android", []string{"Android"}},
+	{"ChromeOS", []string{"CrOS"}},
+	{"macOS", []string{"Macintosh", "Mac OS X"}},
+	{"Linux", []string{"Linux"}},
+}
+
+//matchUserAgent returns the name of the first of matches whose markers appear in userAgent, or ""
+func matchUserAgent(userAgent string, matches []userAgentMatch) string {
+	for _, match := range matches {
+		for _, marker := range match.markers {
+			if strings.Contains(userAgent, marker) {
+				return match.name
+			}
+		}
+	}
+	return ""
+}
+
+//deviceFingerprint reduces a user agent to a coarse device name like "Firefox on Linux". Versions are left out,
+//so a browser update doesn't make a known device look new.
+func deviceFingerprint(userAgent string) string {
+	browser := matchUserAgent(userAgent, userAgentBrowsers)
+	system := matchUserAgent(userAgent, userAgentSystems)
+	switch {
+	case browser == "" && system == "":
+		return unknownDevice
+	case system == "":
+		return browser
+	case browser == "":
+		return "Unknown browser on " + system
+	}
+	return browser + " on " + system
+}
+
+//isNewDevice reports whether none of userID's sessions came from ipAddress on device. A user with no session
+//that recorded its device, like every account before devices were tracked, has nothing to compare with,
+//so their devices aren't new.
+func isNewDevice(userID string, ipAddress string, device string) (bool, error) {
+	var seen, tracked bool
+	err := withRetry(func() error {
+		return DB.QueryRow("SELECT EXISTS(SELECT * FROM sessions WHERE userId = ? AND ipAddress = ? AND device = ?), EXISTS(SELECT * FROM sessions WHERE userId = ? AND device IS NOT NULL);", userID, ipAddress, device, userID).
+			Scan(&seen, &tracked)
+	})
+	if err != nil {
+		return false, err
+	}
+	return tracked && !seen, nil
+}
+
+//sendNewSigninAlert emails userID that they signed in from ipAddress on device, in the background so signin
+//doesn't wait on SendGrid. Alerts past newSigninAlertLimit are dropped.
+func sendNewSigninAlert(userID string, ipAddress string, device string, signedInAt time.Time) {
+	if _, ok := newSigninAlertLimit.allow(userID); !ok {
+		return
+	}
+	var email, username string
+	err := DB.QueryRow("SELECT email, username FROM users WHERE userId = ?;", userID).Scan(&email, &username)
+	if err != nil {
+		log.Print("error looking up new signin alert recipient: " + err.Error())
+		return
+	}
+	go func() {
+		err := SendEmail(context.Background(), email, "new-signin.html", map[string]interface{}{
+			"Username":  username,
+			"Device":    device,
+			"IPAddress": ipAddress,
+			"Time":      signedInAt.UTC().Format("January 2, 2006 at 15:04 MST"),
+		})
+		if err != nil {
+			log.Print("error sending new signin alert: " + err.Error())
+		}
+	}()
+}
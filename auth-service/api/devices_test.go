@@ -0,0 +1,89 @@
+package api_test
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/BearCloud/fa20-project-dev/backend/auth-service/api"
+	"github.com/BearCloud/fa20-project-dev/backend/auth-service/apitest"
+)
+
+const (
+	firefoxOnLinux = "Mozilla/5.0 (X11; Linux x86_64; rv:81.0) Gecko/20100101 Firefox/81.0"
+	safariOnIPhone = "Mozilla/5.0 (iPhone; CPU iPhone OS 14_0 like Mac OS X) AppleWebKit/605.1.15 (KHTML, like Gecko) Version/14.0 Mobile/15E148 Safari/604.1"
+)
+
+//signinFrom signs in with creds from remoteAddr with userAgent, failing the test unless it succeeds
+func signinFrom(t *testing.T, env *apitest.Env, remoteAddr string, userAgent string, creds api.Credentials) {
+	t.Helper()
+	req := env.Request(http.MethodPost, "/api/auth/signin", creds)
+	req.RemoteAddr = remoteAddr
+	req.Header.Set("User-Agent", userAgent)
+	if res := env.Send(req); res.Code != http.StatusOK {
+		t.Fatalf("signin from %s: got %d %s", remoteAddr, res.Code, res.Body.String())
+	}
+}
+
+//signinAlertsTo counts the new signin alerts sent to recipient, after giving background sends the time to arrive
+func signinAlertsTo(env *apitest.Env, recipient string) int {
+	time.Sleep(50 * time.Millisecond)
+	return env.Mailer.Count(recipient, "new-signin.html")
+}
+
+func TestNewSigninAlertForNewDevice(t *testing.T) {
+	env := apitest.NewWithConfig(t, func(cfg *api.Config) {
+		cfg.NewSigninAlerts = true
+		cfg.SigninAlertLimit = 10
+	})
+	creds := api.Credentials{Username: "bear", Email: "alerts@berkeley.edu", Password: "pw"}
+	signUpVerified(t, env, creds)
+	signinFrom(t, env, "192.0.2.1:1234", firefoxOnLinux, creds)
+	if n := signinAlertsTo(env, creds.Email); n != 1 {
+		t.Fatalf("signin from a device the signup didn't use: got %d alerts, want 1", n)
+	}
+
+	//a newer browser version on the same IP is the same device
+	signinFrom(t, env, "192.0.2.1:5678", strings.Replace(firefoxOnLinux, "81.0", "82.0", -1), creds)
+	if n := signinAlertsTo(env, creds.Email); n != 1 {
+		t.Fatalf("signin from a known device: got %d alerts, want still 1", n)
+	}
+
+	signinFrom(t, env, "198.51.100.7:1234", safariOnIPhone, creds)
+	if n := signinAlertsTo(env, creds.Email); n != 2 {
+		t.Fatalf("signin from a new phone: got %d alerts, want 2", n)
+	}
+	alert, _ := env.Mailer.LastFrom(creds.Email, "new-signin.html")
+	if alert.Data["Device"] != "Safari on iOS" || alert.Data["IPAddress"] != "198.51.100.7" {
+		t.Fatalf("new signin alert: got data %v, want the device and IP address", alert.Data)
+	}
+}
+
+func TestNewSigninAlertsLimited(t *testing.T) {
+	env := apitest.NewWithConfig(t, func(cfg *api.Config) {
+		cfg.NewSigninAlerts = true
+		cfg.SigninAlertLimit = 1
+		cfg.SigninAlertWindow = time.Hour
+	})
+	creds := api.Credentials{Username: "bear", Email: "limited-alerts@berkeley.edu", Password: "pw"}
+	signUpVerified(t, env, creds)
+	for _, addr := range []string{"198.51.100.1:1234", "198.51.100.2:1234", "198.51.100.3:1234"} {
+		signinFrom(t, env, addr, firefoxOnLinux, creds)
+	}
+	if n := signinAlertsTo(env, creds.Email); n != 1 {
+		t.Fatalf("three new devices with NEW_SIGNIN_ALERT_LIMIT=1: got %d alerts, want 1", n)
+	}
+}
+
+func TestNewSigninAlertsOff(t *testing.T) {
+	env := apitest.NewWithConfig(t, func(cfg *api.Config) {
+		cfg.NewSigninAlerts = false
+	})
+	creds := api.Credentials{Username: "bear", Email: "no-alerts@berkeley.edu", Password: "pw"}
+	signUpVerified(t, env, creds)
+	signinFrom(t, env, "198.51.100.7:1234", safariOnIPhone, creds)
+	if n := signinAlertsTo(env, creds.Email); n != 0 {
+		t.Fatalf("signin from a new device with NEW_SIGNIN_ALERTS off: got %d alerts, want none", n)
+	}
+}
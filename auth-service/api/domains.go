@@ -0,0 +1,44 @@
+package api
+
+import (
+	"strings"
+)
+
+var (
+	//allowedEmailDomains restricts signups to these email domains, empty allows every domain
+	allowedEmailDomains []string
+	//deniedEmailDomains rejects signups from these email domains, even if they are also allowed
+	deniedEmailDomains []string
+)
+
+//domainMatches reports whether domain matches pattern, where "*.example.com" matches any subdomain of example.com
+func domainMatches(domain string, pattern string) bool {
+	pattern = strings.ToLower(pattern)
+	if strings.HasPrefix(pattern, "*.") {
+		return strings.HasSuffix(domain, pattern[1:])
+	}
+	return domain == pattern
+}
+
+//emailDomainAllowed checks the domain of email against the allow and deny lists
+func emailDomainAllowed(email string) bool {
+	at := strings.LastIndex(email, "@")
+	if at < 0 {
+		return false
+	}
+	domain := strings.ToLower(strings.TrimSuffix(email[at+1:], "."))
+	for _, pattern := range deniedEmailDomains {
+		if domainMatches(domain, pattern) {
+			return false
+		}
+	}
+	if len(allowedEmailDomains) == 0 {
+		return true
+	}
+	for _, pattern := range allowedEmailDomains {
+		if domainMatches(domain, pattern) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,378 @@
+package api
+
+import (
+	"database/sql"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/gorilla/mux"
+)
+
+//AccountEmail is one of the addresses on an account. The primary address is the users.email column,
+//secondary addresses live in the emails table.
+type AccountEmail struct {
+	Email    string `json:"email"`
+	Primary  bool   `json:"primary"`
+	Verified bool   `json:"verified"`
+}
+
+//EmailListResponse is the JSON body returned when listing a user's addresses
+type EmailListResponse struct {
+	SuccessResponse
+	Emails []AccountEmail `json:"emails"`
+}
+
+//emailTaken reports whether any account has verified email, as its primary or a secondary address.
+//Pending signups and secondary addresses don't hold on to their email, see verify.
+func emailTaken(email string) (bool, error) {
+	var taken bool
+	err := withRetry(func() error {
+		return DB.QueryRow("SELECT EXISTS(SELECT * FROM verified_emails WHERE email = ?);", email).Scan(&taken)
+	})
+	return taken, err
+}
+
+//claimEmail records in verified_emails that userID verified email, as part of tx. The primary key lets only one
+//account claim an address, so it fails with ErrEmailTaken when another account got there first. Claiming an
+//address userID already holds succeeds.
+func claimEmail(tx *sql.Tx, email string, userID string) error {
+	_, err := tx.Exec("INSERT INTO verified_emails (email, userId) VALUES (?, ?);", email, userID)
+	if err == nil || !isDuplicateKey(err) {
+		return err
+	}
+	var owner string
+	err = tx.QueryRow("SELECT userId FROM verified_emails WHERE email = ?;", email).Scan(&owner)
+	if err != nil {
+		return err
+	}
+	if owner != userID {
+		return ErrEmailTaken
+	}
+	return nil
+}
+
+//markEmailVerified claims the secondary address email of userID and sets it verified in one transaction
+func markEmailVerified(email string, userID string) error {
+	tx, err := DB.Begin()
+	if err != nil {
+		return err
+	}
+	err = claimEmail(tx, email, userID)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	_, err = tx.Exec("UPDATE emails SET verified = ? WHERE email = ? AND userId = ?;", true, email, userID)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
+
+//emailVerificationLink builds the link that confirms a secondary address
+func emailVerificationLink(token string) string {
+	return strings.TrimSuffix(frontendBaseURL, "/") + "/verify-email?token=" + url.QueryEscape(token)
+}
+
+//listAccountEmails returns the primary address of userID followed by its secondary addresses
+func listAccountEmails(userID string) ([]AccountEmail, error) {
+	primary := AccountEmail{Primary: true}
+	var verified sql.NullBool
+	err := withRetry(func() error {
+		return DB.QueryRow("SELECT email, verified FROM users WHERE userId = ?;", userID).Scan(&primary.Email, &verified)
+	})
+	if err != nil {
+		return nil, err
+	}
+	primary.Verified = verified.Bool
+	emails := []AccountEmail{primary}
+
+	rows, err := DB.Query("SELECT email, verified FROM emails WHERE userId = ? ORDER BY createdAt;", userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var email AccountEmail
+		err = rows.Scan(&email.Email, &email.Verified)
+		if err != nil {
+			return nil, err
+		}
+		emails = append(emails, email)
+	}
+	return emails, rows.Err()
+}
+
+func listEmails(w http.ResponseWriter, r *http.Request) {
+
+	if (*r).Method == "OPTIONS" {
+		return
+	}
+
+	claims, _ := claimsFromContext(r.Context())
+
+	emails, err := listAccountEmails(claims.UserID)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			writeJSONError(w, r, http.StatusNotFound, "this account no longer exists")
+		} else {
+			internalError(w, r, "error listing emails", err)
+		}
+		return
+	}
+
+	writeJSON(w, http.StatusOK, EmailListResponse{
+		SuccessResponse: SuccessResponse{Status: "ok", Message: "emails retrieved"},
+		Emails:          emails,
+	})
+}
+
+func addEmail(w http.ResponseWriter, r *http.Request) {
+
+	if (*r).Method == "OPTIONS" {
+		return
+	}
+
+	claims, _ := claimsFromContext(r.Context())
+
+	credentials := Credentials{}
+	err := decodeJSON(r.Body, &credentials)
+	if err != nil {
+		writeJSONError(w, r, http.StatusBadRequest, decodeErrorMessage(err, "issue retrieving email"))
+		log.Print(err.Error())
+		return
+	}
+	credentials.normalize()
+	credentials.Email, err = cleanText("email", credentials.Email, emailMaxLength)
+	if err != nil {
+		writeJSONError(w, r, http.StatusBadRequest, err.Error())
+		return
+	}
+	if !strings.Contains(credentials.Email, "@") {
+		writeJSONError(w, r, http.StatusBadRequest, "invalid email address")
+		return
+	}
+	if !emailDomainAllowed(credentials.Email) {
+		writeJSONError(w, r, http.StatusForbidden, "emails from this domain are not allowed")
+		return
+	}
+
+	taken, err := emailTaken(credentials.Email)
+	if err != nil {
+		internalError(w, r, "error checking if email exists", err)
+		return
+	}
+	if taken {
+		writeJSONError(w, r, http.StatusConflict, "this email is taken")
+		return
+	}
+
+	//Limit by the address being sent the link, so adding it over and over can't flood its inbox
+	if emailLimited(w, r, credentials.Email) {
+		return
+	}
+
+	//The address only counts for signin once the link sent to it is followed
+	token, err := storeUniqueToken(tokenPurposeEmail, verifyTokenSize, func(tokenHash string) error {
+		_, err := DB.Exec("INSERT INTO emails (email, userId, verified, verifiedToken, createdAt) VALUES (?, ?, ?, ?, ?);", credentials.Email, claims.UserID, false, tokenHash, clock.Now())
+		return err
+	})
+	if err != nil {
+		//another request added the same address after the check above
+		if isDuplicateKey(err) {
+			writeJSONError(w, r, http.StatusConflict, "this email is taken")
+		} else {
+			internalError(w, r, "error adding email", err)
+		}
+		return
+	}
+	recordAudit(claims.UserID, auditAddEmail, claims.UserID)
+
+	err = SendEmail(r.Context(), credentials.Email, "email-verification.html", map[string]interface{}{"Link": emailVerificationLink(token)})
+	if err != nil {
+		internalError(w, r, "error sending verification email", err)
+		return
+	}
+
+	writeJSONSuccess(w, http.StatusCreated, "email added, check it to verify the address")
+}
+
+func verifyEmail(w http.ResponseWriter, r *http.Request) {
+
+	if (*r).Method == "OPTIONS" {
+		return
+	}
+
+	token := tokenParam(r)
+	if token == "" {
+		writeJSONError(w, r, http.StatusBadRequest, "url Param 'token' is missing")
+		return
+	}
+	if wrongTokenPurpose(w, r, token, tokenPurposeEmail) {
+		return
+	}
+
+	var email, userID string
+	err := withRetry(func() error {
+		return DB.QueryRow("SELECT email, userId FROM emails WHERE verifiedToken = ?;", hashToken(token)).Scan(&email, &userID)
+	})
+	if err == sql.ErrNoRows {
+		writeJSONError(w, r, http.StatusNotFound, "verification token not found")
+		return
+	}
+	if err != nil {
+		internalError(w, r, "error verifying email", err)
+		return
+	}
+
+	//Several accounts can have the address pending, but only the first of them to verify it keeps it.
+	//Verifying again with the same link succeeds, like verify does for the primary address.
+	err = withRetry(func() error {
+		return markEmailVerified(email, userID)
+	})
+	if err == ErrEmailTaken {
+		writeJSONError(w, r, http.StatusConflict, err.Error())
+		return
+	}
+	if err != nil {
+		internalError(w, r, "error verifying email", err)
+		return
+	}
+
+	writeJSONSuccess(w, http.StatusOK, "email verified")
+}
+
+func removeEmail(w http.ResponseWriter, r *http.Request) {
+
+	if (*r).Method == "OPTIONS" {
+		return
+	}
+
+	claims, _ := claimsFromContext(r.Context())
+	email := mux.Vars(r)["email"]
+
+	removed, err := deleteSecondaryEmail(claims.UserID, email)
+	if err != nil {
+		internalError(w, r, "error removing email", err)
+		return
+	}
+	if !removed {
+		//the primary address isn't in the emails table, so it can't be removed here
+		writeJSONError(w, r, http.StatusNotFound, "no secondary email with this address on your account")
+		return
+	}
+	recordAudit(claims.UserID, auditRemoveEmail, claims.UserID)
+
+	writeJSONSuccess(w, http.StatusOK, "email removed")
+}
+
+//deleteSecondaryEmail removes the secondary address email of userID and frees it for other accounts to verify,
+//reporting false when userID has no such secondary address
+func deleteSecondaryEmail(userID string, email string) (bool, error) {
+	tx, err := DB.Begin()
+	if err != nil {
+		return false, err
+	}
+	result, err := tx.Exec("DELETE FROM emails WHERE email = ? AND userId = ?;", email, userID)
+	if err != nil {
+		tx.Rollback()
+		return false, err
+	}
+	affected, err := result.RowsAffected()
+	if err != nil || affected == 0 {
+		tx.Rollback()
+		return false, err
+	}
+	_, err = tx.Exec("DELETE FROM verified_emails WHERE email = ? AND userId = ?;", email, userID)
+	if err != nil {
+		tx.Rollback()
+		return false, err
+	}
+	return true, tx.Commit()
+}
+
+//makePrimaryEmail swaps a verified secondary address with the primary one, subject to identityChangeCooldown
+func makePrimaryEmail(w http.ResponseWriter, r *http.Request) {
+
+	if (*r).Method == "OPTIONS" {
+		return
+	}
+
+	claims, _ := claimsFromContext(r.Context())
+	email := mux.Vars(r)["email"]
+
+	var verified bool
+	err := withRetry(func() error {
+		return DB.QueryRow("SELECT verified FROM emails WHERE email = ? AND userId = ?;", email, claims.UserID).Scan(&verified)
+	})
+	if err == sql.ErrNoRows {
+		writeJSONError(w, r, http.StatusNotFound, "no secondary email with this address on your account")
+		return
+	}
+	if err != nil {
+		internalError(w, r, "error retrieving email", err)
+		return
+	}
+	//resets are sent to the primary address, so it must be one the user is known to read
+	if !verified {
+		writeJSONError(w, r, http.StatusConflict, "verify this email before making it your primary address")
+		return
+	}
+
+	retryAt, err := nextIdentityChange(claims.UserID)
+	if err != nil {
+		internalError(w, r, "error checking email change cooldown", err)
+		return
+	}
+	if !retryAt.IsZero() {
+		identityChangeTooSoon(w, r, retryAt)
+		return
+	}
+
+	err = swapPrimaryEmail(claims.UserID, email)
+	if err != nil {
+		internalError(w, r, "error changing primary email", err)
+		return
+	}
+	recordAudit(claims.UserID, auditChangePrimaryEmail, claims.UserID)
+
+	writeJSONSuccess(w, http.StatusOK, "primary email changed")
+}
+
+//swapPrimaryEmail makes the secondary address email the primary address of userID,
+//keeping the old primary address as a secondary one, and starts the identity change cooldown
+func swapPrimaryEmail(userID string, email string) error {
+	tx, err := DB.Begin()
+	if err != nil {
+		return err
+	}
+
+	var oldEmail string
+	var oldVerified sql.NullBool
+	err = tx.QueryRow("SELECT email, verified FROM users WHERE userId = ?;", userID).Scan(&oldEmail, &oldVerified)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	now := clock.Now()
+	statements := []struct {
+		query string
+		args  []interface{}
+	}{
+		{"DELETE FROM emails WHERE email = ? AND userId = ?;", []interface{}{email, userID}},
+		{"UPDATE users SET email = ?, verified = ?, lastUsernameChangeAt = ? WHERE userId = ?;", []interface{}{email, true, now, userID}},
+		{"INSERT INTO emails (email, userId, verified, createdAt) VALUES (?, ?, ?, ?);", []interface{}{oldEmail, userID, oldVerified.Bool, now}},
+	}
+	for _, statement := range statements {
+		_, err = tx.Exec(statement.query, statement.args...)
+		if err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+	return tx.Commit()
+}
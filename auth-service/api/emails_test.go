@@ -0,0 +1,210 @@
+package api_test
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/BearCloud/fa20-project-dev/backend/auth-service/api"
+	"github.com/BearCloud/fa20-project-dev/backend/auth-service/apitest"
+)
+
+//addSecondaryEmail adds address to the signed in account and returns the token of the link emailed to it
+func addSecondaryEmail(t *testing.T, env *apitest.Env, access *http.Cookie, address string) string {
+	t.Helper()
+	res := env.Do(http.MethodPost, "/api/auth/emails", api.Credentials{Email: address}, access)
+	if res.Code != http.StatusCreated {
+		t.Fatalf("adding %s: got %d %s", address, res.Code, res.Body.String())
+	}
+	email, ok := env.Mailer.LastFrom(address, "email-verification.html")
+	if !ok {
+		t.Fatalf("no verification email to %s", address)
+	}
+	return email.Token()
+}
+
+//pendingSignupsShareEmail checks that two pending signups can share an email and only the first to verify keeps it
+func pendingSignupsShareEmail(t *testing.T, env *apitest.Env) {
+	squatter := api.Credentials{Username: "squatter", Email: "bear@berkeley.edu", Password: "squatter-pw"}
+	owner := api.Credentials{Username: "bear", Email: "bear@berkeley.edu", Password: "pw"}
+	var tokens []string
+	for _, creds := range []api.Credentials{squatter, owner} {
+		res := env.Do(http.MethodPost, "/api/auth/signup", creds)
+		if res.Code != http.StatusCreated {
+			t.Fatalf("pending signup %s: got %d %s", creds.Username, res.Code, res.Body.String())
+		}
+		email, _ := env.Mailer.LastFrom(creds.Email, "user-signup.html")
+		tokens = append(tokens, email.Token())
+	}
+
+	res := env.Do(http.MethodPost, "/api/auth/verify?token="+tokens[1], nil)
+	if res.Code != http.StatusOK {
+		t.Fatalf("first verification: got %d %s", res.Code, res.Body.String())
+	}
+	res = env.Do(http.MethodPost, "/api/auth/verify?token="+tokens[1], nil)
+	if res.Code != http.StatusOK {
+		t.Fatalf("following the same link again: got %d %s", res.Code, res.Body.String())
+	}
+	res = env.Do(http.MethodPost, "/api/auth/verify?token="+tokens[0], nil)
+	if res.Code != http.StatusConflict {
+		t.Fatalf("second account verifying the same email: got %d, want 409", res.Code)
+	}
+
+	res = env.Do(http.MethodPost, "/api/auth/signup", api.Credentials{Username: "latecomer", Email: owner.Email, Password: "pw"})
+	if res.Code != http.StatusConflict {
+		t.Fatalf("signup with a verified email: got %d, want 409", res.Code)
+	}
+	signIn(t, env, owner)
+}
+
+func TestPendingSignupsShareEmail(t *testing.T) {
+	pendingSignupsShareEmail(t, apitest.New(t))
+}
+
+func TestPendingSignupsShareEmailInMemoryStore(t *testing.T) {
+	env := apitest.New(t)
+	env.UseMemoryStore()
+	pendingSignupsShareEmail(t, env)
+}
+
+func TestConcurrentVerificationsKeepEmailUnique(t *testing.T) {
+	env := apitest.New(t)
+	var tokens []string
+	for i := 0; i < 5; i++ {
+		creds := api.Credentials{Username: fmt.Sprintf("bear%d", i), Email: "bear@berkeley.edu", Password: "pw"}
+		if res := env.Do(http.MethodPost, "/api/auth/signup", creds); res.Code != http.StatusCreated {
+			t.Fatalf("pending signup %s: got %d %s", creds.Username, res.Code, res.Body.String())
+		}
+		email, _ := env.Mailer.LastFrom(creds.Email, "user-signup.html")
+		tokens = append(tokens, email.Token())
+	}
+
+	codes := make(chan int, len(tokens))
+	for _, token := range tokens {
+		token := token
+		go func() {
+			codes <- env.Do(http.MethodPost, "/api/auth/verify?token="+token, nil).Code
+		}()
+	}
+	verified := 0
+	for range tokens {
+		switch code := <-codes; code {
+		case http.StatusOK:
+			verified++
+		case http.StatusConflict:
+		default:
+			t.Fatalf("concurrent verification: got %d, want 200 or 409", code)
+		}
+	}
+	if verified != 1 {
+		t.Fatalf("five pending signups verifying one email at once: %d verified, want 1", verified)
+	}
+	if rows := countRows(t, env, "verified_emails", "email", "bear@berkeley.edu"); rows != 1 {
+		t.Fatalf("after the concurrent verifications: got %d verified rows for the email, want 1", rows)
+	}
+}
+
+func TestPendingSecondaryEmailsDontReserveAddress(t *testing.T) {
+	env := apitest.New(t)
+	bear := api.Credentials{Username: "bear", Email: "bear@berkeley.edu", Password: "pw"}
+	tree := api.Credentials{Username: "tree", Email: "tree@stanford.edu", Password: "pw"}
+	signUpVerified(t, env, bear)
+	signUpVerified(t, env, tree)
+	bearAccess, _ := signIn(t, env, bear)
+	treeAccess, _ := signIn(t, env, tree)
+
+	bearToken := addSecondaryEmail(t, env, bearAccess, "golden@bears.org")
+	treeToken := addSecondaryEmail(t, env, treeAccess, "golden@bears.org")
+
+	res := env.Do(http.MethodPost, "/api/auth/emails/verify?token="+bearToken, nil)
+	if res.Code != http.StatusOK {
+		t.Fatalf("first verification: got %d %s", res.Code, res.Body.String())
+	}
+	res = env.Do(http.MethodPost, "/api/auth/emails/verify?token="+treeToken, nil)
+	if res.Code != http.StatusConflict {
+		t.Fatalf("second account verifying the same address: got %d, want 409", res.Code)
+	}
+	res = env.Do(http.MethodPost, "/api/auth/emails", api.Credentials{Email: "golden@bears.org"}, treeAccess)
+	if res.Code != http.StatusConflict {
+		t.Fatalf("adding an address verified on another account: got %d, want 409", res.Code)
+	}
+	res = env.Do(http.MethodPost, "/api/auth/signup", api.Credentials{Username: "oski", Email: "golden@bears.org", Password: "pw"})
+	if res.Code != http.StatusConflict {
+		t.Fatalf("signup with a verified secondary address: got %d, want 409", res.Code)
+	}
+
+	//removing the address frees it for the account that still has it pending
+	res = env.Do(http.MethodDelete, "/api/auth/emails/golden@bears.org", nil, bearAccess)
+	if res.Code != http.StatusOK {
+		t.Fatalf("removing the address: got %d %s", res.Code, res.Body.String())
+	}
+	res = env.Do(http.MethodPost, "/api/auth/emails/verify?token="+treeToken, nil)
+	if res.Code != http.StatusOK {
+		t.Fatalf("verifying after the other account removed the address: got %d %s", res.Code, res.Body.String())
+	}
+	signIn(t, env, api.Credentials{Email: "golden@bears.org", Password: "pw"})
+}
+
+func TestSigninWithSecondaryEmail(t *testing.T) {
+	env := apitest.New(t)
+	creds := api.Credentials{Username: "bear", Email: "bear@berkeley.edu", Password: "pw"}
+	signUpVerified(t, env, creds)
+	access, _ := signIn(t, env, creds)
+	secondary := api.Credentials{Email: "golden@bears.org", Password: "pw"}
+
+	token := addSecondaryEmail(t, env, access, secondary.Email)
+	res := env.Do(http.MethodPost, "/api/auth/signin", secondary)
+	if res.Code != http.StatusUnauthorized {
+		t.Fatalf("signin with an unverified secondary email: got %d, want 401", res.Code)
+	}
+
+	res = env.Do(http.MethodPost, "/api/auth/emails/verify?token="+token, nil)
+	if res.Code != http.StatusOK {
+		t.Fatalf("verify secondary email: got %d %s", res.Code, res.Body.String())
+	}
+	res = env.Do(http.MethodGet, "/api/auth/emails", nil, access)
+	var list api.EmailListResponse
+	json.NewDecoder(res.Body).Decode(&list)
+	want := []api.AccountEmail{{Email: creds.Email, Primary: true, Verified: true}, {Email: secondary.Email, Verified: true}}
+	if !reflect.DeepEqual(list.Emails, want) {
+		t.Fatalf("emails: got %+v, want %+v", list.Emails, want)
+	}
+
+	viaSecondary, _ := signIn(t, env, secondary)
+	if username := profileUsername(t, "profile after signing in with the secondary email", getMe(env, "", viaSecondary)); username != creds.Username {
+		t.Fatalf("signin with the secondary email: got account %q, want %q", username, creds.Username)
+	}
+
+	res = env.Do(http.MethodDelete, "/api/auth/emails/"+secondary.Email, nil, access)
+	if res.Code != http.StatusOK {
+		t.Fatalf("remove secondary email: got %d %s", res.Code, res.Body.String())
+	}
+	res = env.Do(http.MethodPost, "/api/auth/signin", secondary)
+	if res.Code != http.StatusUnauthorized {
+		t.Fatalf("signin with a removed secondary email: got %d, want 401", res.Code)
+	}
+}
+
+func TestAddingEmailsCountsTowardsEmailLimits(t *testing.T) {
+	env := apitest.NewWithConfig(t, func(cfg *api.Config) {
+		cfg.EmailIPLimit = 2
+		cfg.EmailAccountLimit = 100
+		cfg.EmailLimitWindow = time.Hour
+	})
+	creds := api.Credentials{Username: "bear", Email: "bear@berkeley.edu", Password: "pw"}
+	signUpVerified(t, env, creds)
+	access, _ := signIn(t, env, creds)
+
+	addSecondaryEmail(t, env, access, "golden@bears.org")
+	addSecondaryEmail(t, env, access, "oski@bears.org")
+	res := env.Do(http.MethodPost, "/api/auth/emails", api.Credentials{Email: "tree@stanford.edu"}, access)
+	if res.Code != http.StatusTooManyRequests || res.Header().Get("Retry-After") == "" {
+		t.Fatalf("third address from one IP: got %d with Retry-After %q, want 429 with one", res.Code, res.Header().Get("Retry-After"))
+	}
+	if _, sent := env.Mailer.LastFrom("tree@stanford.edu", "email-verification.html"); sent {
+		t.Fatalf("an address refused by the email limit was sent a link")
+	}
+}
@@ -0,0 +1,70 @@
+package api
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+const (
+	//eventUserCreated is published once a signup has stored the new account
+	eventUserCreated = "user.created"
+	//eventUserVerified is published the first time an account's email is verified
+	eventUserVerified = "user.verified"
+	//eventPasswordReset is published once a reset link has changed an account's password
+	eventPasswordReset = "password.reset"
+)
+
+var (
+	//eventTopic is the NATS subject or Kafka topic auth events are published to
+	eventTopic = "auth.events"
+	//eventPublishTimeout bounds how long publishing a single event may take
+	eventPublishTimeout = 5 * time.Second
+)
+
+//Event is an auth event published to eventTopic for other services to react to
+type Event struct {
+	ID         string    `json:"id"`
+	Type       string    `json:"type"`
+	UserID     string    `json:"userId"`
+	Email      string    `json:"email"`
+	OccurredAt time.Time `json:"occurredAt"`
+}
+
+//EventPublisher delivers auth events to a message queue, e.g. a NATS subject or a Kafka topic
+type EventPublisher interface {
+	Publish(ctx context.Context, topic string, event Event) error
+}
+
+//publisher delivers every event the handlers publish, nothing unless replaced with SetEventPublisher
+var publisher EventPublisher = noopPublisher{}
+
+//SetEventPublisher replaces the publisher used by the handlers, e.g. with a NATS or Kafka client in main or a mock in tests
+func SetEventPublisher(p EventPublisher) {
+	publisher = p
+}
+
+//publishEvent publishes an event of eventType about a user in the background so requests don't wait on the queue.
+//Call it only once the change it announces is stored. Failures are logged, the change itself already happened.
+func publishEvent(eventType string, userID string, email string) {
+	event := Event{ID: uuid.New().String(), Type: eventType, UserID: userID, Email: email, OccurredAt: clock.Now().UTC()}
+	p, topic := publisher, eventTopic
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), eventPublishTimeout)
+		defer cancel()
+		err := p.Publish(ctx, topic, event)
+		if err != nil {
+			log.Printf("error publishing %s event: %s", eventType, err.Error())
+		}
+	}()
+}
+
+//noopPublisher drops every event, for deployments nothing listens to
+type noopPublisher struct{}
+
+//Publish does nothing
+func (noopPublisher) Publish(ctx context.Context, topic string, event Event) error {
+	return nil
+}
@@ -0,0 +1,68 @@
+package api_test
+
+import (
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/BearCloud/fa20-project-dev/backend/auth-service/api"
+	"github.com/BearCloud/fa20-project-dev/backend/auth-service/apitest"
+)
+
+func TestAuthEventsPublished(t *testing.T) {
+	clock := apitest.NewFakeClock(time.Date(2020, 10, 1, 9, 0, 0, 0, time.UTC))
+	env := apitest.NewWithConfig(t, func(cfg *api.Config) {
+		cfg.Clock = clock
+		cfg.EventTopic = "mixtape.auth"
+	})
+	creds := api.Credentials{Username: "bear", Email: "bear@berkeley.edu", Password: "pw"}
+	signUpVerified(t, env, creds)
+	token := requestReset(t, env, creds.Email)
+	res := env.Do(http.MethodPost, "/api/auth/resetpw", api.PasswordReset{Token: token, NewPassword: "new pw", ConfirmPassword: "new pw"})
+	expectSuccess(t, "resetpw", res, http.StatusOK, "password reset")
+
+	var userID string
+	env.DB.QueryRow("SELECT userId FROM users WHERE email = ?;", creds.Email).Scan(&userID)
+	ids := map[string]bool{}
+	for _, eventType := range []string{"user.created", "user.verified", "password.reset"} {
+		published, ok := env.Publisher.WaitFor(eventType, userID, time.Second)
+		if !ok {
+			t.Fatalf("no %s event for %s, got %+v", eventType, userID, env.Publisher.Published())
+		}
+		if published.Topic != "mixtape.auth" || published.Event.Email != creds.Email || !published.Event.OccurredAt.Equal(clock.Now()) {
+			t.Fatalf("%s event: got %+v, want it on mixtape.auth with the email and the time it happened", eventType, published)
+		}
+		if published.Event.ID == "" || ids[published.Event.ID] {
+			t.Fatalf("%s event: got ID %q, want a unique one", eventType, published.Event.ID)
+		}
+		ids[published.Event.ID] = true
+	}
+}
+
+func TestNoEventForRejectedChanges(t *testing.T) {
+	env := apitest.New(t)
+	creds := api.Credentials{Username: "bear", Email: "bear@berkeley.edu", Password: "pw"}
+	signUpVerified(t, env, creds)
+	var userID string
+	env.DB.QueryRow("SELECT userId FROM users WHERE email = ?;", creds.Email).Scan(&userID)
+	env.Publisher.WaitFor("user.verified", userID, time.Second)
+
+	env.Do(http.MethodPost, "/api/auth/signup", creds)
+	env.Do(http.MethodPost, "/api/auth/resetpw", api.PasswordReset{Token: "r_unknown", NewPassword: "new pw", ConfirmPassword: "new pw"})
+	//give a wrongly published event the time to arrive
+	time.Sleep(50 * time.Millisecond)
+	if published := env.Publisher.Published(); len(published) != 2 {
+		t.Fatalf("a conflicting signup and a bad reset: got events %+v, want only the first signup's two", published)
+	}
+}
+
+func TestPublishFailureDoesNotFailRequest(t *testing.T) {
+	env := apitest.New(t)
+	env.Publisher.Err = errors.New("nats: no servers available")
+
+	res := env.Do(http.MethodPost, "/api/auth/signup", api.Credentials{Username: "bear", Email: "bear@berkeley.edu", Password: "pw"})
+	if res.Code != http.StatusCreated {
+		t.Fatalf("signup while the queue is down: got %d %s, want 201", res.Code, res.Body.String())
+	}
+}
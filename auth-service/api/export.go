@@ -0,0 +1,150 @@
+package api
+
+import (
+	"database/sql"
+	"net/http"
+	"time"
+)
+
+//SessionExport is a refresh-token session as included in an account export
+type SessionExport struct {
+	CreatedAt time.Time  `json:"createdAt"`
+	ExpiresAt time.Time  `json:"expiresAt"`
+	RevokedAt *time.Time `json:"revokedAt,omitempty"`
+}
+
+//AccountExport is everything stored about a user, minus their password hash and token hashes
+type AccountExport struct {
+	SuccessResponse
+	UserID      string          `json:"userId"`
+	Username    string          `json:"username"`
+	DisplayName string          `json:"displayName,omitempty"`
+	Locale      string          `json:"locale,omitempty"`
+	Email       string          `json:"email"`
+	Phone       string          `json:"phone,omitempty"`
+	Verified    bool            `json:"verified"`
+	Role        string          `json:"role"`
+	DeletedAt   *time.Time      `json:"deletedAt,omitempty"`
+	Emails      []AccountEmail  `json:"emails"`
+	Sessions    []SessionExport `json:"sessions"`
+	Invites     []Invite        `json:"invites"`
+	AuditLog    []AuditEntry    `json:"auditLog"`
+}
+
+//exportSessions returns every session userID has held
+func exportSessions(userID string) ([]SessionExport, error) {
+	rows, err := DB.Query("SELECT createdAt, expiresAt, revokedAt FROM sessions WHERE userId = ? ORDER BY createdAt;", userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	sessions := []SessionExport{}
+	for rows.Next() {
+		var session SessionExport
+		var revokedAt sql.NullTime
+		err = rows.Scan(&session.CreatedAt, &session.ExpiresAt, &revokedAt)
+		if err != nil {
+			return nil, err
+		}
+		session.RevokedAt = nullTimePtr(revokedAt)
+		sessions = append(sessions, session)
+	}
+	return sessions, rows.Err()
+}
+
+//exportInvites returns every invite userID created or signed up with
+func exportInvites(userID string) ([]Invite, error) {
+	rows, err := DB.Query("SELECT code, email, role, createdBy, createdAt, expiresAt, usedBy, usedAt, revokedAt FROM invites WHERE createdBy = ? OR usedBy = ? ORDER BY createdAt;", userID, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	invites := []Invite{}
+	for rows.Next() {
+		var invite Invite
+		var email, role, usedBy sql.NullString
+		var expiresAt, usedAt, revokedAt sql.NullTime
+		err = rows.Scan(&invite.Code, &email, &role, &invite.CreatedBy, &invite.CreatedAt, &expiresAt, &usedBy, &usedAt, &revokedAt)
+		if err != nil {
+			return nil, err
+		}
+		invite.Email = email.String
+		invite.Role = role.String
+		invite.UsedBy = usedBy.String
+		invite.ExpiresAt = nullTimePtr(expiresAt)
+		invite.UsedAt = nullTimePtr(usedAt)
+		invite.RevokedAt = nullTimePtr(revokedAt)
+		invites = append(invites, invite)
+	}
+	return invites, rows.Err()
+}
+
+//exportAudit returns every audit entry userID performed or was the target of
+func exportAudit(userID string) ([]AuditEntry, error) {
+	rows, err := DB.Query("SELECT id, actorId, action, targetId, createdAt FROM audit_log WHERE actorId = ? OR targetId = ? ORDER BY id;", userID, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	entries := []AuditEntry{}
+	for rows.Next() {
+		var entry AuditEntry
+		err = rows.Scan(&entry.ID, &entry.ActorID, &entry.Action, &entry.TargetID, &entry.CreatedAt)
+		if err != nil {
+			return nil, err
+		}
+		entries = append(entries, entry)
+	}
+	return entries, rows.Err()
+}
+
+func exportAccount(w http.ResponseWriter, r *http.Request) {
+
+	if (*r).Method == "OPTIONS" {
+		return
+	}
+
+	claims, _ := claimsFromContext(r.Context())
+
+	export := AccountExport{SuccessResponse: SuccessResponse{Status: "ok", Message: "account data exported"}}
+	var displayName, locale, phone sql.NullString
+	var verified sql.NullBool
+	var deletedAt sql.NullTime
+	err := withRetry(func() error {
+		return DB.QueryRow("SELECT userId, username, displayName, locale, email, phone, verified, role, deletedAt FROM users WHERE userId = ?;", claims.UserID).
+			Scan(&export.UserID, &export.Username, &displayName, &locale, &export.Email, &phone, &verified, &export.Role, &deletedAt)
+	})
+	if err != nil {
+		if err == sql.ErrNoRows {
+			writeJSONError(w, r, http.StatusNotFound, "this account no longer exists")
+		} else {
+			internalError(w, r, "error exporting account", err)
+		}
+		return
+	}
+	export.DisplayName = displayName.String
+	export.Locale = locale.String
+	export.Phone = phone.String
+	export.Verified = verified.Bool
+	export.DeletedAt = nullTimePtr(deletedAt)
+
+	export.Emails, err = listAccountEmails(claims.UserID)
+	if err == nil {
+		export.Sessions, err = exportSessions(claims.UserID)
+	}
+	if err == nil {
+		export.Invites, err = exportInvites(claims.UserID)
+	}
+	if err == nil {
+		export.AuditLog, err = exportAudit(claims.UserID)
+	}
+	if err != nil {
+		internalError(w, r, "error exporting account", err)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, export)
+}
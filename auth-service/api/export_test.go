@@ -0,0 +1,162 @@
+package api_test
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/BearCloud/fa20-project-dev/backend/auth-service/api"
+	"github.com/BearCloud/fa20-project-dev/backend/auth-service/apitest"
+	"golang.org/x/crypto/bcrypt"
+)
+
+func TestExportAccount(t *testing.T) {
+	env := apitest.New(t)
+	creds := api.Credentials{Username: "bear", Email: "bear@berkeley.edu", Password: "pw"}
+	signUpVerified(t, env, creds)
+	access, _ := signIn(t, env, creds)
+	env.Do(http.MethodPost, "/api/auth/emails", api.Credentials{Email: "golden@bears.org"}, access)
+
+	res := env.Do(http.MethodGet, "/api/auth/export", nil, access)
+	if res.Code != http.StatusOK {
+		t.Fatalf("export: got %d %s", res.Code, res.Body.String())
+	}
+	raw := res.Body.String()
+	var export api.AccountExport
+	json.NewDecoder(res.Body).Decode(&export)
+
+	if export.Username != creds.Username || export.Email != creds.Email || !export.Verified || export.Role != "user" {
+		t.Fatalf("export: got %+v, want the account's profile", export)
+	}
+	if sessions := countRows(t, env, "sessions", "userId", export.UserID); len(export.Emails) != 2 || len(export.Sessions) != sessions {
+		t.Fatalf("export: got %d emails and %d sessions, want 2 and %d", len(export.Emails), len(export.Sessions), sessions)
+	}
+	if len(export.AuditLog) != 1 || export.AuditLog[0].Action != "add_email" {
+		t.Fatalf("export: got audit log %+v, want the added email", export.AuditLog)
+	}
+	var hashedPassword string
+	env.DB.QueryRow("SELECT hashedPassword FROM users WHERE email = ?;", creds.Email).Scan(&hashedPassword)
+	if strings.Contains(raw, hashedPassword) || strings.Contains(strings.ToLower(raw), "password") {
+		t.Fatalf("export includes the password hash: %s", raw)
+	}
+
+	res = env.Do(http.MethodGet, "/api/auth/export", nil)
+	if res.Code != http.StatusUnauthorized {
+		t.Fatalf("export without signing in: got %d, want 401", res.Code)
+	}
+}
+
+//exportAllUsers pages through the admin account export two users at a time and returns every user
+func exportAllUsers(t *testing.T, env *apitest.Env, admin *http.Cookie) []api.MigrationUser {
+	t.Helper()
+	var users []api.MigrationUser
+	cursor := ""
+	for {
+		res := env.Do(http.MethodGet, "/api/auth/admin/migration/users?limit=2&cursor="+cursor, nil, admin)
+		if res.Code != http.StatusOK {
+			t.Fatalf("export after %q: got %d %s", cursor, res.Code, res.Body.String())
+		}
+		var page api.MigrationExportResponse
+		json.NewDecoder(res.Body).Decode(&page)
+		if page.Version != 1 || len(page.Users) > 2 {
+			t.Fatalf("export page after %q: got version %d with %d users", cursor, page.Version, len(page.Users))
+		}
+		users = append(users, page.Users...)
+		if page.NextCursor == "" {
+			return users
+		}
+		cursor = page.NextCursor
+	}
+}
+
+func TestMigrateUsersThroughExportAndImport(t *testing.T) {
+	env := apitest.New(t)
+	admin := signInAdmin(t, env, api.Credentials{Username: "oski", Email: "oski@berkeley.edu", Password: "admin pw"})
+	accounts := []api.Credentials{
+		{Username: "bear", Email: "bear@berkeley.edu", Password: "bear pw"},
+		{Username: "tree", Email: "tree@stanford.edu", Password: "tree pw"},
+		{Username: "golden", Email: "golden@berkeley.edu", Password: "golden pw"},
+	}
+	for _, creds := range accounts {
+		signUpVerified(t, env, creds)
+	}
+	access, _ := signIn(t, env, accounts[0])
+	token := addSecondaryEmail(t, env, access, "bear@bears.org")
+	env.Do(http.MethodPost, "/api/auth/emails/verify?token="+token, nil)
+
+	users := exportAllUsers(t, env, admin)
+	if len(users) != 4 {
+		t.Fatalf("export: got %d users, want the admin and three accounts", len(users))
+	}
+	userIDs := map[string]string{}
+	for _, user := range users {
+		userIDs[user.Username] = user.UserID
+		if user.Username == "bear" && (len(user.Emails) != 1 || user.Emails[0] != (api.MigrationEmail{Email: "bear@bears.org", Verified: true})) {
+			t.Fatalf("export: got bear's emails %+v, want the verified secondary one", user.Emails)
+		}
+	}
+	body, _ := json.Marshal(api.MigrationImport{Version: 1, Users: users})
+
+	//the new deployment has its own admin
+	env = apitest.NewWithConfig(t, func(cfg *api.Config) {
+		cfg.MigrationImport = true
+	})
+	admin = signInAdmin(t, env, api.Credentials{Username: "stanfurd", Email: "stanfurd@stanford.edu", Password: "pw"})
+	res := env.Do(http.MethodPost, "/api/auth/admin/migration/users", string(body), admin)
+	var imported api.MigrationImportResponse
+	json.NewDecoder(res.Body).Decode(&imported)
+	if res.Code != http.StatusOK || len(imported.Imported) != 4 || len(imported.Skipped) != 0 {
+		t.Fatalf("import: got %d %+v, want all four imported", res.Code, imported)
+	}
+
+	//passwords keep working and userIds are kept
+	for _, creds := range accounts {
+		signIn(t, env, creds)
+		var userID string
+		env.DB.QueryRow("SELECT userId FROM users WHERE email = ?;", creds.Email).Scan(&userID)
+		if userID != userIDs[creds.Username] {
+			t.Fatalf("imported %s: got userId %q, want %q", creds.Username, userID, userIDs[creds.Username])
+		}
+	}
+	signIn(t, env, api.Credentials{Email: "bear@bears.org", Password: "bear pw"})
+
+	//running the same import again changes nothing
+	res = env.Do(http.MethodPost, "/api/auth/admin/migration/users", string(body), admin)
+	imported = api.MigrationImportResponse{}
+	json.NewDecoder(res.Body).Decode(&imported)
+	if res.Code != http.StatusOK || len(imported.Imported) != 0 || len(imported.Skipped) != 4 {
+		t.Fatalf("repeated import: got %d %+v, want all four skipped", res.Code, imported)
+	}
+}
+
+func TestMigrationImportValidatedStrictly(t *testing.T) {
+	env := apitest.NewWithConfig(t, func(cfg *api.Config) {
+		cfg.MigrationImport = true
+	})
+	admin := signInAdmin(t, env, api.Credentials{Username: "oski", Email: "oski@berkeley.edu", Password: "pw"})
+	user := `{"userId":"u1","username":"bear","email":"bear@berkeley.edu","hashedPassword":%q,"verified":true,"role":"user","emails":[]}`
+	hashed, _ := bcrypt.GenerateFromPassword([]byte("pw"), bcrypt.MinCost)
+	hash := string(hashed)
+
+	for _, check := range []struct {
+		step string
+		body string
+		want int
+	}{
+		{"an unknown version", `{"version":2,"users":[` + fmt.Sprintf(user, hash) + `]}`, http.StatusBadRequest},
+		{"an unknown field", `{"version":1,"users":[` + strings.Replace(fmt.Sprintf(user, hash), `"role"`, `"nickname":"b","role"`, 1) + `]}`, http.StatusBadRequest},
+		{"a truncated hash", `{"version":1,"users":[` + fmt.Sprintf(user, hash[:40]) + `]}`, http.StatusBadRequest},
+		{"a plaintext password", `{"version":1,"users":[` + fmt.Sprintf(user, "pw") + `]}`, http.StatusBadRequest},
+		{"the same user twice", `{"version":1,"users":[` + fmt.Sprintf(user, hash) + `,` + fmt.Sprintf(user, hash) + `]}`, http.StatusBadRequest},
+		{"a username taken by another account", `{"version":1,"users":[` + strings.Replace(fmt.Sprintf(user, hash), `"bear"`, `"oski"`, 1) + `]}`, http.StatusConflict},
+	} {
+		if res := env.Do(http.MethodPost, "/api/auth/admin/migration/users", check.body, admin); res.Code != check.want {
+			t.Errorf("import with %s: got %d %s, want %d", check.step, res.Code, res.Body.String(), check.want)
+		}
+	}
+	if n := countRows(t, env, "users", "userId", "u1"); n != 0 {
+		t.Fatalf("refused imports inserted %d users", n)
+	}
+}
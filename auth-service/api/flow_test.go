@@ -0,0 +1,313 @@
+package api_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/BearCloud/fa20-project-dev/backend/auth-service/api"
+	"github.com/BearCloud/fa20-project-dev/backend/auth-service/apitest"
+	"golang.org/x/crypto/bcrypt"
+)
+
+//signUpVerified signs up creds and follows the link in the verification email
+func signUpVerified(t *testing.T, env *apitest.Env, creds api.Credentials) {
+	t.Helper()
+	res := env.Do(http.MethodPost, "/api/auth/signup", creds)
+	if res.Code != http.StatusCreated {
+		t.Fatalf("signup: got %d %s", res.Code, res.Body.String())
+	}
+	email, ok := env.Mailer.LastFrom(creds.Email, "user-signup.html")
+	if !ok {
+		t.Fatalf("no verification email to %s", creds.Email)
+	}
+	res = env.Do(http.MethodPost, "/api/auth/verify?token="+email.Token(), nil)
+	if res.Code != http.StatusOK {
+		t.Fatalf("verify: got %d %s", res.Code, res.Body.String())
+	}
+}
+
+//signIn signs in with creds and returns the access and refresh cookies
+func signIn(t *testing.T, env *apitest.Env, creds api.Credentials) (*http.Cookie, *http.Cookie) {
+	t.Helper()
+	res := env.Do(http.MethodPost, "/api/auth/signin", creds)
+	if res.Code != http.StatusOK {
+		t.Fatalf("signin: got %d %s", res.Code, res.Body.String())
+	}
+	access := apitest.Cookie(res, "access_token")
+	refresh := apitest.Cookie(res, "refresh_token")
+	if access == nil || refresh == nil {
+		t.Fatalf("signin set no session cookies")
+	}
+	return access, refresh
+}
+
+func TestSignupVerifySignin(t *testing.T) {
+	env := apitest.New(t)
+	creds := api.Credentials{Username: "bear", Email: "bear@berkeley.edu", Password: "pw"}
+
+	res := env.Do(http.MethodPost, "/api/auth/signup", creds)
+	if res.Code != http.StatusCreated {
+		t.Fatalf("signup: got %d %s", res.Code, res.Body.String())
+	}
+
+	email, ok := env.Mailer.LastFrom(creds.Email, "user-signup.html")
+	if !ok {
+		t.Fatalf("no verification email to %s", creds.Email)
+	}
+	res = env.Do(http.MethodPost, "/api/auth/verify?token="+email.Token(), nil)
+	if res.Code != http.StatusOK {
+		t.Fatalf("verify: got %d %s", res.Code, res.Body.String())
+	}
+	var verified bool
+	err := env.DB.QueryRow("SELECT verified FROM users WHERE email = ?", creds.Email).Scan(&verified)
+	if err != nil || !verified {
+		t.Fatalf("user not verified: %v", err)
+	}
+
+	_, refresh := signIn(t, env, creds)
+	res = env.Do(http.MethodPost, "/api/auth/session/renew", nil, refresh)
+	if res.Code != http.StatusOK {
+		t.Fatalf("renew: got %d %s", res.Code, res.Body.String())
+	}
+
+	wrong := creds
+	wrong.Password = "wrong"
+	res = env.Do(http.MethodPost, "/api/auth/signin", wrong)
+	if res.Code != http.StatusUnauthorized {
+		t.Fatalf("signin with a wrong password: got %d, want 401", res.Code)
+	}
+}
+
+//signInAdmin signs up creds, makes the account an admin and returns an access cookie carrying the role
+func signInAdmin(t *testing.T, env *apitest.Env, creds api.Credentials) *http.Cookie {
+	t.Helper()
+	signUpVerified(t, env, creds)
+	_, err := env.DB.Exec("UPDATE users SET role = ? WHERE email = ?;", "admin", creds.Email)
+	if err != nil {
+		t.Fatalf("promoting %s: %v", creds.Email, err)
+	}
+	access, _ := signIn(t, env, creds)
+	return access
+}
+
+//TestWelcomeEmailOnFirstVerification signs up an address no other test uses, since welcome emails from earlier
+//tests can still land in this test's mailer
+func TestWelcomeEmailOnFirstVerification(t *testing.T) {
+	env := apitest.NewWithConfig(t, func(cfg *api.Config) {
+		cfg.WelcomeEmail = true
+	})
+	creds := api.Credentials{Username: "bear", Email: "welcome@berkeley.edu", Password: "pw"}
+	env.Do(http.MethodPost, "/api/auth/signup", creds)
+	verification, _ := env.Mailer.LastFrom(creds.Email, "user-signup.html")
+
+	env.Do(http.MethodPost, "/api/auth/verify?token="+verification.Token(), nil)
+	welcome, ok := env.Mailer.WaitFor(creds.Email, "welcome.html", time.Second)
+	if !ok {
+		t.Fatalf("no welcome email after verifying")
+	}
+	if welcome.Data["Username"] != creds.Username {
+		t.Fatalf("welcome email: got data %v, want the username", welcome.Data)
+	}
+
+	env.Do(http.MethodPost, "/api/auth/verify?token="+verification.Token(), nil)
+	time.Sleep(50 * time.Millisecond)
+	if n := env.Mailer.Count(creds.Email, "welcome.html"); n != 1 {
+		t.Fatalf("verifying twice: got %d welcome emails, want 1", n)
+	}
+}
+
+func TestWelcomeEmailDisabled(t *testing.T) {
+	env := apitest.NewWithConfig(t, func(cfg *api.Config) {
+		cfg.WelcomeEmail = false
+	})
+	creds := api.Credentials{Username: "bear", Email: "welcome@berkeley.edu", Password: "pw"}
+	signUpVerified(t, env, creds)
+	time.Sleep(50 * time.Millisecond)
+	if n := env.Mailer.Count(creds.Email, "welcome.html"); n != 0 {
+		t.Fatalf("WELCOME_EMAIL_ENABLED=false: got %d welcome emails, want none", n)
+	}
+}
+
+func TestVerifyAutoSignIn(t *testing.T) {
+	env := apitest.NewWithConfig(t, func(cfg *api.Config) {
+		cfg.VerifyAutoSignIn = true
+	})
+	creds := api.Credentials{Username: "bear", Email: "welcome@berkeley.edu", Password: "pw"}
+	env.Do(http.MethodPost, "/api/auth/signup", creds)
+	verification, _ := env.Mailer.LastFrom(creds.Email, "user-signup.html")
+
+	res := env.Do(http.MethodPost, "/api/auth/verify?token="+verification.Token(), nil)
+	expectSuccess(t, "verify", res, http.StatusOK, "email verified, signed in")
+	access := apitest.Cookie(res, "access_token")
+	if access == nil || apitest.Cookie(res, "refresh_token") == nil {
+		t.Fatalf("verify with VERIFY_AUTO_SIGNIN: no session cookies set")
+	}
+	if username := profileUsername(t, "profile after verifying", getMe(env, "", access)); username != creds.Username {
+		t.Fatalf("profile after verifying: got %q, want %q", username, creds.Username)
+	}
+	if claims := tokenClaims(t, access); time.Since(time.Unix(claims.AuthTime, 0)) > time.Minute || !reflect.DeepEqual(claims.AMR, []string{"email"}) {
+		t.Fatalf("token from verifying: got auth_time %d and amr %v, want now and [email]", claims.AuthTime, claims.AMR)
+	}
+
+	//no password was entered, so sensitive operations still ask for one
+	res = env.Do(http.MethodDelete, "/api/auth/account", nil, access)
+	if res.Code != http.StatusForbidden {
+		t.Fatalf("delete with a session from verifying: got %d, want 403", res.Code)
+	}
+
+	//following the link again doesn't sign anyone in
+	res = env.Do(http.MethodPost, "/api/auth/verify?token="+verification.Token(), nil)
+	if apitest.Cookie(res, "access_token") != nil {
+		t.Fatalf("following the verification link again set session cookies")
+	}
+}
+
+func TestUnverifiedSigninGrace(t *testing.T) {
+	clock := apitest.NewFakeClock(time.Now())
+	env := apitest.NewWithConfig(t, func(cfg *api.Config) {
+		cfg.Clock = clock
+		cfg.UnverifiedGrace = 24 * time.Hour
+	})
+	creds := api.Credentials{Username: "bear", Email: "bear@berkeley.edu", Password: "pw"}
+	env.Do(http.MethodPost, "/api/auth/signup", creds)
+
+	access, _ := signIn(t, env, creds)
+	if claims := tokenClaims(t, access); !claims.Unverified || claims.Verified {
+		t.Fatalf("signin within the grace period: got unverified %t and verified %t, want a token marked unverified", claims.Unverified, claims.Verified)
+	}
+
+	clock.Advance(25 * time.Hour)
+	res := env.Do(http.MethodPost, "/api/auth/signin", creds)
+	var body api.ErrorResponse
+	json.NewDecoder(res.Body).Decode(&body)
+	if res.Code != http.StatusForbidden || body.Hint != "verify" || apitest.Cookie(res, "access_token") != nil {
+		t.Fatalf("signin past the grace period: got %d %+v, want 403 with the verify hint and no tokens", res.Code, body)
+	}
+
+	verification, _ := env.Mailer.LastFrom(creds.Email, "user-signup.html")
+	env.Do(http.MethodPost, "/api/auth/verify?token="+verification.Token(), nil)
+	access, _ = signIn(t, env, creds)
+	if claims := tokenClaims(t, access); claims.Unverified || !claims.Verified {
+		t.Fatalf("signin after verifying: got unverified %t and verified %t, want a verified token", claims.Unverified, claims.Verified)
+	}
+}
+
+func TestVerifyRedirects(t *testing.T) {
+	env := apitest.NewWithConfig(t, func(cfg *api.Config) {
+		cfg.VerifySuccessURL = "https://mixtape.com/verified"
+		cfg.VerifyFailureURL = "https://mixtape.com/verify-failed?lang=en"
+	})
+	creds := api.Credentials{Username: "bear", Email: "bear@berkeley.edu", Password: "pw"}
+	env.Do(http.MethodPost, "/api/auth/signup", creds)
+	verification, _ := env.Mailer.LastFrom(creds.Email, "user-signup.html")
+
+	for _, check := range []struct {
+		step  string
+		token string
+		want  string
+	}{
+		{"valid token", verification.Token(), "https://mixtape.com/verified"},
+		{"valid token followed again", verification.Token(), "https://mixtape.com/verified"},
+		{"unknown token", "v_unknown", "https://mixtape.com/verify-failed?lang=en&reason=not_found"},
+		{"no token", "", "https://mixtape.com/verify-failed?lang=en&reason=missing_token"},
+		{"reset token", "r_" + verification.Token()[2:], "https://mixtape.com/verify-failed?lang=en&reason=wrong_token"},
+	} {
+		res := env.Do(http.MethodGet, "/api/auth/verify?token="+check.token, nil)
+		if res.Code != http.StatusFound || res.Header().Get("Location") != check.want {
+			t.Fatalf("verify with a %s: got %d to %q, want 302 to %s", check.step, res.Code, res.Header().Get("Location"), check.want)
+		}
+	}
+}
+
+func TestVerifyWithoutRedirectsAnswersJSON(t *testing.T) {
+	env := apitest.NewWithConfig(t, func(cfg *api.Config) {
+		cfg.VerifySuccessURL = ""
+		cfg.VerifyFailureURL = ""
+	})
+	creds := api.Credentials{Username: "bear", Email: "bear@berkeley.edu", Password: "pw"}
+	env.Do(http.MethodPost, "/api/auth/signup", creds)
+	verification, _ := env.Mailer.LastFrom(creds.Email, "user-signup.html")
+
+	res := env.Do(http.MethodGet, "/api/auth/verify?token="+verification.Token(), nil)
+	expectSuccess(t, "verify", res, http.StatusOK, "email verified")
+	res = env.Do(http.MethodGet, "/api/auth/verify?token=v_unknown", nil)
+	if res.Code != http.StatusNotFound || res.Header().Get("Location") != "" {
+		t.Fatalf("verify with an unknown token: got %d to %q, want a 404", res.Code, res.Header().Get("Location"))
+	}
+}
+
+func TestEmailLinksAcceptGetAndPost(t *testing.T) {
+	env := apitest.NewWithConfig(t, func(cfg *api.Config) {
+		cfg.CORSOrigins = []string{"https://mixtape.com"}
+		cfg.VerifySuccessURL = ""
+		cfg.VerifyFailureURL = ""
+	})
+	for _, method := range []string{http.MethodGet, http.MethodPost} {
+		creds := api.Credentials{Username: "bear" + strings.ToLower(method), Email: strings.ToLower(method) + "@berkeley.edu", Password: "pw"}
+		env.Do(http.MethodPost, "/api/auth/signup", creds)
+		verification, _ := env.Mailer.LastFrom(creds.Email, "user-signup.html")
+		res := env.Do(method, "/api/auth/verify?token="+verification.Token(), nil)
+		expectSuccess(t, method+" verify", res, http.StatusOK, "email verified")
+
+		token := requestReset(t, env, creds.Email)
+		if res := env.Do(method, "/api/auth/resetpw/validate?token="+token, nil); res.Code != http.StatusOK {
+			t.Fatalf("%s resetpw/validate with a fresh token: got %d %s, want 200", method, res.Code, res.Body.String())
+		}
+
+		access, _ := signIn(t, env, creds)
+		token = addSecondaryEmail(t, env, access, "golden-"+creds.Email)
+		if res := env.Do(method, "/api/auth/emails/verify?token="+token, nil); res.Code != http.StatusOK {
+			t.Fatalf("%s emails/verify: got %d %s, want 200", method, res.Code, res.Body.String())
+		}
+	}
+
+	for _, path := range []string{"/api/auth/verify", "/api/auth/resetpw/validate", "/api/auth/emails/verify"} {
+		res := sendFrom(env, http.MethodOptions, path, "https://mixtape.com")
+		if res.Code != http.StatusNoContent || res.Header().Get("Access-Control-Allow-Origin") != "https://mixtape.com" {
+			t.Fatalf("preflight for %s: got %d with Access-Control-Allow-Origin %q, want 204 allowing the origin", path, res.Code, res.Header().Get("Access-Control-Allow-Origin"))
+		}
+		if res := env.Do(http.MethodPut, path+"?token=v_unknown", nil); res.Code != http.StatusMethodNotAllowed {
+			t.Fatalf("PUT %s: got %d, want 405", path, res.Code)
+		}
+	}
+}
+
+func TestSigninUpgradesBcryptHashToArgon2id(t *testing.T) {
+	env := apitest.NewWithConfig(t, func(cfg *api.Config) {
+		cfg.HashAlgorithm = "argon2id"
+		cfg.Argon2Memory = 1024
+	})
+	creds := api.Credentials{Username: "bear", Email: "bear@berkeley.edu", Password: "pw"}
+	signUpVerified(t, env, creds)
+	storedHash := func() string {
+		var hashed string
+		env.DB.QueryRow("SELECT hashedPassword FROM users WHERE email = ?;", creds.Email).Scan(&hashed)
+		return hashed
+	}
+	if hashed := storedHash(); !strings.HasPrefix(hashed, "$argon2id$") {
+		t.Fatalf("signup with HASH_ALGORITHM=argon2id stored %q", hashed)
+	}
+
+	//an account from before the switch still has its bcrypt hash
+	legacy, _ := bcrypt.GenerateFromPassword([]byte(creds.Password), bcrypt.MinCost)
+	env.DB.Exec("UPDATE users SET hashedPassword = ? WHERE email = ?;", legacy, creds.Email)
+
+	wrong := creds
+	wrong.Password = "wrong"
+	if res := env.Do(http.MethodPost, "/api/auth/signin", wrong); res.Code != http.StatusUnauthorized {
+		t.Fatalf("signin with the wrong password: got %d, want 401", res.Code)
+	}
+	if storedHash() != string(legacy) {
+		t.Fatalf("a failed signin replaced the bcrypt hash")
+	}
+
+	signIn(t, env, creds)
+	if hashed := storedHash(); !strings.HasPrefix(hashed, "$argon2id$") {
+		t.Fatalf("bcrypt hash after a signin: got %q, want it upgraded to argon2id", hashed)
+	}
+	signIn(t, env, creds)
+}
@@ -0,0 +1,39 @@
+package api
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"time"
+)
+
+var (
+	//healthCheckTimeout bounds the database ping of a health check, so a hung database fails the check quickly
+	healthCheckTimeout = 2 * time.Second
+)
+
+//HealthResponse is the JSON body of /healthz
+type HealthResponse struct {
+	Status   string `json:"status"`
+	Database string `json:"database"`
+	Breaker  string `json:"breaker"`
+}
+
+//healthz reports whether the service can reach its database, 200 if it can and 503 if it can't.
+//The answer is never cached, a stale "ok" would hide an outage from whoever is polling. The ping counts towards
+//dbBreaker like any other database call, so health checks also close the breaker once the database is back.
+func healthz(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Cache-Control", "no-store")
+
+	ctx, cancel := context.WithTimeout(r.Context(), healthCheckTimeout)
+	defer cancel()
+	err := DB.PingContext(ctx)
+	dbBreaker.record(err)
+	if err != nil {
+		log.Print("health check failed to reach the database: " + err.Error())
+		writeJSON(w, http.StatusServiceUnavailable, HealthResponse{Status: "unavailable", Database: "unreachable", Breaker: dbBreaker.state()})
+		return
+	}
+
+	writeJSON(w, http.StatusOK, HealthResponse{Status: "ok", Database: "ok", Breaker: dbBreaker.state()})
+}
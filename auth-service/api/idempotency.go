@@ -0,0 +1,170 @@
+package api
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"io/ioutil"
+	"net/http"
+	"sync"
+	"time"
+)
+
+var (
+	//idempotencyTTL is how long a response is kept for replay under its Idempotency-Key, 0 turns keys off
+	idempotencyTTL = 24 * time.Hour
+)
+
+const (
+	//idempotencyHeader carries the client's key for a request it may retry
+	idempotencyHeader = "Idempotency-Key"
+	//replayedHeader marks a response replayed from an earlier request with the same key
+	replayedHeader = "Idempotent-Replayed"
+	//maxIdempotencyKeyLength is the longest Idempotency-Key accepted, a UUID needs 36
+	maxIdempotencyKeyLength = 255
+	//maxIdempotentBody caps the request bodies read to fingerprint a keyed request
+	maxIdempotentBody = 64 << 10
+)
+
+//idempotentResponse is what a request with an Idempotency-Key answered, kept for replaying to retries
+type idempotentResponse struct {
+	fingerprint [sha256.Size]byte
+	expires     time.Time
+	done        bool
+	status      int
+	header      http.Header
+	body        []byte
+}
+
+//idempotencyStore keeps the responses to keyed requests until they expire
+type idempotencyStore struct {
+	mu        sync.Mutex
+	responses map[string]*idempotentResponse
+	lastSweep time.Time
+}
+
+//idempotencyKeys holds the responses of every route wrapped in idempotent
+var idempotencyKeys = &idempotencyStore{}
+
+//begin claims key for a request whose body hashes to fingerprint. It returns the stored response if the key
+//was used before, or nil with the claim made if it wasn't, in which case finish or release must follow.
+func (store *idempotencyStore) begin(key string, fingerprint [sha256.Size]byte) *idempotentResponse {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	now := clock.Now()
+	if store.responses == nil {
+		store.responses = map[string]*idempotentResponse{}
+	}
+	//drop expired responses now and then so keys that won't be retried don't pile up
+	if now.Sub(store.lastSweep) > idempotencyTTL {
+		for k, response := range store.responses {
+			if now.After(response.expires) {
+				delete(store.responses, k)
+			}
+		}
+		store.lastSweep = now
+	}
+
+	response, ok := store.responses[key]
+	if ok && now.Before(response.expires) {
+		copied := *response
+		return &copied
+	}
+	store.responses[key] = &idempotentResponse{fingerprint: fingerprint, expires: now.Add(idempotencyTTL)}
+	return nil
+}
+
+//finish stores the response to the request that claimed key
+func (store *idempotencyStore) finish(key string, status int, header http.Header, body []byte) {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+	if response, ok := store.responses[key]; ok {
+		response.done, response.status, response.header, response.body = true, status, header, body
+	}
+}
+
+//release gives up the claim on key, so a retry is processed afresh
+func (store *idempotencyStore) release(key string) {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+	delete(store.responses, key)
+}
+
+//bodyRecorder passes a response through while keeping a copy of its status and body
+type bodyRecorder struct {
+	statusRecorder
+	body bytes.Buffer
+}
+
+//Write keeps a copy of body before passing it on
+func (recorder *bodyRecorder) Write(body []byte) (int, error) {
+	recorder.body.Write(body)
+	return recorder.statusRecorder.Write(body)
+}
+
+//idempotent lets clients safely retry next by sending an Idempotency-Key header. The first request with a key is
+//processed and its response kept for idempotencyTTL; a retry with the same key and body gets that response again,
+//cookies included, with Idempotent-Replayed: true instead of being processed twice. Reusing a key for a different
+//body gets a 422, and retrying while the first request is still running gets a 409. Server errors aren't kept, so
+//a retry after one is processed afresh. Requests without the header are served as usual.
+func idempotent(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		key := r.Header.Get(idempotencyHeader)
+		if key == "" || idempotencyTTL <= 0 || r.Method == http.MethodOptions {
+			next.ServeHTTP(w, r)
+			return
+		}
+		if len(key) > maxIdempotencyKeyLength {
+			writeJSONError(w, r, http.StatusBadRequest, "the Idempotency-Key header is too long")
+			return
+		}
+		body, err := ioutil.ReadAll(http.MaxBytesReader(w, r.Body, maxIdempotentBody))
+		if err != nil {
+			writeJSONError(w, r, http.StatusRequestEntityTooLarge, "request body is too large")
+			return
+		}
+		r.Body = ioutil.NopCloser(bytes.NewReader(body))
+
+		//Keys are scoped to the route, and the body fingerprint only ever lives in memory since it covers the password
+		scope := r.Method + " " + r.URL.Path + " " + key
+		fingerprint := sha256.Sum256(body)
+		stored := idempotencyKeys.begin(scope, fingerprint)
+		if stored != nil {
+			switch {
+			case stored.fingerprint != fingerprint:
+				writeJSONError(w, r, http.StatusUnprocessableEntity, "this Idempotency-Key was already used for a different request")
+			case !stored.done:
+				writeJSONError(w, r, http.StatusConflict, "a request with this Idempotency-Key is still being processed")
+			default:
+				replay(w, stored)
+			}
+			return
+		}
+
+		recorder := &bodyRecorder{statusRecorder: statusRecorder{ResponseWriter: w}}
+		finished := false
+		defer func() {
+			if !finished {
+				idempotencyKeys.release(scope)
+			}
+		}()
+		next.ServeHTTP(recorder, r)
+		if recorder.status == 0 || recorder.status >= http.StatusInternalServerError {
+			return
+		}
+		idempotencyKeys.finish(scope, recorder.status, w.Header().Clone(), recorder.body.Bytes())
+		finished = true
+	})
+}
+
+//replay answers with a stored response. Headers this request already has, such as its own X-Request-Id, are kept.
+func replay(w http.ResponseWriter, stored *idempotentResponse) {
+	for name, values := range stored.header {
+		if _, ok := w.Header()[name]; !ok {
+			w.Header()[name] = values
+		}
+	}
+	w.Header().Set(replayedHeader, "true")
+	w.WriteHeader(stored.status)
+	w.Write(stored.body)
+}
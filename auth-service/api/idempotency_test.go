@@ -0,0 +1,79 @@
+package api_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/BearCloud/fa20-project-dev/backend/auth-service/api"
+	"github.com/BearCloud/fa20-project-dev/backend/auth-service/apitest"
+)
+
+//signupWithKey signs up creds with key as the Idempotency-Key header
+func signupWithKey(env *apitest.Env, key string, creds api.Credentials) *httptest.ResponseRecorder {
+	req := env.Request(http.MethodPost, "/api/auth/signup", creds)
+	req.Header.Set("Idempotency-Key", key)
+	return env.Send(req)
+}
+
+func TestRetriedSignupReplaysResponse(t *testing.T) {
+	env := apitest.New(t)
+	creds := api.Credentials{Username: "bear", Email: "retried@berkeley.edu", Password: "pw"}
+
+	first := signupWithKey(env, "retried-signup-key", creds)
+	if first.Code != http.StatusCreated {
+		t.Fatalf("signup: got %d %s", first.Code, first.Body.String())
+	}
+	retry := signupWithKey(env, "retried-signup-key", creds)
+	if retry.Code != http.StatusCreated || retry.Header().Get("Idempotent-Replayed") != "true" {
+		t.Fatalf("retried signup: got %d with Idempotent-Replayed %q, want the cached 201", retry.Code, retry.Header().Get("Idempotent-Replayed"))
+	}
+	if retry.Body.String() != first.Body.String() {
+		t.Fatalf("retried signup: got body %s, want the original %s", retry.Body.String(), first.Body.String())
+	}
+	if access := apitest.Cookie(retry, "access_token"); access == nil || access.Value != apitest.Cookie(first, "access_token").Value {
+		t.Fatalf("retried signup didn't replay the session cookies")
+	}
+	if retry.Header().Get("X-Request-ID") == first.Header().Get("X-Request-ID") {
+		t.Fatalf("retried signup reused the first request's X-Request-ID")
+	}
+	users, verifications := countRows(t, env, "users", "email", creds.Email), env.Mailer.Count(creds.Email, "user-signup.html")
+	if users != 1 || verifications != 1 {
+		t.Fatalf("retried signup: got %d users and %d verification emails, want one of each", users, verifications)
+	}
+
+	//a retry without the key is processed again
+	if res := env.Do(http.MethodPost, "/api/auth/signup", creds); res.Code != http.StatusConflict {
+		t.Fatalf("signup again without a key: got %d, want 409", res.Code)
+	}
+}
+
+func TestIdempotencyKeyReusedForAnotherSignup(t *testing.T) {
+	env := apitest.New(t)
+	signupWithKey(env, "reused-signup-key", api.Credentials{Username: "bear", Email: "bear@berkeley.edu", Password: "pw"})
+
+	res := signupWithKey(env, "reused-signup-key", api.Credentials{Username: "tree", Email: "tree@stanford.edu", Password: "pw"})
+	if res.Code != http.StatusUnprocessableEntity {
+		t.Fatalf("signup reusing a key for another body: got %d, want 422", res.Code)
+	}
+	if n := countRows(t, env, "users", "email", "tree@stanford.edu"); n != 0 {
+		t.Fatalf("signup reusing a key created the account")
+	}
+}
+
+func TestIdempotencyKeyExpires(t *testing.T) {
+	clock := apitest.NewFakeClock(time.Now())
+	env := apitest.NewWithConfig(t, func(cfg *api.Config) {
+		cfg.Clock = clock
+		cfg.IdempotencyTTL = time.Hour
+	})
+	creds := api.Credentials{Username: "bear", Email: "bear@berkeley.edu", Password: "pw"}
+	signupWithKey(env, "expiring-signup-key", creds)
+
+	clock.Advance(time.Hour + time.Second)
+	res := signupWithKey(env, "expiring-signup-key", creds)
+	if res.Code != http.StatusConflict || res.Header().Get("Idempotent-Replayed") != "" {
+		t.Fatalf("retry after IDEMPOTENCY_TTL: got %d, want it processed again and refused as taken", res.Code)
+	}
+}
@@ -0,0 +1,49 @@
+package api
+
+import (
+	"database/sql"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+var (
+	//identityChangeCooldown is how long an account must wait between username or primary email changes,
+	//so a handle can't be churned to impersonate others. 0 lets changes through at any time.
+	identityChangeCooldown = 30 * 24 * time.Hour
+)
+
+//CooldownResponse is the JSON body of a username or email change attempted during the cooldown
+type CooldownResponse struct {
+	ErrorResponse
+	RetryAt time.Time `json:"retryAt"`
+}
+
+//nextIdentityChange returns when userID may next change its username or primary email,
+//the zero time if it may change them now
+func nextIdentityChange(userID string) (time.Time, error) {
+	var lastChange sql.NullTime
+	err := withRetry(func() error {
+		return DB.QueryRow("SELECT lastUsernameChangeAt FROM users WHERE userId = ?;", userID).Scan(&lastChange)
+	})
+	if err != nil {
+		return time.Time{}, err
+	}
+	if !lastChange.Valid || identityChangeCooldown <= 0 {
+		return time.Time{}, nil
+	}
+	retryAt := lastChange.Time.Add(identityChangeCooldown)
+	if !clock.Now().Before(retryAt) {
+		return time.Time{}, nil
+	}
+	return retryAt, nil
+}
+
+//identityChangeTooSoon answers a change attempted before retryAt with a 429
+func identityChangeTooSoon(w http.ResponseWriter, r *http.Request, retryAt time.Time) {
+	w.Header().Set("Retry-After", strconv.Itoa(int(until(retryAt).Seconds())+1))
+	writeJSON(w, http.StatusTooManyRequests, CooldownResponse{
+		ErrorResponse: ErrorResponse{Status: "error", Message: "your username or email was changed recently, try again later", CorrelationID: requestIDFromContext(r.Context())},
+		RetryAt:       retryAt.UTC(),
+	})
+}
@@ -0,0 +1,77 @@
+package api_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/BearCloud/fa20-project-dev/backend/auth-service/api"
+	"github.com/BearCloud/fa20-project-dev/backend/auth-service/apitest"
+)
+
+func TestUsernameChangeCooldown(t *testing.T) {
+	clock := apitest.NewFakeClock(time.Now())
+	env := apitest.NewWithConfig(t, func(cfg *api.Config) {
+		cfg.Clock = clock
+		cfg.IdentityCooldown = 30 * 24 * time.Hour
+	})
+	creds := api.Credentials{Username: "bear", Email: "bear@berkeley.edu", Password: "pw"}
+	signUpVerified(t, env, creds)
+	access, _ := signIn(t, env, creds)
+
+	//the first change isn't held back by the signup
+	changedAt := clock.Now()
+	if profile := patchProfile(t, env, access, `{"username":"oski"}`); profile.Username != "oski" {
+		t.Fatalf("first username change: got %q, want oski", profile.Username)
+	}
+
+	clock.Advance(29 * 24 * time.Hour)
+	access, _ = signIn(t, env, creds)
+	res := env.Do(http.MethodPatch, "/api/auth/me", `{"username":"golden"}`, access)
+	var body api.CooldownResponse
+	json.NewDecoder(res.Body).Decode(&body)
+	if res.Code != http.StatusTooManyRequests || res.Header().Get("Retry-After") == "" {
+		t.Fatalf("username change within the cooldown: got %d with Retry-After %q, want 429 with one", res.Code, res.Header().Get("Retry-After"))
+	}
+	if want := changedAt.Add(30 * 24 * time.Hour); !body.RetryAt.Equal(want) {
+		t.Fatalf("username change within the cooldown: got retryAt %s, want %s", body.RetryAt, want)
+	}
+	//sending the current username again isn't a change
+	patchProfile(t, env, access, `{"username":"oski"}`)
+
+	clock.Advance(24 * time.Hour)
+	access, _ = signIn(t, env, creds)
+	if profile := patchProfile(t, env, access, `{"username":"golden"}`); profile.Username != "golden" {
+		t.Fatalf("username change after the cooldown: got %q, want golden", profile.Username)
+	}
+}
+
+func TestPrimaryEmailChangeSharesCooldown(t *testing.T) {
+	clock := apitest.NewFakeClock(time.Now())
+	env := apitest.NewWithConfig(t, func(cfg *api.Config) {
+		cfg.Clock = clock
+		cfg.IdentityCooldown = 30 * 24 * time.Hour
+	})
+	creds := api.Credentials{Username: "bear", Email: "bear@berkeley.edu", Password: "pw"}
+	signUpVerified(t, env, creds)
+	access, _ := signIn(t, env, creds)
+	token := addSecondaryEmail(t, env, access, "golden@bears.org")
+	res := env.Do(http.MethodPost, "/api/auth/emails/verify?token="+token, nil)
+	if res.Code != http.StatusOK {
+		t.Fatalf("verifying the secondary email: got %d %s", res.Code, res.Body.String())
+	}
+	patchProfile(t, env, access, `{"username":"oski"}`)
+
+	res = env.Do(http.MethodPost, "/api/auth/emails/golden@bears.org/primary", nil, access)
+	if res.Code != http.StatusTooManyRequests {
+		t.Fatalf("primary email change right after a username change: got %d %s, want 429", res.Code, res.Body.String())
+	}
+
+	clock.Advance(30 * 24 * time.Hour)
+	access, _ = signIn(t, env, creds)
+	res = env.Do(http.MethodPost, "/api/auth/emails/golden@bears.org/primary", nil, access)
+	if res.Code != http.StatusOK {
+		t.Fatalf("primary email change after the cooldown: got %d %s, want 200", res.Code, res.Body.String())
+	}
+}
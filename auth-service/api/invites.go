@@ -0,0 +1,249 @@
+package api
+
+import (
+	"database/sql"
+	"errors"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+const (
+	inviteCodeSize = 12
+
+	//signupModeOpen lets anyone sign up
+	signupModeOpen = "open"
+	//signupModeInvite requires a valid, unused invite code to sign up
+	signupModeInvite = "invite"
+	//signupModeClosed rejects every signup
+	signupModeClosed = "closed"
+)
+
+var (
+	//signupMode controls who may create an account
+	signupMode = signupModeOpen
+)
+
+//errInvalidInvite is returned when an invite code doesn't exist, was already used, was revoked or has expired
+var errInvalidInvite = errors.New("this invite code is invalid or has already been used")
+
+//consumeInvite marks code as used by userID and returns the role it grants, "" for the default role.
+//Codes bound to an email only work for that email, compared trimmed and lowercased as createInvite stores it.
+//The conditional update makes sure two signups can't both use the same code.
+func consumeInvite(code string, email string, userID string) (string, error) {
+	if code == "" {
+		return "", errInvalidInvite
+	}
+	now := clock.Now()
+	result, err := DB.Exec("UPDATE invites SET usedBy = ?, usedAt = ? WHERE code = ? AND usedAt IS NULL AND revokedAt IS NULL AND (expiresAt IS NULL OR expiresAt > ?) AND (email IS NULL OR email = ?);", userID, now, code, now, normalizeEmail(email))
+	if err != nil {
+		return "", err
+	}
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return "", err
+	}
+	if affected == 0 {
+		return "", errInvalidInvite
+	}
+
+	var role sql.NullString
+	err = DB.QueryRow("SELECT role FROM invites WHERE code = ?;", code).Scan(&role)
+	return role.String, err
+}
+
+//releaseInvite makes code usable again after a signup that consumed it failed
+func releaseInvite(code string) error {
+	_, err := DB.Exec("UPDATE invites SET usedBy = NULL, usedAt = NULL WHERE code = ?;", code)
+	return err
+}
+
+//Invite is an invite code as returned by the admin endpoints
+type Invite struct {
+	Code      string     `json:"code"`
+	Email     string     `json:"email,omitempty"`
+	Role      string     `json:"role,omitempty"`
+	CreatedBy string     `json:"createdBy"`
+	CreatedAt time.Time  `json:"createdAt"`
+	ExpiresAt *time.Time `json:"expiresAt,omitempty"`
+	UsedBy    string     `json:"usedBy,omitempty"`
+	UsedAt    *time.Time `json:"usedAt,omitempty"`
+	RevokedAt *time.Time `json:"revokedAt,omitempty"`
+}
+
+//InviteRequest is the body accepted when creating an invite, every field is optional.
+//Role is given to the account created with the invite instead of defaultRole.
+type InviteRequest struct {
+	Email     string     `json:"email"`
+	Role      string     `json:"role"`
+	ExpiresAt *time.Time `json:"expiresAt"`
+}
+
+//InviteResponse is the JSON body returned after creating an invite
+type InviteResponse struct {
+	SuccessResponse
+	Invite Invite `json:"invite"`
+}
+
+//InviteListResponse is the JSON body returned when listing invites
+type InviteListResponse struct {
+	SuccessResponse
+	Invites []Invite `json:"invites"`
+}
+
+//nullTimePtr converts a nullable column to a pointer so it can be omitted from JSON
+func nullTimePtr(t sql.NullTime) *time.Time {
+	if !t.Valid {
+		return nil
+	}
+	return &t.Time
+}
+
+func createInvite(w http.ResponseWriter, r *http.Request) {
+
+	if (*r).Method == "OPTIONS" {
+		return
+	}
+
+	request := InviteRequest{}
+	err := decodeJSON(r.Body, &request)
+	if err != nil {
+		writeJSONError(w, r, http.StatusBadRequest, decodeErrorMessage(err, "issue retrieving invite details"))
+		log.Print(err.Error())
+		return
+	}
+
+	if request.ExpiresAt != nil && !request.ExpiresAt.After(clock.Now()) {
+		writeJSONError(w, r, http.StatusBadRequest, "expiresAt must be in the future")
+		return
+	}
+	request.Email = normalizeEmail(request.Email)
+	if request.Email != "" {
+		request.Email, err = cleanText("email", request.Email, emailMaxLength)
+		if err != nil {
+			writeJSONError(w, r, http.StatusBadRequest, err.Error())
+			return
+		}
+		if !strings.Contains(request.Email, "@") {
+			writeJSONError(w, r, http.StatusBadRequest, "invalid email address")
+			return
+		}
+	}
+	if request.Role != "" && !validRole(request.Role) {
+		writeJSONError(w, r, http.StatusBadRequest, "role must be lowercase letters, digits, \"_\" or \"-\", up to 20 characters")
+		return
+	}
+
+	claims, _ := claimsFromContext(r.Context())
+	invite := Invite{
+		Code:      GetRandomBase62(inviteCodeSize),
+		Email:     request.Email,
+		Role:      request.Role,
+		CreatedBy: claims.UserID,
+		CreatedAt: clock.Now(),
+		ExpiresAt: request.ExpiresAt,
+	}
+
+	//an empty email is stored as NULL so the code works for any address
+	var email sql.NullString
+	if invite.Email != "" {
+		email = sql.NullString{String: invite.Email, Valid: true}
+	}
+	_, err = DB.Exec("INSERT INTO invites (code, email, role, createdBy, createdAt, expiresAt) VALUES (?, ?, ?, ?, ?, ?);", invite.Code, email, nullableString(invite.Role), invite.CreatedBy, invite.CreatedAt, invite.ExpiresAt)
+	if err != nil {
+		internalError(w, r, "error creating invite", err)
+		return
+	}
+
+	recordAudit(claims.UserID, auditCreateInvite, invite.Code)
+
+	writeJSON(w, http.StatusCreated, InviteResponse{
+		SuccessResponse: SuccessResponse{Status: "ok", Message: "invite created"},
+		Invite:          invite,
+	})
+}
+
+func listInvites(w http.ResponseWriter, r *http.Request) {
+
+	if (*r).Method == "OPTIONS" {
+		return
+	}
+
+	rows, err := DB.Query("SELECT code, email, role, createdBy, createdAt, expiresAt, usedBy, usedAt, revokedAt FROM invites ORDER BY createdAt DESC;")
+	if err != nil {
+		internalError(w, r, "error retrieving invites", err)
+		return
+	}
+	defer rows.Close()
+
+	invites := []Invite{}
+	for rows.Next() {
+		var invite Invite
+		var email, role, usedBy sql.NullString
+		var expiresAt, usedAt, revokedAt sql.NullTime
+		err = rows.Scan(&invite.Code, &email, &role, &invite.CreatedBy, &invite.CreatedAt, &expiresAt, &usedBy, &usedAt, &revokedAt)
+		if err != nil {
+			internalError(w, r, "error retrieving invites", err)
+			return
+		}
+		invite.Email = email.String
+		invite.Role = role.String
+		invite.UsedBy = usedBy.String
+		invite.ExpiresAt = nullTimePtr(expiresAt)
+		invite.UsedAt = nullTimePtr(usedAt)
+		invite.RevokedAt = nullTimePtr(revokedAt)
+		invites = append(invites, invite)
+	}
+	if err = rows.Err(); err != nil {
+		internalError(w, r, "error retrieving invites", err)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, InviteListResponse{
+		SuccessResponse: SuccessResponse{Status: "ok", Message: "invites retrieved"},
+		Invites:         invites,
+	})
+}
+
+func revokeInvite(w http.ResponseWriter, r *http.Request) {
+
+	if (*r).Method == "OPTIONS" {
+		return
+	}
+
+	code := mux.Vars(r)["code"]
+
+	var usedAt, revokedAt sql.NullTime
+	err := DB.QueryRow("SELECT usedAt, revokedAt FROM invites WHERE code = ?;", code).Scan(&usedAt, &revokedAt)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			writeJSONError(w, r, http.StatusNotFound, "this invite does not exist")
+		} else {
+			internalError(w, r, "error retrieving invite", err)
+		}
+		return
+	}
+
+	if usedAt.Valid {
+		writeJSONError(w, r, http.StatusConflict, "this invite has already been used")
+		return
+	}
+	if revokedAt.Valid {
+		writeJSONError(w, r, http.StatusConflict, "this invite has already been revoked")
+		return
+	}
+
+	_, err = DB.Exec("UPDATE invites SET revokedAt = ? WHERE code = ? AND usedAt IS NULL;", clock.Now(), code)
+	if err != nil {
+		internalError(w, r, "error revoking invite", err)
+		return
+	}
+
+	claims, _ := claimsFromContext(r.Context())
+	recordAudit(claims.UserID, auditRevokeInvite, code)
+
+	writeJSONSuccess(w, http.StatusOK, "invite revoked")
+}
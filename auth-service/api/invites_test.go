@@ -0,0 +1,206 @@
+package api_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/BearCloud/fa20-project-dev/backend/auth-service/api"
+	"github.com/BearCloud/fa20-project-dev/backend/auth-service/apitest"
+)
+
+//createInvite creates an invite with request as admin and returns it
+func createInvite(t *testing.T, env *apitest.Env, admin *http.Cookie, request api.InviteRequest) api.Invite {
+	t.Helper()
+	res := env.Do(http.MethodPost, "/api/auth/admin/invites", request, admin)
+	if res.Code != http.StatusCreated {
+		t.Fatalf("creating invite: got %d %s", res.Code, res.Body.String())
+	}
+	var body api.InviteResponse
+	json.NewDecoder(res.Body).Decode(&body)
+	return body.Invite
+}
+
+//signInFirstAdmin signs in an admin who signed up with an invite inserted straight into the database, as the first
+//account of an invite-only api has to
+func signInFirstAdmin(t *testing.T, env *apitest.Env) *http.Cookie {
+	t.Helper()
+	_, err := env.DB.Exec("INSERT INTO invites (code, createdBy, createdAt) VALUES (?, ?, ?);", "first-admin", "seed", time.Now())
+	if err != nil {
+		t.Fatalf("inserting the first invite: %v", err)
+	}
+	return signInAdmin(t, env, api.Credentials{Username: "oski", Email: "oski@berkeley.edu", Password: "pw", InviteCode: "first-admin"})
+}
+
+func TestClosedSignups(t *testing.T) {
+	env := apitest.NewWithConfig(t, func(cfg *api.Config) {
+		cfg.SignupMode = "closed"
+	})
+	res := env.Do(http.MethodPost, "/api/auth/signup", api.Credentials{Username: "bear", Email: "bear@berkeley.edu", Password: "pw"})
+	if res.Code != http.StatusForbidden {
+		t.Fatalf("signup while closed: got %d, want 403", res.Code)
+	}
+}
+
+func TestInviteOnlySignups(t *testing.T) {
+	env := apitest.NewWithConfig(t, func(cfg *api.Config) {
+		cfg.SignupMode = "invite"
+	})
+	admin := signInFirstAdmin(t, env)
+	invite := createInvite(t, env, admin, api.InviteRequest{})
+
+	bear := api.Credentials{Username: "bear", Email: "bear@berkeley.edu", Password: "pw"}
+	res := env.Do(http.MethodPost, "/api/auth/signup", bear)
+	if res.Code != http.StatusForbidden {
+		t.Fatalf("signup without an invite: got %d, want 403", res.Code)
+	}
+	bear.InviteCode = "not-a-code"
+	res = env.Do(http.MethodPost, "/api/auth/signup", bear)
+	if res.Code != http.StatusForbidden {
+		t.Fatalf("signup with an unknown invite: got %d, want 403", res.Code)
+	}
+	bear.InviteCode = invite.Code
+	res = env.Do(http.MethodPost, "/api/auth/signup", bear)
+	if res.Code != http.StatusCreated {
+		t.Fatalf("signup with an invite: got %d %s", res.Code, res.Body.String())
+	}
+	tree := api.Credentials{Username: "tree", Email: "tree@stanford.edu", Password: "pw", InviteCode: invite.Code}
+	res = env.Do(http.MethodPost, "/api/auth/signup", tree)
+	if res.Code != http.StatusForbidden {
+		t.Fatalf("signup with a used invite: got %d, want 403", res.Code)
+	}
+}
+
+func TestInviteEmailNormalized(t *testing.T) {
+	env := apitest.NewWithConfig(t, func(cfg *api.Config) {
+		cfg.SignupMode = "invite"
+	})
+	admin := signInFirstAdmin(t, env)
+
+	res := env.Do(http.MethodPost, "/api/auth/admin/invites", api.InviteRequest{Email: "not an email"}, admin)
+	if res.Code != http.StatusBadRequest {
+		t.Fatalf("invite for an address without @: got %d, want 400", res.Code)
+	}
+
+	invite := createInvite(t, env, admin, api.InviteRequest{Email: "  Bear@Berkeley.EDU "})
+	if invite.Email != "bear@berkeley.edu" {
+		t.Fatalf("invite email: got %q, want it trimmed and lowercased", invite.Email)
+	}
+	res = env.Do(http.MethodPost, "/api/auth/signup", api.Credentials{Username: "tree", Email: "tree@stanford.edu", Password: "pw", InviteCode: invite.Code})
+	if res.Code != http.StatusForbidden {
+		t.Fatalf("signup with an invite for another address: got %d, want 403", res.Code)
+	}
+	res = env.Do(http.MethodPost, "/api/auth/signup", api.Credentials{Username: "bear", Email: " BEAR@berkeley.edu", Password: "pw", InviteCode: invite.Code})
+	if res.Code != http.StatusCreated {
+		t.Fatalf("signup with the invited address typed differently: got %d %s", res.Code, res.Body.String())
+	}
+}
+
+func TestRevokeAndListInvites(t *testing.T) {
+	env := apitest.NewWithConfig(t, func(cfg *api.Config) {
+		cfg.SignupMode = "invite"
+	})
+	admin := signInFirstAdmin(t, env)
+	used := createInvite(t, env, admin, api.InviteRequest{})
+	unused := createInvite(t, env, admin, api.InviteRequest{Role: "admin"})
+	if len(used.Code) == 0 || used.Code == unused.Code {
+		t.Fatalf("created invite codes %q and %q, want two distinct codes", used.Code, unused.Code)
+	}
+	res := env.Do(http.MethodPost, "/api/auth/signup", api.Credentials{Username: "bear", Email: "bear@berkeley.edu", Password: "pw", InviteCode: used.Code})
+	if res.Code != http.StatusCreated {
+		t.Fatalf("signup with an invite: got %d %s", res.Code, res.Body.String())
+	}
+
+	res = env.Do(http.MethodDelete, "/api/auth/admin/invites/"+used.Code, nil, admin)
+	if res.Code != http.StatusConflict {
+		t.Fatalf("revoking a used invite: got %d, want 409", res.Code)
+	}
+	res = env.Do(http.MethodDelete, "/api/auth/admin/invites/"+unused.Code, nil, admin)
+	expectSuccess(t, "revoking an unused invite", res, http.StatusOK, "invite revoked")
+	res = env.Do(http.MethodDelete, "/api/auth/admin/invites/not-a-code", nil, admin)
+	if res.Code != http.StatusNotFound {
+		t.Fatalf("revoking an unknown invite: got %d, want 404", res.Code)
+	}
+	res = env.Do(http.MethodPost, "/api/auth/signup", api.Credentials{Username: "tree", Email: "tree@stanford.edu", Password: "pw", InviteCode: unused.Code})
+	if res.Code != http.StatusForbidden {
+		t.Fatalf("signup with a revoked invite: got %d, want 403", res.Code)
+	}
+
+	res = env.Do(http.MethodGet, "/api/auth/admin/invites", nil, admin)
+	var list api.InviteListResponse
+	json.NewDecoder(res.Body).Decode(&list)
+	states := map[string]api.Invite{}
+	for _, invite := range list.Invites {
+		states[invite.Code] = invite
+	}
+	if states[used.Code].UsedAt == nil || states[unused.Code].RevokedAt == nil {
+		t.Fatalf("listed invites %+v, want the used one marked used and the other revoked", list.Invites)
+	}
+}
+
+func TestExpiredInvite(t *testing.T) {
+	env := apitest.NewWithConfig(t, func(cfg *api.Config) {
+		cfg.SignupMode = "invite"
+	})
+	admin := signInFirstAdmin(t, env)
+	expiresAt := time.Now().Add(time.Hour)
+	invite := createInvite(t, env, admin, api.InviteRequest{ExpiresAt: &expiresAt})
+	_, err := env.DB.Exec("UPDATE invites SET expiresAt = ? WHERE code = ?;", time.Now().Add(-time.Minute), invite.Code)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	res := env.Do(http.MethodPost, "/api/auth/signup", api.Credentials{Username: "bear", Email: "bear@berkeley.edu", Password: "pw", InviteCode: invite.Code})
+	if res.Code != http.StatusForbidden {
+		t.Fatalf("signup with an expired invite: got %d, want 403", res.Code)
+	}
+}
+
+//meRole returns the role of the account signed in with creds
+func meRole(t *testing.T, env *apitest.Env, creds api.Credentials) string {
+	t.Helper()
+	access, _ := signIn(t, env, creds)
+	var profile api.Profile
+	json.NewDecoder(getMe(env, "", access).Body).Decode(&profile)
+	return profile.Role
+}
+
+func TestInviteGrantsRole(t *testing.T) {
+	env := apitest.NewWithConfig(t, func(cfg *api.Config) {
+		cfg.SignupMode = "invite"
+	})
+	admin := signInFirstAdmin(t, env)
+	moderator := createInvite(t, env, admin, api.InviteRequest{Role: "moderator"})
+	plain := createInvite(t, env, admin, api.InviteRequest{})
+	if moderator.Role != "moderator" || plain.Role != "" {
+		t.Fatalf("created invites with roles %q and %q, want moderator and none", moderator.Role, plain.Role)
+	}
+
+	bear := api.Credentials{Username: "bear", Email: "bear@berkeley.edu", Password: "pw", InviteCode: moderator.Code}
+	signUpVerified(t, env, bear)
+	if role := meRole(t, env, bear); role != "moderator" {
+		t.Fatalf("signup with a moderator invite: got role %q, want moderator", role)
+	}
+	tree := api.Credentials{Username: "tree", Email: "tree@stanford.edu", Password: "pw", InviteCode: plain.Code}
+	signUpVerified(t, env, tree)
+	if role := meRole(t, env, tree); role != "user" {
+		t.Fatalf("signup with an invite naming no role: got role %q, want user", role)
+	}
+
+	res := env.Do(http.MethodPost, "/api/auth/admin/invites", api.InviteRequest{Role: "Super Admin"}, admin)
+	if res.Code != http.StatusBadRequest {
+		t.Fatalf("invite with an invalid role: got %d, want 400", res.Code)
+	}
+}
+
+func TestDefaultRoleConfigurable(t *testing.T) {
+	env := apitest.NewWithConfig(t, func(cfg *api.Config) {
+		cfg.DefaultRole = "listener"
+	})
+	creds := api.Credentials{Username: "bear", Email: "bear@berkeley.edu", Password: "pw"}
+	signUpVerified(t, env, creds)
+	if role := meRole(t, env, creds); role != "listener" {
+		t.Fatalf("signup with DEFAULT_ROLE=listener: got role %q", role)
+	}
+}
@@ -1,8 +1,8 @@
 package api
 
 import (
+	"crypto/rand"
 	"errors"
-	"math/rand"
 	"time"
 
 	"github.com/dgrijalva/jwt-go"
@@ -13,19 +13,66 @@ var (
 	DefaultAccessJWTExpiry = 01 * 1440 * time.Minute // refresh every 01 days
 	//DefaultRefreshJWTExpiry is the default refresh token duration
 	DefaultRefreshJWTExpiry = 30 * 1440 * time.Minute // refresh every 30 days
+	//rememberMeRefreshExpiry is the refresh token duration when the user asked to be remembered at signin
+	rememberMeRefreshExpiry = 90 * 1440 * time.Minute
+	//DefaultResetTokenExpiry is how long a password reset token stays valid
+	DefaultResetTokenExpiry = 60 * time.Minute
 	defaultJWTIssuer        = "CalChat"
-	jwtKey                  = []byte("my_secret_key")
+	//tokenLeeway tolerates clock skew between services when checking token times
+	tokenLeeway = 30 * time.Second
 )
 
-//AuthClaims represents the claims in the access token
+const (
+	//amrPassword is the authentication method recorded when the user proved who they are with their password
+	amrPassword = "pwd"
+	//amrEmail is the authentication method recorded when the user was signed in by following an emailed link
+	amrEmail = "email"
+	//amrOTP is added to amrPassword when the user also entered an authenticator app code or a backup code
+	amrOTP = "otp"
+)
+
+//AuthClaims represents the claims in the access token.
+//AuthTime is when the user last authenticated, with the methods in AMR, it carries over when a session is renewed.
+//Custom holds the claims configured with CUSTOM_CLAIMS for downstream services.
+//Unverified marks accounts still in their grace period, downstream services can use it to limit features.
+//Verified is set only once the primary email is verified, so a token without it never passes RequireVerified.
+//TokenVersion must match users.tokenVersion, so bumping the column signs the user out of every device.
+//RememberMe records that the user asked to be remembered at signin, so renewed refresh tokens keep the longer lifetime.
 type AuthClaims struct {
-	UserID string
+	UserID       string
+	AuthTime     int64                  `json:"auth_time,omitempty"`
+	AMR          []string               `json:"amr,omitempty"`
+	Custom       map[string]interface{} `json:"custom,omitempty"`
+	Unverified   bool                   `json:"unverified,omitempty"`
+	Verified     bool                   `json:"verified"`
+	TokenVersion int                    `json:"tokenVersion"`
+	RememberMe   bool                   `json:"rememberMe,omitempty"`
 	jwt.StandardClaims
 }
 
+//Valid checks the token's time claims, allowing tokenLeeway of clock skew either way
+func (claims AuthClaims) Valid() error {
+	now := clock.Now()
+	if !claims.VerifyExpiresAt(now.Add(-tokenLeeway).Unix(), false) {
+		return errors.New("token is expired")
+	}
+	if !claims.VerifyIssuedAt(now.Add(tokenLeeway).Unix(), false) {
+		return errors.New("token used before issued")
+	}
+	if !claims.VerifyNotBefore(now.Add(tokenLeeway).Unix(), false) {
+		return errors.New("token is not valid yet")
+	}
+	return nil
+}
+
 func setClaims(claims AuthClaims) (tokenString string, Error error) {
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
-	tokenString, err := token.SignedString(jwtKey)
+	key, err := primaryKey()
+	if err != nil {
+		return "", err
+	}
+	token := jwt.NewWithClaims(key.method, claims)
+	token.Header["kid"] = key.id
+	tokenString, err = token.SignedString(key.private)
 	if err != nil {
 		return "", err
 	}
@@ -34,9 +81,7 @@ func setClaims(claims AuthClaims) (tokenString string, Error error) {
 
 func getClaims(tokenString string) (claims AuthClaims, Error error) {
 	claims = AuthClaims{}
-	token, err := jwt.ParseWithClaims(tokenString, &claims, func(token *jwt.Token) (interface{}, error) {
-		return jwtKey, nil
-	})
+	token, err := jwt.ParseWithClaims(tokenString, &claims, verificationKey)
 	if err != nil {
 		return AuthClaims{}, err
 	}
@@ -46,13 +91,23 @@ func getClaims(tokenString string) (claims AuthClaims, Error error) {
 	return claims, nil
 }
 
-//GetRandomBase62 returns a string of random base62 characters
+//GetRandomBase62 returns a string of random base62 characters drawn from crypto/rand
 func GetRandomBase62(length int) string {
 	const base62 = "ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz0123456789"
-	rand.Seed(time.Now().Unix())
-	r := make([]byte, length)
-	for i := range r {
-		r[i] = base62[rand.Intn(len(base62))]
+	r := make([]byte, 0, length)
+	buf := make([]byte, length)
+	for len(r) < length {
+		_, err := rand.Read(buf)
+		if err != nil {
+			//crypto/rand only fails when the OS can't supply randomness, no token is safe to hand out then
+			panic(err)
+		}
+		for _, b := range buf {
+			//bytes past the last multiple of 62 are skipped so every character is equally likely
+			if int(b) < 256-256%len(base62) && len(r) < length {
+				r = append(r, base62[int(b)%len(base62)])
+			}
+		}
 	}
 	return string(r)
-}
\ No newline at end of file
+}
@@ -0,0 +1,172 @@
+package api
+
+import (
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"strings"
+
+	"github.com/dgrijalva/jwt-go"
+)
+
+//signingKey is a key tokens are signed and verified with, identified by the kid in the token header
+type signingKey struct {
+	id      string
+	method  jwt.SigningMethod
+	private interface{}
+	public  interface{}
+}
+
+var (
+	//signingKeys holds every key tokens are verified with, the first one also signs new tokens.
+	//The rest are earlier keys still trusted so rotating keys doesn't sign everyone out.
+	//It is set from the JWT_* settings by InitConfig.
+	signingKeys []signingKey
+)
+
+//hmacKey wraps an HS256 secret. Its kid is derived from the secret, so every instance sharing it agrees on the kid.
+func hmacKey(secret []byte) signingKey {
+	sum := sha256.Sum256(append([]byte("kid:"), secret...))
+	return signingKey{id: hex.EncodeToString(sum[:8]), method: jwt.SigningMethodHS256, private: secret, public: secret}
+}
+
+//rsaKey wraps an RS256 public key, with the private key if this instance signs with it.
+//Its kid is derived from the public key.
+func rsaKey(public *rsa.PublicKey, private *rsa.PrivateKey) (signingKey, error) {
+	der, err := x509.MarshalPKIXPublicKey(public)
+	if err != nil {
+		return signingKey{}, err
+	}
+	sum := sha256.Sum256(der)
+	key := signingKey{id: base64.RawURLEncoding.EncodeToString(sum[:12]), method: jwt.SigningMethodRS256, public: public}
+	if private != nil {
+		key.private = private
+	}
+	return key, nil
+}
+
+//loadRSAKey reads a PEM encoded RSA private key from path
+func loadRSAKey(path string) (signingKey, error) {
+	pemBytes, err := ioutil.ReadFile(path)
+	if err != nil {
+		return signingKey{}, err
+	}
+	private, err := jwt.ParseRSAPrivateKeyFromPEM(pemBytes)
+	if err != nil {
+		return signingKey{}, err
+	}
+	return rsaKey(&private.PublicKey, private)
+}
+
+//loadRSAVerificationKey reads a PEM encoded RSA public or private key from path, keeping only the public half
+func loadRSAVerificationKey(path string) (signingKey, error) {
+	pemBytes, err := ioutil.ReadFile(path)
+	if err != nil {
+		return signingKey{}, err
+	}
+	if private, err := jwt.ParseRSAPrivateKeyFromPEM(pemBytes); err == nil {
+		return rsaKey(&private.PublicKey, nil)
+	}
+	public, err := jwt.ParseRSAPublicKeyFromPEM(pemBytes)
+	if err != nil {
+		return signingKey{}, err
+	}
+	return rsaKey(public, nil)
+}
+
+//primaryKey returns the key new tokens are signed with
+func primaryKey() (signingKey, error) {
+	if len(signingKeys) == 0 {
+		return signingKey{}, errors.New("no signing key configured")
+	}
+	return signingKeys[0], nil
+}
+
+//verificationKey picks the key for token by its kid, rejecting keys of a different algorithm
+func verificationKey(token *jwt.Token) (interface{}, error) {
+	kid, _ := token.Header["kid"].(string)
+	//tokens issued before kids were added were all signed with JWT_SECRET
+	if kid == "" && len(signingKeys) > 0 && signingKeys[0].method == jwt.SigningMethodHS256 {
+		kid = signingKeys[0].id
+	}
+	for _, key := range signingKeys {
+		if key.id == kid {
+			if token.Method.Alg() != key.method.Alg() {
+				return nil, errors.New("unexpected signing method " + token.Method.Alg())
+			}
+			return key.public, nil
+		}
+	}
+	return nil, errors.New("unknown signing key")
+}
+
+//JWK is a public key in JSON Web Key format
+type JWK struct {
+	Kty string `json:"kty"`
+	Use string `json:"use"`
+	Alg string `json:"alg"`
+	Kid string `json:"kid"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+}
+
+//JWKSet is the body served at /.well-known/jwks.json
+type JWKSet struct {
+	Keys []JWK `json:"keys"`
+}
+
+//jwks serves the public halves of the RSA signing keys so other services can verify tokens.
+//HS256 secrets are never published, with only those configured the set is empty.
+func jwks(w http.ResponseWriter, r *http.Request) {
+	set := JWKSet{Keys: []JWK{}}
+	for _, key := range signingKeys {
+		public, ok := key.public.(*rsa.PublicKey)
+		if !ok {
+			continue
+		}
+		set.Keys = append(set.Keys, JWK{
+			Kty: "RSA",
+			Use: "sig",
+			Alg: key.method.Alg(),
+			Kid: key.id,
+			N:   base64.RawURLEncoding.EncodeToString(public.N.Bytes()),
+			E:   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(public.E)).Bytes()),
+		})
+	}
+	body, err := json.Marshal(set)
+	if err != nil {
+		internalError(w, r, "error encoding signing keys", err)
+		return
+	}
+
+	//the ETag is a hash of the set itself, so rotating keys changes it without any bookkeeping
+	sum := sha256.Sum256(body)
+	etag := `"` + hex.EncodeToString(sum[:16]) + `"`
+	w.Header().Set("Cache-Control", "public, max-age=300")
+	w.Header().Set("ETag", etag)
+	if etagMatches(r.Header.Get("If-None-Match"), etag) {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	w.Write(append(body, '\n'))
+}
+
+//etagMatches reports whether an If-None-Match header names etag, ignoring weak validator prefixes
+func etagMatches(ifNoneMatch string, etag string) bool {
+	for _, candidate := range strings.Split(ifNoneMatch, ",") {
+		candidate = strings.TrimPrefix(strings.TrimSpace(candidate), "W/")
+		if candidate == "*" || candidate == etag {
+			return true
+		}
+	}
+	return false
+}
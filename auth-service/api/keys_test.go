@@ -0,0 +1,175 @@
+package api_test
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+
+	"github.com/BearCloud/fa20-project-dev/backend/auth-service/api"
+	"github.com/BearCloud/fa20-project-dev/backend/auth-service/apitest"
+	"github.com/dgrijalva/jwt-go"
+)
+
+//writeRSAKey writes a new PEM encoded RSA private key to a file that is removed when the test ends
+func writeRSAKey(t *testing.T) string {
+	t.Helper()
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(t.TempDir(), "jwt.pem")
+	err = ioutil.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)}), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+//publicKey decodes the RSA public key of jwk
+func publicKey(t *testing.T, jwk api.JWK) *rsa.PublicKey {
+	t.Helper()
+	n, err := base64.RawURLEncoding.DecodeString(jwk.N)
+	if err != nil {
+		t.Fatalf("decoding n of %s: %v", jwk.Kid, err)
+	}
+	e, err := base64.RawURLEncoding.DecodeString(jwk.E)
+	if err != nil {
+		t.Fatalf("decoding e of %s: %v", jwk.Kid, err)
+	}
+	return &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}
+}
+
+func TestJWKSServesSigningKey(t *testing.T) {
+	env := apitest.NewWithConfig(t, func(cfg *api.Config) {
+		cfg.JWTPrivateKey = writeRSAKey(t)
+	})
+	creds := api.Credentials{Username: "bear", Email: "bear@berkeley.edu", Password: "pw"}
+	signUpVerified(t, env, creds)
+	access, _ := signIn(t, env, creds)
+
+	res := env.Do(http.MethodGet, "/.well-known/jwks.json", nil)
+	if res.Code != http.StatusOK {
+		t.Fatalf("jwks: got %d %s", res.Code, res.Body.String())
+	}
+	var set api.JWKSet
+	json.NewDecoder(res.Body).Decode(&set)
+	//JWT_SECRET is still trusted for verification, but secrets are never published
+	if len(set.Keys) != 1 {
+		t.Fatalf("jwks: got %d keys, want only the RSA key", len(set.Keys))
+	}
+	jwk := set.Keys[0]
+	if jwk.Kty != "RSA" || jwk.Use != "sig" || jwk.Alg != "RS256" || jwk.Kid == "" {
+		t.Fatalf("jwks: got %+v, want an RS256 signing key with a kid", jwk)
+	}
+
+	token, err := jwt.Parse(access.Value, func(token *jwt.Token) (interface{}, error) {
+		if token.Header["kid"] != jwk.Kid {
+			t.Fatalf("access token kid %v isn't in the JWKS", token.Header["kid"])
+		}
+		return publicKey(t, jwk), nil
+	})
+	if err != nil || !token.Valid {
+		t.Fatalf("access token doesn't verify with the published key: %v", err)
+	}
+
+	cached := fetchJWKS(env, res.Header().Get("ETag"))
+	if cached.Code != http.StatusNotModified {
+		t.Fatalf("jwks with the ETag from before: got %d, want 304", cached.Code)
+	}
+}
+
+//rotateSecret switches the api to sign with secret, still trusting previous for verification
+func rotateSecret(t *testing.T, secret string, previous ...string) {
+	t.Helper()
+	cfg := api.LoadConfig()
+	cfg.JWTSecret = secret
+	cfg.JWTPreviousSecrets = previous
+	cfg.SendGridKey = "apitest-key"
+	err := api.ApplyConfig(cfg)
+	if err != nil {
+		t.Fatalf("rotating the secret: %v", err)
+	}
+}
+
+func TestRotatedKeysStillVerify(t *testing.T) {
+	env := apitest.New(t)
+	creds := api.Credentials{Username: "bear", Email: "bear@berkeley.edu", Password: "pw"}
+	signUpVerified(t, env, creds)
+	old, _ := signIn(t, env, creds)
+
+	rotateSecret(t, "rotated-secret", "apitest-secret")
+	profileUsername(t, "access token signed with the previous secret", getMe(env, "", old))
+	current, _ := signIn(t, env, creds)
+	profileUsername(t, "access token signed with the new secret", getMe(env, "", current))
+	oldToken, _, _ := new(jwt.Parser).ParseUnverified(old.Value, jwt.MapClaims{})
+	currentToken, _, _ := new(jwt.Parser).ParseUnverified(current.Value, jwt.MapClaims{})
+	if oldToken.Header["kid"] == currentToken.Header["kid"] {
+		t.Fatalf("tokens signed with different secrets share the kid %v", oldToken.Header["kid"])
+	}
+
+	//a token naming a key the api doesn't know is rejected, even when its signature would verify with a trusted one
+	claims := jwt.MapClaims{}
+	jwt.ParseWithClaims(old.Value, claims, func(token *jwt.Token) (interface{}, error) { return []byte("apitest-secret"), nil })
+	forged := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
+	forged.Header["kid"] = "unknown"
+	forgedValue, _ := forged.SignedString([]byte("apitest-secret"))
+	if res := getMe(env, "Bearer "+forgedValue); res.Code != http.StatusUnauthorized {
+		t.Fatalf("access token with an unknown kid: got %d, want 401", res.Code)
+	}
+
+	rotateSecret(t, "rotated-secret")
+	if res := getMe(env, "", old); res.Code != http.StatusUnauthorized {
+		t.Fatalf("access token signed with a secret no longer trusted: got %d, want 401", res.Code)
+	}
+}
+
+//fetchJWKS gets the JWK set with ifNoneMatch as the If-None-Match header, unless it is ""
+func fetchJWKS(env *apitest.Env, ifNoneMatch string) *httptest.ResponseRecorder {
+	req := env.Request(http.MethodGet, "/.well-known/jwks.json", nil)
+	if ifNoneMatch != "" {
+		req.Header.Set("If-None-Match", ifNoneMatch)
+	}
+	return env.Send(req)
+}
+
+func TestJWKSETagChangesWhenKeysRotate(t *testing.T) {
+	env := apitest.NewWithConfig(t, func(cfg *api.Config) {
+		cfg.JWTPrivateKey = writeRSAKey(t)
+	})
+	res := fetchJWKS(env, "")
+	etag := res.Header().Get("ETag")
+	if res.Code != http.StatusOK || etag == "" || res.Header().Get("Cache-Control") != "public, max-age=300" {
+		t.Fatalf("jwks: got %d with ETag %q and Cache-Control %q, want both set", res.Code, etag, res.Header().Get("Cache-Control"))
+	}
+	for _, ifNoneMatch := range []string{etag, "W/" + etag, `"older", ` + etag} {
+		res = fetchJWKS(env, ifNoneMatch)
+		if res.Code != http.StatusNotModified || res.Body.Len() != 0 || res.Header().Get("ETag") != etag {
+			t.Fatalf("jwks with If-None-Match %s: got %d %q, want 304 with no body", ifNoneMatch, res.Code, res.Body.String())
+		}
+	}
+
+	env = apitest.NewWithConfig(t, func(cfg *api.Config) {
+		cfg.JWTPrivateKey = writeRSAKey(t)
+	})
+	res = fetchJWKS(env, etag)
+	if res.Code != http.StatusOK || res.Header().Get("ETag") == etag {
+		t.Fatalf("jwks after rotating the key, with the old ETag: got %d with ETag %q, want 200 with a new one", res.Code, res.Header().Get("ETag"))
+	}
+}
+
+func TestHealthNeverCached(t *testing.T) {
+	env := apitest.New(t)
+	res := env.Do(http.MethodGet, "/healthz", nil)
+	if res.Code != http.StatusOK || res.Header().Get("Cache-Control") != "no-store" {
+		t.Fatalf("healthz: got %d with Cache-Control %q, want 200 with no-store", res.Code, res.Header().Get("Cache-Control"))
+	}
+}
@@ -0,0 +1,132 @@
+package api
+
+import (
+	"database/sql"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+var (
+	//maxLoginAttempts is how many failed signins in a row lock an email out, 0 turns lockout off
+	maxLoginAttempts = 5
+	//lockoutDuration is how long a lockout lasts, and how long a failed attempt is remembered
+	lockoutDuration = 15 * time.Minute
+)
+
+//SigninErrorResponse is the JSON body of a failed signin. AttemptsRemaining is left out when lockout is off.
+type SigninErrorResponse struct {
+	ErrorResponse
+	AttemptsRemaining *int `json:"attemptsRemaining,omitempty"`
+}
+
+//lockedOut returns when the lockout on email ends, or the zero time if it isn't locked out
+func lockedOut(email string) (time.Time, error) {
+	if maxLoginAttempts <= 0 {
+		return time.Time{}, nil
+	}
+	var lockedUntil sql.NullTime
+	err := withRetry(func() error {
+		return DB.QueryRow("SELECT lockedUntil FROM login_attempts WHERE email = ?;", email).Scan(&lockedUntil)
+	})
+	if err == sql.ErrNoRows {
+		return time.Time{}, nil
+	}
+	if err != nil {
+		return time.Time{}, err
+	}
+	if !lockedUntil.Valid || lockedUntil.Time.Before(clock.Now()) {
+		return time.Time{}, nil
+	}
+	return lockedUntil.Time, nil
+}
+
+//recordLoginFailure counts a failed signin for email, locking it out once maxLoginAttempts is reached.
+//It returns how many attempts are left before the lockout. Attempts are counted per email as typed, whether
+//or not an account has it, so an unknown email counts down just like a wrong password and doesn't reveal
+//which emails are registered.
+func recordLoginFailure(email string) (int, error) {
+	now := clock.Now()
+
+	//a finished lockout, or failures older than lockoutDuration, start the count over
+	_, err := DB.Exec("UPDATE login_attempts SET failures = 0, lockedUntil = NULL WHERE email = ? AND (lockedUntil < ? OR (lockedUntil IS NULL AND updatedAt < ?));", email, now, now.Add(-lockoutDuration))
+	if err != nil {
+		return 0, err
+	}
+
+	//the increment happens in the database so concurrent failures can't undercount
+	result, err := DB.Exec("UPDATE login_attempts SET failures = failures + 1, updatedAt = ? WHERE email = ?;", now, email)
+	if err != nil {
+		return 0, err
+	}
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return 0, err
+	}
+	if affected == 0 {
+		_, err = DB.Exec("INSERT INTO login_attempts (email, failures, updatedAt) VALUES (?, ?, ?);", email, 1, now)
+		//a concurrent failure inserted the row first, count this one on top of it
+		if err != nil && isDuplicateKey(err) {
+			_, err = DB.Exec("UPDATE login_attempts SET failures = failures + 1, updatedAt = ? WHERE email = ?;", now, email)
+		}
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	var failures int
+	err = DB.QueryRow("SELECT failures FROM login_attempts WHERE email = ?;", email).Scan(&failures)
+	if err != nil {
+		return 0, err
+	}
+	if failures < maxLoginAttempts {
+		return maxLoginAttempts - failures, nil
+	}
+
+	_, err = DB.Exec("UPDATE login_attempts SET lockedUntil = ? WHERE email = ? AND lockedUntil IS NULL;", now.Add(lockoutDuration), email)
+	return 0, err
+}
+
+//clearLoginFailures forgets the failed signins for email after a successful one
+func clearLoginFailures(email string) error {
+	if maxLoginAttempts <= 0 {
+		return nil
+	}
+	_, err := DB.Exec("DELETE FROM login_attempts WHERE email = ?;", email)
+	return err
+}
+
+//signinFailed answers a failed signin with a 401 and how many attempts are left, the same way for an unknown
+//email as for a wrong password, after the signinBackoff delay. Failures from LIMIT_BYPASS_CIDRS aren't counted,
+//so a noisy CI job can't lock out or slow down the real user.
+func signinFailed(w http.ResponseWriter, r *http.Request, email string) {
+	passwordFailed(w, r, email, signinBackoffKey(clientIP(r), email), "invalid credentials")
+}
+
+//passwordFailed answers a wrong password with a 401 and message. The failure counts towards the lockout of
+//lockoutKey, and the answer waits out the signinBackoff delay of backoffKey, unless it came from LIMIT_BYPASS_CIDRS.
+func passwordFailed(w http.ResponseWriter, r *http.Request, lockoutKey string, backoffKey string, message string) {
+	response := SigninErrorResponse{ErrorResponse: ErrorResponse{Status: "error", Message: message, CorrelationID: requestIDFromContext(r.Context())}}
+	if maxLoginAttempts > 0 && !limitBypassed(r) {
+		remaining, err := recordLoginFailure(lockoutKey)
+		if err != nil {
+			internalError(w, r, "error counting failed signin", err)
+			return
+		}
+		response.AttemptsRemaining = &remaining
+	}
+	if !limitBypassed(r) {
+		signinSleeper(r.Context(), signinBackoff.fail(backoffKey))
+	}
+	writeJSON(w, http.StatusUnauthorized, response)
+}
+
+//signinLockedOut answers a signin for an email that is locked out until lockedUntil with a 429
+func signinLockedOut(w http.ResponseWriter, r *http.Request, lockedUntil time.Time) {
+	remaining := 0
+	w.Header().Set("Retry-After", strconv.Itoa(int(until(lockedUntil).Seconds())+1))
+	writeJSON(w, http.StatusTooManyRequests, SigninErrorResponse{
+		ErrorResponse:     ErrorResponse{Status: "error", Message: "too many failed sign in attempts, try again later", CorrelationID: requestIDFromContext(r.Context())},
+		AttemptsRemaining: &remaining,
+	})
+}
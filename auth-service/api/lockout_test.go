@@ -0,0 +1,145 @@
+package api_test
+
+import (
+	"encoding/json"
+	"net"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/BearCloud/fa20-project-dev/backend/auth-service/api"
+	"github.com/BearCloud/fa20-project-dev/backend/auth-service/apitest"
+)
+
+//failedSignin signs in with creds, expecting status, and returns the body
+func failedSignin(t *testing.T, env *apitest.Env, creds api.Credentials, status int) api.SigninErrorResponse {
+	t.Helper()
+	res := env.Do(http.MethodPost, "/api/auth/signin", creds)
+	if res.Code != status {
+		t.Fatalf("signin as %s with %q: got %d %s, want %d", creds.Email, creds.Password, res.Code, res.Body.String(), status)
+	}
+	var body api.SigninErrorResponse
+	json.NewDecoder(res.Body).Decode(&body)
+	return body
+}
+
+//remaining returns the attemptsRemaining of body, or -1 if it has none
+func remaining(body api.SigninErrorResponse) int {
+	if body.AttemptsRemaining == nil {
+		return -1
+	}
+	return *body.AttemptsRemaining
+}
+
+func TestSigninCountsDownToLockout(t *testing.T) {
+	clock := apitest.NewFakeClock(time.Now())
+	env := apitest.NewWithConfig(t, func(cfg *api.Config) {
+		cfg.Clock = clock
+		cfg.MaxLoginAttempts = 3
+		cfg.LockoutDuration = 15 * time.Minute
+	})
+	creds := api.Credentials{Username: "bear", Email: "bear@berkeley.edu", Password: "pw"}
+	signUpVerified(t, env, creds)
+	wrong := api.Credentials{Email: creds.Email, Password: "wrong"}
+
+	for _, want := range []int{2, 1, 0} {
+		body := failedSignin(t, env, wrong, http.StatusUnauthorized)
+		if got := remaining(body); got != want || body.Message != "invalid credentials" {
+			t.Fatalf("wrong password: got %q with attemptsRemaining %d, want %d", body.Message, got, want)
+		}
+	}
+	res := env.Do(http.MethodPost, "/api/auth/signin", creds)
+	if res.Code != http.StatusTooManyRequests || res.Header().Get("Retry-After") == "" {
+		t.Fatalf("right password during the lockout: got %d with Retry-After %q, want 429 with one", res.Code, res.Header().Get("Retry-After"))
+	}
+
+	clock.Advance(15*time.Minute + time.Second)
+	signIn(t, env, creds)
+}
+
+func TestUnknownEmailCountsDownTheSame(t *testing.T) {
+	env := apitest.NewWithConfig(t, func(cfg *api.Config) {
+		cfg.MaxLoginAttempts = 3
+	})
+	signUpVerified(t, env, api.Credentials{Username: "bear", Email: "bear@berkeley.edu", Password: "pw"})
+
+	known := failedSignin(t, env, api.Credentials{Email: "bear@berkeley.edu", Password: "wrong"}, http.StatusUnauthorized)
+	unknown := failedSignin(t, env, api.Credentials{Email: "tree@stanford.edu", Password: "wrong"}, http.StatusUnauthorized)
+	if known.Message != unknown.Message || remaining(known) != remaining(unknown) {
+		t.Fatalf("wrong password got %+v, unknown email got %+v, want the same answer", known, unknown)
+	}
+	failedSignin(t, env, api.Credentials{Email: "tree@stanford.edu", Password: "wrong"}, http.StatusUnauthorized)
+	failedSignin(t, env, api.Credentials{Email: "tree@stanford.edu", Password: "wrong"}, http.StatusUnauthorized)
+	failedSignin(t, env, api.Credentials{Email: "tree@stanford.edu", Password: "wrong"}, http.StatusTooManyRequests)
+}
+
+func TestSuccessfulSigninResetsCountdown(t *testing.T) {
+	env := apitest.NewWithConfig(t, func(cfg *api.Config) {
+		cfg.MaxLoginAttempts = 3
+	})
+	creds := api.Credentials{Username: "bear", Email: "bear@berkeley.edu", Password: "pw"}
+	signUpVerified(t, env, creds)
+	wrong := api.Credentials{Email: creds.Email, Password: "wrong"}
+
+	failedSignin(t, env, wrong, http.StatusUnauthorized)
+	failedSignin(t, env, wrong, http.StatusUnauthorized)
+	signIn(t, env, creds)
+	if got := remaining(failedSignin(t, env, wrong, http.StatusUnauthorized)); got != 2 {
+		t.Fatalf("wrong password after signing in: got attemptsRemaining %d, want 2", got)
+	}
+}
+
+func TestLockoutOff(t *testing.T) {
+	env := apitest.NewWithConfig(t, func(cfg *api.Config) {
+		cfg.MaxLoginAttempts = 0
+	})
+	creds := api.Credentials{Username: "bear", Email: "bear@berkeley.edu", Password: "pw"}
+	signUpVerified(t, env, creds)
+
+	for i := 0; i < 6; i++ {
+		if got := remaining(failedSignin(t, env, api.Credentials{Email: creds.Email, Password: "wrong"}, http.StatusUnauthorized)); got != -1 {
+			t.Fatalf("wrong password without lockout: got attemptsRemaining %d, want none", got)
+		}
+	}
+	signIn(t, env, creds)
+}
+
+//signinVia signs in with creds from remoteAddr with forwardedFor as X-Forwarded-For and returns the status
+func signinVia(env *apitest.Env, remoteAddr string, forwardedFor string, creds api.Credentials) int {
+	req := env.Request(http.MethodPost, "/api/auth/signin", creds)
+	req.RemoteAddr = remoteAddr
+	req.Header.Set("X-Forwarded-For", forwardedFor)
+	return env.Send(req).Code
+}
+
+func TestLockoutBypassedFromAllowlistedNetwork(t *testing.T) {
+	_, proxies, _ := net.ParseCIDR("10.0.0.0/8")
+	_, office, _ := net.ParseCIDR("198.51.100.0/24")
+	env := apitest.NewWithConfig(t, func(cfg *api.Config) {
+		cfg.MaxLoginAttempts = 2
+		cfg.TrustedProxies = []*net.IPNet{proxies}
+		cfg.BypassNetworks = []*net.IPNet{office}
+	})
+	bear := api.Credentials{Username: "bear", Email: "bear@berkeley.edu", Password: "pw"}
+	tree := api.Credentials{Username: "tree", Email: "tree@stanford.edu", Password: "pw"}
+	signUpVerified(t, env, bear)
+	signUpVerified(t, env, tree)
+
+	for i := 0; i < 4; i++ {
+		if code := signinVia(env, "10.0.0.2:4000", "198.51.100.7", api.Credentials{Email: bear.Email, Password: "wrong"}); code != http.StatusUnauthorized {
+			t.Fatalf("wrong password %d from the bypass network: got %d, want 401", i+1, code)
+		}
+	}
+	if code := signinVia(env, "10.0.0.2:4000", "198.51.100.7", bear); code != http.StatusOK {
+		t.Fatalf("right password from the bypass network after failures: got %d, want 200", code)
+	}
+	if len(env.Sleeper.Delays()) != 0 {
+		t.Fatalf("failures from the bypass network were delayed by %v", env.Sleeper.Delays())
+	}
+
+	signinVia(env, "10.0.0.2:4000", "203.0.113.1", api.Credentials{Email: tree.Email, Password: "wrong"})
+	signinVia(env, "10.0.0.2:4000", "203.0.113.1", api.Credentials{Email: tree.Email, Password: "wrong"})
+	if code := signinVia(env, "10.0.0.2:4000", "203.0.113.1", tree); code != http.StatusTooManyRequests {
+		t.Fatalf("right password from outside the bypass network after failures: got %d, want 429", code)
+	}
+}
@@ -0,0 +1,49 @@
+package api
+
+import (
+	"errors"
+	"strings"
+)
+
+//maxEmailSubjectLength caps configured subjects and sender names, which must also be free of control characters
+//since they end up in email headers
+const maxEmailSubjectLength = 200
+
+//emailTemplate is an email the service sends, with the subject and sender name it goes out with
+type emailTemplate struct {
+	path string
+	//setting ends the names of the EMAIL_SUBJECT_ and EMAIL_FROM_NAME_ variables that override subject and fromName
+	setting string
+	//subject may contain {brand}, which is replaced with brandName
+	subject string
+	//fromName is the sender name, empty for the one in SENDER_NAME
+	fromName string
+}
+
+//emailTemplates registers every template in api/templates with its default subject and sender name
+var emailTemplates = []*emailTemplate{
+	{path: "user-signup.html", setting: "SIGNUP", subject: "Email Verification"},
+	{path: "email-verification.html", setting: "ADDED_EMAIL", subject: "Email Verification"},
+	{path: "password-reset.html", setting: "PASSWORD_RESET", subject: "{brand} Password Reset"},
+	{path: "welcome.html", setting: "WELCOME", subject: "Welcome to {brand}"},
+	{path: "new-signin.html", setting: "NEW_SIGNIN", subject: "New sign-in to {brand}"},
+}
+
+//lookupEmailTemplate returns the registered template at path
+func lookupEmailTemplate(path string) (*emailTemplate, error) {
+	for _, t := range emailTemplates {
+		if t.path == path {
+			return t, nil
+		}
+	}
+	return nil, errors.New("no email template is registered for " + path)
+}
+
+//envelope returns the subject and sender name the template is sent with, with the branding filled in
+func (t *emailTemplate) envelope() (string, string) {
+	fromName := t.fromName
+	if fromName == "" {
+		fromName = defaultSender.Name
+	}
+	return strings.Replace(t.subject, "{brand}", brandName, -1), fromName
+}
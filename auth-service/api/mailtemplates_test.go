@@ -0,0 +1,65 @@
+package api_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/BearCloud/fa20-project-dev/backend/auth-service/api"
+	"github.com/BearCloud/fa20-project-dev/backend/auth-service/apitest"
+)
+
+func TestConfiguredSubjectPerEmail(t *testing.T) {
+	templates := []string{"user-signup.html", "email-verification.html", "password-reset.html", "welcome.html", "new-signin.html"}
+	env := apitest.NewWithConfig(t, func(cfg *api.Config) {
+		//fresh maps, the ones in cfg are shared with every other test's configuration
+		cfg.EmailSubjects, cfg.EmailFromNames = map[string]string{}, map[string]string{}
+		for _, template := range templates {
+			cfg.EmailSubjects[template] = "{brand}: " + template
+			cfg.EmailFromNames[template] = "Sender of " + template
+		}
+		cfg.BrandName = "Mixtape"
+		cfg.WelcomeEmail = true
+		cfg.NewSigninAlerts = true
+		cfg.SigninAlertLimit = 10
+	})
+	creds := api.Credentials{Username: "bear", Email: "subjects@berkeley.edu", Password: "pw"}
+	signUpVerified(t, env, creds)
+	access, _ := signIn(t, env, creds)
+	addSecondaryEmail(t, env, access, "subjects@bears.org")
+	requestReset(t, env, creds.Email)
+	signinFrom(t, env, "198.51.100.7:1234", safariOnIPhone, creds)
+
+	for _, check := range []struct {
+		recipient string
+		template  string
+	}{
+		{creds.Email, "user-signup.html"},
+		{"subjects@bears.org", "email-verification.html"},
+		{creds.Email, "password-reset.html"},
+		{creds.Email, "welcome.html"},
+		{creds.Email, "new-signin.html"},
+	} {
+		email, ok := env.Mailer.WaitFor(check.recipient, check.template, time.Second)
+		if !ok {
+			t.Fatalf("no %s email to %s", check.template, check.recipient)
+		}
+		if email.Subject != "Mixtape: "+check.template || email.FromName != "Sender of "+check.template {
+			t.Errorf("%s email: got subject %q from %q, want the configured ones", check.template, email.Subject, email.FromName)
+		}
+	}
+}
+
+func TestDefaultSubjectsBranded(t *testing.T) {
+	env := apitest.NewWithConfig(t, func(cfg *api.Config) {
+		cfg.BrandName = "Mixtape"
+		cfg.SenderName = "Mixtape Team"
+	})
+	creds := api.Credentials{Username: "bear", Email: "default-subjects@berkeley.edu", Password: "pw"}
+	signUpVerified(t, env, creds)
+	requestReset(t, env, creds.Email)
+
+	email, _ := env.Mailer.LastFrom(creds.Email, "password-reset.html")
+	if email.Subject != "Mixtape Password Reset" || email.FromName != "Mixtape Team" {
+		t.Fatalf("reset email without EMAIL_SUBJECT_PASSWORD_RESET: got subject %q from %q, want the branded default from the sender", email.Subject, email.FromName)
+	}
+}
@@ -0,0 +1,133 @@
+package api
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	//maintenancePath toggles maintenance mode and stays reachable during it, so admins can end it early
+	maintenancePath = "/api/auth/admin/maintenance"
+	//maxMaintenanceWindow caps how long an admin can put the service into maintenance at once
+	maxMaintenanceWindow = 24 * time.Hour
+)
+
+var (
+	maintenanceMu sync.Mutex
+	//maintenanceUntil is when the current maintenance window ends, the zero time or a past one means none
+	maintenanceUntil time.Time
+)
+
+//maintenanceEnd returns when the current maintenance window ends, or the zero time outside of one.
+//Windows end on their own, so a forgotten one can't keep the service read-only.
+func maintenanceEnd() time.Time {
+	maintenanceMu.Lock()
+	defer maintenanceMu.Unlock()
+	if clock.Now().After(maintenanceUntil) {
+		return time.Time{}
+	}
+	return maintenanceUntil
+}
+
+//setMaintenance starts a maintenance window lasting until until, a zero or past time ends it
+func setMaintenance(until time.Time) {
+	maintenanceMu.Lock()
+	defer maintenanceMu.Unlock()
+	maintenanceUntil = until
+}
+
+//isReadOnlyMethod reports whether method can't change anything and so is served during maintenance
+func isReadOnlyMethod(method string) bool {
+	return method == http.MethodGet || method == http.MethodHead || method == http.MethodOptions
+}
+
+//maintenanceMiddleware answers every request that could change data with a 503 and a Retry-After pointing
+//at the end of the window while maintenance is on. Reads such as /healthz, /api/auth/me or validating a reset
+//link keep working, and so does maintenancePath.
+func maintenanceMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		end := maintenanceEnd()
+		if end.IsZero() || isReadOnlyMethod(r.Method) || strings.TrimRight(r.URL.Path, "/") == maintenancePath {
+			next.ServeHTTP(w, r)
+			return
+		}
+		w.Header().Set("Retry-After", strconv.Itoa(int(until(end).Seconds())+1))
+		writeJSONError(w, r, http.StatusServiceUnavailable, "down for maintenance until "+end.UTC().Format(time.RFC3339)+", try again then")
+	})
+}
+
+//MaintenanceRequest is the body of PUT /api/auth/admin/maintenance, how long the window lasts, e.g. "30m"
+type MaintenanceRequest struct {
+	Duration string `json:"duration"`
+}
+
+//MaintenanceResponse reports whether maintenance mode is on and when it ends
+type MaintenanceResponse struct {
+	SuccessResponse
+	Active bool       `json:"active"`
+	Until  *time.Time `json:"until,omitempty"`
+}
+
+//writeMaintenance answers with the current maintenance window and message
+func writeMaintenance(w http.ResponseWriter, message string) {
+	response := MaintenanceResponse{SuccessResponse: SuccessResponse{Status: "ok", Message: message}}
+	if until := maintenanceEnd(); !until.IsZero() {
+		response.Active = true
+		response.Until = &until
+	}
+	writeJSON(w, http.StatusOK, response)
+}
+
+//maintenanceStatus tells admins whether maintenance mode is on and until when
+func maintenanceStatus(w http.ResponseWriter, r *http.Request) {
+
+	if (*r).Method == "OPTIONS" {
+		return
+	}
+
+	writeMaintenance(w, "maintenance status retrieved")
+}
+
+//startMaintenance puts this instance into maintenance mode for the requested duration, replacing any current window
+func startMaintenance(w http.ResponseWriter, r *http.Request) {
+
+	if (*r).Method == "OPTIONS" {
+		return
+	}
+
+	claims, _ := claimsFromContext(r.Context())
+
+	var request MaintenanceRequest
+	err := decodeJSON(r.Body, &request)
+	if err != nil {
+		writeJSONError(w, r, http.StatusBadRequest, decodeErrorMessage(err, "issue decoding maintenance request"))
+		return
+	}
+	duration, err := time.ParseDuration(request.Duration)
+	if err != nil || duration <= 0 || duration > maxMaintenanceWindow {
+		writeJSONError(w, r, http.StatusBadRequest, "duration must be a positive duration like \"30m\", at most "+maxMaintenanceWindow.String())
+		return
+	}
+
+	until := clock.Now().Add(duration)
+	setMaintenance(until)
+	recordAudit(claims.UserID, auditStartMaintenance, until.UTC().Format(time.RFC3339))
+	writeMaintenance(w, "maintenance started")
+}
+
+//endMaintenance takes this instance out of maintenance mode early
+func endMaintenance(w http.ResponseWriter, r *http.Request) {
+
+	if (*r).Method == "OPTIONS" {
+		return
+	}
+
+	claims, _ := claimsFromContext(r.Context())
+
+	setMaintenance(time.Time{})
+	recordAudit(claims.UserID, auditEndMaintenance, "")
+	writeMaintenance(w, "maintenance ended")
+}
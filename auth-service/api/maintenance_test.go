@@ -0,0 +1,77 @@
+package api_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/BearCloud/fa20-project-dev/backend/auth-service/api"
+	"github.com/BearCloud/fa20-project-dev/backend/auth-service/apitest"
+)
+
+func TestMaintenanceRejectsWritesOnly(t *testing.T) {
+	clock := apitest.NewFakeClock(time.Date(2020, 10, 1, 9, 0, 0, 0, time.UTC))
+	env := apitest.NewWithClock(t, clock)
+	admin := signInAdmin(t, env, api.Credentials{Username: "oski", Email: "oski@berkeley.edu", Password: "pw"})
+	creds := api.Credentials{Username: "bear", Email: "bear@berkeley.edu", Password: "pw"}
+	signUpVerified(t, env, creds)
+	access, _ := signIn(t, env, creds)
+
+	res := env.Do(http.MethodPut, "/api/auth/admin/maintenance", api.MaintenanceRequest{Duration: "30m"}, admin)
+	var status api.MaintenanceResponse
+	json.NewDecoder(res.Body).Decode(&status)
+	if res.Code != http.StatusOK || !status.Active || status.Until == nil || !status.Until.Equal(clock.Now().Add(30*time.Minute)) {
+		t.Fatalf("starting maintenance: got %d %+v, want it active for 30 minutes", res.Code, status)
+	}
+
+	res = env.Do(http.MethodPost, "/api/auth/signin", creds)
+	if res.Code != http.StatusServiceUnavailable || res.Header().Get("Retry-After") != "1801" {
+		t.Fatalf("signin during maintenance: got %d with Retry-After %q, want 503 until the window ends", res.Code, res.Header().Get("Retry-After"))
+	}
+	for _, path := range []string{"/healthz", "/api/auth/policy", "/api/auth/resetpw/validate?token=r_unknown"} {
+		if res := env.Do(http.MethodGet, path, nil); res.Code == http.StatusServiceUnavailable {
+			t.Fatalf("GET %s during maintenance: got 503, want it served", path)
+		}
+	}
+	profileUsername(t, "me during maintenance", getMe(env, "", access))
+
+	//the window ends on its own
+	clock.Advance(31 * time.Minute)
+	signIn(t, env, creds)
+}
+
+func TestMaintenanceEndedEarly(t *testing.T) {
+	env := apitest.New(t)
+	admin := signInAdmin(t, env, api.Credentials{Username: "oski", Email: "oski@berkeley.edu", Password: "pw"})
+	res := env.Do(http.MethodPut, "/api/auth/admin/maintenance", api.MaintenanceRequest{Duration: "2h"}, admin)
+	if res.Code != http.StatusOK {
+		t.Fatalf("starting maintenance: got %d %s", res.Code, res.Body.String())
+	}
+	creds := api.Credentials{Username: "bear", Email: "bear@berkeley.edu", Password: "pw"}
+	if res := env.Do(http.MethodPost, "/api/auth/signup", creds); res.Code != http.StatusServiceUnavailable {
+		t.Fatalf("signup during maintenance: got %d, want 503", res.Code)
+	}
+
+	//the toggle stays reachable, so the admin can end the window early
+	res = env.Do(http.MethodDelete, "/api/auth/admin/maintenance", nil, admin)
+	var status api.MaintenanceResponse
+	json.NewDecoder(res.Body).Decode(&status)
+	if res.Code != http.StatusOK || status.Active {
+		t.Fatalf("ending maintenance: got %d %+v, want it inactive", res.Code, status)
+	}
+	signUpVerified(t, env, creds)
+}
+
+func TestMaintenanceFromConfig(t *testing.T) {
+	env := apitest.NewWithConfig(t, func(cfg *api.Config) {
+		cfg.MaintenanceUntil = time.Now().Add(time.Hour)
+	})
+	res := env.Do(http.MethodPost, "/api/auth/signup", api.Credentials{Username: "bear", Email: "bear@berkeley.edu", Password: "pw"})
+	if res.Code != http.StatusServiceUnavailable || res.Header().Get("Retry-After") == "" {
+		t.Fatalf("signup with MAINTENANCE_UNTIL an hour ahead: got %d with Retry-After %q, want 503 with one", res.Code, res.Header().Get("Retry-After"))
+	}
+	if res := env.Do(http.MethodGet, "/healthz", nil); res.Code != http.StatusOK {
+		t.Fatalf("healthz with MAINTENANCE_UNTIL an hour ahead: got %d, want 200", res.Code)
+	}
+}
@@ -0,0 +1,73 @@
+package api_test
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/BearCloud/fa20-project-dev/backend/auth-service/api"
+	"github.com/BearCloud/fa20-project-dev/backend/auth-service/apitest"
+)
+
+func TestMemoryStoreResetExpiresOnEnvClock(t *testing.T) {
+	clock := apitest.NewFakeClock(time.Now())
+	env := apitest.NewWithClock(t, clock)
+	store := env.UseMemoryStore()
+
+	err := store.CreateUser(api.User{UserID: "bear-id", Username: "bear", Email: "bear@berkeley.edu", CreatedAt: clock.Now()}, "verify-hash")
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = store.SetResetToken("used-hash", "bear-id", clock.Now().Add(time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = store.ResetPassword("used-hash", "bear-id", []byte("new-hash"))
+	if err != nil {
+		t.Fatalf("reset within the hour: %v", err)
+	}
+	//resetting clears every token of the account, so the next one is set afterwards
+	err = store.SetResetToken("expired-hash", "bear-id", clock.Now().Add(time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	clock.Advance(2 * time.Hour)
+	err = store.ResetPassword("expired-hash", "bear-id", []byte("newer-hash"))
+	if err != api.ErrNotFound {
+		t.Fatalf("reset after the token expired on the fake clock: got %v, want ErrNotFound", err)
+	}
+}
+
+func TestHandlersOnMemoryStore(t *testing.T) {
+	env := apitest.New(t)
+	store := env.UseMemoryStore()
+	creds := api.Credentials{Username: "bear", Email: "bear@berkeley.edu", Password: "pw"}
+	signUpVerified(t, env, creds)
+
+	user, err := store.GetByEmail(creds.Email)
+	if err != nil || !user.Verified || user.Username != creds.Username {
+		t.Fatalf("account after signup and verify: got %+v, %v, want bear verified in the memory store", user, err)
+	}
+	if rows := countRows(t, env, "users", "email", creds.Email); rows != 0 {
+		t.Fatalf("signup on the memory store: got %d rows in the users table, want none", rows)
+	}
+
+	taken := api.Credentials{Username: "bear", Email: "oski@berkeley.edu", Password: "pw"}
+	if res := env.Do(http.MethodPost, "/api/auth/signup", taken); res.Code != http.StatusConflict {
+		t.Fatalf("signup with a username taken in the memory store: got %d %s, want 409", res.Code, res.Body.String())
+	}
+
+	token := requestReset(t, env, creds.Email)
+	res := env.Do(http.MethodPost, "/api/auth/resetpw", api.PasswordReset{Token: token, NewPassword: "new pw", ConfirmPassword: "new pw"})
+	expectSuccess(t, "resetpw", res, http.StatusOK, "password reset")
+	res = env.Do(http.MethodPost, "/api/auth/resetpw", api.PasswordReset{Token: token, NewPassword: "newer pw", ConfirmPassword: "newer pw"})
+	if res.Code == http.StatusOK {
+		t.Fatalf("reusing a reset token on the memory store: got 200, want it refused")
+	}
+
+	if res := env.Do(http.MethodPost, "/api/auth/signin", creds); res.Code != http.StatusUnauthorized {
+		t.Fatalf("signin with the old password: got %d, want 401", res.Code)
+	}
+	creds.Password = "new pw"
+	signIn(t, env, creds)
+}
@@ -0,0 +1,400 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"log"
+	"net/http"
+	"runtime/debug"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+//requestIDHeader carries the request ID in and out of the service
+const requestIDHeader = "X-Request-ID"
+
+type contextKey string
+
+const (
+	requestIDKey contextKey = "requestID"
+	claimsKey    contextKey = "claims"
+)
+
+//requestIDMiddleware tags each request with an ID, reusing the caller's X-Request-ID when one is sent
+func requestIDMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requestID := r.Header.Get(requestIDHeader)
+		if requestID == "" || len(requestID) > 128 {
+			requestID = uuid.New().String()
+		}
+		w.Header().Set(requestIDHeader, requestID)
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), requestIDKey, requestID)))
+	})
+}
+
+//requestIDFromContext returns the request ID set by requestIDMiddleware, or "" if there is none
+func requestIDFromContext(ctx context.Context) string {
+	requestID, _ := ctx.Value(requestIDKey).(string)
+	return requestID
+}
+
+//recoverMiddleware turns a panic in a handler into a 500 JSON error instead of crashing the request
+func recoverMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		defer func() {
+			if rec := recover(); rec != nil {
+				// net/http uses this panic to abort a response on purpose, let it through
+				if rec == http.ErrAbortHandler {
+					panic(rec)
+				}
+				log.Printf("panic serving %s %s (request %s): %v\n%s", r.Method, r.URL.Path, requestIDFromContext(r.Context()), rec, debug.Stack())
+				writeJSONError(w, r, http.StatusInternalServerError, "internal server error")
+			}
+		}()
+		next.ServeHTTP(w, r)
+	})
+}
+
+//securityHeaderValues maps each security header to the value sent with every response, empty disables it
+var securityHeaderValues = map[string]string{
+	"Strict-Transport-Security": "max-age=63072000; includeSubDomains",
+	"X-Content-Type-Options":    "nosniff",
+	"X-Frame-Options":           "DENY",
+	"Referrer-Policy":           "no-referrer",
+}
+
+//securityHeaders adds the configured security headers to every response
+func securityHeaders(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		for header, value := range securityHeaderValues {
+			if value != "" {
+				w.Header().Set(header, value)
+			}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+//requireHTTPS rejects requests that didn't arrive over HTTPS, so tokens never travel in the clear
+var requireHTTPS = false
+
+//isHTTPS reports whether r reached the service over TLS, directly or through a proxy that terminated it.
+//X-Forwarded-Proto is only believed from trustedProxies, like X-Forwarded-For in clientIP.
+func isHTTPS(r *http.Request) bool {
+	if r.TLS != nil {
+		return true
+	}
+	if !fromTrustedProxy(r) {
+		return false
+	}
+	//a chain of proxies lists one protocol per hop, the first is what the client used
+	proto := strings.SplitN(r.Header.Get("X-Forwarded-Proto"), ",", 2)[0]
+	return strings.EqualFold(strings.TrimSpace(proto), "https")
+}
+
+//httpsMiddleware enforces requireHTTPS. Plain GET and HEAD requests are redirected to the same URL
+//over HTTPS. Anything else may already have sent credentials, so it is rejected with a 400 instead.
+func httpsMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !requireHTTPS || isHTTPS(r) {
+			next.ServeHTTP(w, r)
+			return
+		}
+		if r.Method == http.MethodGet || r.Method == http.MethodHead {
+			target := *r.URL
+			target.Scheme = "https"
+			target.Host = r.Host
+			http.Redirect(w, r, target.String(), http.StatusMovedPermanently)
+			return
+		}
+		writeJSONError(w, r, http.StatusBadRequest, "requests must use HTTPS")
+	})
+}
+
+//requestTimeout is how long a request may take before it is answered with a 503, 0 means no limit
+var requestTimeout = 30 * time.Second
+
+//timeoutWriter holds back the response of a handler run by timeoutMiddleware, so a handler that runs late can't
+//write over the timeout error
+type timeoutWriter struct {
+	mu       sync.Mutex
+	header   http.Header
+	body     bytes.Buffer
+	code     int
+	timedOut bool
+}
+
+func (tw *timeoutWriter) Header() http.Header {
+	return tw.header
+}
+
+func (tw *timeoutWriter) Write(p []byte) (int, error) {
+	tw.mu.Lock()
+	defer tw.mu.Unlock()
+	if tw.timedOut {
+		return 0, http.ErrHandlerTimeout
+	}
+	if tw.code == 0 {
+		tw.code = http.StatusOK
+	}
+	return tw.body.Write(p)
+}
+
+func (tw *timeoutWriter) WriteHeader(code int) {
+	tw.mu.Lock()
+	defer tw.mu.Unlock()
+	if tw.timedOut || tw.code != 0 {
+		return
+	}
+	tw.code = code
+}
+
+//timeoutMiddleware answers requests that run past requestTimeout with a 503 JSON error, like http.TimeoutHandler
+//but through writeJSONError so the body carries the correlationId.
+//The request context is canceled at the same time, so calls made with it such as SendEmail give up too.
+func timeoutMiddleware(next http.Handler) http.Handler {
+	if requestTimeout <= 0 {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx, cancel := context.WithTimeout(r.Context(), requestTimeout)
+		defer cancel()
+		r = r.WithContext(ctx)
+
+		tw := &timeoutWriter{header: http.Header{}}
+		done := make(chan struct{})
+		panicked := make(chan interface{}, 1)
+		go func() {
+			//hand a panic back to this goroutine, where recoverMiddleware can catch it
+			defer func() {
+				if rec := recover(); rec != nil {
+					panicked <- rec
+				}
+			}()
+			next.ServeHTTP(tw, r)
+			close(done)
+		}()
+
+		select {
+		case rec := <-panicked:
+			panic(rec)
+		case <-done:
+			tw.mu.Lock()
+			defer tw.mu.Unlock()
+			for key, values := range tw.header {
+				w.Header()[key] = values
+			}
+			if tw.code == 0 {
+				tw.code = http.StatusOK
+			}
+			w.WriteHeader(tw.code)
+			w.Write(tw.body.Bytes())
+		case <-ctx.Done():
+			tw.mu.Lock()
+			defer tw.mu.Unlock()
+			tw.timedOut = true
+			writeJSONError(w, r, http.StatusServiceUnavailable, "the request timed out")
+		}
+	})
+}
+
+//maxInFlight caps how many requests are served at once, 0 means no limit
+var maxInFlight = 512
+
+//shedRetryAfter is how long a shed request is told to wait before trying again
+const shedRetryAfter = time.Second
+
+//loadShedMiddleware answers requests past maxInFlight with a 503 and Retry-After right away instead of queueing them,
+//so an overloaded instance keeps serving the requests it has rather than slowing down for everyone.
+//Health checks on /healthz are never shed, so a busy instance isn't mistaken for a dead one and restarted.
+func loadShedMiddleware(next http.Handler) http.Handler {
+	if maxInFlight <= 0 {
+		return next
+	}
+	slots := make(chan struct{}, maxInFlight)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/healthz" {
+			next.ServeHTTP(w, r)
+			return
+		}
+		select {
+		case slots <- struct{}{}:
+			defer func() { <-slots }()
+			next.ServeHTTP(w, r)
+		default:
+			w.Header().Set("Retry-After", strconv.Itoa(int(shedRetryAfter.Seconds())))
+			writeJSONError(w, r, http.StatusServiceUnavailable, "the server is busy, try again shortly")
+		}
+	})
+}
+
+//ignoreTrailingSlash routes paths ending in "/" like the same path without it, e.g. /api/auth/signin/ to signin
+var ignoreTrailingSlash = true
+
+//trailingSlashMiddleware strips trailing slashes from the path before the router sees it when ignoreTrailingSlash is set.
+//The request is rewritten rather than redirected, so POST bodies and CORS preflights reach the handler as sent.
+func trailingSlashMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		trimmed := strings.TrimRight(r.URL.Path, "/")
+		if !ignoreTrailingSlash || trimmed == "" || trimmed == r.URL.Path {
+			next.ServeHTTP(w, r)
+			return
+		}
+		r = r.Clone(r.Context())
+		r.URL.Path = trimmed
+		r.URL.RawPath = strings.TrimRight(r.URL.RawPath, "/")
+		next.ServeHTTP(w, r)
+	})
+}
+
+//Middleware wraps handler with the api's request ID, access log, panic recovery, security header, load shedding,
+//HTTPS, maintenance, database circuit breaker, timeout and trailing slash middleware. Panic recovery comes right after
+//the request ID and access log, so a panic in any later middleware or the router is answered with a 500 and logged
+//with the request ID. Use it around the whole router so unmatched routes get the same treatment.
+func Middleware(handler http.Handler) http.Handler {
+	return requestIDMiddleware(accessLogMiddleware(recoverMiddleware(securityHeaders(loadShedMiddleware(httpsMiddleware(maintenanceMiddleware(breakerMiddleware(timeoutMiddleware(trailingSlashMiddleware(handler))))))))))
+}
+
+//maxAuthorizationLength is the longest Authorization header accepted, far more than any access token needs
+const maxAuthorizationLength = 4096
+
+//bearerToken returns the token of an Authorization header reading exactly "Bearer <token>".
+//Anything else gets an error saying what is wrong with the header, so the client is told instead of
+//getting a confusing token parse error.
+func bearerToken(values []string) (string, error) {
+	if len(values) > 1 {
+		return "", errors.New("send a single Authorization header")
+	}
+	header := values[0]
+	if len(header) > maxAuthorizationLength {
+		return "", errors.New("the Authorization header is too long")
+	}
+	scheme, token := header, ""
+	if i := strings.IndexByte(header, ' '); i >= 0 {
+		scheme, token = header[:i], header[i+1:]
+	}
+	if !strings.EqualFold(scheme, "Bearer") {
+		return "", errors.New("the Authorization header must read \"Bearer <token>\"")
+	}
+	if token == "" {
+		return "", errors.New("the Authorization header has no token")
+	}
+	if strings.TrimSpace(token) != token {
+		return "", errors.New("the Authorization header has extra whitespace around the token")
+	}
+	if strings.ContainsAny(token, " \t,") {
+		return "", errors.New("the Authorization header must hold a single token")
+	}
+	if !isBearerToken(token) {
+		return "", errors.New("the Authorization header token contains invalid characters")
+	}
+	return token, nil
+}
+
+//isBearerToken reports whether token only uses the characters RFC 6750 allows in bearer tokens,
+//which covers the base64url parts and dots of a JWT
+func isBearerToken(token string) bool {
+	trimmed := strings.TrimRight(token, "=")
+	if trimmed == "" {
+		return false
+	}
+	for _, c := range trimmed {
+		if !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || strings.ContainsRune("-._~+/", c)) {
+			return false
+		}
+	}
+	return true
+}
+
+//accessTokenFromRequest returns the bearer token from the Authorization header,
+//falling back to the access token cookie, or "" if the request carries neither.
+//A malformed Authorization header is an error rather than a reason to fall back to the cookie.
+func accessTokenFromRequest(r *http.Request) (string, error) {
+	if values := r.Header.Values("Authorization"); len(values) > 1 || len(values) == 1 && values[0] != "" {
+		return bearerToken(values)
+	}
+	cookie, err := r.Cookie(accessCookieName())
+	if err != nil {
+		return "", nil
+	}
+	return cookie.Value, nil
+}
+
+//RequireAuth rejects requests without a valid access token and stores its claims in the request context
+func RequireAuth(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodOptions {
+			next.ServeHTTP(w, r)
+			return
+		}
+		tokenString, err := accessTokenFromRequest(r)
+		if err != nil {
+			writeJSONError(w, r, http.StatusUnauthorized, err.Error())
+			return
+		}
+		if tokenString == "" {
+			writeJSONError(w, r, http.StatusUnauthorized, "missing access token")
+			return
+		}
+		claims, err := getClaims(tokenString)
+		if err != nil || claims.Subject != "access" {
+			writeJSONError(w, r, http.StatusUnauthorized, "invalid access token")
+			return
+		}
+		err = checkTokenVersion(claims)
+		if err == errTokenVersion {
+			writeJSONError(w, r, http.StatusUnauthorized, err.Error())
+			return
+		}
+		if err != nil {
+			internalError(w, r, "error checking token version", err)
+			return
+		}
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), claimsKey, claims)))
+	})
+}
+
+//requireVerifiedEmail puts RequireVerified in front of the routes that change the profile or email addresses
+var requireVerifiedEmail = false
+
+//RequireVerified rejects access tokens of accounts whose email wasn't verified when the token was issued,
+//with a 403 and the "verify" hint. It must run after RequireAuth. The check only reads the verified claim,
+//so a user who just verified gets through once their session is renewed.
+func RequireVerified(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodOptions {
+			next.ServeHTTP(w, r)
+			return
+		}
+		claims, _ := claimsFromContext(r.Context())
+		if !claims.Verified {
+			writeJSON(w, http.StatusForbidden, ErrorResponse{
+				Status:        "error",
+				Message:       "verify your email address to continue",
+				Hint:          "verify",
+				CorrelationID: requestIDFromContext(r.Context()),
+			})
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+//verifiedOnly wraps handler in RequireVerified when requireVerifiedEmail is set
+func verifiedOnly(handler http.Handler) http.Handler {
+	if !requireVerifiedEmail {
+		return handler
+	}
+	return RequireVerified(handler)
+}
+
+//claimsFromContext returns the access token claims stored by RequireAuth
+func claimsFromContext(ctx context.Context) (AuthClaims, bool) {
+	claims, ok := ctx.Value(claimsKey).(AuthClaims)
+	return claims, ok
+}
@@ -0,0 +1,343 @@
+package api_test
+
+import (
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/BearCloud/fa20-project-dev/backend/auth-service/api"
+	"github.com/BearCloud/fa20-project-dev/backend/auth-service/apitest"
+	"github.com/gorilla/mux"
+)
+
+func TestPanicAnsweredWithCorrelatedJSONError(t *testing.T) {
+	apitest.New(t)
+	router := mux.NewRouter()
+	router.HandleFunc("/boom", func(w http.ResponseWriter, r *http.Request) {
+		panic("boom")
+	})
+	handler := api.Middleware(router)
+
+	req := httptest.NewRequest(http.MethodGet, "/boom", nil)
+	req.Header.Set("X-Request-ID", "boom-request")
+	res := httptest.NewRecorder()
+	handler.ServeHTTP(res, req)
+
+	if res.Code != http.StatusInternalServerError {
+		t.Fatalf("panicking handler: got %d, want 500", res.Code)
+	}
+	if contentType := res.Header().Get("Content-Type"); contentType != "application/json" {
+		t.Fatalf("panicking handler: got Content-Type %q, want application/json", contentType)
+	}
+	var body api.ErrorResponse
+	json.NewDecoder(res.Body).Decode(&body)
+	if body.CorrelationID != "boom-request" {
+		t.Fatalf("panicking handler: got correlationId %q, want the request ID", body.CorrelationID)
+	}
+}
+
+func TestInternalErrorCarriesRequestID(t *testing.T) {
+	env := apitest.New(t)
+	creds := api.Credentials{Username: "bear", Email: "bear@berkeley.edu", Password: "pw"}
+	signUpVerified(t, env, creds)
+	env.DB.Close()
+
+	res := env.Do(http.MethodPost, "/api/auth/signin", creds)
+	var body api.ErrorResponse
+	json.NewDecoder(res.Body).Decode(&body)
+	requestID := res.Header().Get("X-Request-ID")
+	if res.Code != http.StatusInternalServerError || requestID == "" || body.CorrelationID != requestID {
+		t.Fatalf("signin with the database down: got %d with X-Request-ID %q and body %+v, want a 500 whose correlationId is the request ID", res.Code, requestID, body)
+	}
+
+	req := env.Request(http.MethodGet, "/api/auth/resetpw/validate?token=r_unknown", nil)
+	req.Header.Set("X-Request-ID", "client-request")
+	rec := env.Send(req)
+	body = api.ErrorResponse{}
+	json.NewDecoder(rec.Body).Decode(&body)
+	if rec.Code != http.StatusInternalServerError || body.CorrelationID != "client-request" {
+		t.Fatalf("resetpw/validate with the database down and X-Request-ID client-request: got %d %+v, want a 500 with that correlationId", rec.Code, body)
+	}
+}
+
+func TestSlowHandlerTimesOutWithJSONError(t *testing.T) {
+	apitest.NewWithConfig(t, func(cfg *api.Config) {
+		cfg.RequestTimeout = 20 * time.Millisecond
+	})
+	handler := api.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-time.After(time.Second):
+			w.Write([]byte("too late"))
+		case <-r.Context().Done():
+		}
+	}))
+
+	res := httptest.NewRecorder()
+	handler.ServeHTTP(res, httptest.NewRequest(http.MethodGet, "/slow", nil))
+
+	if res.Code != http.StatusServiceUnavailable {
+		t.Fatalf("slow handler: got %d, want 503", res.Code)
+	}
+	if contentType := res.Header().Get("Content-Type"); contentType != "application/json" {
+		t.Fatalf("slow handler: got Content-Type %q, want application/json", contentType)
+	}
+	var body api.ErrorResponse
+	json.NewDecoder(res.Body).Decode(&body)
+	if body.Message != "the request timed out" || body.CorrelationID != res.Header().Get("X-Request-ID") {
+		t.Fatalf("slow handler: got %+v, want the timeout error with correlationId %q", body, res.Header().Get("X-Request-ID"))
+	}
+}
+
+func TestFastHandlerAnsweredUnderTimeout(t *testing.T) {
+	apitest.NewWithConfig(t, func(cfg *api.Config) {
+		cfg.RequestTimeout = time.Second
+	})
+	handler := api.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.SetCookie(w, &http.Cookie{Name: "flavor", Value: "oatmeal"})
+		w.WriteHeader(http.StatusTeapot)
+		w.Write([]byte("short and stout"))
+	}))
+
+	res := httptest.NewRecorder()
+	handler.ServeHTTP(res, httptest.NewRequest(http.MethodGet, "/fast", nil))
+
+	if res.Code != http.StatusTeapot || res.Body.String() != "short and stout" || apitest.Cookie(res, "flavor") == nil {
+		t.Fatalf("fast handler: got %d %q with cookies %v, want its own response", res.Code, res.Body.String(), res.Result().Cookies())
+	}
+}
+
+func TestSecurityHeadersOnEveryResponse(t *testing.T) {
+	env := apitest.New(t)
+
+	for _, path := range []string{"/api/auth/policy", "/api/auth/me", "/nowhere"} {
+		res := env.Do(http.MethodGet, path, nil)
+		for header, want := range map[string]string{
+			"Strict-Transport-Security": "max-age=63072000; includeSubDomains",
+			"X-Content-Type-Options":    "nosniff",
+			"X-Frame-Options":           "DENY",
+			"Referrer-Policy":           "no-referrer",
+		} {
+			if got := res.Header().Get(header); got != want {
+				t.Errorf("%s answering %d: got %s %q, want %q", path, res.Code, header, got, want)
+			}
+		}
+	}
+}
+
+func TestSecurityHeadersConfigurable(t *testing.T) {
+	env := apitest.NewWithConfig(t, func(cfg *api.Config) {
+		cfg.SecurityHeaders = map[string]string{
+			"Strict-Transport-Security": "max-age=300",
+			"X-Frame-Options":           "",
+		}
+	})
+
+	res := env.Do(http.MethodGet, "/api/auth/policy", nil)
+	if got := res.Header().Get("Strict-Transport-Security"); got != "max-age=300" {
+		t.Fatalf("overridden Strict-Transport-Security: got %q, want max-age=300", got)
+	}
+	if _, ok := res.Header()["X-Frame-Options"]; ok {
+		t.Fatalf("disabled X-Frame-Options was still sent")
+	}
+}
+
+func TestRequireHTTPS(t *testing.T) {
+	_, proxies, _ := net.ParseCIDR("10.0.0.0/8")
+	env := apitest.NewWithConfig(t, func(cfg *api.Config) {
+		cfg.AppEnv = "production"
+		cfg.RequireHTTPS = true
+		cfg.TrustedProxies = []*net.IPNet{proxies}
+	})
+
+	res := env.Do(http.MethodPost, "/api/auth/signin", api.Credentials{Email: "bear@berkeley.edu", Password: "pw"})
+	if res.Code != http.StatusBadRequest {
+		t.Fatalf("signin over HTTP: got %d, want 400", res.Code)
+	}
+	res = env.Do(http.MethodGet, "/api/auth/policy?verbose=1", nil)
+	if res.Code != http.StatusMovedPermanently || res.Header().Get("Location") != "https://example.com/api/auth/policy?verbose=1" {
+		t.Fatalf("GET over HTTP: got %d to %q, want a redirect to the HTTPS URL", res.Code, res.Header().Get("Location"))
+	}
+
+	for _, proto := range []string{"https", "HTTPS", "https, http"} {
+		req := env.Request(http.MethodGet, "/api/auth/policy", nil)
+		req.RemoteAddr = "10.0.0.2:4000"
+		req.Header.Set("X-Forwarded-Proto", proto)
+		res = env.Send(req)
+		if res.Code != http.StatusOK {
+			t.Fatalf("X-Forwarded-Proto %q: got %d, want 200", proto, res.Code)
+		}
+	}
+	res = env.Do(http.MethodGet, "https://example.com/api/auth/policy", nil)
+	if res.Code != http.StatusOK {
+		t.Fatalf("request over TLS: got %d, want 200", res.Code)
+	}
+	req := env.Request(http.MethodGet, "/api/auth/policy", nil)
+	req.RemoteAddr = "10.0.0.2:4000"
+	req.Header.Set("X-Forwarded-Proto", "http, https")
+	res = env.Send(req)
+	if res.Code != http.StatusMovedPermanently {
+		t.Fatalf("X-Forwarded-Proto with the client on HTTP: got %d, want a redirect", res.Code)
+	}
+
+	//a client talking to the service directly can't claim HTTPS
+	req = env.Request(http.MethodPost, "/api/auth/signin", api.Credentials{Email: "bear@berkeley.edu", Password: "pw"})
+	req.Header.Set("X-Forwarded-Proto", "https")
+	if res = env.Send(req); res.Code != http.StatusBadRequest {
+		t.Fatalf("X-Forwarded-Proto from an untrusted address: got %d, want 400", res.Code)
+	}
+}
+
+func TestRequireHTTPSOffInDev(t *testing.T) {
+	env := apitest.NewWithConfig(t, func(cfg *api.Config) {
+		cfg.AppEnv = "dev"
+		cfg.RequireHTTPS = true
+	})
+
+	res := env.Do(http.MethodGet, "/api/auth/policy", nil)
+	if res.Code != http.StatusOK {
+		t.Fatalf("HTTP in dev with REQUIRE_HTTPS: got %d, want 200", res.Code)
+	}
+}
+
+func TestTrailingSlashRoutesToSameHandler(t *testing.T) {
+	env := apitest.NewWithConfig(t, func(cfg *api.Config) {
+		cfg.TrailingSlash = true
+		cfg.CORSOrigins = []string{"https://mixtape.com"}
+	})
+	creds := api.Credentials{Username: "bear", Email: "bear@berkeley.edu", Password: "pw"}
+	signUpVerified(t, env, creds)
+
+	for _, path := range []string{"/api/auth/signin", "/api/auth/signin/", "/api/auth/signin//"} {
+		res := env.Do(http.MethodPost, path, creds)
+		if res.Code != http.StatusOK || apitest.Cookie(res, "access_token") == nil {
+			t.Fatalf("POST %s: got %d %s, want a signin", path, res.Code, res.Body.String())
+		}
+
+		res = sendFrom(env, http.MethodOptions, path, "https://mixtape.com")
+		if res.Code != http.StatusNoContent || res.Header().Get("Access-Control-Allow-Origin") != "https://mixtape.com" {
+			t.Fatalf("preflight for %s: got %d with Access-Control-Allow-Origin %q, want 204 allowing the origin", path, res.Code, res.Header().Get("Access-Control-Allow-Origin"))
+		}
+	}
+}
+
+func TestTrailingSlashStrictWhenOff(t *testing.T) {
+	env := apitest.NewWithConfig(t, func(cfg *api.Config) {
+		cfg.TrailingSlash = false
+	})
+	creds := api.Credentials{Username: "bear", Email: "bear@berkeley.edu", Password: "pw"}
+	signUpVerified(t, env, creds)
+
+	signIn(t, env, creds)
+	if res := env.Do(http.MethodPost, "/api/auth/signin/", creds); res.Code != http.StatusNotFound {
+		t.Fatalf("POST /api/auth/signin/ with IGNORE_TRAILING_SLASH off: got %d, want 404", res.Code)
+	}
+}
+
+func TestRequestsPastInFlightLimitShed(t *testing.T) {
+	apitest.NewWithConfig(t, func(cfg *api.Config) {
+		cfg.MaxInFlight = 3
+	})
+	started, release := make(chan struct{}), make(chan struct{})
+	handler := api.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/slow" {
+			started <- struct{}{}
+			<-release
+		}
+		w.Write([]byte("ok"))
+	}))
+	serve := func(path string) *httptest.ResponseRecorder {
+		res := httptest.NewRecorder()
+		handler.ServeHTTP(res, httptest.NewRequest(http.MethodGet, path, nil))
+		return res
+	}
+
+	//fill every slot with a request that waits to be released
+	slow := make(chan int, 3)
+	for i := 0; i < 3; i++ {
+		go func() { slow <- serve("/slow").Code }()
+		<-started
+	}
+
+	shed := make(chan *httptest.ResponseRecorder, 5)
+	for i := 0; i < 5; i++ {
+		go func() { shed <- serve("/fast") }()
+	}
+	for i := 0; i < 5; i++ {
+		res := <-shed
+		if res.Code != http.StatusServiceUnavailable || res.Header().Get("Retry-After") == "" {
+			t.Fatalf("request past the in-flight limit: got %d with Retry-After %q, want 503 with one", res.Code, res.Header().Get("Retry-After"))
+		}
+	}
+	if res := serve("/healthz"); res.Code != http.StatusOK {
+		t.Fatalf("health check while full: got %d, want 200", res.Code)
+	}
+
+	close(release)
+	for i := 0; i < 3; i++ {
+		if code := <-slow; code != http.StatusOK {
+			t.Fatalf("request holding a slot: got %d, want 200", code)
+		}
+	}
+	if res := serve("/fast"); res.Code != http.StatusOK {
+		t.Fatalf("request once the slots are free: got %d, want 200", res.Code)
+	}
+}
+
+func TestRequireVerifiedChecksTheClaim(t *testing.T) {
+	env := apitest.NewWithConfig(t, func(cfg *api.Config) {
+		cfg.UnverifiedGrace = 24 * time.Hour
+	})
+	handler := api.RequireAuth(api.RequireVerified(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("verified only"))
+	})))
+	serve := func(access *http.Cookie) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, "/verified-only", nil)
+		req.AddCookie(access)
+		res := httptest.NewRecorder()
+		handler.ServeHTTP(res, req)
+		return res
+	}
+
+	unverified := api.Credentials{Username: "bear", Email: "bear@berkeley.edu", Password: "pw"}
+	env.Do(http.MethodPost, "/api/auth/signup", unverified)
+	access, refresh := signIn(t, env, unverified)
+	res := serve(access)
+	var body api.ErrorResponse
+	json.NewDecoder(res.Body).Decode(&body)
+	if res.Code != http.StatusForbidden || body.Hint != "verify" {
+		t.Fatalf("unverified token: got %d %+v, want 403 with the verify hint", res.Code, body)
+	}
+
+	//verifying takes effect once the session is renewed
+	verification, _ := env.Mailer.LastFrom(unverified.Email, "user-signup.html")
+	env.Do(http.MethodPost, "/api/auth/verify?token="+verification.Token(), nil)
+	res = env.Do(http.MethodPost, "/api/auth/session/renew", nil, refresh)
+	if res := serve(apitest.Cookie(res, "access_token")); res.Code != http.StatusOK || res.Body.String() != "verified only" {
+		t.Fatalf("token renewed after verifying: got %d %s, want through", res.Code, res.Body.String())
+	}
+}
+
+func TestRequireVerifiedEmailOnProtectedRoutes(t *testing.T) {
+	for _, required := range []bool{true, false} {
+		env := apitest.NewWithConfig(t, func(cfg *api.Config) {
+			cfg.UnverifiedGrace = 24 * time.Hour
+			cfg.RequireVerified = required
+		})
+		creds := api.Credentials{Username: "bear", Email: "bear@berkeley.edu", Password: "pw"}
+		env.Do(http.MethodPost, "/api/auth/signup", creds)
+		access, _ := signIn(t, env, creds)
+
+		want := http.StatusOK
+		if required {
+			want = http.StatusForbidden
+		}
+		if res := env.Do(http.MethodPatch, "/api/auth/me", `{"displayName":"Oski"}`, access); res.Code != want {
+			t.Fatalf("unverified profile edit with REQUIRE_VERIFIED_EMAIL=%t: got %d, want %d", required, res.Code, want)
+		}
+		//reading the profile is allowed either way
+		profileUsername(t, "unverified me", getMe(env, "", access))
+	}
+}
@@ -0,0 +1,399 @@
+package api
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+const (
+	//migrationFormatVersion is the version of the account migration format, bumped whenever a field changes meaning
+	migrationFormatVersion = 1
+	//maxImportUsers caps how many users a single import may carry, larger migrations are split across requests
+	maxImportUsers = 500
+	//maxImportBody caps the size of an import request
+	maxImportBody = 10 << 20
+	//userIDMaxLength matches the users.userId column
+	userIDMaxLength = 128
+	//bcryptHashLength is the length of every encoded bcrypt hash
+	bcryptHashLength = 60
+)
+
+var (
+	//migrationImport registers the account import endpoint, which is off unless a migration is under way
+	migrationImport = false
+)
+
+//errImportConflict is returned when an imported account collides with a different existing one
+var errImportConflict = errors.New("import conflicts with existing accounts")
+
+//MigrationEmail is a secondary email address of a migrated account
+type MigrationEmail struct {
+	Email    string `json:"email"`
+	Verified bool   `json:"verified"`
+}
+
+//MigrationUser is an account in the migration format. HashedPassword is the stored hash, bcrypt or Argon2id,
+//so the account's password keeps working in the deployment it is imported into.
+type MigrationUser struct {
+	UserID            string           `json:"userId"`
+	Username          string           `json:"username"`
+	DisplayName       string           `json:"displayName,omitempty"`
+	Locale            string           `json:"locale,omitempty"`
+	Email             string           `json:"email"`
+	HashedPassword    string           `json:"hashedPassword"`
+	Verified          bool             `json:"verified"`
+	Role              string           `json:"role"`
+	CreatedAt         *time.Time       `json:"createdAt,omitempty"`
+	DeletedAt         *time.Time       `json:"deletedAt,omitempty"`
+	PasswordChangedAt *time.Time       `json:"passwordChangedAt,omitempty"`
+	Emails            []MigrationEmail `json:"emails"`
+}
+
+//MigrationExportResponse is the JSON body of a page of exported accounts.
+//NextCursor is empty on the last page.
+type MigrationExportResponse struct {
+	SuccessResponse
+	Version    int             `json:"version"`
+	Users      []MigrationUser `json:"users"`
+	NextCursor string          `json:"nextCursor,omitempty"`
+}
+
+//MigrationImport is the body of an account import, the version and users of one or more export pages
+type MigrationImport struct {
+	Version int             `json:"version"`
+	Users   []MigrationUser `json:"users"`
+}
+
+//MigrationImportResponse lists the userIds an import inserted and the ones that already existed unchanged
+type MigrationImportResponse struct {
+	SuccessResponse
+	Imported []string `json:"imported"`
+	Skipped  []string `json:"skipped"`
+}
+
+//nullableTime stores a missing time as NULL
+func nullableTime(value *time.Time) sql.NullTime {
+	if value == nil {
+		return sql.NullTime{}
+	}
+	return sql.NullTime{Time: *value, Valid: true}
+}
+
+//validPasswordHash reports whether hashed is a complete bcrypt or Argon2id hash comparePassword can check
+func validPasswordHash(hashed string) bool {
+	if strings.HasPrefix(hashed, argon2Prefix) {
+		_, salt, _, err := parseArgon2(hashed)
+		return err == nil && len(salt) > 0
+	}
+	_, err := bcrypt.Cost([]byte(hashed))
+	return err == nil && len(hashed) == bcryptHashLength
+}
+
+//exportUsers returns a page of accounts in the migration format, password hashes included, ordered by userId.
+//Admins page through every account with the cursor to move them to another deployment.
+func exportUsers(w http.ResponseWriter, r *http.Request) {
+
+	if (*r).Method == "OPTIONS" {
+		return
+	}
+
+	claims, _ := claimsFromContext(r.Context())
+	query := r.URL.Query()
+
+	limit := defaultUserPageSize
+	if value := query.Get("limit"); value != "" {
+		var err error
+		limit, err = strconv.Atoi(value)
+		if err != nil || limit < 1 {
+			writeJSONError(w, r, http.StatusBadRequest, "limit must be a positive number")
+			return
+		}
+		if limit > maxUserPageSize {
+			limit = maxUserPageSize
+		}
+	}
+
+	//the cursor is the id of the last user on the previous page, fetch one extra row to learn whether there is another
+	cursor := query.Get("cursor")
+	rows, err := DB.Query("SELECT userId, username, displayName, locale, email, hashedPassword, verified, role, createdAt, deletedAt, passwordChangedAt FROM users WHERE userId > ? ORDER BY userId LIMIT ?;", cursor, limit+1)
+	if err != nil {
+		internalError(w, r, "error exporting users", err)
+		return
+	}
+	defer rows.Close()
+
+	users := []MigrationUser{}
+	for rows.Next() {
+		var user MigrationUser
+		var displayName, locale sql.NullString
+		var verified sql.NullBool
+		var createdAt, deletedAt, passwordChangedAt sql.NullTime
+		err = rows.Scan(&user.UserID, &user.Username, &displayName, &locale, &user.Email, &user.HashedPassword, &verified, &user.Role, &createdAt, &deletedAt, &passwordChangedAt)
+		if err != nil {
+			internalError(w, r, "error exporting users", err)
+			return
+		}
+		user.DisplayName, user.Locale, user.Verified = displayName.String, locale.String, verified.Bool
+		for _, column := range []struct {
+			value  sql.NullTime
+			target **time.Time
+		}{{createdAt, &user.CreatedAt}, {deletedAt, &user.DeletedAt}, {passwordChangedAt, &user.PasswordChangedAt}} {
+			if column.value.Valid {
+				value := column.value.Time
+				*column.target = &value
+			}
+		}
+		user.Emails = []MigrationEmail{}
+		users = append(users, user)
+	}
+	if err = rows.Err(); err != nil {
+		internalError(w, r, "error exporting users", err)
+		return
+	}
+
+	response := MigrationExportResponse{SuccessResponse: SuccessResponse{Status: "ok", Message: "users exported"}, Version: migrationFormatVersion}
+	if len(users) > limit {
+		users = users[:limit]
+		response.NextCursor = users[limit-1].UserID
+	}
+
+	//secondary emails for the whole page in one query
+	if len(users) > 0 {
+		byID := map[string]*MigrationUser{}
+		placeholders := make([]string, len(users))
+		args := make([]interface{}, len(users))
+		for i := range users {
+			byID[users[i].UserID] = &users[i]
+			placeholders[i] = "?"
+			args[i] = users[i].UserID
+		}
+		emailRows, err := DB.Query("SELECT userId, email, verified FROM emails WHERE userId IN ("+strings.Join(placeholders, ", ")+") ORDER BY email;", args...)
+		if err != nil {
+			internalError(w, r, "error exporting emails", err)
+			return
+		}
+		defer emailRows.Close()
+		for emailRows.Next() {
+			var userID string
+			var email MigrationEmail
+			err = emailRows.Scan(&userID, &email.Email, &email.Verified)
+			if err != nil {
+				internalError(w, r, "error exporting emails", err)
+				return
+			}
+			byID[userID].Emails = append(byID[userID].Emails, email)
+		}
+		if err = emailRows.Err(); err != nil {
+			internalError(w, r, "error exporting emails", err)
+			return
+		}
+	}
+
+	recordAudit(claims.UserID, auditExportUsers, cursor)
+	response.Users = users
+	writeJSON(w, http.StatusOK, response)
+}
+
+//validateMigrationUser checks an imported account field by field, returning every problem found.
+//Values aren't cleaned up on the way in, anything the service wouldn't have stored itself is refused.
+func validateMigrationUser(user MigrationUser) []string {
+	var problems []string
+	if user.UserID == "" || len(user.UserID) > userIDMaxLength || strings.IndexFunc(user.UserID, func(r rune) bool { return r <= ' ' }) >= 0 {
+		problems = append(problems, "userId must be 1 to "+strconv.Itoa(userIDMaxLength)+" characters without spaces")
+	}
+	if username, err := cleanText("username", user.Username, usernameMaxLength); err != nil {
+		problems = append(problems, err.Error())
+	} else if username == "" || username != user.Username || strings.TrimSpace(username) != username {
+		problems = append(problems, "username must be non-empty, trimmed and in Unicode NFC")
+	}
+	if displayName, err := cleanText("displayName", user.DisplayName, displayNameMaxLength); err != nil {
+		problems = append(problems, err.Error())
+	} else if displayName != user.DisplayName {
+		problems = append(problems, "displayName must be in Unicode NFC")
+	}
+	if locale, err := validateLocale(user.Locale); err != nil {
+		problems = append(problems, err.Error())
+	} else if locale != user.Locale {
+		problems = append(problems, "locale must be in canonical form, e.g. \""+locale+"\"")
+	}
+	emails := []string{user.Email}
+	for _, email := range user.Emails {
+		emails = append(emails, email.Email)
+	}
+	for _, email := range emails {
+		if !strings.Contains(email, "@") || len(email) > emailMaxLength || strings.TrimSpace(email) != email {
+			problems = append(problems, "email \""+email+"\" must be a trimmed address of at most "+strconv.Itoa(emailMaxLength)+" characters")
+		}
+	}
+	if !validPasswordHash(user.HashedPassword) {
+		problems = append(problems, "hashedPassword must be a complete bcrypt or argon2id hash")
+	}
+	if !validRole(user.Role) {
+		problems = append(problems, "role \""+user.Role+"\" is not a valid role name")
+	}
+	return problems
+}
+
+//validateMigrationImport checks the whole import before anything is written, including that no userId, username
+//or email appears twice in it. Problems are prefixed with the index of the user they concern.
+func validateMigrationImport(migration MigrationImport) []string {
+	var problems []string
+	if migration.Version != migrationFormatVersion {
+		problems = append(problems, "version must be "+strconv.Itoa(migrationFormatVersion)+", got "+strconv.Itoa(migration.Version))
+	}
+	if len(migration.Users) == 0 || len(migration.Users) > maxImportUsers {
+		problems = append(problems, "users must hold 1 to "+strconv.Itoa(maxImportUsers)+" accounts")
+	}
+	seen := map[string]int{}
+	for i, user := range migration.Users {
+		prefix := "users[" + strconv.Itoa(i) + "]: "
+		for _, problem := range validateMigrationUser(user) {
+			problems = append(problems, prefix+problem)
+		}
+		//emails compare without regard to case, as they do in MySQL
+		keys := []string{"userId " + user.UserID, "username " + user.Username, "email " + strings.ToLower(user.Email)}
+		for _, email := range user.Emails {
+			keys = append(keys, "email "+strings.ToLower(email.Email))
+		}
+		for _, key := range keys {
+			if first, ok := seen[key]; ok {
+				problems = append(problems, prefix+key+" already appears in users["+strconv.Itoa(first)+"]")
+				continue
+			}
+			seen[key] = i
+		}
+	}
+	return problems
+}
+
+//importUser inserts user within tx unless an account with its userId, username and primary email exists already.
+//It reports whether the account was inserted, and errImportConflict when it collides with a different account.
+func importUser(tx *sql.Tx, user MigrationUser) (bool, error) {
+	var username, email string
+	err := tx.QueryRow("SELECT username, email FROM users WHERE userId = ?;", user.UserID).Scan(&username, &email)
+	if err == nil {
+		if username == user.Username && email == user.Email {
+			return false, nil
+		}
+		return false, errImportConflict
+	}
+	if err != sql.ErrNoRows {
+		return false, err
+	}
+
+	var taken bool
+	err = tx.QueryRow("SELECT EXISTS(SELECT * FROM users WHERE username = ?);", user.Username).Scan(&taken)
+	if err != nil {
+		return false, err
+	}
+	for _, address := range append([]MigrationEmail{{Email: user.Email}}, user.Emails...) {
+		if taken {
+			break
+		}
+		err = tx.QueryRow("SELECT EXISTS(SELECT * FROM users WHERE email = ?) OR EXISTS(SELECT * FROM emails WHERE email = ?);", address.Email, address.Email).Scan(&taken)
+		if err != nil {
+			return false, err
+		}
+	}
+	if taken {
+		return false, errImportConflict
+	}
+
+	createdAt := clock.Now()
+	if user.CreatedAt != nil {
+		createdAt = *user.CreatedAt
+	}
+	_, err = tx.Exec("INSERT INTO users (username, displayName, locale, email, hashedPassword, verified, userId, role, createdAt, deletedAt, passwordChangedAt) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?);",
+		user.Username, nullableString(user.DisplayName), nullableString(user.Locale), user.Email, []byte(user.HashedPassword), user.Verified, user.UserID, user.Role, createdAt, nullableTime(user.DeletedAt), nullableTime(user.PasswordChangedAt))
+	if err != nil {
+		return false, err
+	}
+	for _, address := range user.Emails {
+		_, err = tx.Exec("INSERT INTO emails (email, userId, verified, createdAt) VALUES (?, ?, ?, ?);", address.Email, user.UserID, address.Verified, createdAt)
+		if err != nil {
+			return false, err
+		}
+	}
+	for _, address := range append([]MigrationEmail{{Email: user.Email, Verified: user.Verified}}, user.Emails...) {
+		if !address.Verified {
+			continue
+		}
+		err = claimEmail(tx, address.Email, user.UserID)
+		if err != nil {
+			return false, err
+		}
+	}
+	return true, nil
+}
+
+//importUsers inserts accounts exported from another deployment, keeping their userIds and password hashes.
+//The import is all or nothing: it is validated strictly first, and a conflict with an existing account rolls
+//everything back. Accounts that already exist unchanged are skipped, so a failed or repeated import can be rerun.
+func importUsers(w http.ResponseWriter, r *http.Request) {
+
+	if (*r).Method == "OPTIONS" {
+		return
+	}
+
+	claims, _ := claimsFromContext(r.Context())
+
+	//unknown fields are refused whatever STRICT_JSON says, a field this version doesn't know would be lost silently
+	var migration MigrationImport
+	decoder := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxImportBody))
+	decoder.DisallowUnknownFields()
+	err := decoder.Decode(&migration)
+	if err == nil && decoder.Decode(&struct{}{}) != io.EOF {
+		err = errors.New("trailing data after the import")
+	}
+	if err != nil {
+		writeJSONError(w, r, http.StatusBadRequest, "invalid import: "+err.Error())
+		return
+	}
+	if problems := validateMigrationImport(migration); len(problems) > 0 {
+		writeJSONError(w, r, http.StatusBadRequest, "invalid import:\n"+strings.Join(problems, "\n"))
+		return
+	}
+
+	tx, err := DB.Begin()
+	if err != nil {
+		internalError(w, r, "error importing users", err)
+		return
+	}
+	response := MigrationImportResponse{Imported: []string{}, Skipped: []string{}}
+	for i, user := range migration.Users {
+		inserted, err := importUser(tx, user)
+		if err != nil {
+			tx.Rollback()
+			if err == errImportConflict {
+				writeJSONError(w, r, http.StatusConflict, "users["+strconv.Itoa(i)+"]: userId, username or email belongs to a different existing account, nothing was imported")
+			} else {
+				internalError(w, r, "error importing users", err)
+			}
+			return
+		}
+		if inserted {
+			response.Imported = append(response.Imported, user.UserID)
+		} else {
+			response.Skipped = append(response.Skipped, user.UserID)
+		}
+	}
+	err = tx.Commit()
+	if err != nil {
+		internalError(w, r, "error importing users", err)
+		return
+	}
+
+	for _, userID := range response.Imported {
+		recordAudit(claims.UserID, auditImportUser, userID)
+	}
+	response.SuccessResponse = SuccessResponse{Status: "ok", Message: "users imported"}
+	writeJSON(w, http.StatusOK, response)
+}
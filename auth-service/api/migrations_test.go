@@ -0,0 +1,106 @@
+package api_test
+
+import (
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"testing"
+)
+
+var (
+	createTablePattern = regexp.MustCompile(`(?s)CREATE TABLE (?:IF NOT EXISTS )?(\w+) \((.*?)\n\);`)
+	alterTablePattern  = regexp.MustCompile(`ALTER TABLE (\w+) ([^;]*);`)
+	addColumnPattern   = regexp.MustCompile(`ADD COLUMN (\w+)`)
+	dropColumnPattern  = regexp.MustCompile(`DROP COLUMN (\w+)`)
+)
+
+//tableColumns returns the columns of each CREATE TABLE in sql, leaving out constraints such as PRIMARY KEY (...)
+func tableColumns(sql string) map[string]map[string]bool {
+	tables := map[string]map[string]bool{}
+	for _, match := range createTablePattern.FindAllStringSubmatch(sql, -1) {
+		columns := map[string]bool{}
+		for _, line := range strings.Split(match[2], "\n") {
+			fields := strings.Fields(strings.TrimSuffix(strings.TrimSpace(line), ","))
+			if len(fields) > 0 && fields[0] != "PRIMARY" {
+				columns[fields[0]] = true
+			}
+		}
+		tables[match[1]] = columns
+	}
+	return tables
+}
+
+//describeSchema lists tables and their columns in a stable order for comparing
+func describeSchema(tables map[string]map[string]bool) string {
+	var lines []string
+	for table, columns := range tables {
+		var names []string
+		for column := range columns {
+			names = append(names, column)
+		}
+		sort.Strings(names)
+		lines = append(lines, table+": "+strings.Join(names, ", "))
+	}
+	sort.Strings(lines)
+	return strings.Join(lines, "\n")
+}
+
+//TestMigrationsReachInitdb replays db-server/migrations over the auth tables of the original initdb.sql and checks
+//they end up with the tables and columns of the current initdb.sql
+func TestMigrationsReachInitdb(t *testing.T) {
+	initdb, err := ioutil.ReadFile("../../db-server/initdb.sql")
+	if err != nil {
+		t.Fatal(err)
+	}
+	auth := string(initdb)[strings.Index(string(initdb), "USE auth;"):strings.Index(string(initdb), "CREATE DATABASE postsDB;")]
+	want := tableColumns(auth)
+
+	got := tableColumns(`CREATE TABLE users (
+    username VARCHAR(20),
+    email VARCHAR(320),
+    hashedPassword TEXT,
+    verified boolean,
+    resetToken TEXT,
+    verifiedToken TEXT,
+    userId VARCHAR(128) PRIMARY KEY
+);`)
+
+	files, err := filepath.Glob("../../db-server/migrations/*.sql")
+	if err != nil {
+		t.Fatal(err)
+	}
+	sort.Strings(files)
+	for i, file := range files {
+		if prefix := fmt.Sprintf("%03d_", i); !strings.HasPrefix(filepath.Base(file), prefix) {
+			t.Fatalf("migration %s should start with %s, migrations are numbered from 000 without gaps", filepath.Base(file), prefix)
+		}
+		migration, err := ioutil.ReadFile(file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for table, columns := range tableColumns(string(migration)) {
+			if _, ok := got[table]; !ok {
+				got[table] = columns
+			}
+		}
+		for _, alter := range alterTablePattern.FindAllStringSubmatch(string(migration), -1) {
+			columns, ok := got[alter[1]]
+			if !ok {
+				t.Fatalf("%s alters %s before any migration creates it", filepath.Base(file), alter[1])
+			}
+			for _, add := range addColumnPattern.FindAllStringSubmatch(alter[2], -1) {
+				columns[add[1]] = true
+			}
+			for _, drop := range dropColumnPattern.FindAllStringSubmatch(alter[2], -1) {
+				delete(columns, drop[1])
+			}
+		}
+	}
+
+	if describeSchema(got) != describeSchema(want) {
+		t.Fatalf("migrations end up with\n%s\n\ninitdb.sql has\n%s", describeSchema(got), describeSchema(want))
+	}
+}
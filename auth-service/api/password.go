@@ -0,0 +1,228 @@
+package api
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+	"unicode/utf8"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+const (
+	//minHashTiming is the hashing time below which the bcrypt cost is too cheap to slow down brute force
+	minHashTiming = 50 * time.Millisecond
+	//maxHashTiming is the hashing time above which signups and resets start to feel sluggish
+	maxHashTiming = 1 * time.Second
+)
+
+var (
+	hashTimingMu   sync.Mutex
+	lastHashTiming time.Duration
+
+	dummyHashMu sync.Mutex
+	//dummyHash is what compareDummyPassword checks against, nil until it is first needed
+	dummyHash []byte
+
+	//bannedPasswords holds the lowercased passwords from BANNED_PASSWORDS_FILE, nil when no list is configured
+	bannedPasswords map[string]struct{}
+	//passwordMinLength is the fewest characters, not bytes, a password may have
+	passwordMinLength = 1
+	//passwordRequiredClasses lists the passwordClasses every password must contain a character of
+	passwordRequiredClasses []string
+)
+
+//passwordClass is a kind of character PASSWORD_REQUIRED_CLASSES can require
+type passwordClass struct {
+	//description completes "password must contain ..."
+	description string
+	matches     func(rune) bool
+}
+
+//passwordClasses are the character classes by the names PASSWORD_REQUIRED_CLASSES and the policy endpoint use
+var passwordClasses = map[string]passwordClass{
+	"lower":  {"a lowercase letter", unicode.IsLower},
+	"upper":  {"an uppercase letter", unicode.IsUpper},
+	"digit":  {"a digit", unicode.IsDigit},
+	"symbol": {"a symbol", isPasswordSymbol},
+}
+
+//isPasswordSymbol reports whether r is neither a letter, a digit nor whitespace
+func isPasswordSymbol(r rune) bool {
+	return !unicode.IsLetter(r) && !unicode.IsDigit(r) && !unicode.IsSpace(r)
+}
+
+//errBannedPassword is returned by validatePassword for a password on the banned list
+var errBannedPassword = errors.New("this password is too common, choose a different one")
+
+//loadBannedPasswords reads a banned password list with one password per line into a set.
+//Blank lines and lines starting with # are skipped. An empty path means no list.
+func loadBannedPasswords(path string) (map[string]struct{}, error) {
+	if path == "" {
+		return nil, nil
+	}
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	banned := map[string]struct{}{}
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		banned[strings.ToLower(line)] = struct{}{}
+	}
+	return banned, scanner.Err()
+}
+
+//validatePassword rejects passwords shorter than passwordMinLength, missing one of passwordRequiredClasses
+//or on the banned list. Matching the list ignores case, so "Password" is as banned as "password".
+func validatePassword(password string) error {
+	if password == "" {
+		return errors.New("password is required")
+	}
+	if utf8.RuneCountInString(password) < passwordMinLength {
+		return errors.New("password must be at least " + strconv.Itoa(passwordMinLength) + " characters")
+	}
+	for _, name := range passwordRequiredClasses {
+		class := passwordClasses[name]
+		if strings.IndexFunc(password, class.matches) < 0 {
+			return errors.New("password must contain " + class.description)
+		}
+	}
+	if _, banned := bannedPasswords[strings.ToLower(password)]; banned {
+		return errBannedPassword
+	}
+	return nil
+}
+
+const (
+	hashBcrypt   = "bcrypt"
+	hashArgon2id = "argon2id"
+)
+
+//hashAlgorithm is how new password hashes are made, existing hashes of the other algorithm keep working
+var hashAlgorithm = hashBcrypt
+
+//errPasswordMismatch is returned by comparePassword when the password is wrong
+var errPasswordMismatch = errors.New("password does not match hash")
+
+//hashPassword hashes password with hashAlgorithm and its configured parameters and records how long it took
+func hashPassword(password string) ([]byte, error) {
+	start := time.Now()
+	var hashed []byte
+	var err error
+	if hashAlgorithm == hashArgon2id {
+		hashed, err = hashArgon2(password)
+	} else {
+		hashed, err = bcrypt.GenerateFromPassword([]byte(password), bcryptCost)
+	}
+	if err != nil {
+		return nil, err
+	}
+	hashTimingMu.Lock()
+	lastHashTiming = time.Since(start)
+	hashTimingMu.Unlock()
+	return hashed, nil
+}
+
+//comparePassword checks password against a stored hash of either algorithm, telling them apart by the hash's prefix
+func comparePassword(hashed string, password string) error {
+	if strings.HasPrefix(hashed, argon2Prefix) {
+		return compareArgon2(hashed, password)
+	}
+	err := bcrypt.CompareHashAndPassword([]byte(hashed), []byte(password))
+	if err == bcrypt.ErrMismatchedHashAndPassword {
+		return errPasswordMismatch
+	}
+	return err
+}
+
+//compareDummyPassword checks password against a throwaway hash, so a signin naming no account takes as long as
+//one with a wrong password. The hash is made with the configured algorithm and parameters the first time it is needed.
+func compareDummyPassword(password string) {
+	dummyHashMu.Lock()
+	if dummyHash == nil {
+		hashed, err := hashPassword(GetRandomBase62(32))
+		if err != nil {
+			dummyHashMu.Unlock()
+			log.Println("error making the dummy password hash: " + err.Error())
+			return
+		}
+		dummyHash = hashed
+	}
+	hashed := dummyHash
+	dummyHashMu.Unlock()
+	comparePassword(string(hashed), password)
+}
+
+//forgetDummyHash drops the dummy hash, so the next one is made with the hashing parameters just configured
+func forgetDummyHash() {
+	dummyHashMu.Lock()
+	dummyHash = nil
+	dummyHashMu.Unlock()
+}
+
+//passwordNeedsRehash reports whether a stored hash was made with another algorithm or other parameters than
+//the configured ones, so it can be replaced while the password is at hand after a successful signin
+func passwordNeedsRehash(hashed string) bool {
+	if strings.HasPrefix(hashed, argon2Prefix) {
+		params, _, _, err := parseArgon2(hashed)
+		return hashAlgorithm != hashArgon2id || err != nil || params != argon2Params{argon2Memory, argon2Time, argon2Threads}
+	}
+	cost, err := bcrypt.Cost([]byte(hashed))
+	return hashAlgorithm != hashBcrypt || err != nil || cost != bcryptCost
+}
+
+//upgradePasswordHash rehashes a user's password with the configured algorithm and parameters if their stored
+//hash is outdated. The update only applies if the stored hash is still the one checked, so a password reset
+//that happened meanwhile isn't undone.
+func upgradePasswordHash(userID string, hashed string, password string) error {
+	if !passwordNeedsRehash(hashed) {
+		return nil
+	}
+	rehashed, err := hashPassword(password)
+	if err != nil {
+		return err
+	}
+	return userStore.UpgradePasswordHash(userID, []byte(hashed), rehashed)
+}
+
+//HashTiming returns how long the most recent password hash took with the configured algorithm and parameters
+func HashTiming() time.Duration {
+	hashTimingMu.Lock()
+	defer hashTimingMu.Unlock()
+	return lastHashTiming
+}
+
+//CalibrateHashing hashes a sample password with the configured algorithm and parameters and logs the timing
+//so operators can tune BCRYPT_COST, or ARGON2_MEMORY and ARGON2_TIME
+func CalibrateHashing() {
+	_, err := hashPassword("calibration-password")
+	if err != nil {
+		log.Println(hashAlgorithm + " calibration failed: " + err.Error())
+		return
+	}
+	timing := HashTiming()
+	setting, knobs := fmt.Sprintf("bcrypt cost %d", bcryptCost), "BCRYPT_COST"
+	if hashAlgorithm == hashArgon2id {
+		setting, knobs = fmt.Sprintf("argon2id m=%d,t=%d,p=%d", argon2Memory, argon2Time, argon2Threads), "ARGON2_MEMORY or ARGON2_TIME"
+	}
+	log.Printf("%s takes %s per hash", setting, timing)
+	if timing < minHashTiming {
+		log.Printf("%s hashes in under %s, consider raising %s", setting, minHashTiming, knobs)
+	} else if timing > maxHashTiming {
+		log.Printf("%s takes over %s per hash, consider lowering %s", setting, maxHashTiming, knobs)
+	}
+}
@@ -0,0 +1,157 @@
+package api
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+//BenchmarkBcryptCost hashes with the BCRYPT_COST from the environment, so operators can measure a cost on their
+//own hardware before setting it:
+//
+//	BCRYPT_COST=12 go test ./api -run '^$' -bench BcryptCost
+func BenchmarkBcryptCost(b *testing.B) {
+	cost := LoadConfig().BcryptCost
+	if cost < bcrypt.MinCost || cost > bcrypt.MaxCost {
+		b.Fatalf("BCRYPT_COST %d is out of range", cost)
+	}
+	saved, savedAlgorithm := bcryptCost, hashAlgorithm
+	bcryptCost, hashAlgorithm = cost, hashBcrypt
+	defer func() { bcryptCost, hashAlgorithm = saved, savedAlgorithm }()
+
+	for i := 0; i < b.N; i++ {
+		_, err := hashPassword("benchmark-password")
+		if err != nil {
+			b.Fatal(err)
+		}
+	}
+	b.Logf("bcrypt cost %d took %s for the last hash", cost, HashTiming())
+}
+
+func TestHashTimingRecordsLastHash(t *testing.T) {
+	saved, savedAlgorithm := bcryptCost, hashAlgorithm
+	bcryptCost, hashAlgorithm = bcrypt.MinCost, hashBcrypt
+	defer func() { bcryptCost, hashAlgorithm = saved, savedAlgorithm }()
+
+	hashed, err := hashPassword("pw")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if HashTiming() <= 0 {
+		t.Fatalf("HashTiming is %s after hashing", HashTiming())
+	}
+	if comparePassword(string(hashed), "pw") != nil {
+		t.Fatalf("hash does not match its password")
+	}
+	if comparePassword(string(hashed), "other") != errPasswordMismatch {
+		t.Fatalf("hash matches another password")
+	}
+}
+
+func TestHashAndCompareEachAlgorithm(t *testing.T) {
+	saved, savedAlgorithm, savedMemory := bcryptCost, hashAlgorithm, argon2Memory
+	defer func() { bcryptCost, hashAlgorithm, argon2Memory = saved, savedAlgorithm, savedMemory }()
+	bcryptCost, argon2Memory = bcrypt.MinCost, 1024
+
+	hashes := map[string]string{}
+	for algorithm, prefix := range map[string]string{hashBcrypt: "$2a$", hashArgon2id: "$argon2id$v=19$m=1024,"} {
+		hashAlgorithm = algorithm
+		hashed, err := hashPassword(" pw ")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !strings.HasPrefix(string(hashed), prefix) {
+			t.Fatalf("%s hash %q doesn't start with %q", algorithm, hashed, prefix)
+		}
+		if err = comparePassword(string(hashed), " pw "); err != nil {
+			t.Fatalf("%s hash doesn't match its password: %v", algorithm, err)
+		}
+		if err = comparePassword(string(hashed), "pw"); err != errPasswordMismatch {
+			t.Fatalf("%s hash compared with another password: got %v, want errPasswordMismatch", algorithm, err)
+		}
+		if passwordNeedsRehash(string(hashed)) {
+			t.Fatalf("fresh %s hash needs a rehash", algorithm)
+		}
+		hashes[algorithm] = string(hashed)
+	}
+
+	//either hash still verifies once the other algorithm is configured, but is due for a rehash
+	hashAlgorithm = hashArgon2id
+	for algorithm, hashed := range hashes {
+		if comparePassword(hashed, " pw ") != nil {
+			t.Fatalf("%s hash doesn't match its password with HASH_ALGORITHM=%s", algorithm, hashAlgorithm)
+		}
+	}
+	if !passwordNeedsRehash(hashes[hashBcrypt]) || passwordNeedsRehash(hashes[hashArgon2id]) {
+		t.Fatalf("with HASH_ALGORITHM=argon2id only the bcrypt hash should need a rehash")
+	}
+
+	//an Argon2id hash keeps verifying with the parameters it was made with after they change
+	argon2Memory = 2048
+	if comparePassword(hashes[hashArgon2id], " pw ") != nil || !passwordNeedsRehash(hashes[hashArgon2id]) {
+		t.Fatalf("argon2id hash after ARGON2_MEMORY changed: want it to match and need a rehash")
+	}
+
+	if err := comparePassword("$argon2id$v=19$m=1024,t=0,p=1$c2FsdA$a2V5", " pw "); err != errArgon2Hash {
+		t.Fatalf("malformed argon2id hash: got %v, want errArgon2Hash", err)
+	}
+}
+
+func TestDummyHashFollowsConfiguredAlgorithm(t *testing.T) {
+	saved, savedAlgorithm, savedMemory := bcryptCost, hashAlgorithm, argon2Memory
+	defer func() {
+		bcryptCost, hashAlgorithm, argon2Memory = saved, savedAlgorithm, savedMemory
+		forgetDummyHash()
+	}()
+	bcryptCost, argon2Memory = bcrypt.MinCost, 1024
+
+	for algorithm, prefix := range map[string]string{hashBcrypt: "$2a$", hashArgon2id: "$argon2id$"} {
+		hashAlgorithm = algorithm
+		forgetDummyHash()
+		compareDummyPassword("pw")
+		if !strings.HasPrefix(string(dummyHash), prefix) {
+			t.Fatalf("dummy hash with HASH_ALGORITHM=%s: got %q, want it to start with %q", algorithm, dummyHash, prefix)
+		}
+	}
+}
+
+func TestBannedPasswords(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "banned.txt")
+	err := ioutil.WriteFile(path, []byte("# top passwords\n123456\n\n  Password  \nletmein\n"), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	banned, err := loadBannedPasswords(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(banned) != 3 {
+		t.Fatalf("loaded %v, want the three passwords without the comment or blank line", banned)
+	}
+	saved := bannedPasswords
+	bannedPasswords = banned
+	defer func() { bannedPasswords = saved }()
+
+	for _, password := range []string{"123456", "password", "PASSWORD", "LetMeIn"} {
+		if err := validatePassword(password); err != errBannedPassword {
+			t.Errorf("banned password %q: got %v, want it rejected as too common", password, err)
+		}
+	}
+	for _, password := range []string{"correct horse battery staple", "letmein2", "# top passwords"} {
+		if err := validatePassword(password); err != nil {
+			t.Errorf("password %q not on the list: got %v", password, err)
+		}
+	}
+
+	_, err = loadBannedPasswords(filepath.Join(t.TempDir(), "missing.txt"))
+	if err == nil {
+		t.Fatalf("loading a missing list: got no error")
+	}
+	banned, err = loadBannedPasswords("")
+	if banned != nil || err != nil {
+		t.Fatalf("loading no list: got %v, %v", banned, err)
+	}
+}
@@ -0,0 +1,59 @@
+package api
+
+import (
+	"net/http"
+)
+
+//PasswordPolicy is what a password must look like. RequiredClasses names the passwordClasses it must contain,
+//and RejectsCommon tells whether a BANNED_PASSWORDS_FILE refuses common passwords, whose list isn't published.
+type PasswordPolicy struct {
+	MinLength       int      `json:"minLength"`
+	RequiredClasses []string `json:"requiredClasses"`
+	RejectsCommon   bool     `json:"rejectsCommon"`
+}
+
+//LengthPolicy bounds a text field in characters
+type LengthPolicy struct {
+	MinLength int `json:"minLength"`
+	MaxLength int `json:"maxLength"`
+}
+
+//VerificationPolicy tells whether new accounts must verify their email, and how long they can sign in before they do.
+//GraceSeconds is 0 when verification isn't required.
+type VerificationPolicy struct {
+	Required     bool  `json:"required"`
+	GraceSeconds int64 `json:"graceSeconds"`
+}
+
+//PolicyResponse is the JSON body of GET /api/auth/policy, the rules signup and the profile endpoints apply
+type PolicyResponse struct {
+	SuccessResponse
+	Password          PasswordPolicy     `json:"password"`
+	Username          LengthPolicy       `json:"username"`
+	DisplayName       LengthPolicy       `json:"displayName"`
+	EmailVerification VerificationPolicy `json:"emailVerification"`
+}
+
+//policy lets frontends render the input rules from the configuration the server enforces, so the two can't drift apart
+func policy(w http.ResponseWriter, r *http.Request) {
+
+	if (*r).Method == "OPTIONS" {
+		return
+	}
+
+	classes := passwordRequiredClasses
+	if classes == nil {
+		classes = []string{}
+	}
+	response := PolicyResponse{
+		SuccessResponse: SuccessResponse{Status: "ok", Message: "policy retrieved"},
+		Password:        PasswordPolicy{MinLength: passwordMinLength, RequiredClasses: classes, RejectsCommon: len(bannedPasswords) > 0},
+		Username:        LengthPolicy{MinLength: 1, MaxLength: usernameMaxLength},
+		DisplayName:     LengthPolicy{MinLength: 0, MaxLength: displayNameMaxLength},
+	}
+	if unverifiedGrace > 0 {
+		response.EmailVerification = VerificationPolicy{Required: true, GraceSeconds: int64(unverifiedGrace.Seconds())}
+	}
+
+	writeJSON(w, http.StatusOK, response)
+}
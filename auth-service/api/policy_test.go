@@ -0,0 +1,74 @@
+package api_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/BearCloud/fa20-project-dev/backend/auth-service/api"
+	"github.com/BearCloud/fa20-project-dev/backend/auth-service/apitest"
+)
+
+//getPolicy fetches the published input policy
+func getPolicy(t *testing.T, env *apitest.Env) api.PolicyResponse {
+	t.Helper()
+	res := env.Do(http.MethodGet, "/api/auth/policy", nil)
+	if res.Code != http.StatusOK {
+		t.Fatalf("policy: got %d %s", res.Code, res.Body.String())
+	}
+	var policy api.PolicyResponse
+	json.NewDecoder(res.Body).Decode(&policy)
+	return policy
+}
+
+func TestPolicyReflectsConfig(t *testing.T) {
+	env := apitest.NewWithConfig(t, func(cfg *api.Config) {
+		cfg.PasswordMinLength = 12
+		cfg.PasswordClasses = []string{"upper", "digit"}
+		cfg.BannedPasswords = map[string]struct{}{"password123": {}}
+		cfg.DisplayNameMax = 40
+		cfg.UnverifiedGrace = 48 * time.Hour
+	})
+
+	policy := getPolicy(t, env)
+	want := api.PasswordPolicy{MinLength: 12, RequiredClasses: []string{"upper", "digit"}, RejectsCommon: true}
+	if !reflect.DeepEqual(policy.Password, want) {
+		t.Fatalf("password policy: got %+v, want %+v", policy.Password, want)
+	}
+	if policy.Username.MinLength != 1 || policy.Username.MaxLength == 0 || policy.DisplayName.MaxLength != 40 {
+		t.Fatalf("length policies: got username %+v and display name %+v, want the display name capped at 40", policy.Username, policy.DisplayName)
+	}
+	if policy.EmailVerification != (api.VerificationPolicy{Required: true, GraceSeconds: 48 * 60 * 60}) {
+		t.Fatalf("verification policy: got %+v, want required with a 48h grace", policy.EmailVerification)
+	}
+
+	//signup enforces what the policy publishes
+	for _, password := range []string{"Short1", "NoDigitsInThisOne", "no-uppercase-42"} {
+		res := env.Do(http.MethodPost, "/api/auth/signup", api.Credentials{Username: "bear", Email: "bear@berkeley.edu", Password: password})
+		if res.Code == http.StatusCreated {
+			t.Fatalf("signup with %q against the published policy: got 201", password)
+		}
+	}
+	res := env.Do(http.MethodPost, "/api/auth/signup", api.Credentials{Username: "bear", Email: "bear@berkeley.edu", Password: "Long enough 42"})
+	if res.Code != http.StatusCreated {
+		t.Fatalf("signup with a password meeting the policy: got %d %s", res.Code, res.Body.String())
+	}
+}
+
+func TestPolicyWithoutVerificationOrClasses(t *testing.T) {
+	env := apitest.NewWithConfig(t, func(cfg *api.Config) {
+		cfg.PasswordClasses = nil
+		cfg.BannedPasswords = nil
+		cfg.UnverifiedGrace = 0
+	})
+
+	policy := getPolicy(t, env)
+	if policy.Password.RequiredClasses == nil || len(policy.Password.RequiredClasses) != 0 || policy.Password.RejectsCommon {
+		t.Fatalf("password policy without classes: got %+v, want an empty list of classes", policy.Password)
+	}
+	if policy.EmailVerification.Required || policy.EmailVerification.GraceSeconds != 0 {
+		t.Fatalf("verification policy with UNVERIFIED_GRACE=0: got %+v, want not required", policy.EmailVerification)
+	}
+}
@@ -0,0 +1,291 @@
+package api
+
+import (
+	"bytes"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"strings"
+
+	"golang.org/x/text/language"
+)
+
+var (
+	//displayNameMaxLength is the most characters, not bytes, a display name may have
+	displayNameMaxLength = 64
+)
+
+const (
+	//localeMaxLength is the size of the users.locale column
+	localeMaxLength = 35
+)
+
+//immutableProfileFields are the Profile fields PATCH /api/auth/me refuses to change
+var immutableProfileFields = []string{"userId", "email", "verified", "role"}
+
+//Profile is the signed-in user's account as returned by /api/auth/me
+type Profile struct {
+	SuccessResponse
+	UserID      string `json:"userId"`
+	Username    string `json:"username"`
+	DisplayName string `json:"displayName,omitempty"`
+	Locale      string `json:"locale,omitempty"`
+	Email       string `json:"email"`
+	Verified    bool   `json:"verified"`
+	Role        string `json:"role"`
+}
+
+//ProfileUpdate is the body of PATCH /api/auth/me. Fields left out are nil and keep their value,
+//an empty string clears the field. The username can't be cleared and is subject to identityChangeCooldown.
+type ProfileUpdate struct {
+	Username    *string `json:"username"`
+	DisplayName *string `json:"displayName"`
+	Locale      *string `json:"locale"`
+}
+
+//DisplayNameUpdate is the body of a display name change, an empty name clears it
+type DisplayNameUpdate struct {
+	DisplayName string `json:"displayName"`
+}
+
+//validateDisplayName trims name and checks it with cleanText against displayNameMaxLength.
+//An empty result means the user has no display name.
+func validateDisplayName(name string) (string, error) {
+	return cleanText("display name", strings.TrimSpace(name), displayNameMaxLength)
+}
+
+//validateLocale checks locale is a BCP 47 language tag and returns it in canonical form, e.g. "en_us" as "en-US".
+//An empty result means the user has no locale.
+func validateLocale(locale string) (string, error) {
+	locale = strings.TrimSpace(locale)
+	if locale == "" {
+		return "", nil
+	}
+	tag, err := language.Parse(strings.ReplaceAll(locale, "_", "-"))
+	if err != nil || len(tag.String()) > localeMaxLength {
+		return "", errors.New("locale must be a language tag like \"en-US\"")
+	}
+	return tag.String(), nil
+}
+
+//nullableString stores an empty string as NULL
+func nullableString(value string) sql.NullString {
+	return sql.NullString{String: value, Valid: value != ""}
+}
+
+func me(w http.ResponseWriter, r *http.Request) {
+
+	if (*r).Method == "OPTIONS" {
+		return
+	}
+
+	claims, _ := claimsFromContext(r.Context())
+
+	profile, err := loadProfile(claims.UserID)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			writeJSONError(w, r, http.StatusNotFound, "this account no longer exists")
+		} else {
+			internalError(w, r, "error retrieving profile", err)
+		}
+		return
+	}
+	profile.SuccessResponse = SuccessResponse{Status: "ok", Message: "profile retrieved"}
+
+	writeJSON(w, http.StatusOK, profile)
+}
+
+//loadProfile reads the profile of userID, sql.ErrNoRows if the account is gone or deleted
+func loadProfile(userID string) (Profile, error) {
+	var profile Profile
+	var displayName, locale sql.NullString
+	var verified sql.NullBool
+	err := withRetry(func() error {
+		return DB.QueryRow("SELECT userId, username, displayName, locale, email, verified, role FROM users WHERE userId = ? AND deletedAt IS NULL;", userID).
+			Scan(&profile.UserID, &profile.Username, &displayName, &locale, &profile.Email, &verified, &profile.Role)
+	})
+	if err != nil {
+		return Profile{}, err
+	}
+	profile.DisplayName = displayName.String
+	profile.Locale = locale.String
+	profile.Verified = verified.Bool
+	return profile, nil
+}
+
+//updateProfile changes only the fields present in the body and answers with the updated profile
+func updateProfile(w http.ResponseWriter, r *http.Request) {
+
+	if (*r).Method == "OPTIONS" {
+		return
+	}
+
+	claims, _ := claimsFromContext(r.Context())
+
+	//decode to raw fields first, a struct can't tell a field left out from one the client tried to change
+	var fields map[string]json.RawMessage
+	err := decodeJSON(r.Body, &fields)
+	if err != nil {
+		writeJSONError(w, r, http.StatusBadRequest, decodeErrorMessage(err, "issue retrieving profile"))
+		log.Print(err.Error())
+		return
+	}
+	for _, field := range immutableProfileFields {
+		if _, ok := fields[field]; ok {
+			writeJSONError(w, r, http.StatusBadRequest, field+" can't be changed here")
+			return
+		}
+	}
+	body, _ := json.Marshal(fields)
+	update := ProfileUpdate{}
+	err = decodeJSON(bytes.NewReader(body), &update)
+	if err != nil {
+		writeJSONError(w, r, http.StatusBadRequest, decodeErrorMessage(err, "issue retrieving profile"))
+		log.Print(err.Error())
+		return
+	}
+
+	//each field is validated before anything is written, so a bad field leaves the profile untouched
+	var columns []string
+	var values []interface{}
+	if update.DisplayName != nil {
+		displayName, err := validateDisplayName(*update.DisplayName)
+		if err != nil {
+			writeJSONError(w, r, http.StatusBadRequest, err.Error())
+			return
+		}
+		columns = append(columns, "displayName = ?")
+		values = append(values, nullableString(displayName))
+	}
+	if update.Locale != nil {
+		locale, err := validateLocale(*update.Locale)
+		if err != nil {
+			writeJSONError(w, r, http.StatusBadRequest, err.Error())
+			return
+		}
+		columns = append(columns, "locale = ?")
+		values = append(values, nullableString(locale))
+	}
+	if update.Username != nil {
+		username, changed, ok := checkUsernameChange(w, r, claims.UserID, *update.Username)
+		if !ok {
+			return
+		}
+		columns = append(columns, "username = ?")
+		values = append(values, username)
+		//sending the current username again isn't a change, so it doesn't restart the cooldown
+		if changed {
+			columns = append(columns, "lastUsernameChangeAt = ?")
+			values = append(values, clock.Now())
+		}
+	}
+	if len(columns) == 0 {
+		writeJSONError(w, r, http.StatusBadRequest, "no profile fields to update")
+		return
+	}
+
+	err = withRetry(func() error {
+		_, err := DB.Exec("UPDATE users SET "+strings.Join(columns, ", ")+" WHERE userId = ?;", append(values, claims.UserID)...)
+		return err
+	})
+	if err != nil {
+		internalError(w, r, "error updating profile", err)
+		return
+	}
+
+	profile, err := loadProfile(claims.UserID)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			writeJSONError(w, r, http.StatusNotFound, "this account no longer exists")
+		} else {
+			internalError(w, r, "error retrieving profile", err)
+		}
+		return
+	}
+	profile.SuccessResponse = SuccessResponse{Status: "ok", Message: "profile updated"}
+
+	writeJSON(w, http.StatusOK, profile)
+}
+
+//checkUsernameChange validates a new username for userID, answering the request itself if it can't be used.
+//changed is false when username is already the account's username.
+func checkUsernameChange(w http.ResponseWriter, r *http.Request, userID string, username string) (string, bool, bool) {
+	username, err := cleanText("username", username, usernameMaxLength)
+	if err != nil {
+		writeJSONError(w, r, http.StatusBadRequest, err.Error())
+		return "", false, false
+	}
+	if username == "" {
+		writeJSONError(w, r, http.StatusBadRequest, "username can't be empty")
+		return "", false, false
+	}
+
+	var current string
+	var taken bool
+	err = withRetry(func() error {
+		return DB.QueryRow("SELECT username, EXISTS(SELECT * FROM users WHERE username = ? AND userId <> ?) FROM users WHERE userId = ?;", username, userID, userID).
+			Scan(&current, &taken)
+	})
+	if err != nil {
+		if err == sql.ErrNoRows {
+			writeJSONError(w, r, http.StatusNotFound, "this account no longer exists")
+		} else {
+			internalError(w, r, "error checking if username exists", err)
+		}
+		return "", false, false
+	}
+	if current == username {
+		return username, false, true
+	}
+	if taken {
+		writeJSONError(w, r, http.StatusConflict, "this username is taken")
+		return "", false, false
+	}
+
+	retryAt, err := nextIdentityChange(userID)
+	if err != nil {
+		internalError(w, r, "error checking username change cooldown", err)
+		return "", false, false
+	}
+	if !retryAt.IsZero() {
+		identityChangeTooSoon(w, r, retryAt)
+		return "", false, false
+	}
+	return username, true, true
+}
+
+func setDisplayName(w http.ResponseWriter, r *http.Request) {
+
+	if (*r).Method == "OPTIONS" {
+		return
+	}
+
+	claims, _ := claimsFromContext(r.Context())
+
+	update := DisplayNameUpdate{}
+	err := decodeJSON(r.Body, &update)
+	if err != nil {
+		writeJSONError(w, r, http.StatusBadRequest, decodeErrorMessage(err, "issue retrieving display name"))
+		log.Print(err.Error())
+		return
+	}
+
+	displayName, err := validateDisplayName(update.DisplayName)
+	if err != nil {
+		writeJSONError(w, r, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	err = withRetry(func() error {
+		_, err := DB.Exec("UPDATE users SET displayName = ? WHERE userId = ?;", nullableString(displayName), claims.UserID)
+		return err
+	})
+	if err != nil {
+		internalError(w, r, "error updating display name", err)
+		return
+	}
+
+	writeJSONSuccess(w, http.StatusOK, "display name updated")
+}
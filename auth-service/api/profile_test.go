@@ -0,0 +1,139 @@
+package api_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/BearCloud/fa20-project-dev/backend/auth-service/api"
+	"github.com/BearCloud/fa20-project-dev/backend/auth-service/apitest"
+)
+
+//meDisplayName returns the display name /me answers with for access
+func meDisplayName(t *testing.T, env *apitest.Env, access *http.Cookie) string {
+	t.Helper()
+	res := getMe(env, "", access)
+	if res.Code != http.StatusOK {
+		t.Fatalf("me: got %d %s", res.Code, res.Body.String())
+	}
+	var profile api.Profile
+	json.NewDecoder(res.Body).Decode(&profile)
+	return profile.DisplayName
+}
+
+func TestDisplayNameSetAndUpdated(t *testing.T) {
+	env := apitest.New(t)
+	creds := api.Credentials{Username: "bear", Email: "bear@berkeley.edu", Password: "pw", DisplayName: "  Oski the Bear 🐻 "}
+	signUpVerified(t, env, creds)
+	access, _ := signIn(t, env, creds)
+	if got := meDisplayName(t, env, access); got != "Oski the Bear 🐻" {
+		t.Fatalf("display name from signup: got %q, want it trimmed", got)
+	}
+
+	res := env.Do(http.MethodPut, "/api/auth/me/displayname", api.DisplayNameUpdate{DisplayName: "Golden Bear"}, access)
+	expectSuccess(t, "setting the display name", res, http.StatusOK, "display name updated")
+	if got := meDisplayName(t, env, access); got != "Golden Bear" {
+		t.Fatalf("updated display name: got %q, want Golden Bear", got)
+	}
+	if got := profileUsername(t, "me", getMe(env, "", access)); got != "bear" {
+		t.Fatalf("username after setting the display name: got %q, want bear", got)
+	}
+
+	//64 characters is the limit, counted in characters rather than bytes
+	res = env.Do(http.MethodPut, "/api/auth/me/displayname", api.DisplayNameUpdate{DisplayName: strings.Repeat("é", 64)}, access)
+	expectSuccess(t, "64 character display name", res, http.StatusOK, "display name updated")
+	res = env.Do(http.MethodPut, "/api/auth/me/displayname", api.DisplayNameUpdate{DisplayName: strings.Repeat("é", 65)}, access)
+	if res.Code != http.StatusBadRequest {
+		t.Fatalf("65 character display name: got %d, want 400", res.Code)
+	}
+
+	res = env.Do(http.MethodPut, "/api/auth/me/displayname", api.DisplayNameUpdate{DisplayName: "   "}, access)
+	expectSuccess(t, "clearing the display name", res, http.StatusOK, "display name updated")
+	if got := meDisplayName(t, env, access); got != "" {
+		t.Fatalf("cleared display name: got %q, want none", got)
+	}
+
+	res = env.Do(http.MethodPut, "/api/auth/me/displayname", api.DisplayNameUpdate{DisplayName: "Golden Bear"})
+	if res.Code != http.StatusUnauthorized {
+		t.Fatalf("setting the display name signed out: got %d, want 401", res.Code)
+	}
+}
+
+func TestSignupDisplayNameTooLong(t *testing.T) {
+	env := apitest.New(t)
+	res := env.Do(http.MethodPost, "/api/auth/signup", api.Credentials{Username: "bear", Email: "bear@berkeley.edu", Password: "pw", DisplayName: strings.Repeat("a", 65)})
+	if res.Code != http.StatusBadRequest {
+		t.Fatalf("signup with a 65 character display name: got %d, want 400", res.Code)
+	}
+}
+
+//patchProfile sends body to PATCH /api/auth/me and returns the profile it answers with
+func patchProfile(t *testing.T, env *apitest.Env, access *http.Cookie, body string) api.Profile {
+	t.Helper()
+	res := env.Do(http.MethodPatch, "/api/auth/me", body, access)
+	if res.Code != http.StatusOK {
+		t.Fatalf("PATCH %s: got %d %s", body, res.Code, res.Body.String())
+	}
+	var profile api.Profile
+	json.NewDecoder(res.Body).Decode(&profile)
+	return profile
+}
+
+func TestPatchProfileChangesOnlyGivenFields(t *testing.T) {
+	env := apitest.New(t)
+	creds := api.Credentials{Username: "bear", Email: "bear@berkeley.edu", Password: "pw", DisplayName: "Oski"}
+	signUpVerified(t, env, creds)
+	access, _ := signIn(t, env, creds)
+
+	profile := patchProfile(t, env, access, `{"locale":"en_us"}`)
+	if profile.Message != "profile updated" || profile.Locale != "en-US" || profile.DisplayName != "Oski" || profile.Username != "bear" {
+		t.Fatalf("PATCH with only a locale: got %+v, want the locale set and nothing else changed", profile)
+	}
+	profile = patchProfile(t, env, access, `{"displayName":"Golden Bear"}`)
+	if profile.DisplayName != "Golden Bear" || profile.Locale != "en-US" {
+		t.Fatalf("PATCH with only a display name: got %+v, want the locale kept", profile)
+	}
+	profile = patchProfile(t, env, access, `{"displayName":""}`)
+	if profile.DisplayName != "" || profile.Locale != "en-US" {
+		t.Fatalf("PATCH with an empty display name: got %+v, want it cleared", profile)
+	}
+
+	//a bad field fails the whole update
+	res := env.Do(http.MethodPatch, "/api/auth/me", `{"displayName":"Oski","locale":"not a locale"}`, access)
+	if res.Code != http.StatusBadRequest {
+		t.Fatalf("PATCH with a bad locale: got %d, want 400", res.Code)
+	}
+	if got := meDisplayName(t, env, access); got != "" {
+		t.Fatalf("display name after a rejected PATCH: got %q, want it unchanged", got)
+	}
+	res = env.Do(http.MethodPatch, "/api/auth/me", `{}`, access)
+	if res.Code != http.StatusBadRequest {
+		t.Fatalf("PATCH with no fields: got %d, want 400", res.Code)
+	}
+}
+
+func TestPatchProfileRejectsImmutableFields(t *testing.T) {
+	env := apitest.New(t)
+	creds := api.Credentials{Username: "bear", Email: "bear@berkeley.edu", Password: "pw"}
+	signUpVerified(t, env, creds)
+	access, _ := signIn(t, env, creds)
+
+	for _, body := range []string{
+		`{"email":"tree@stanford.edu"}`,
+		`{"userId":"someone-else"}`,
+		`{"role":"admin","displayName":"Oski"}`,
+		`{"verified":false}`,
+	} {
+		res := env.Do(http.MethodPatch, "/api/auth/me", body, access)
+		if res.Code != http.StatusBadRequest || !strings.Contains(res.Body.String(), "can't be changed here") {
+			t.Fatalf("PATCH %s: got %d %s, want 400 naming the field", body, res.Code, res.Body.String())
+		}
+	}
+	res := getMe(env, "", access)
+	var profile api.Profile
+	json.NewDecoder(res.Body).Decode(&profile)
+	if profile.Email != creds.Email || profile.Role != "user" || profile.DisplayName != "" || !profile.Verified {
+		t.Fatalf("profile after rejected PATCHes: got %+v, want it unchanged", profile)
+	}
+}
@@ -0,0 +1,112 @@
+package api_test
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/BearCloud/fa20-project-dev/backend/auth-service/api"
+	"github.com/BearCloud/fa20-project-dev/backend/auth-service/apitest"
+)
+
+//countRows counts the rows of table whose column is value
+func countRows(t *testing.T, env *apitest.Env, table string, column string, value string) int {
+	t.Helper()
+	var count int
+	err := env.DB.QueryRow("SELECT COUNT(*) FROM "+table+" WHERE "+column+" = ?", value).Scan(&count)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return count
+}
+
+func TestPurgeRemovesRowsKeptPerAddress(t *testing.T) {
+	clock := apitest.NewFakeClock(time.Now())
+	env := apitest.NewWithClock(t, clock)
+	creds := api.Credentials{Username: "bear", Email: "bear@berkeley.edu", Password: "pw"}
+	signUpVerified(t, env, creds)
+	other := api.Credentials{Username: "oski", Email: "oski@berkeley.edu", Password: "pw"}
+	signUpVerified(t, env, other)
+	access, _ := signIn(t, env, creds)
+
+	res := env.Do(http.MethodPost, "/api/auth/emails", api.Credentials{Email: "golden@bears.org"}, access)
+	if res.Code != http.StatusCreated {
+		t.Fatalf("add email: got %d %s", res.Code, res.Body.String())
+	}
+	email, _ := env.Mailer.LastFrom("golden@bears.org", "email-verification.html")
+	res = env.Do(http.MethodPost, "/api/auth/emails/verify?token="+email.Token(), nil)
+	if res.Code != http.StatusOK {
+		t.Fatalf("verify email: got %d %s", res.Code, res.Body.String())
+	}
+
+	addresses := []string{creds.Email, "golden@bears.org", other.Email}
+	for _, address := range addresses {
+		env.Do(http.MethodPost, "/api/auth/signin", api.Credentials{Email: address, Password: "wrong"})
+		_, err := env.DB.Exec("INSERT INTO email_deliveries (email, status, updatedAt) VALUES (?, ?, ?)", address, "bounce", clock.Now())
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	env.Do(http.MethodPost, "/api/auth/reauth", api.Credentials{Password: "wrong"}, access)
+
+	var userID string
+	env.DB.QueryRow("SELECT userId FROM users WHERE email = ?", creds.Email).Scan(&userID)
+	access, _ = signIn(t, env, creds)
+	res = env.Do(http.MethodDelete, "/api/auth/account", nil, access)
+	if res.Code != http.StatusOK {
+		t.Fatalf("delete: got %d %s", res.Code, res.Body.String())
+	}
+	clock.Advance(31 * 24 * time.Hour)
+	purged, err := api.PurgeDeletedAccounts()
+	if err != nil || purged != 1 {
+		t.Fatalf("purge: purged %d, %v", purged, err)
+	}
+
+	for _, address := range addresses[:2] {
+		if countRows(t, env, "login_attempts", "email", address) != 0 {
+			t.Fatalf("failed signins for %s survived the purge", address)
+		}
+		if countRows(t, env, "email_deliveries", "email", address) != 0 {
+			t.Fatalf("delivery status of %s survived the purge", address)
+		}
+	}
+	if countRows(t, env, "login_attempts", "email", "reauth:"+userID) != 0 {
+		t.Fatalf("failed re-authentications survived the purge")
+	}
+	if countRows(t, env, "login_attempts", "email", other.Email) != 1 || countRows(t, env, "email_deliveries", "email", other.Email) != 1 {
+		t.Fatalf("purge removed rows of another account")
+	}
+	if countRows(t, env, "users", "userId", userID) != 0 || countRows(t, env, "emails", "userId", userID) != 0 {
+		t.Fatalf("purge left the account behind")
+	}
+}
+
+func TestPurgeAuditRetention(t *testing.T) {
+	for retention, want := range map[string]int{"anonymize": 1, "delete": 0, "keep": 1} {
+		clock := apitest.NewFakeClock(time.Now())
+		env := apitest.NewWithConfig(t, func(cfg *api.Config) {
+			cfg.Clock = clock
+			cfg.AuditRetention = retention
+		})
+		creds := api.Credentials{Username: "bear", Email: "bear@berkeley.edu", Password: "pw"}
+		signUpVerified(t, env, creds)
+		access, _ := signIn(t, env, creds)
+		env.Do(http.MethodPost, "/api/auth/emails", api.Credentials{Email: "golden@bears.org"}, access)
+		var userID string
+		env.DB.QueryRow("SELECT userId FROM users WHERE email = ?", creds.Email).Scan(&userID)
+
+		env.Do(http.MethodDelete, "/api/auth/account", nil, access)
+		clock.Advance(31 * 24 * time.Hour)
+		api.PurgeDeletedAccounts()
+
+		var entries int
+		env.DB.QueryRow("SELECT COUNT(*) FROM audit_log WHERE action = ?", "add_email").Scan(&entries)
+		if entries != want {
+			t.Fatalf("AUDIT_RETENTION=%s: got %d audit entries after the purge, want %d", retention, entries, want)
+		}
+		kept := countRows(t, env, "audit_log", "actorId", userID)
+		if retention == "keep" && kept != 1 || retention != "keep" && kept != 0 {
+			t.Fatalf("AUDIT_RETENTION=%s: %d audit entries still name the purged user", retention, kept)
+		}
+	}
+}
@@ -0,0 +1,215 @@
+package api
+
+import (
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+//rateWindow counts the requests a client made in the current window
+type rateWindow struct {
+	start time.Time
+	count int
+}
+
+//rateLimiter allows each client at most limit requests per fixed window, a limit of 0 turns it off
+type rateLimiter struct {
+	limit  int
+	window time.Duration
+
+	mu        sync.Mutex
+	clients   map[string]*rateWindow
+	lastSweep time.Time
+}
+
+//publicRateLimit throttles the public endpoints per client IP
+var publicRateLimit = &rateLimiter{limit: 60, window: time.Minute}
+
+var (
+	//emailIPRateLimit caps the emails each client IP can have sent, however many accounts it asks for
+	emailIPRateLimit = &rateLimiter{limit: 10, window: time.Hour}
+	//emailAccountRateLimit caps the emails each account can be sent, however many IPs ask for them
+	emailAccountRateLimit = &rateLimiter{limit: 3, window: time.Hour}
+)
+
+var (
+	//limitBypassNetworks are client networks, such as an office or CI, exempt from the rate limit and signin lockout
+	limitBypassNetworks []*net.IPNet
+	//trustedProxies are the networks of the proxies in front of the service, whose X-Forwarded-For clientIP believes
+	trustedProxies []*net.IPNet
+)
+
+//parseNetworks parses the comma separated list of CIDRs in the setting name, where a bare IP stands for just that address
+func parseNetworks(name string, list string) ([]*net.IPNet, []string) {
+	var networks []*net.IPNet
+	var problems []string
+	for _, entry := range splitList(list) {
+		if ip := net.ParseIP(entry); ip != nil {
+			bits := 8 * net.IPv6len
+			if ip.To4() != nil {
+				ip, bits = ip.To4(), 8*net.IPv4len
+			}
+			networks = append(networks, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+		_, network, err := net.ParseCIDR(entry)
+		if err != nil {
+			problems = append(problems, name+" entries must be CIDRs like 10.0.0.0/8 or single IPs, got \""+entry+"\"")
+			continue
+		}
+		networks = append(networks, network)
+	}
+	return networks, problems
+}
+
+//limitBypassed reports whether the request comes from one of limitBypassNetworks
+func limitBypassed(r *http.Request) bool {
+	if len(limitBypassNetworks) == 0 {
+		return false
+	}
+	return inNetworks(net.ParseIP(clientIP(r)), limitBypassNetworks)
+}
+
+//inNetworks reports whether ip is in one of networks
+func inNetworks(ip net.IP, networks []*net.IPNet) bool {
+	if ip == nil {
+		return false
+	}
+	for _, network := range networks {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+//take counts a request from client and reports how many are left in the window, when the window resets
+//and whether the request is allowed
+func (limiter *rateLimiter) take(client string) (int, time.Time, bool) {
+	limiter.mu.Lock()
+	defer limiter.mu.Unlock()
+
+	now := clock.Now()
+	if limiter.clients == nil {
+		limiter.clients = map[string]*rateWindow{}
+	}
+	//drop finished windows now and then so clients that went away don't pile up
+	if now.Sub(limiter.lastSweep) > limiter.window {
+		for key, w := range limiter.clients {
+			if now.Sub(w.start) >= limiter.window {
+				delete(limiter.clients, key)
+			}
+		}
+		limiter.lastSweep = now
+	}
+
+	w, ok := limiter.clients[client]
+	if !ok || now.Sub(w.start) >= limiter.window {
+		w = &rateWindow{start: now}
+		limiter.clients[client] = w
+	}
+	reset := w.start.Add(limiter.window)
+	if w.count >= limiter.limit {
+		return 0, reset, false
+	}
+	w.count++
+	return limiter.limit - w.count, reset, true
+}
+
+//allow is take for a limiter that may be off, it reports when the window resets and whether the request is allowed
+func (limiter *rateLimiter) allow(client string) (time.Time, bool) {
+	if limiter.limit <= 0 {
+		return time.Time{}, true
+	}
+	_, reset, ok := limiter.take(client)
+	return reset, ok
+}
+
+//emailLimited counts a request that sends email to account against the per IP and per account email limits,
+//and answers it with a 429 if either is used up. Together they stop an attacker rotating IPs to flood one inbox
+//as well as one IP spraying many accounts. A request refused by the IP limit isn't counted against the account,
+//so a single client can't use up a victim's allowance.
+func emailLimited(w http.ResponseWriter, r *http.Request, account string) bool {
+	if limitBypassed(r) {
+		return false
+	}
+	reset, ok := emailIPRateLimit.allow(clientIP(r))
+	if ok {
+		reset, ok = emailAccountRateLimit.allow(strings.ToLower(strings.TrimSpace(account)))
+	}
+	if ok {
+		return false
+	}
+	w.Header().Set("Retry-After", strconv.Itoa(int(until(reset).Seconds())+1))
+	writeJSONError(w, r, http.StatusTooManyRequests, "too many emails requested, try again later")
+	return true
+}
+
+//remoteHost returns the address of the connection r came over, without its port
+func remoteHost(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+//fromTrustedProxy reports whether r's connection comes from one of trustedProxies, so the forwarding headers it
+//carries were written by a proxy rather than made up by the client
+func fromTrustedProxy(r *http.Request) bool {
+	return inNetworks(net.ParseIP(remoteHost(r)), trustedProxies)
+}
+
+//clientIP returns the address the request came from, without its port. Every per-client limit, the bypass list,
+//the signin backoff and session devices go by it. When the connection comes from one of trustedProxies it is the
+//right-most X-Forwarded-For hop that isn't a trusted proxy itself: each proxy appends the address it got the request
+//from, so hops further left could have been made up by the client.
+func clientIP(r *http.Request) string {
+	host := remoteHost(r)
+	if !fromTrustedProxy(r) {
+		return host
+	}
+
+	var hops []string
+	for _, header := range r.Header.Values("X-Forwarded-For") {
+		hops = append(hops, strings.Split(header, ",")...)
+	}
+	for i := len(hops) - 1; i >= 0; i-- {
+		ip := net.ParseIP(strings.TrimSpace(hops[i]))
+		//a hop that isn't an address wasn't written by a trusted proxy, so the last trusted one is as far as it goes
+		if ip == nil {
+			break
+		}
+		host = ip.String()
+		if !inNetworks(ip, trustedProxies) {
+			break
+		}
+	}
+	return host
+}
+
+//Middleware rejects clients that went over the limit with 429, and tells every client
+//how much of its allowance is left through the X-RateLimit headers
+func (limiter *rateLimiter) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if limiter.limit <= 0 || r.Method == http.MethodOptions || limitBypassed(r) {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		remaining, reset, ok := limiter.take(clientIP(r))
+		w.Header().Set("X-RateLimit-Limit", strconv.Itoa(limiter.limit))
+		w.Header().Set("X-RateLimit-Remaining", strconv.Itoa(remaining))
+		w.Header().Set("X-RateLimit-Reset", strconv.FormatInt(reset.Unix(), 10))
+		if !ok {
+			retryAfter := int(until(reset).Seconds()) + 1
+			w.Header().Set("Retry-After", strconv.Itoa(retryAfter))
+			writeJSONError(w, r, http.StatusTooManyRequests, "too many requests, try again later")
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
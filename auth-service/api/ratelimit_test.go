@@ -0,0 +1,129 @@
+package api_test
+
+import (
+	"net/http"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/BearCloud/fa20-project-dev/backend/auth-service/api"
+	"github.com/BearCloud/fa20-project-dev/backend/auth-service/apitest"
+)
+
+func TestRateLimitHeaders(t *testing.T) {
+	clock := apitest.NewFakeClock(time.Now())
+	env := apitest.NewWithConfig(t, func(cfg *api.Config) {
+		cfg.Clock = clock
+		cfg.RateLimit = 3
+		cfg.RateLimitWindow = time.Minute
+	})
+
+	for i, want := range []struct {
+		code      int
+		remaining string
+	}{
+		{http.StatusOK, "2"},
+		{http.StatusOK, "1"},
+		{http.StatusOK, "0"},
+		{http.StatusTooManyRequests, "0"},
+	} {
+		res := env.Do(http.MethodGet, "/api/auth/policy", nil)
+		if res.Code != want.code {
+			t.Fatalf("request %d: got %d, want %d", i+1, res.Code, want.code)
+		}
+		if got := res.Header().Get("X-RateLimit-Limit"); got != "3" {
+			t.Fatalf("request %d: got X-RateLimit-Limit %q, want 3", i+1, got)
+		}
+		if got := res.Header().Get("X-RateLimit-Remaining"); got != want.remaining {
+			t.Fatalf("request %d: got X-RateLimit-Remaining %q, want %s", i+1, got, want.remaining)
+		}
+		reset, err := strconv.ParseInt(res.Header().Get("X-RateLimit-Reset"), 10, 64)
+		if err != nil || reset < clock.Now().Unix() || reset > clock.Now().Add(time.Minute).Unix() {
+			t.Fatalf("request %d: got X-RateLimit-Reset %q, want a time within the window", i+1, res.Header().Get("X-RateLimit-Reset"))
+		}
+		if want.code == http.StatusTooManyRequests && res.Header().Get("Retry-After") == "" {
+			t.Fatalf("throttled request: no Retry-After")
+		}
+	}
+
+	clock.Advance(time.Minute + time.Second)
+	res := env.Do(http.MethodGet, "/api/auth/policy", nil)
+	if res.Code != http.StatusOK || res.Header().Get("X-RateLimit-Remaining") != "2" {
+		t.Fatalf("after the window: got %d with X-RateLimit-Remaining %q, want 200 and 2", res.Code, res.Header().Get("X-RateLimit-Remaining"))
+	}
+}
+
+//sendResetFrom asks for a reset link for email from remoteAddr and returns the status
+func sendResetFrom(env *apitest.Env, remoteAddr string, email string) int {
+	req := env.Request(http.MethodPost, "/api/auth/sendreset", api.Credentials{Email: email})
+	req.RemoteAddr = remoteAddr
+	return env.Send(req).Code
+}
+
+func TestEmailLimitPerAccount(t *testing.T) {
+	env := apitest.NewWithConfig(t, func(cfg *api.Config) {
+		cfg.EmailIPLimit = 100
+		cfg.EmailAccountLimit = 2
+		cfg.EmailLimitWindow = time.Hour
+	})
+
+	//rotating IPs doesn't get around the account's limit
+	for i, addr := range []string{"192.0.2.1:1234", "192.0.2.2:1234", "192.0.2.3:1234"} {
+		want := http.StatusOK
+		if i == 2 {
+			want = http.StatusTooManyRequests
+		}
+		if code := sendResetFrom(env, addr, "bear@berkeley.edu"); code != want {
+			t.Fatalf("reset %d for bear from %s: got %d, want %d", i+1, addr, code, want)
+		}
+	}
+	//the address is counted the same however it is written
+	if code := sendResetFrom(env, "192.0.2.4:1234", " Bear@Berkeley.edu "); code != http.StatusTooManyRequests {
+		t.Fatalf("reset for bear in other case: got %d, want 429", code)
+	}
+	if code := sendResetFrom(env, "192.0.2.1:1234", "tree@stanford.edu"); code != http.StatusOK {
+		t.Fatalf("reset for another account: got %d, want 200", code)
+	}
+}
+
+func TestEmailLimitPerIP(t *testing.T) {
+	env := apitest.NewWithConfig(t, func(cfg *api.Config) {
+		cfg.EmailIPLimit = 2
+		cfg.EmailAccountLimit = 100
+		cfg.EmailLimitWindow = time.Hour
+	})
+
+	//rotating accounts doesn't get around the IP's limit
+	for i, email := range []string{"bear@berkeley.edu", "tree@stanford.edu", "oski@berkeley.edu"} {
+		want := http.StatusOK
+		if i == 2 {
+			want = http.StatusTooManyRequests
+		}
+		if code := sendResetFrom(env, "192.0.2.1:1234", email); code != want {
+			t.Fatalf("reset %d from one IP for %s: got %d, want %d", i+1, email, code, want)
+		}
+	}
+	if code := sendResetFrom(env, "192.0.2.2:1234", "oski@berkeley.edu"); code != http.StatusOK {
+		t.Fatalf("reset from another IP: got %d, want 200", code)
+	}
+}
+
+func TestEmailLimitRefusedByIPNotCountedForAccount(t *testing.T) {
+	env := apitest.NewWithConfig(t, func(cfg *api.Config) {
+		cfg.EmailIPLimit = 1
+		cfg.EmailAccountLimit = 2
+		cfg.EmailLimitWindow = time.Hour
+	})
+
+	sendResetFrom(env, "192.0.2.1:1234", "tree@stanford.edu")
+	for i := 0; i < 5; i++ {
+		if code := sendResetFrom(env, "192.0.2.1:1234", "bear@berkeley.edu"); code != http.StatusTooManyRequests {
+			t.Fatalf("reset from a limited IP: got %d, want 429", code)
+		}
+	}
+	for _, addr := range []string{"192.0.2.2:1234", "192.0.2.3:1234"} {
+		if code := sendResetFrom(env, addr, "bear@berkeley.edu"); code != http.StatusOK {
+			t.Fatalf("reset for bear from %s after a flood from a limited IP: got %d, want 200", addr, code)
+		}
+	}
+}
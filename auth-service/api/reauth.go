@@ -0,0 +1,142 @@
+package api
+
+import (
+	"database/sql"
+	"log"
+	"net/http"
+	"time"
+)
+
+var (
+	//reauthWindow is how recently the user must have entered their password to perform a sensitive operation
+	reauthWindow = 5 * time.Minute
+)
+
+//requireRecentAuth guards sensitive operations, rejecting access tokens whose password entry is older than reauthWindow,
+//or that were issued without a password at all. It must run after RequireAuth. The "reauth" hint tells the client to
+//call /api/auth/reauth and retry.
+func requireRecentAuth(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodOptions {
+			next.ServeHTTP(w, r)
+			return
+		}
+		claims, _ := claimsFromContext(r.Context())
+		if !enteredPassword(claims.AMR) || since(time.Unix(claims.AuthTime, 0)) > reauthWindow {
+			writeJSON(w, http.StatusForbidden, ErrorResponse{
+				Status:        "error",
+				Message:       "re-enter your password to continue",
+				Hint:          "reauth",
+				CorrelationID: requestIDFromContext(r.Context()),
+			})
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+//enteredPassword reports whether amr, the authentication methods of a token, include the password
+func enteredPassword(amr []string) bool {
+	for _, method := range amr {
+		if method == amrPassword {
+			return true
+		}
+	}
+	return false
+}
+
+//reauthLockoutKey is what wrong passwords at /api/auth/reauth are counted against, for the lockout and the backoff.
+//It goes by the account the session belongs to rather than an email or IP, and its prefix keeps it apart from
+//the emails signin failures are counted against.
+func reauthLockoutKey(userID string) string {
+	return "reauth:" + userID
+}
+
+//ReauthResponse is the JSON body returned after confirming the password, so clients know until when
+//sensitive operations are allowed without asking again
+type ReauthResponse struct {
+	SuccessResponse
+	ReauthUntil time.Time `json:"reauthUntil"`
+	TokenExpiry
+}
+
+//reauthenticate confirms the signed in user's current password for step-up auth. On success the session is swapped
+//for fresh tokens whose auth_time is now, which opens the reauthWindow for requireRecentAuth. A wrong password
+//gets a 401 and leaves the current tokens, and so the window, as they were.
+func reauthenticate(w http.ResponseWriter, r *http.Request) {
+
+	if (*r).Method == "OPTIONS" {
+		return
+	}
+
+	claims, _ := claimsFromContext(r.Context())
+
+	//Someone holding a stolen session gets no more password guesses here than at signin
+	lockoutKey := reauthLockoutKey(claims.UserID)
+	if !limitBypassed(r) {
+		lockedUntil, err := lockedOut(lockoutKey)
+		if err != nil {
+			internalError(w, r, "error checking re-authentication lockout", err)
+			return
+		}
+		if !lockedUntil.IsZero() {
+			signinLockedOut(w, r, lockedUntil)
+			return
+		}
+	}
+
+	credentials := Credentials{}
+	err := decodeJSON(r.Body, &credentials)
+	if err != nil {
+		writeJSONError(w, r, http.StatusBadRequest, decodeErrorMessage(err, "issue retrieving credentials"))
+		log.Print(err.Error())
+		return
+	}
+
+	var hashedPassword string
+	err = withRetry(func() error {
+		return DB.QueryRow("SELECT hashedPassword FROM users WHERE userId = ? AND deletedAt IS NULL;", claims.UserID).Scan(&hashedPassword)
+	})
+	if err != nil {
+		if err == sql.ErrNoRows {
+			writeJSONError(w, r, http.StatusNotFound, "this account no longer exists")
+		} else {
+			internalError(w, r, "error retrieving account", err)
+		}
+		return
+	}
+
+	err = comparePassword(hashedPassword, credentials.Password)
+	if err != nil {
+		passwordFailed(w, r, lockoutKey, lockoutKey, "incorrect password")
+		return
+	}
+	err = clearLoginFailures(lockoutKey)
+	if err != nil {
+		log.Print(err.Error())
+	}
+	signinBackoff.clear(lockoutKey)
+
+	//The fresh tokens replace the current session rather than adding another one
+	if cookie, err := r.Cookie(refreshCookieName()); err == nil {
+		if refreshClaims, err := getClaims(cookie.Value); err == nil && refreshClaims.Id != "" {
+			err = revokeSession(refreshClaims.Id)
+			if err != nil {
+				log.Print(err.Error())
+			}
+		}
+	}
+
+	authTime := clock.Now()
+	expiry, err := issueTokens(w, r, claims.UserID, authTime, []string{amrPassword}, claims.RememberMe)
+	if err != nil {
+		tokenError(w, r, err)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, ReauthResponse{
+		SuccessResponse: SuccessResponse{Status: "ok", Message: "password confirmed"},
+		ReauthUntil:     authTime.Add(reauthWindow),
+		TokenExpiry:     expiry,
+	})
+}
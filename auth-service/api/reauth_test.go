@@ -0,0 +1,123 @@
+package api_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/BearCloud/fa20-project-dev/backend/auth-service/api"
+	"github.com/BearCloud/fa20-project-dev/backend/auth-service/apitest"
+)
+
+func TestReauthLocksOutAfterWrongPasswords(t *testing.T) {
+	env := apitest.NewWithConfig(t, func(cfg *api.Config) {
+		cfg.MaxLoginAttempts = 2
+	})
+	creds := api.Credentials{Username: "bear", Email: "bear@berkeley.edu", Password: "pw"}
+	signUpVerified(t, env, creds)
+	access, _ := signIn(t, env, creds)
+
+	res := env.Do(http.MethodPost, "/api/auth/reauth", api.Credentials{Password: "wrong"}, access)
+	if res.Code != http.StatusUnauthorized {
+		t.Fatalf("first wrong password: got %d, want 401", res.Code)
+	}
+	var failed api.SigninErrorResponse
+	json.NewDecoder(res.Body).Decode(&failed)
+	if failed.AttemptsRemaining == nil || *failed.AttemptsRemaining != 1 {
+		t.Fatalf("first wrong password: got attemptsRemaining %v, want 1", failed.AttemptsRemaining)
+	}
+	if env.Sleeper.Last() == 0 {
+		t.Fatalf("wrong password wasn't delayed")
+	}
+
+	env.Do(http.MethodPost, "/api/auth/reauth", api.Credentials{Password: "wrong"}, access)
+	res = env.Do(http.MethodPost, "/api/auth/reauth", api.Credentials{Password: "pw"}, access)
+	if res.Code != http.StatusTooManyRequests {
+		t.Fatalf("right password during the lockout: got %d, want 429", res.Code)
+	}
+	if res.Header().Get("Retry-After") == "" {
+		t.Fatalf("lockout without Retry-After")
+	}
+
+	//the lockout is the account's at reauth, signin by email still works
+	signIn(t, env, creds)
+}
+
+func TestReauthClearsFailuresOnSuccess(t *testing.T) {
+	env := apitest.NewWithConfig(t, func(cfg *api.Config) {
+		cfg.MaxLoginAttempts = 2
+	})
+	creds := api.Credentials{Username: "bear", Email: "bear@berkeley.edu", Password: "pw"}
+	signUpVerified(t, env, creds)
+	access, _ := signIn(t, env, creds)
+
+	env.Do(http.MethodPost, "/api/auth/reauth", api.Credentials{Password: "wrong"}, access)
+	res := env.Do(http.MethodPost, "/api/auth/reauth", api.Credentials{Password: "pw"}, access)
+	if res.Code != http.StatusOK {
+		t.Fatalf("right password: got %d %s", res.Code, res.Body.String())
+	}
+	access = apitest.Cookie(res, "access_token")
+	res = env.Do(http.MethodPost, "/api/auth/reauth", api.Credentials{Password: "wrong"}, access)
+	var failed api.SigninErrorResponse
+	json.NewDecoder(res.Body).Decode(&failed)
+	if failed.AttemptsRemaining == nil || *failed.AttemptsRemaining != 1 {
+		t.Fatalf("wrong password after a right one: got attemptsRemaining %v, want 1", failed.AttemptsRemaining)
+	}
+}
+
+func TestSensitiveOperationNeedsRecentAuth(t *testing.T) {
+	clock := apitest.NewFakeClock(time.Now())
+	env := apitest.NewWithConfig(t, func(cfg *api.Config) {
+		cfg.Clock = clock
+		cfg.ReauthWindow = 5 * time.Minute
+		cfg.AccessTokenTTL = time.Hour
+	})
+	creds := api.Credentials{Username: "bear", Email: "bear@berkeley.edu", Password: "pw"}
+	signUpVerified(t, env, creds)
+	access, _ := signIn(t, env, creds)
+
+	clock.Advance(6 * time.Minute)
+	res := env.Do(http.MethodDelete, "/api/auth/account", nil, access)
+	var body api.ErrorResponse
+	json.NewDecoder(res.Body).Decode(&body)
+	if res.Code != http.StatusForbidden || body.Hint != "reauth" {
+		t.Fatalf("delete outside the reauth window: got %d %+v, want 403 with the reauth hint", res.Code, body)
+	}
+
+	res = env.Do(http.MethodPost, "/api/auth/reauth", api.Credentials{Password: "pw"}, access)
+	if res.Code != http.StatusOK {
+		t.Fatalf("reauth: got %d %s", res.Code, res.Body.String())
+	}
+	var reauth api.ReauthResponse
+	json.NewDecoder(res.Body).Decode(&reauth)
+	if !reauth.ReauthUntil.Equal(clock.Now().Add(5 * time.Minute)) {
+		t.Fatalf("reauth: got reauthUntil %s, want five minutes from now", reauth.ReauthUntil)
+	}
+	res = env.Do(http.MethodDelete, "/api/auth/account", nil, apitest.Cookie(res, "access_token"))
+	if res.Code != http.StatusOK {
+		t.Fatalf("delete within the reauth window: got %d %s", res.Code, res.Body.String())
+	}
+}
+
+func TestFailedReauthLeavesWindowClosed(t *testing.T) {
+	clock := apitest.NewFakeClock(time.Now())
+	env := apitest.NewWithConfig(t, func(cfg *api.Config) {
+		cfg.Clock = clock
+		cfg.ReauthWindow = 5 * time.Minute
+		cfg.AccessTokenTTL = time.Hour
+	})
+	creds := api.Credentials{Username: "bear", Email: "bear@berkeley.edu", Password: "pw"}
+	signUpVerified(t, env, creds)
+	access, _ := signIn(t, env, creds)
+
+	clock.Advance(6 * time.Minute)
+	res := env.Do(http.MethodPost, "/api/auth/reauth", api.Credentials{Password: "wrong"}, access)
+	if res.Code != http.StatusUnauthorized || apitest.Cookie(res, "access_token") != nil {
+		t.Fatalf("reauth with the wrong password: got %d with cookies %v, want 401 and no new session", res.Code, res.Result().Cookies())
+	}
+	res = env.Do(http.MethodDelete, "/api/auth/account", nil, access)
+	if res.Code != http.StatusForbidden {
+		t.Fatalf("delete after a failed reauth: got %d, want 403", res.Code)
+	}
+}
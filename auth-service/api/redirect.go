@@ -0,0 +1,59 @@
+package api
+
+import (
+	"net/http"
+	"net/url"
+)
+
+var (
+	//verifySuccessRedirect is where verify sends the browser after a successful verification, "" answers with JSON
+	verifySuccessRedirect = ""
+	//verifyFailureRedirect is where verify sends the browser when verification fails, "" answers with an error
+	verifyFailureRedirect = ""
+)
+
+//reasons passed to verifyFailureRedirect, so the frontend can explain what went wrong
+const (
+	verifyReasonMissingToken = "missing_token"
+	verifyReasonWrongToken   = "wrong_token"
+	verifyReasonInvalidToken = "invalid_token"
+	verifyReasonNotFound     = "not_found"
+	verifyReasonEmailTaken   = "email_taken"
+	verifyReasonError        = "error"
+)
+
+//withQueryParam returns target with name=value added to its query string
+func withQueryParam(target string, name string, value string) string {
+	parsed, err := url.Parse(target)
+	if err != nil {
+		return target
+	}
+	query := parsed.Query()
+	query.Set(name, value)
+	parsed.RawQuery = query.Encode()
+	return parsed.String()
+}
+
+//verifySucceeded answers a successful verify with a redirect to verifySuccessRedirect, or with JSON if it isn't set
+func verifySucceeded(w http.ResponseWriter, r *http.Request, message string) {
+	if verifySuccessRedirect == "" {
+		writeJSONSuccess(w, http.StatusOK, message)
+		return
+	}
+	http.Redirect(w, r, verifySuccessRedirect, http.StatusFound)
+}
+
+//verifyFailed answers a failed verify with a redirect to verifyFailureRedirect carrying reason,
+//or with message and statusCode if it isn't set
+func verifyFailed(w http.ResponseWriter, r *http.Request, statusCode int, reason string, message string) {
+	if verifyFailureRedirect == "" {
+		writeJSONError(w, r, statusCode, message)
+		return
+	}
+	http.Redirect(w, r, withQueryParam(verifyFailureRedirect, "reason", reason), http.StatusFound)
+}
+
+//verifyError logs a server-side failure in verify and answers it like verifyFailed, see internalErrorMessage
+func verifyError(w http.ResponseWriter, r *http.Request, message string, err error) {
+	verifyFailed(w, r, http.StatusInternalServerError, verifyReasonError, internalErrorMessage(r, message, err))
+}
@@ -0,0 +1,29 @@
+package api
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"errors"
+	"strings"
+)
+
+var (
+	//resetRequiresEmail makes resetPassword require the account's email along with the reset token
+	resetRequiresEmail = false
+)
+
+//errResetLinkInvalid is the one answer for a reset token that doesn't exist, belongs to another account
+//or was used up by a concurrent request, so none of them can be told apart
+var errResetLinkInvalid = errors.New("this reset link is invalid")
+
+//resetAccountMatches reports whether supplied, the email sent with a reset, is the primary email of the account
+//the token belongs to. Nothing supplied matches. Both sides are hashed to the same length and compared in constant
+//time, so how long the check takes says nothing about how close a guess was.
+func resetAccountMatches(supplied string, email string) bool {
+	if supplied == "" {
+		return true
+	}
+	want := sha256.Sum256([]byte(strings.ToLower(email)))
+	got := sha256.Sum256([]byte(strings.ToLower(strings.TrimSpace(supplied))))
+	return subtle.ConstantTimeCompare(want[:], got[:]) == 1
+}
@@ -0,0 +1,230 @@
+package api_test
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/BearCloud/fa20-project-dev/backend/auth-service/api"
+	"github.com/BearCloud/fa20-project-dev/backend/auth-service/apitest"
+)
+
+//requestReset asks for a reset link for email and returns its token
+func requestReset(t *testing.T, env *apitest.Env, email string) string {
+	t.Helper()
+	res := env.Do(http.MethodPost, "/api/auth/sendreset", api.Credentials{Email: email})
+	if res.Code != http.StatusOK {
+		t.Fatalf("sendreset: got %d %s", res.Code, res.Body.String())
+	}
+	reset, ok := env.Mailer.LastFrom(email, "password-reset.html")
+	if !ok {
+		t.Fatalf("no reset email to %s", email)
+	}
+	return reset.Token()
+}
+
+//resetTokenStatus returns the status of validating token
+func resetTokenStatus(env *apitest.Env, token string) int {
+	return env.Do(http.MethodGet, "/api/auth/resetpw/validate?token="+token, nil).Code
+}
+
+func TestKeepModeKeepsEarlierResetLinks(t *testing.T) {
+	clock := apitest.NewFakeClock(time.Now())
+	env := apitest.NewWithConfig(t, func(cfg *api.Config) {
+		cfg.Clock = clock
+		cfg.ResetTokenMode = "keep"
+	})
+	signUpVerified(t, env, api.Credentials{Username: "bear", Email: "bear@berkeley.edu", Password: "pw"})
+
+	first := requestReset(t, env, "bear@berkeley.edu")
+	clock.Advance(30 * time.Minute)
+	second := requestReset(t, env, "bear@berkeley.edu")
+	if first == second {
+		t.Fatalf("sendreset sent the same token twice")
+	}
+	if code := resetTokenStatus(env, first); code != http.StatusOK {
+		t.Fatalf("earlier link after sending another: got %d, want 200", code)
+	}
+
+	//each link still expires on its own schedule
+	clock.Advance(45 * time.Minute)
+	if code := resetTokenStatus(env, first); code != http.StatusGone {
+		t.Fatalf("earlier link past its expiry: got %d, want 410", code)
+	}
+	if code := resetTokenStatus(env, second); code != http.StatusOK {
+		t.Fatalf("later link within its expiry: got %d, want 200", code)
+	}
+}
+
+func TestRotateInvalidatesEarlierResetLinks(t *testing.T) {
+	env := apitest.NewWithConfig(t, func(cfg *api.Config) {
+		cfg.ResetTokenMode = "rotate"
+	})
+	signUpVerified(t, env, api.Credentials{Username: "bear", Email: "bear@berkeley.edu", Password: "pw"})
+
+	first := requestReset(t, env, "bear@berkeley.edu")
+	second := requestReset(t, env, "bear@berkeley.edu")
+	if code := resetTokenStatus(env, first); code != http.StatusNotFound {
+		t.Fatalf("earlier link after rotating: got %d, want 404", code)
+	}
+	if code := resetTokenStatus(env, second); code != http.StatusOK {
+		t.Fatalf("latest link: got %d, want 200", code)
+	}
+}
+
+func TestSendResetSurfacesDeliveryFailure(t *testing.T) {
+	env := apitest.New(t)
+	signUpVerified(t, env, api.Credentials{Username: "bear", Email: "bear@berkeley.edu", Password: "pw"})
+	env.Mailer.Err = errors.New("sendgrid responded with status 400")
+
+	res := env.Do(http.MethodPost, "/api/auth/sendreset", api.Credentials{Email: "bear@berkeley.edu"})
+	if res.Code != http.StatusInternalServerError {
+		t.Fatalf("sendreset when the email can't be delivered: got %d, want 500", res.Code)
+	}
+}
+
+func TestResetLinkFormats(t *testing.T) {
+	for format, want := range map[string]string{
+		"{base}/reset?token={token}": "https://mixtape.com/reset?token=",
+		"{base}/reset/{token}":       "https://mixtape.com/reset/",
+	} {
+		env := apitest.NewWithConfig(t, func(cfg *api.Config) {
+			cfg.FrontendBaseURL = "https://mixtape.com/"
+			cfg.ResetLinkFormat = format
+		})
+		signUpVerified(t, env, api.Credentials{Username: "bear", Email: "bear@berkeley.edu", Password: "pw"})
+		token := requestReset(t, env, "bear@berkeley.edu")
+		email, _ := env.Mailer.LastFrom("bear@berkeley.edu", "password-reset.html")
+		if link := email.Data["Link"]; link != want+token {
+			t.Fatalf("RESET_LINK_TEMPLATE=%s: got link %v, want %s", format, link, want+token)
+		}
+	}
+
+	cfg := api.LoadConfig()
+	cfg.ResetLinkFormat = "{base}/reset"
+	if err := cfg.Validate(); err == nil || !strings.Contains(err.Error(), "RESET_LINK_TEMPLATE") {
+		t.Fatalf("RESET_LINK_TEMPLATE without {token}: got %v, want it rejected", err)
+	}
+}
+
+func TestValidateResetToken(t *testing.T) {
+	clock := apitest.NewFakeClock(time.Now())
+	env := apitest.NewWithConfig(t, func(cfg *api.Config) {
+		cfg.Clock = clock
+		cfg.ResetTokenMode = "keep"
+	})
+	creds := api.Credentials{Username: "bear", Email: "bear@berkeley.edu", Password: "pw"}
+	signUpVerified(t, env, creds)
+	expiring := requestReset(t, env, creds.Email)
+	clock.Advance(30 * time.Minute)
+	token := requestReset(t, env, creds.Email)
+	clock.Advance(31 * time.Minute)
+
+	for _, check := range []struct {
+		step  string
+		token string
+		want  int
+	}{
+		{"valid token", token, http.StatusOK},
+		{"valid token checked again", token, http.StatusOK},
+		{"expired token", expiring, http.StatusGone},
+		{"unknown token", "r_unknown", http.StatusNotFound},
+		{"no token", "", http.StatusBadRequest},
+	} {
+		if code := resetTokenStatus(env, check.token); code != check.want {
+			t.Fatalf("validating a %s: got %d, want %d", check.step, code, check.want)
+		}
+	}
+
+	//validating didn't use the token up
+	res := env.Do(http.MethodPost, "/api/auth/resetpw", api.PasswordReset{Token: token, NewPassword: "new pw", ConfirmPassword: "new pw"})
+	expectSuccess(t, "resetpw after validating", res, http.StatusOK, "password reset")
+	if code := resetTokenStatus(env, token); code != http.StatusNotFound {
+		t.Fatalf("validating a used token: got %d, want 404", code)
+	}
+}
+
+func TestResetWithTokenAndConfirmation(t *testing.T) {
+	env := apitest.New(t)
+	creds := api.Credentials{Username: "bear", Email: "bear@berkeley.edu", Password: "pw"}
+	signUpVerified(t, env, creds)
+	token := requestReset(t, env, creds.Email)
+
+	res := env.Do(http.MethodPost, "/api/auth/resetpw", api.PasswordReset{Token: token, NewPassword: "new pw", ConfirmPassword: "new wp"})
+	if res.Code != http.StatusBadRequest || !strings.Contains(res.Body.String(), "passwords do not match") {
+		t.Fatalf("resetpw with a mismatched confirmation: got %d %s, want 400", res.Code, res.Body.String())
+	}
+	if code := resetTokenStatus(env, token); code != http.StatusOK {
+		t.Fatalf("validating the token after a mismatch: got %d, want it still usable", code)
+	}
+
+	//the token alone says whose password it is
+	res = env.Do(http.MethodPost, "/api/auth/resetpw", api.PasswordReset{Token: token, NewPassword: "new pw", ConfirmPassword: "new pw"})
+	expectSuccess(t, "resetpw with only the token", res, http.StatusOK, "password reset")
+	if res := env.Do(http.MethodPost, "/api/auth/signin", creds); res.Code != http.StatusUnauthorized {
+		t.Fatalf("signin with the old password: got %d, want 401", res.Code)
+	}
+	creds.Password = "new pw"
+	signIn(t, env, creds)
+}
+
+func TestResetForAnotherAccountLooksLikeInvalidToken(t *testing.T) {
+	env := apitest.NewWithConfig(t, func(cfg *api.Config) {
+		cfg.ResetRequiresEmail = true
+	})
+	bear := api.Credentials{Username: "bear", Email: "bear@berkeley.edu", Password: "pw"}
+	tree := api.Credentials{Username: "tree", Email: "tree@stanford.edu", Password: "pw"}
+	signUpVerified(t, env, bear)
+	signUpVerified(t, env, tree)
+	token := requestReset(t, env, bear.Email)
+
+	reset := func(token string, email string) (int, string) {
+		res := env.Do(http.MethodPost, "/api/auth/resetpw", api.PasswordReset{Token: token, Email: email, NewPassword: "new pw", ConfirmPassword: "new pw"})
+		var body api.ErrorResponse
+		json.NewDecoder(res.Body).Decode(&body)
+		return res.Code, body.Message
+	}
+	wrongAccountCode, wrongAccount := reset(token, tree.Email)
+	invalidTokenCode, invalidToken := reset("r_unknown", tree.Email)
+	if wrongAccountCode != http.StatusNotFound || wrongAccountCode != invalidTokenCode || wrongAccount != invalidToken {
+		t.Fatalf("bear's token with tree's email: got %d %q, want the same answer as an invalid token, %d %q", wrongAccountCode, wrongAccount, invalidTokenCode, invalidToken)
+	}
+
+	//the mismatch didn't use the token up
+	if code, body := reset(token, " Bear@Berkeley.edu "); code != http.StatusOK {
+		t.Fatalf("bear's token with bear's email: got %d %s", code, body)
+	}
+	if code, body := reset(token, bear.Email); code != invalidTokenCode || body != invalidToken {
+		t.Fatalf("a used token: got %d %q, want the invalid token answer", code, body)
+	}
+}
+
+func TestConcurrentResetsUseTokenOnce(t *testing.T) {
+	env := apitest.New(t)
+	creds := api.Credentials{Username: "bear", Email: "bear@berkeley.edu", Password: "pw"}
+	signUpVerified(t, env, creds)
+	token := requestReset(t, env, creds.Email)
+
+	codes := make(chan int, 5)
+	for i := 0; i < 5; i++ {
+		go func() {
+			codes <- env.Do(http.MethodPost, "/api/auth/resetpw", api.PasswordReset{Token: token, NewPassword: "new pw", ConfirmPassword: "new pw"}).Code
+		}()
+	}
+	succeeded := 0
+	for i := 0; i < 5; i++ {
+		switch code := <-codes; code {
+		case http.StatusOK:
+			succeeded++
+		case http.StatusNotFound:
+		default:
+			t.Fatalf("concurrent reset: got %d, want 200 or 404", code)
+		}
+	}
+	if succeeded != 1 {
+		t.Fatalf("five concurrent resets with one token: %d succeeded, want 1", succeeded)
+	}
+}
@@ -0,0 +1,119 @@
+package api
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+)
+
+var (
+	//debugErrors includes the detail of server-side failures in responses, for local development only
+	debugErrors = false
+)
+
+//SuccessResponse is the JSON body returned by handlers that succeed without other data to send back
+type SuccessResponse struct {
+	Status  string `json:"status"`
+	Message string `json:"message"`
+}
+
+//SignupResponse is the JSON body returned after a successful signup
+type SignupResponse struct {
+	SuccessResponse
+	UserID string `json:"userId"`
+}
+
+//SigninResponse is the JSON body returned after a successful signin, so clients know how long the session lasts
+type SigninResponse struct {
+	SuccessResponse
+	UserID string `json:"userId"`
+	TokenExpiry
+}
+
+//LogoutResponse is the JSON body returned by logout. ClearedCookies names the token cookies the request carried,
+//and SessionRevoked tells whether an active session was actually ended rather than the client having none.
+type LogoutResponse struct {
+	SuccessResponse
+	ClearedCookies []string `json:"clearedCookies"`
+	SessionRevoked bool     `json:"sessionRevoked"`
+}
+
+//RenewResponse is the JSON body returned after renewing a session, so clients can schedule the next renewal
+type RenewResponse struct {
+	SuccessResponse
+	TokenExpiry
+}
+
+//ErrorResponse is the JSON body returned when a request fails.
+//Hint optionally names the action the client can take to recover, e.g. "reactivate".
+//CorrelationID is the request ID from X-Request-ID, so a user reporting the error can quote what the logs show.
+type ErrorResponse struct {
+	Status        string `json:"status"`
+	Message       string `json:"message"`
+	Hint          string `json:"hint,omitempty"`
+	CorrelationID string `json:"correlationId,omitempty"`
+}
+
+//ConflictResponse is the JSON body of a signup that clashes with an existing account.
+//Fields names every field that is taken, so the client can point at each one.
+type ConflictResponse struct {
+	ErrorResponse
+	Fields []string `json:"fields"`
+}
+
+//writeSignupConflict answers a signup whose username, email or both are taken with a 409
+func writeSignupConflict(w http.ResponseWriter, r *http.Request, usernameTaken bool, emailTaken bool) {
+	response := ConflictResponse{ErrorResponse: ErrorResponse{Status: "error", CorrelationID: requestIDFromContext(r.Context())}}
+	switch {
+	case usernameTaken && emailTaken:
+		response.Message = "this username and email are taken"
+		response.Fields = []string{"username", "email"}
+	case usernameTaken:
+		response.Message = "this username is taken"
+		response.Fields = []string{"username"}
+	default:
+		response.Message = "this email is taken"
+		response.Fields = []string{"email"}
+	}
+	writeJSON(w, http.StatusConflict, response)
+}
+
+//writeJSON encodes body as JSON with the given status code
+func writeJSON(w http.ResponseWriter, statusCode int, body interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(statusCode)
+	err := json.NewEncoder(w).Encode(body)
+	if err != nil {
+		log.Print(err.Error())
+	}
+}
+
+//writeJSONSuccess writes a SuccessResponse with the given status code and message
+func writeJSONSuccess(w http.ResponseWriter, statusCode int, message string) {
+	writeJSON(w, statusCode, SuccessResponse{Status: "ok", Message: message})
+}
+
+//writeJSONError writes an ErrorResponse with the given status code and message, tagged with the request ID
+func writeJSONError(w http.ResponseWriter, r *http.Request, statusCode int, message string) {
+	writeJSON(w, statusCode, ErrorResponse{Status: "error", Message: message, CorrelationID: requestIDFromContext(r.Context())})
+}
+
+//internalErrorMessage logs a server-side failure with the request ID and returns what the client should see.
+//Outside of DEBUG_ERRORS the detail stays in the log and the client only gets the request ID to report.
+//Failures to reach the database count towards opening dbBreaker.
+func internalErrorMessage(r *http.Request, message string, err error) string {
+	if isDBUnavailable(err) {
+		dbBreaker.failure()
+	}
+	requestID := requestIDFromContext(r.Context())
+	log.Print(requestID + " " + message + ": " + err.Error())
+	if debugErrors {
+		return message + ": " + err.Error()
+	}
+	return "internal error, request ID " + requestID
+}
+
+//internalError writes a JSON 500 for a server-side failure, see internalErrorMessage
+func internalError(w http.ResponseWriter, r *http.Request, message string, err error) {
+	writeJSONError(w, r, http.StatusInternalServerError, internalErrorMessage(r, message, err))
+}
@@ -0,0 +1,149 @@
+package api_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/BearCloud/fa20-project-dev/backend/auth-service/api"
+	"github.com/BearCloud/fa20-project-dev/backend/auth-service/apitest"
+)
+
+//expectSuccess checks that res is a JSON success envelope with code and message
+func expectSuccess(t *testing.T, step string, res *httptest.ResponseRecorder, code int, message string) {
+	t.Helper()
+	if res.Code != code {
+		t.Fatalf("%s: got %d %s, want %d", step, res.Code, res.Body.String(), code)
+	}
+	if contentType := res.Header().Get("Content-Type"); contentType != "application/json" {
+		t.Fatalf("%s: got Content-Type %q, want application/json", step, contentType)
+	}
+	var body api.SuccessResponse
+	err := json.NewDecoder(res.Body).Decode(&body)
+	if err != nil || body.Status != "ok" || body.Message != message {
+		t.Fatalf("%s: got %+v (%v), want status ok and message %q", step, body, err, message)
+	}
+}
+
+func TestHappyPathsAnswerWithSuccessEnvelope(t *testing.T) {
+	env := apitest.New(t)
+	creds := api.Credentials{Username: "bear", Email: "bear@berkeley.edu", Password: "pw"}
+	env.Do(http.MethodPost, "/api/auth/signup", creds)
+	verification, _ := env.Mailer.LastFrom(creds.Email, "user-signup.html")
+
+	res := env.Do(http.MethodPost, "/api/auth/verify?token="+verification.Token(), nil)
+	expectSuccess(t, "verify", res, http.StatusOK, "email verified")
+
+	res = env.Do(http.MethodPost, "/api/auth/signin", creds)
+	access, refresh := apitest.Cookie(res, "access_token"), apitest.Cookie(res, "refresh_token")
+	expectSuccess(t, "signin", res, http.StatusOK, "signed in")
+
+	res = env.Do(http.MethodPost, "/api/auth/logout", nil, access, refresh)
+	expectSuccess(t, "logout", res, http.StatusOK, "logged out")
+
+	res = env.Do(http.MethodPost, "/api/auth/sendreset", api.Credentials{Email: creds.Email})
+	expectSuccess(t, "sendreset", res, http.StatusOK, "password reset email sent")
+
+	reset, _ := env.Mailer.LastFrom(creds.Email, "password-reset.html")
+	res = env.Do(http.MethodPost, "/api/auth/resetpw", api.PasswordReset{Token: reset.Token(), NewPassword: "new pw", ConfirmPassword: "new pw"})
+	expectSuccess(t, "resetpw", res, http.StatusOK, "password reset")
+}
+
+//failSignin makes the users table unreadable and signs in, so the api answers with a 500, and returns its body
+func failSignin(t *testing.T, env *apitest.Env) api.ErrorResponse {
+	t.Helper()
+	_, err := env.DB.Exec("DROP TABLE users;")
+	if err != nil {
+		t.Fatal(err)
+	}
+	req := env.Request(http.MethodPost, "/api/auth/signin", api.Credentials{Email: "bear@berkeley.edu", Password: "pw"})
+	req.Header.Set("X-Request-ID", "failed-signin")
+	res := env.Send(req)
+	if res.Code != http.StatusInternalServerError {
+		t.Fatalf("signin without a users table: got %d %s, want 500", res.Code, res.Body.String())
+	}
+	var body api.ErrorResponse
+	json.NewDecoder(res.Body).Decode(&body)
+	return body
+}
+
+func TestInternalErrorsHideDetail(t *testing.T) {
+	env := apitest.NewWithConfig(t, func(cfg *api.Config) {
+		cfg.DebugErrors = false
+	})
+	body := failSignin(t, env)
+	if body.Message != "internal error, request ID failed-signin" || body.CorrelationID != "failed-signin" {
+		t.Fatalf("500 without DEBUG_ERRORS: got %+v, want the generic message and the request ID", body)
+	}
+}
+
+func TestInternalErrorsDetailedForDebugging(t *testing.T) {
+	env := apitest.NewWithConfig(t, func(cfg *api.Config) {
+		cfg.DebugErrors = true
+	})
+	body := failSignin(t, env)
+	if !strings.Contains(body.Message, "no such table: users") || body.CorrelationID != "failed-signin" {
+		t.Fatalf("500 with DEBUG_ERRORS: got %+v, want the database error and the request ID", body)
+	}
+}
+
+func TestClientErrorsCarryCorrelationID(t *testing.T) {
+	env := apitest.NewWithConfig(t, func(cfg *api.Config) {
+		cfg.MaxLoginAttempts = 1
+	})
+	creds := api.Credentials{Username: "bear", Email: "bear@berkeley.edu", Password: "pw"}
+	signUpVerified(t, env, creds)
+	access, _ := signIn(t, env, creds)
+	env.Do(http.MethodPost, "/api/auth/signin", api.Credentials{Email: creds.Email, Password: "wrong"})
+
+	for _, check := range []struct {
+		step string
+		req  *http.Request
+		want int
+	}{
+		{"signup without a username", env.Request(http.MethodPost, "/api/auth/signup", api.Credentials{Email: "tree@stanford.edu", Password: "pw"}), http.StatusBadRequest},
+		{"signup with a taken username", env.Request(http.MethodPost, "/api/auth/signup", creds), http.StatusConflict},
+		{"signin during a lockout", env.Request(http.MethodPost, "/api/auth/signin", creds), http.StatusTooManyRequests},
+		{"unknown secondary email", env.Request(http.MethodDelete, "/api/auth/emails/tree@stanford.edu", nil, access), http.StatusNotFound},
+	} {
+		check.req.Header.Set("X-Request-ID", "client-error")
+		res := env.Send(check.req)
+		var body api.ErrorResponse
+		err := json.NewDecoder(res.Body).Decode(&body)
+		if res.Code != check.want || err != nil || body.Status != "error" || body.CorrelationID != "client-error" {
+			t.Errorf("%s: got %d %+v (%v), want %d with correlationId client-error", check.step, res.Code, body, err, check.want)
+		}
+	}
+}
+
+func TestSigninBody(t *testing.T) {
+	clock := apitest.NewFakeClock(time.Now())
+	env := apitest.NewWithConfig(t, func(cfg *api.Config) {
+		cfg.Clock = clock
+		cfg.AccessTokenTTL = 15 * time.Minute
+		cfg.RefreshTokenTTL = 24 * time.Hour
+	})
+	creds := api.Credentials{Username: "bear", Email: "bear@berkeley.edu", Password: "pw"}
+	signUpVerified(t, env, creds)
+
+	res := env.Do(http.MethodPost, "/api/auth/signin", creds)
+	if res.Code != http.StatusOK {
+		t.Fatalf("signin: got %d %s, want 200", res.Code, res.Body.String())
+	}
+	var body api.SigninResponse
+	json.NewDecoder(res.Body).Decode(&body)
+	var userID string
+	env.DB.QueryRow("SELECT userId FROM users WHERE email = ?;", creds.Email).Scan(&userID)
+	if body.Status != "ok" || body.UserID != userID {
+		t.Fatalf("signin: got %+v, want status ok and userId %q", body, userID)
+	}
+	if !body.AccessExpiresAt.Equal(clock.Now().Add(15 * time.Minute)) {
+		t.Fatalf("signin: got accessExpiresAt %s, want 15 minutes from now", body.AccessExpiresAt)
+	}
+	if !body.RefreshExpiresAt.Equal(clock.Now().Add(24 * time.Hour)) {
+		t.Fatalf("signin: got refreshExpiresAt %s, want a day from now", body.RefreshExpiresAt)
+	}
+}
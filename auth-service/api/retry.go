@@ -0,0 +1,57 @@
+package api
+
+import (
+	"database/sql/driver"
+	"math/rand"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-sql-driver/mysql"
+)
+
+var (
+	//dbMaxRetries is how many times an operation that hit a transient database error is retried
+	dbMaxRetries = 3
+	//dbRetryBaseDelay caps the wait before the first retry, the cap doubles with every retry
+	dbRetryBaseDelay = 50 * time.Millisecond
+
+	//jitter spreads retries out so instances that failed together don't retry together
+	jitter   = rand.New(rand.NewSource(time.Now().UnixNano()))
+	jitterMu sync.Mutex
+)
+
+//isTransientDBError reports whether err is worth retrying: deadlocks, lock timeouts and dropped connections.
+//SQLite, used by apitest, only reports a busy database through the error message.
+func isTransientDBError(err error) bool {
+	if err == driver.ErrBadConn || err == mysql.ErrInvalidConn {
+		return true
+	}
+	if mysqlErr, ok := err.(*mysql.MySQLError); ok {
+		//1213 is a deadlock, 1205 a lock wait timeout
+		return mysqlErr.Number == 1213 || mysqlErr.Number == 1205
+	}
+	if _, ok := err.(net.Error); ok {
+		return true
+	}
+	message := err.Error()
+	return strings.Contains(message, "connection reset") || strings.Contains(message, "database is locked")
+}
+
+//withRetry runs op and retries it on transient database errors with exponential backoff and full jitter.
+//Only wrap reads and writes that are safe to run more than once. An answer from the database closes dbBreaker.
+func withRetry(op func() error) error {
+	err := op()
+	for attempt := 0; attempt < dbMaxRetries && err != nil && isTransientDBError(err); attempt++ {
+		jitterMu.Lock()
+		delay := time.Duration(jitter.Int63n(int64(dbRetryBaseDelay<<uint(attempt)))) + 1
+		jitterMu.Unlock()
+		time.Sleep(delay)
+		err = op()
+	}
+	if err == nil || !isDBUnavailable(err) {
+		dbBreaker.success()
+	}
+	return err
+}
@@ -0,0 +1,47 @@
+package api
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/go-sql-driver/mysql"
+)
+
+func TestWithRetryRetriesTransientErrors(t *testing.T) {
+	for name, transient := range map[string]error{
+		"deadlock": &mysql.MySQLError{Number: 1213, Message: "Deadlock found when trying to get lock"},
+		"SQLite":   errors.New("database is locked"),
+	} {
+		calls := 0
+		err := withRetry(func() error {
+			calls++
+			if calls < 3 {
+				return transient
+			}
+			return nil
+		})
+		if err != nil || calls != 3 {
+			t.Fatalf("%s: two transient failures then success: got %v after %d calls, want nil after 3", name, err, calls)
+		}
+	}
+}
+
+func TestWithRetryGivesUp(t *testing.T) {
+	calls := 0
+	err := withRetry(func() error {
+		calls++
+		return errors.New("database is locked")
+	})
+	if err == nil || calls != dbMaxRetries+1 {
+		t.Fatalf("always failing: got %v after %d calls, want the error after %d", err, calls, dbMaxRetries+1)
+	}
+
+	calls = 0
+	withRetry(func() error {
+		calls++
+		return errors.New("no such table: users")
+	})
+	if calls != 1 {
+		t.Fatalf("permanent error: got %d calls, want 1", calls)
+	}
+}
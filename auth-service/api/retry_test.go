@@ -0,0 +1,55 @@
+package api_test
+
+import (
+	"errors"
+	"net/http"
+	"testing"
+
+	"github.com/BearCloud/fa20-project-dev/backend/auth-service/api"
+	"github.com/BearCloud/fa20-project-dev/backend/auth-service/apitest"
+)
+
+//flakyStore fails looking up accounts to sign in to with a busy database the first failures times
+type flakyStore struct {
+	api.SQLUserStore
+	failures int
+	calls    int
+}
+
+func (store *flakyStore) GetBySigninEmail(email string) (api.User, error) {
+	store.calls++
+	if store.calls <= store.failures {
+		return api.User{}, errors.New("database is locked")
+	}
+	return store.SQLUserStore.GetBySigninEmail(email)
+}
+
+func TestSigninRetriesTransientDBErrors(t *testing.T) {
+	env := apitest.NewWithConfig(t, func(cfg *api.Config) {
+		cfg.DBMaxRetries = 3
+	})
+	creds := api.Credentials{Username: "bear", Email: "bear@berkeley.edu", Password: "pw"}
+	signUpVerified(t, env, creds)
+
+	store := &flakyStore{failures: 2}
+	api.SetUserStore(store)
+	signIn(t, env, creds)
+	if store.calls != 3 {
+		t.Fatalf("signin with two transient failures: looked the account up %d times, want 3", store.calls)
+	}
+}
+
+func TestSigninGivesUpAfterMaxRetries(t *testing.T) {
+	env := apitest.NewWithConfig(t, func(cfg *api.Config) {
+		cfg.DBMaxRetries = 1
+	})
+	creds := api.Credentials{Username: "bear", Email: "bear@berkeley.edu", Password: "pw"}
+	signUpVerified(t, env, creds)
+
+	store := &flakyStore{failures: 2}
+	api.SetUserStore(store)
+	res := env.Do(http.MethodPost, "/api/auth/signin", creds)
+	if res.Code != http.StatusInternalServerError || store.calls != 2 {
+		t.Fatalf("signin with DB_MAX_RETRIES=1 and two transient failures: got %d after %d lookups, want 500 after 2", res.Code, store.calls)
+	}
+}
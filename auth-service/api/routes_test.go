@@ -0,0 +1,20 @@
+package api_test
+
+import (
+	"testing"
+
+	"github.com/BearCloud/fa20-project-dev/backend/auth-service/api"
+	"github.com/BearCloud/fa20-project-dev/backend/auth-service/apitest"
+	"github.com/gorilla/mux"
+)
+
+func TestRegisterRoutesWithoutDatabase(t *testing.T) {
+	env := apitest.New(t)
+	api.DB = nil
+	defer func() { api.DB = env.DB }()
+
+	err := api.RegisterRoutes(mux.NewRouter())
+	if err == nil {
+		t.Fatalf("RegisterRoutes without a database: got no error")
+	}
+}
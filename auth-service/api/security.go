@@ -0,0 +1,66 @@
+package api
+
+import (
+	"database/sql"
+	"net/http"
+	"time"
+)
+
+//SecurityResponse is the JSON body of GET /api/auth/security, a summary of how well the account is protected.
+//RecoveryCodesRemain tells whether any backup code is still unused.
+type SecurityResponse struct {
+	SuccessResponse
+	EmailVerified       bool       `json:"emailVerified"`
+	TwoFactorEnabled    bool       `json:"twoFactorEnabled"`
+	RecoveryCodesRemain bool       `json:"recoveryCodesRemain"`
+	ActiveSessions      int        `json:"activeSessions"`
+	LastSigninAt        *time.Time `json:"lastSigninAt,omitempty"`
+	PasswordChangedAt   *time.Time `json:"passwordChangedAt,omitempty"`
+}
+
+//recordSignin notes that userID just signed in with their password, for the security summary
+func recordSignin(userID string) error {
+	_, err := DB.Exec("UPDATE users SET lastSigninAt = ? WHERE userId = ?;", clock.Now(), userID)
+	return err
+}
+
+func securitySummary(w http.ResponseWriter, r *http.Request) {
+
+	if (*r).Method == "OPTIONS" {
+		return
+	}
+
+	claims, _ := claimsFromContext(r.Context())
+
+	var verified sql.NullBool
+	var lastSigninAt, passwordChangedAt, createdAt, twoFactorEnabledAt sql.NullTime
+	var backupCodes int
+	response := SecurityResponse{SuccessResponse: SuccessResponse{Status: "ok", Message: "security summary retrieved"}}
+	err := withRetry(func() error {
+		return DB.QueryRow("SELECT verified, lastSigninAt, passwordChangedAt, createdAt, twoFactorEnabledAt, (SELECT COUNT(*) FROM sessions WHERE sessions.userId = users.userId AND revokedAt IS NULL AND expiresAt > ?), (SELECT COUNT(*) FROM backup_codes WHERE backup_codes.userId = users.userId AND usedAt IS NULL) FROM users WHERE userId = ? AND deletedAt IS NULL;", clock.Now(), claims.UserID).
+			Scan(&verified, &lastSigninAt, &passwordChangedAt, &createdAt, &twoFactorEnabledAt, &response.ActiveSessions, &backupCodes)
+	})
+	if err != nil {
+		if err == sql.ErrNoRows {
+			writeJSONError(w, r, http.StatusNotFound, "this account no longer exists")
+		} else {
+			internalError(w, r, "error retrieving security summary", err)
+		}
+		return
+	}
+	response.EmailVerified = verified.Bool
+	response.TwoFactorEnabled = twoFactorEnabledAt.Valid
+	response.RecoveryCodesRemain = twoFactorEnabledAt.Valid && backupCodes > 0
+	if lastSigninAt.Valid {
+		response.LastSigninAt = &lastSigninAt.Time
+	}
+	//accounts that never changed their password have had it since they were created
+	if !passwordChangedAt.Valid {
+		passwordChangedAt = createdAt
+	}
+	if passwordChangedAt.Valid {
+		response.PasswordChangedAt = &passwordChangedAt.Time
+	}
+
+	writeJSON(w, http.StatusOK, response)
+}
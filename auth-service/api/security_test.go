@@ -0,0 +1,64 @@
+package api_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/BearCloud/fa20-project-dev/backend/auth-service/api"
+	"github.com/BearCloud/fa20-project-dev/backend/auth-service/apitest"
+)
+
+//securitySummary fetches the security summary of the signed in user
+func securitySummary(t *testing.T, env *apitest.Env, access *http.Cookie) api.SecurityResponse {
+	t.Helper()
+	res := env.Do(http.MethodGet, "/api/auth/security", nil, access)
+	if res.Code != http.StatusOK {
+		t.Fatalf("security summary: got %d %s", res.Code, res.Body.String())
+	}
+	var summary api.SecurityResponse
+	json.NewDecoder(res.Body).Decode(&summary)
+	return summary
+}
+
+func TestSecuritySummaryReflectsAccount(t *testing.T) {
+	clock := apitest.NewFakeClock(time.Date(2020, 10, 1, 9, 0, 0, 0, time.UTC))
+	env := apitest.NewWithClock(t, clock)
+	creds := api.Credentials{Username: "bear", Email: "bear@berkeley.edu", Password: "pw"}
+	createdAt := clock.Now()
+	signUpVerified(t, env, creds)
+
+	clock.Advance(time.Hour)
+	_, refresh := signIn(t, env, creds)
+	clock.Advance(time.Hour)
+	access, _ := signIn(t, env, creds)
+
+	//signup signs the new account in too
+	summary := securitySummary(t, env, access)
+	if !summary.EmailVerified || summary.TwoFactorEnabled || summary.RecoveryCodesRemain || summary.ActiveSessions != 3 {
+		t.Fatalf("after signup and two signins: got %+v, want verified without 2fa and three sessions", summary)
+	}
+	if summary.LastSigninAt == nil || !summary.LastSigninAt.Equal(clock.Now()) {
+		t.Fatalf("after signup and two signins: got lastSigninAt %v, want %s", summary.LastSigninAt, clock.Now())
+	}
+	if summary.PasswordChangedAt == nil || !summary.PasswordChangedAt.Equal(createdAt) {
+		t.Fatalf("password never changed: got passwordChangedAt %v, want the signup time %s", summary.PasswordChangedAt, createdAt)
+	}
+
+	enableTwoFactor(t, env, clock, access)
+	env.Do(http.MethodPost, "/api/auth/logout", nil, refresh)
+	summary = securitySummary(t, env, access)
+	if !summary.TwoFactorEnabled || !summary.RecoveryCodesRemain || summary.ActiveSessions != 2 {
+		t.Fatalf("after enabling 2fa and logging one session out: got %+v, want 2fa with backup codes and two sessions", summary)
+	}
+
+	clock.Advance(time.Hour)
+	token := requestReset(t, env, creds.Email)
+	res := env.Do(http.MethodPost, "/api/auth/resetpw", api.PasswordReset{Token: token, NewPassword: "new pw", ConfirmPassword: "new pw"})
+	expectSuccess(t, "resetpw", res, http.StatusOK, "password reset")
+	summary = securitySummary(t, env, access)
+	if summary.PasswordChangedAt == nil || !summary.PasswordChangedAt.Equal(clock.Now()) {
+		t.Fatalf("after a password reset: got passwordChangedAt %v, want %s", summary.PasswordChangedAt, clock.Now())
+	}
+}
@@ -0,0 +1,86 @@
+package api
+
+import (
+	"database/sql"
+	"errors"
+	"log"
+	"os"
+
+	"github.com/google/uuid"
+)
+
+const (
+	//roleAdmin is the role of accounts with administrative access
+	roleAdmin = "admin"
+	//defaultAdminUsername is used for the seeded admin when SEED_ADMIN_USERNAME is unset
+	defaultAdminUsername = "admin"
+)
+
+//SeedAdmin creates a verified admin account from SEED_ADMIN_EMAIL and SEED_ADMIN_PASSWORD,
+//or promotes the existing account with that email. It does nothing if the variables are unset
+//or the account is already an admin, so it is safe to run on every startup.
+func SeedAdmin() error {
+	email := os.Getenv("SEED_ADMIN_EMAIL")
+	password := os.Getenv("SEED_ADMIN_PASSWORD")
+	if email == "" && password == "" {
+		return nil
+	}
+	if email == "" || password == "" {
+		return errors.New("SEED_ADMIN_EMAIL and SEED_ADMIN_PASSWORD must be set together")
+	}
+
+	var userID, role string
+	err := DB.QueryRow("SELECT userId, role FROM users WHERE email = ?"+preferVerified+";", email).Scan(&userID, &role)
+	if err != nil && err != sql.ErrNoRows {
+		return err
+	}
+
+	//promote the existing account without touching its password
+	if err == nil {
+		if role == roleAdmin {
+			return nil
+		}
+		err = seedAdminAccount(email, userID, "UPDATE users SET role = ?, verified = ? WHERE userId = ?;", roleAdmin, 1, userID)
+		if err != nil {
+			return err
+		}
+		log.Println("promoted " + email + " to admin")
+		return nil
+	}
+
+	username := os.Getenv("SEED_ADMIN_USERNAME")
+	if username == "" {
+		username = defaultAdminUsername
+	}
+	hashed, err := hashPassword(password)
+	if err != nil {
+		return err
+	}
+	userID = uuid.New().String()
+	err = seedAdminAccount(email, userID, "INSERT INTO users (username, email, hashedPassword, verified, userId, role, createdAt) VALUES (?, ?, ?, ?, ?, ?, ?);", username, email, hashed, 1, userID, roleAdmin, clock.Now())
+	if err != nil {
+		return err
+	}
+	log.Println("seeded admin account " + email)
+	return nil
+}
+
+//seedAdminAccount runs query, which writes the verified admin account userID, and claims its email in
+//verified_emails in the same transaction
+func seedAdminAccount(email string, userID string, query string, args ...interface{}) error {
+	tx, err := DB.Begin()
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec(query, args...)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	err = claimEmail(tx, email, userID)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
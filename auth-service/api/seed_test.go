@@ -0,0 +1,76 @@
+package api_test
+
+import (
+	"net/http"
+	"os"
+	"testing"
+
+	"github.com/BearCloud/fa20-project-dev/backend/auth-service/api"
+	"github.com/BearCloud/fa20-project-dev/backend/auth-service/apitest"
+)
+
+//seedAdminFrom runs SeedAdmin with SEED_ADMIN_EMAIL and SEED_ADMIN_PASSWORD set to email and password
+func seedAdminFrom(email string, password string) error {
+	os.Setenv("SEED_ADMIN_EMAIL", email)
+	os.Setenv("SEED_ADMIN_PASSWORD", password)
+	defer os.Unsetenv("SEED_ADMIN_EMAIL")
+	defer os.Unsetenv("SEED_ADMIN_PASSWORD")
+	return api.SeedAdmin()
+}
+
+func TestSeedAdminCreatesVerifiedAdmin(t *testing.T) {
+	env := apitest.New(t)
+	for i := 0; i < 2; i++ {
+		err := seedAdminFrom("oski@berkeley.edu", "go bears")
+		if err != nil {
+			t.Fatalf("seeding run %d: %v", i+1, err)
+		}
+	}
+	if count := countRows(t, env, "users", "email", "oski@berkeley.edu"); count != 1 {
+		t.Fatalf("seeding twice: got %d accounts, want 1", count)
+	}
+	var role string
+	var verified bool
+	env.DB.QueryRow("SELECT role, verified FROM users WHERE email = ?;", "oski@berkeley.edu").Scan(&role, &verified)
+	if role != "admin" || !verified {
+		t.Fatalf("seeded account: got role %q and verified %v, want a verified admin", role, verified)
+	}
+
+	access, _ := signIn(t, env, api.Credentials{Email: "oski@berkeley.edu", Password: "go bears"})
+	res := env.Do(http.MethodGet, "/api/auth/admin/users", nil, access)
+	if res.Code != http.StatusOK {
+		t.Fatalf("admin endpoint as the seeded admin: got %d %s", res.Code, res.Body.String())
+	}
+	res = env.Do(http.MethodPost, "/api/auth/signup", api.Credentials{Username: "oski2", Email: "oski@berkeley.edu", Password: "pw"})
+	if res.Code != http.StatusConflict {
+		t.Fatalf("signup with the seeded admin's email: got %d, want 409", res.Code)
+	}
+}
+
+func TestSeedAdminPromotesExistingAccount(t *testing.T) {
+	env := apitest.New(t)
+	creds := api.Credentials{Username: "oski", Email: "oski@berkeley.edu", Password: "pw"}
+	signUpVerified(t, env, creds)
+
+	err := seedAdminFrom(creds.Email, "another password")
+	if err != nil {
+		t.Fatalf("seeding over an existing account: %v", err)
+	}
+	var role string
+	env.DB.QueryRow("SELECT role FROM users WHERE email = ?;", creds.Email).Scan(&role)
+	if role != "admin" {
+		t.Fatalf("existing account: got role %q, want admin", role)
+	}
+	//the password isn't touched
+	signIn(t, env, creds)
+}
+
+func TestSeedAdminNeedsBothVariables(t *testing.T) {
+	apitest.New(t)
+	if err := seedAdminFrom("oski@berkeley.edu", ""); err == nil {
+		t.Fatalf("SEED_ADMIN_EMAIL without SEED_ADMIN_PASSWORD: got no error")
+	}
+	if err := seedAdminFrom("", ""); err != nil {
+		t.Fatalf("neither variable set: got %v, want nothing to do", err)
+	}
+}
@@ -2,8 +2,13 @@ package api
 
 import (
 	"bytes"
+	"context"
+	"fmt"
 	"html/template"
-	"os"
+	"log"
+	"net/url"
+	"strings"
+	"time"
 
 	"github.com/sendgrid/sendgrid-go"
 	"github.com/sendgrid/sendgrid-go/helpers/mail"
@@ -14,40 +19,151 @@ var (
 	sendgridClient *sendgrid.Client
 	defaultSender  = mail.NewEmail("BearChat Dev", "kkhus5@berkeley.edu")
 	defaultScheme  = "http"
+	//sendgridBaseURL is the SendGrid API host, pointed elsewhere to use a mock server or a regional endpoint
+	sendgridBaseURL = "https://api.sendgrid.com"
+	//emailSendTimeout bounds how long a single SendGrid call may take
+	emailSendTimeout = 10 * time.Second
+	//welcomeEmailEnabled sends a welcome email when an account is first verified
+	welcomeEmailEnabled = true
+	//frontendBaseURL is where the links in emails point to
+	frontendBaseURL = "https://bearchat.com"
+	//resetLinkTemplate shapes the link in password reset emails, {base} is frontendBaseURL and {token} the reset token
+	resetLinkTemplate = "{base}/reset?token={token}"
+	//brandName is the product name shown in emails, so one codebase can serve white-labeled deployments
+	brandName = "BearChat"
+	//supportEmail is the address emails tell users to contact, emails leave the line out when it's empty
+	supportEmail = ""
+	//brandLogoURL is the image at the top of every email
+	brandLogoURL = "https://seeklogo.com/images/U/university-of-california-berkeley-athletic-logo-815CB73082-seeklogo.com.png"
 )
 
-//InitMailer initalizes the sendgrid client
+//templateData returns data with the branding every template can use added. Keys already in data win.
+func templateData(data map[string]interface{}) map[string]interface{} {
+	merged := map[string]interface{}{
+		"BrandName":    brandName,
+		"SupportEmail": supportEmail,
+		"LogoURL":      brandLogoURL,
+	}
+	for key, value := range data {
+		merged[key] = value
+	}
+	return merged
+}
+
+//resetLink builds the password reset link for token from resetLinkTemplate
+func resetLink(token string) string {
+	return strings.NewReplacer("{base}", strings.TrimSuffix(frontendBaseURL, "/"), "{token}", url.PathEscape(token)).Replace(resetLinkTemplate)
+}
+
+//Mailer renders an email template and delivers it to a recipient, from fromName at the configured sender address
+type Mailer interface {
+	SendEmail(ctx context.Context, recipient string, fromName string, subject string, templatePath string, data map[string]interface{}) error
+}
+
+//mailer delivers every email the handlers send, SendGrid unless replaced with SetMailer
+var mailer Mailer = sendgridMailer{}
+
+//SetMailer replaces the mailer used by the handlers, e.g. with a mock in tests
+func SetMailer(m Mailer) {
+	mailer = m
+}
+
+//InitMailer initalizes the sendgrid client. Without a SendGrid key, which InitConfig only allows
+//with APP_ENV="dev", emails are logged instead of sent.
 func InitMailer() {
-	// load environmental variables
-	sendgridKey = os.Getenv("SENDGRID_KEY")
-	sendgridClient = sendgrid.NewSendClient(sendgridKey)
+	if sendgridKey == "" {
+		log.Println("SENDGRID_KEY is not set, emails will be logged instead of sent")
+		mailer = logMailer{}
+		return
+	}
+	// sendgridKey and sendgridBaseURL are loaded from the environment by InitConfig
+	request := sendgrid.GetRequest(sendgridKey, "/v3/mail/send", strings.TrimSuffix(sendgridBaseURL, "/"))
+	request.Method = "POST"
+	sendgridClient = &sendgrid.Client{Request: request}
 }
 
-//SendEmail sends an email to the recipient with the specified subject
-func SendEmail(recipient string, subject string, templatePath string, data map[string]interface{}) error {
+//SendEmail sends the email templatePath to the recipient using the configured mailer, with the subject and
+//sender name registered for it in emailTemplates. The branding from templateData is merged into data first.
+func SendEmail(ctx context.Context, recipient string, templatePath string, data map[string]interface{}) error {
+	t, err := lookupEmailTemplate(templatePath)
+	if err != nil {
+		return err
+	}
+	subject, fromName := t.envelope()
+	return mailer.SendEmail(ctx, recipient, fromName, subject, templatePath, templateData(data))
+}
+
+//sendWelcomeEmail emails the user with the verifiedToken hash in the background so verify doesn't wait on SendGrid
+func sendWelcomeEmail(verifiedTokenHash string) {
+	user, err := userStore.GetByVerifyToken(verifiedTokenHash)
+	email, username := user.Email, user.Username
+	if err != nil {
+		log.Print("error looking up welcome email recipient: " + err.Error())
+		return
+	}
+	go func() {
+		err := SendEmail(context.Background(), email, "welcome.html", map[string]interface{}{"Username": username})
+		if err != nil {
+			log.Print("error sending welcome email: " + err.Error())
+		}
+	}()
+}
+
+//renderEmail executes the email template templatePath with data
+func renderEmail(templatePath string, data map[string]interface{}) (string, error) {
 	// Parse template file and execute with data.
 	var html bytes.Buffer
 	tmpl, err := template.ParseFiles("./api/templates/" + templatePath)
 	if err != nil {
-		return err
+		return "", err
 	}
 	err = tmpl.Execute(&html, data)
+	if err != nil {
+		return "", err
+	}
+	return html.String(), nil
+}
+
+//logMailer writes emails to the log instead of sending them, for local development without a SendGrid key
+type logMailer struct{}
+
+//SendEmail renders the template so broken templates still fail, then logs the email
+func (logMailer) SendEmail(ctx context.Context, recipient string, fromName string, subject string, templatePath string, data map[string]interface{}) error {
+	html, err := renderEmail(templatePath, data)
 	if err != nil {
 		return err
 	}
+	log.Printf("not sending email to %s from %q, subject %q:\n%s", recipient, fromName, subject, html)
+	return nil
+}
 
-	//turn our html page buffer into a string
-	plainTextContent := html.String()
+//sendgridMailer sends emails through the SendGrid API
+type sendgridMailer struct{}
+
+//SendEmail renders the template and sends it with SendGrid.
+//The SendGrid call is abandoned when ctx is canceled or emailSendTimeout passes, whichever comes first.
+func (sendgridMailer) SendEmail(ctx context.Context, recipient string, fromName string, subject string, templatePath string, data map[string]interface{}) error {
+	html, err := renderEmail(templatePath, data)
+	if err != nil {
+		return err
+	}
 
 	recipientEmail := mail.NewEmail("recipient", recipient)
 
 	// Construct and send email via Sendgrid.
-	message := mail.NewSingleEmail(defaultSender, subject, recipientEmail, plainTextContent, html.String())
+	message := mail.NewSingleEmail(mail.NewEmail(fromName, defaultSender.Address), subject, recipientEmail, html, html)
 
-	_, err = sendgridClient.Send(message)
+	ctx, cancel := context.WithTimeout(ctx, emailSendTimeout)
+	defer cancel()
+	response, err := sendgridClient.SendWithContext(ctx, message)
 	if err != nil {
 		return err
 	}
 
+	//SendGrid reports rejected messages through the status code, not err
+	if response.StatusCode < 200 || response.StatusCode > 299 {
+		return fmt.Errorf("sendgrid responded with status %d: %s", response.StatusCode, response.Body)
+	}
+
 	return nil
 }
\ No newline at end of file
@@ -0,0 +1,204 @@
+package api
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+//useSendgridServer points the SendGrid client at a server answering with handler for the rest of the test and
+//returns its URL. Templates are read relative to the service directory, so the test runs from there.
+func useSendgridServer(t *testing.T, handler http.HandlerFunc) string {
+	server := httptest.NewServer(handler)
+	savedKey, savedURL, savedClient, savedMailer := sendgridKey, sendgridBaseURL, sendgridClient, mailer
+	sendgridKey, sendgridBaseURL = "test-key", server.URL
+	InitMailer()
+
+	dir, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = os.Chdir("..")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		os.Chdir(dir)
+		server.Close()
+		sendgridKey, sendgridBaseURL, sendgridClient, mailer = savedKey, savedURL, savedClient, savedMailer
+	})
+	return server.URL
+}
+
+func TestSendgridErrorStatus(t *testing.T) {
+	useSendgridServer(t, func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(`{"errors":[{"message":"The from address does not match a verified Sender Identity"}]}`))
+	})
+
+	err := sendgridMailer{}.SendEmail(context.Background(), "bear@berkeley.edu", "CalChat", "Welcome", "welcome.html", map[string]interface{}{"Username": "bear"})
+	if err == nil || !strings.Contains(err.Error(), "400") {
+		t.Fatalf("SendGrid answering 400: got %v, want an error with the status", err)
+	}
+}
+
+func TestSendgridAccepted(t *testing.T) {
+	var path string
+	useSendgridServer(t, func(w http.ResponseWriter, r *http.Request) {
+		path = r.URL.Path
+		w.WriteHeader(http.StatusAccepted)
+	})
+
+	err := sendgridMailer{}.SendEmail(context.Background(), "bear@berkeley.edu", "CalChat", "Welcome", "welcome.html", map[string]interface{}{"Username": "bear"})
+	if err != nil {
+		t.Fatalf("SendGrid answering 202: got %v", err)
+	}
+	if path != "/v3/mail/send" {
+		t.Fatalf("SendGrid request went to %q, want /v3/mail/send", path)
+	}
+}
+
+func TestSendgridCallGivesUp(t *testing.T) {
+	release := make(chan struct{})
+	useSendgridServer(t, func(w http.ResponseWriter, r *http.Request) {
+		<-release
+	})
+	defer close(release)
+	savedTimeout := emailSendTimeout
+	emailSendTimeout = 20 * time.Millisecond
+	defer func() { emailSendTimeout = savedTimeout }()
+
+	started := time.Now()
+	err := sendgridMailer{}.SendEmail(context.Background(), "bear@berkeley.edu", "CalChat", "Welcome", "welcome.html", map[string]interface{}{"Username": "bear"})
+	if err == nil {
+		t.Fatalf("slow SendGrid past SENDGRID_TIMEOUT: got no error")
+	}
+
+	//canceling the caller's context, as a timed out request does, stops the call too
+	emailSendTimeout = time.Minute
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	err = sendgridMailer{}.SendEmail(ctx, "bear@berkeley.edu", "CalChat", "Welcome", "welcome.html", map[string]interface{}{"Username": "bear"})
+	if err == nil {
+		t.Fatalf("slow SendGrid with a canceled context: got no error")
+	}
+	if elapsed := time.Since(started); elapsed > 2*time.Second {
+		t.Fatalf("slow SendGrid calls took %s to give up", elapsed)
+	}
+}
+
+func TestSendgridConfiguredFromEnvironment(t *testing.T) {
+	var authorization, body string
+	baseURL := useSendgridServer(t, func(w http.ResponseWriter, r *http.Request) {
+		authorization = r.Header.Get("Authorization")
+		raw, _ := ioutil.ReadAll(r.Body)
+		body = string(raw)
+		w.WriteHeader(http.StatusAccepted)
+	})
+	savedTimeout := emailSendTimeout
+	defer func() { emailSendTimeout = savedTimeout }()
+	os.Setenv("SENDGRID_BASE_URL", baseURL+"/")
+	os.Setenv("SENDGRID_TIMEOUT", "2s")
+	defer os.Unsetenv("SENDGRID_BASE_URL")
+	defer os.Unsetenv("SENDGRID_TIMEOUT")
+
+	cfg := LoadConfig()
+	cfg.JWTSecret = "sendgrid-test-secret"
+	cfg.SendGridKey = "configured-key"
+	err := ApplyConfig(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if emailSendTimeout != 2*time.Second {
+		t.Fatalf("SENDGRID_TIMEOUT=2s: got a timeout of %s", emailSendTimeout)
+	}
+	InitMailer()
+	mailer = sendgridMailer{}
+
+	err = SendEmail(context.Background(), "bear@berkeley.edu", "welcome.html", map[string]interface{}{"Username": "bear"})
+	if err != nil {
+		t.Fatalf("sending through the configured SendGrid: %v", err)
+	}
+	if authorization != "Bearer configured-key" || !strings.Contains(body, "bear@berkeley.edu") {
+		t.Fatalf("SendGrid got Authorization %q and body %s, want the configured key and the recipient", authorization, body)
+	}
+}
+
+func TestSendgridKeyRequiredOutsideDev(t *testing.T) {
+	cfg := LoadConfig()
+	cfg.JWTSecret = "sendgrid-test-secret"
+	cfg.SendGridKey = ""
+	cfg.AppEnv = appEnvProduction
+	err := cfg.Validate()
+	if err == nil || !strings.Contains(err.Error(), "SENDGRID_KEY") {
+		t.Fatalf("no SENDGRID_KEY in production: got %v, want it required", err)
+	}
+	cfg.AppEnv = appEnvDev
+	err = cfg.Validate()
+	if err != nil {
+		t.Fatalf("no SENDGRID_KEY in dev: got %v", err)
+	}
+}
+
+func TestMissingSendgridKeyLogsEmails(t *testing.T) {
+	useSendgridServer(t, func(w http.ResponseWriter, r *http.Request) {
+		t.Errorf("an email was sent to SendGrid without a key")
+	})
+	sendgridKey = ""
+	InitMailer()
+
+	if _, ok := mailer.(logMailer); !ok {
+		t.Fatalf("without a SendGrid key: got mailer %T, want the log mailer", mailer)
+	}
+	err := SendEmail(context.Background(), "bear@berkeley.edu", "welcome.html", map[string]interface{}{"Username": "bear"})
+	if err != nil {
+		t.Fatalf("logging an email: %v", err)
+	}
+}
+
+func TestBrandInEveryTemplate(t *testing.T) {
+	var body string
+	useSendgridServer(t, func(w http.ResponseWriter, r *http.Request) {
+		raw, _ := ioutil.ReadAll(r.Body)
+		body = string(raw)
+		w.WriteHeader(http.StatusAccepted)
+	})
+	savedBrand, savedSupport, savedLogo := brandName, supportEmail, brandLogoURL
+	defer func() { brandName, supportEmail, brandLogoURL = savedBrand, savedSupport, savedLogo }()
+	brandName, supportEmail, brandLogoURL = "Mixtape", "help@mixtape.com", "https://mixtape.com/logo.png"
+
+	for _, template := range emailTemplates {
+		html, err := renderEmail(template.path, templateData(map[string]interface{}{"Username": "bear"}))
+		if err != nil {
+			t.Fatalf("rendering %s: %v", template.path, err)
+		}
+		for _, want := range []string{"Mixtape", "help@mixtape.com", "https://mixtape.com/logo.png"} {
+			if !strings.Contains(html, want) {
+				t.Errorf("%s doesn't show %s", template.path, want)
+			}
+		}
+		if strings.Contains(html, "BearChat") {
+			t.Errorf("%s still says BearChat", template.path)
+		}
+	}
+
+	err := SendEmail(context.Background(), "bear@berkeley.edu", "welcome.html", map[string]interface{}{"Username": "bear"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(body, "Welcome to Mixtape") {
+		t.Fatalf("welcome email sent with body %s, want the subject to name the brand", body)
+	}
+}
+
+func TestTemplateDataKeepsCallerValues(t *testing.T) {
+	data := templateData(map[string]interface{}{"BrandName": "Mixtape Duets", "Username": "bear"})
+	if data["BrandName"] != "Mixtape Duets" || data["Username"] != "bear" || data["LogoURL"] != brandLogoURL {
+		t.Fatalf("templateData: got %v, want the caller's values with the branding added", data)
+	}
+}
@@ -0,0 +1,184 @@
+package api
+
+import (
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+var (
+	//maxSessionsPerUser caps how many refresh-token sessions a user can hold at once, 0 means unlimited
+	maxSessionsPerUser = 0
+)
+
+//startSession records a new refresh-token session for userID, started from ipAddress on device, and returns its jti.
+//If the user would then hold more than maxSessionsPerUser sessions, the oldest ones are revoked.
+func startSession(userID string, expiresAt time.Time, ipAddress string, device string) (string, error) {
+	jti := uuid.New().String()
+	_, err := DB.Exec("INSERT INTO sessions (jti, userId, createdAt, expiresAt, ipAddress, device) VALUES (?, ?, ?, ?, ?, ?);", jti, userID, clock.Now(), expiresAt, ipAddress, device)
+	if err != nil {
+		return "", err
+	}
+
+	if maxSessionsPerUser > 0 {
+		err = evictOldestSessions(userID, maxSessionsPerUser)
+		if err != nil {
+			return "", err
+		}
+	}
+	return jti, nil
+}
+
+//evictOldestSessions revokes all but the newest keep active sessions of userID
+func evictOldestSessions(userID string, keep int) error {
+	rows, err := DB.Query("SELECT jti FROM sessions WHERE userId = ? AND revokedAt IS NULL AND expiresAt > ? ORDER BY createdAt DESC;", userID, clock.Now())
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	var evict []string
+	for i := 0; rows.Next(); i++ {
+		var jti string
+		err = rows.Scan(&jti)
+		if err != nil {
+			return err
+		}
+		if i >= keep {
+			evict = append(evict, jti)
+		}
+	}
+	err = rows.Err()
+	if err != nil {
+		return err
+	}
+
+	for _, jti := range evict {
+		err = revokeSession(jti)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+//revokeSession denylists the refresh token with jti so it can no longer be used
+func revokeSession(jti string) error {
+	_, err := endSession(jti)
+	return err
+}
+
+//endSession revokes the session jti like revokeSession and reports whether it was still active
+func endSession(jti string) (bool, error) {
+	result, err := DB.Exec("UPDATE sessions SET revokedAt = ? WHERE jti = ? AND revokedAt IS NULL;", clock.Now(), jti)
+	if err != nil {
+		return false, err
+	}
+	revoked, err := result.RowsAffected()
+	return err == nil && revoked > 0, nil
+}
+
+//revokeAllSessions denylists every active refresh token of userID
+func revokeAllSessions(userID string) error {
+	_, err := DB.Exec("UPDATE sessions SET revokedAt = ? WHERE userId = ? AND revokedAt IS NULL;", clock.Now(), userID)
+	return err
+}
+
+//errSessionRevoked is returned when a refresh token's session was revoked, rotated or never existed
+var errSessionRevoked = errors.New("this session has been revoked")
+
+//consumeSession revokes the active session jti of userID, failing with errSessionRevoked if it isn't active.
+//Revoking with a conditional update means two concurrent renewals can't both use the same refresh token.
+func consumeSession(jti string, userID string) error {
+	result, err := DB.Exec("UPDATE sessions SET revokedAt = ? WHERE jti = ? AND userId = ? AND revokedAt IS NULL AND expiresAt > ?;", clock.Now(), jti, userID, clock.Now())
+	if err != nil {
+		return err
+	}
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if affected == 0 {
+		return errSessionRevoked
+	}
+	return nil
+}
+
+func renewSession(w http.ResponseWriter, r *http.Request) {
+
+	if (*r).Method == "OPTIONS" {
+		return
+	}
+
+	cookie, err := r.Cookie(refreshCookieName())
+	if err != nil || cookie.Value == "" {
+		writeJSONError(w, r, http.StatusUnauthorized, "missing refresh token")
+		return
+	}
+
+	claims, err := getClaims(cookie.Value)
+	if err != nil || claims.Subject != "refresh" || claims.Id == "" {
+		writeJSONError(w, r, http.StatusUnauthorized, "invalid refresh token")
+		return
+	}
+
+	err = checkTokenVersion(claims)
+	if err == errTokenVersion {
+		writeJSONError(w, r, http.StatusUnauthorized, err.Error())
+		return
+	}
+	if err != nil {
+		internalError(w, r, "error checking token version", err)
+		return
+	}
+
+	//Rotate the refresh token: the old session is revoked before the new one is issued
+	err = consumeSession(claims.Id, claims.UserID)
+	if err != nil {
+		if err == errSessionRevoked {
+			writeJSONError(w, r, http.StatusUnauthorized, err.Error())
+		} else {
+			internalError(w, r, "error renewing session", err)
+		}
+		return
+	}
+
+	//Renewing is not re-entering the password, so the original auth time and methods carry over, as does remember me
+	expiry, err := issueTokens(w, r, claims.UserID, time.Unix(claims.AuthTime, 0), claims.AMR, claims.RememberMe)
+	if err != nil {
+		tokenError(w, r, err)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, RenewResponse{
+		SuccessResponse: SuccessResponse{Status: "ok", Message: "session renewed"},
+		TokenExpiry:     expiry,
+	})
+}
+
+//logoutAll signs the user out of every device by bumping their tokenVersion, which every access and refresh token
+//issued so far carries, and revoking their sessions. The cookies of the device asking are cleared too.
+func logoutAll(w http.ResponseWriter, r *http.Request) {
+
+	if (*r).Method == "OPTIONS" {
+		return
+	}
+
+	claims, _ := claimsFromContext(r.Context())
+
+	_, err := DB.Exec("UPDATE users SET tokenVersion = tokenVersion + 1 WHERE userId = ?;", claims.UserID)
+	if err != nil {
+		internalError(w, r, "error signing out of all devices", err)
+		return
+	}
+	err = revokeAllSessions(claims.UserID)
+	if err != nil {
+		internalError(w, r, "error revoking sessions", err)
+		return
+	}
+	clearTokenCookies(w)
+
+	writeJSONSuccess(w, http.StatusOK, "logged out of all devices")
+}
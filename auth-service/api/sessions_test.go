@@ -0,0 +1,266 @@
+package api_test
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/BearCloud/fa20-project-dev/backend/auth-service/api"
+	"github.com/BearCloud/fa20-project-dev/backend/auth-service/apitest"
+)
+
+func TestSessionCapEvictsOldest(t *testing.T) {
+	clock := apitest.NewFakeClock(time.Now())
+	env := apitest.NewWithConfig(t, func(cfg *api.Config) {
+		cfg.Clock = clock
+		cfg.MaxSessions = 2
+	})
+	creds := api.Credentials{Username: "bear", Email: "bear@berkeley.edu", Password: "pw"}
+	signUpVerified(t, env, creds)
+
+	var refreshes []*http.Cookie
+	for i := 0; i < 3; i++ {
+		_, refresh := signIn(t, env, creds)
+		refreshes = append(refreshes, refresh)
+		clock.Advance(time.Minute)
+	}
+
+	res := env.Do(http.MethodPost, "/api/auth/session/renew", nil, refreshes[0])
+	if res.Code != http.StatusUnauthorized {
+		t.Fatalf("renewing the oldest session past the cap: got %d, want 401", res.Code)
+	}
+	for i, refresh := range refreshes[1:] {
+		res = env.Do(http.MethodPost, "/api/auth/session/renew", nil, refresh)
+		if res.Code != http.StatusOK {
+			t.Fatalf("renewing session %d within the cap: got %d %s", i+2, res.Code, res.Body.String())
+		}
+	}
+}
+
+func TestRenewSessionRotatesBothTokens(t *testing.T) {
+	env := apitest.New(t)
+	creds := api.Credentials{Username: "bear", Email: "bear@berkeley.edu", Password: "pw"}
+	signUpVerified(t, env, creds)
+	_, refresh := signIn(t, env, creds)
+
+	res := env.Do(http.MethodPost, "/api/auth/session/renew", nil, refresh)
+	if res.Code != http.StatusOK {
+		t.Fatalf("renew: got %d %s", res.Code, res.Body.String())
+	}
+	access, renewed := apitest.Cookie(res, "access_token"), apitest.Cookie(res, "refresh_token")
+	if access == nil || renewed == nil || renewed.Value == refresh.Value {
+		t.Fatalf("renew didn't set a new access and refresh token")
+	}
+	var body api.RenewResponse
+	json.NewDecoder(res.Body).Decode(&body)
+	if body.AccessExpiresAt.IsZero() || !body.RefreshExpiresAt.After(body.AccessExpiresAt) {
+		t.Fatalf("renew: got expiries %+v, want both with the refresh token outliving the access token", body.TokenExpiry)
+	}
+	res = env.Do(http.MethodGet, "/api/auth/me", nil, access)
+	if res.Code != http.StatusOK {
+		t.Fatalf("renewed access token: got %d, want 200", res.Code)
+	}
+
+	res = env.Do(http.MethodPost, "/api/auth/session/renew", nil, refresh)
+	if res.Code != http.StatusUnauthorized {
+		t.Fatalf("renewing with the rotated refresh token: got %d, want 401", res.Code)
+	}
+}
+
+func TestRenewSessionRevoked(t *testing.T) {
+	env := apitest.New(t)
+	creds := api.Credentials{Username: "bear", Email: "bear@berkeley.edu", Password: "pw"}
+	signUpVerified(t, env, creds)
+	access, refresh := signIn(t, env, creds)
+	env.Do(http.MethodPost, "/api/auth/logout", nil, access, refresh)
+
+	res := env.Do(http.MethodPost, "/api/auth/session/renew", nil, refresh)
+	if res.Code != http.StatusUnauthorized {
+		t.Fatalf("renewing after logout: got %d, want 401", res.Code)
+	}
+	if apitest.Cookie(res, "access_token") != nil {
+		t.Fatalf("renewing a revoked session set an access token")
+	}
+}
+
+func TestLogoutRevokesCapturedRefreshToken(t *testing.T) {
+	env := apitest.New(t)
+	creds := api.Credentials{Username: "bear", Email: "bear@berkeley.edu", Password: "pw"}
+	signUpVerified(t, env, creds)
+	access, refresh := signIn(t, env, creds)
+	_, otherDevice := signIn(t, env, creds)
+	//a copy taken before logout, e.g. by a script on the page
+	captured := &http.Cookie{Name: refresh.Name, Value: refresh.Value}
+
+	res := env.Do(http.MethodPost, "/api/auth/logout", nil, access, refresh)
+	var body api.LogoutResponse
+	json.NewDecoder(res.Body).Decode(&body)
+	if res.Code != http.StatusOK || !body.SessionRevoked || body.Message != "logged out" {
+		t.Fatalf("logout: got %d %+v, want the session revoked", res.Code, body)
+	}
+
+	res = env.Do(http.MethodPost, "/api/auth/session/renew", nil, captured)
+	if res.Code != http.StatusUnauthorized {
+		t.Fatalf("renewing with a refresh token captured before logout: got %d, want 401", res.Code)
+	}
+	res = env.Do(http.MethodPost, "/api/auth/logout", nil, captured)
+	body = api.LogoutResponse{}
+	json.NewDecoder(res.Body).Decode(&body)
+	if res.Code != http.StatusOK || body.SessionRevoked || body.Message != "no active session, cookies cleared" {
+		t.Fatalf("logging out again with the captured token: got %d %+v, want no session to revoke", res.Code, body)
+	}
+
+	//only the session that logged out ends
+	res = env.Do(http.MethodPost, "/api/auth/session/renew", nil, otherDevice)
+	if res.Code != http.StatusOK {
+		t.Fatalf("renewing another device's session after logout: got %d %s, want 200", res.Code, res.Body.String())
+	}
+}
+
+func TestRefreshTokenExpiresOnFakeClock(t *testing.T) {
+	clock := apitest.NewFakeClock(time.Now())
+	env := apitest.NewWithConfig(t, func(cfg *api.Config) {
+		cfg.Clock = clock
+		cfg.RefreshTokenTTL = 24 * time.Hour
+		cfg.TokenLeeway = 0
+	})
+	creds := api.Credentials{Username: "bear", Email: "bear@berkeley.edu", Password: "pw"}
+	signUpVerified(t, env, creds)
+	_, early := signIn(t, env, creds)
+	_, late := signIn(t, env, creds)
+
+	clock.Advance(24*time.Hour - time.Minute)
+	if res := env.Do(http.MethodPost, "/api/auth/session/renew", nil, early); res.Code != http.StatusOK {
+		t.Fatalf("renewing a minute before the refresh token expires: got %d %s, want 200", res.Code, res.Body.String())
+	}
+	clock.Advance(2 * time.Minute)
+	if res := env.Do(http.MethodPost, "/api/auth/session/renew", nil, late); res.Code != http.StatusUnauthorized {
+		t.Fatalf("renewing a minute after the refresh token expired: got %d, want 401", res.Code)
+	}
+}
+
+//logout posts to logout with cookies and decodes the answer
+func logout(t *testing.T, env *apitest.Env, cookies ...*http.Cookie) api.LogoutResponse {
+	t.Helper()
+	res := env.Do(http.MethodPost, "/api/auth/logout", nil, cookies...)
+	if res.Code != http.StatusOK {
+		t.Fatalf("logout: got %d %s, want 200", res.Code, res.Body.String())
+	}
+	for _, name := range []string{"access_token", "refresh_token"} {
+		if cleared := apitest.Cookie(res, name); cleared == nil || cleared.Value != "" || cleared.Expires.After(env.Clock.Now()) {
+			t.Fatalf("logout didn't expire the %s cookie, got %v", name, cleared)
+		}
+	}
+	var body api.LogoutResponse
+	json.NewDecoder(res.Body).Decode(&body)
+	return body
+}
+
+func TestLogoutReportsClearedCookies(t *testing.T) {
+	env := apitest.New(t)
+	creds := api.Credentials{Username: "bear", Email: "bear@berkeley.edu", Password: "pw"}
+	signUpVerified(t, env, creds)
+
+	body := logout(t, env)
+	if fmt.Sprint(body.ClearedCookies) != "[]" || body.SessionRevoked || body.Message != "no active session, cookies cleared" {
+		t.Fatalf("logout without cookies: got %+v, want nothing cleared and no session revoked", body)
+	}
+
+	access, _ := signIn(t, env, creds)
+	body = logout(t, env, access)
+	if fmt.Sprint(body.ClearedCookies) != "[access_token]" || body.SessionRevoked {
+		t.Fatalf("logout with only the access token: got %+v, want it cleared and no session revoked", body)
+	}
+
+	body = logout(t, env, &http.Cookie{Name: "refresh_token", Value: "not-a-jwt"})
+	if fmt.Sprint(body.ClearedCookies) != "[refresh_token]" || body.SessionRevoked {
+		t.Fatalf("logout with an invalid refresh token: got %+v, want it cleared and no session revoked", body)
+	}
+
+	access, refresh := signIn(t, env, creds)
+	body = logout(t, env, access, refresh)
+	if fmt.Sprint(body.ClearedCookies) != "[access_token refresh_token]" || !body.SessionRevoked || body.Message != "logged out" {
+		t.Fatalf("logout with both tokens: got %+v, want both cleared and the session revoked", body)
+	}
+}
+
+func TestLogoutAllRejectsEveryEarlierToken(t *testing.T) {
+	env := apitest.New(t)
+	creds := api.Credentials{Username: "bear", Email: "bear@berkeley.edu", Password: "pw"}
+	tree := api.Credentials{Username: "tree", Email: "tree@stanford.edu", Password: "pw"}
+	signUpVerified(t, env, creds)
+	signUpVerified(t, env, tree)
+	laptopAccess, laptopRefresh := signIn(t, env, creds)
+	phoneAccess, phoneRefresh := signIn(t, env, creds)
+	treeAccess, _ := signIn(t, env, tree)
+
+	res := env.Do(http.MethodPost, "/api/auth/logout-all", nil, laptopAccess)
+	expectSuccess(t, "logout-all", res, http.StatusOK, "logged out of all devices")
+	if cleared := apitest.Cookie(res, "access_token"); cleared == nil || cleared.Value != "" {
+		t.Fatalf("logout-all didn't clear the access token cookie")
+	}
+
+	for device, access := range map[string]*http.Cookie{"laptop": laptopAccess, "phone": phoneAccess} {
+		if res := getMe(env, "", access); res.Code != http.StatusUnauthorized {
+			t.Fatalf("%s access token after logout-all: got %d, want 401", device, res.Code)
+		}
+	}
+	for device, refresh := range map[string]*http.Cookie{"laptop": laptopRefresh, "phone": phoneRefresh} {
+		if res := env.Do(http.MethodPost, "/api/auth/session/renew", nil, refresh); res.Code != http.StatusUnauthorized {
+			t.Fatalf("%s refresh token after logout-all: got %d, want 401", device, res.Code)
+		}
+	}
+
+	access, _ := signIn(t, env, creds)
+	profileUsername(t, "signing in again after logout-all", getMe(env, "", access))
+	profileUsername(t, "another account after logout-all", getMe(env, "", treeAccess))
+
+	if res := env.Do(http.MethodPost, "/api/auth/logout-all", nil); res.Code != http.StatusUnauthorized {
+		t.Fatalf("logout-all signed out: got %d, want 401", res.Code)
+	}
+}
+
+func TestRememberMeExtendsRefreshToken(t *testing.T) {
+	clock := apitest.NewFakeClock(time.Date(2020, 10, 1, 9, 0, 0, 0, time.UTC))
+	env := apitest.NewWithConfig(t, func(cfg *api.Config) {
+		cfg.Clock = clock
+		cfg.RefreshTokenTTL = 7 * 24 * time.Hour
+		cfg.RememberMeTTL = 30 * 24 * time.Hour
+	})
+	creds := api.Credentials{Username: "bear", Email: "bear@berkeley.edu", Password: "pw"}
+	signUpVerified(t, env, creds)
+
+	remembered := creds
+	remembered.RememberMe = true
+	for _, c := range []struct {
+		name  string
+		creds api.Credentials
+		ttl   time.Duration
+	}{
+		{"signin", creds, 7 * 24 * time.Hour},
+		{"signin with rememberMe", remembered, 30 * 24 * time.Hour},
+	} {
+		want := clock.Now().Add(c.ttl)
+		res := env.Do(http.MethodPost, "/api/auth/signin", c.creds)
+		var body api.SigninResponse
+		json.NewDecoder(res.Body).Decode(&body)
+		refresh := apitest.Cookie(res, "refresh_token")
+		if res.Code != http.StatusOK || refresh == nil {
+			t.Fatalf("%s: got %d %s", c.name, res.Code, res.Body.String())
+		}
+		if !body.RefreshExpiresAt.Equal(want) || !refresh.Expires.Equal(want) {
+			t.Fatalf("%s: got refreshExpiresAt %s and a cookie expiring %s, want both %s", c.name, body.RefreshExpiresAt, refresh.Expires, want)
+		}
+
+		//renewing keeps the lifetime picked at signin
+		clock.Advance(time.Hour)
+		want = clock.Now().Add(c.ttl)
+		res = env.Do(http.MethodPost, "/api/auth/session/renew", nil, refresh)
+		refresh = apitest.Cookie(res, "refresh_token")
+		if res.Code != http.StatusOK || refresh == nil || !refresh.Expires.Equal(want) {
+			t.Fatalf("renewing after %s: got %d with cookies %v, want the refresh token to expire %s", c.name, res.Code, res.Result().Cookies(), want)
+		}
+	}
+}
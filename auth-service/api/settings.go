@@ -0,0 +1,126 @@
+package api
+
+import (
+	"log"
+	"sort"
+	"strings"
+)
+
+//redacted stands in for the value of a secret setting that is set
+const redacted = "[redacted]"
+
+var (
+	//secretSettings are the settings whose values never appear in logs
+	secretSettings = map[string]bool{"JWT_SECRET": true, "JWT_PREVIOUS_SECRETS": true, "SENDGRID_KEY": true, "SEED_ADMIN_PASSWORD": true, "TWILIO_AUTH_TOKEN": true}
+	//otherSettings are read outside of LoadConfig, so they count as known when looking for misspelled settings
+	otherSettings = []string{"SEED_ADMIN_EMAIL", "SEED_ADMIN_PASSWORD", "SEED_ADMIN_USERNAME"}
+)
+
+//Effective returns every setting LoadConfig read with the value in use, defaults included.
+//Secrets that are set show as "[redacted]".
+func (cfg Config) Effective() map[string]string {
+	effective := map[string]string{}
+	for name, value := range cfg.effective {
+		if secretSettings[name] && value != "" {
+			value = redacted
+		}
+		effective[name] = value
+	}
+	return effective
+}
+
+//logEffective logs the settings in use one per line in name order, so operators can check what the service runs with
+func (cfg Config) logEffective() {
+	effective := cfg.Effective()
+	names := make([]string, 0, len(effective))
+	for name := range effective {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		log.Printf("config %s=%q", name, effective[name])
+	}
+}
+
+//unknownSettings returns a warning for each variable in environ, as "NAME=value" pairs, that looks like a misspelled
+//setting: it isn't a setting itself, but starts with the same word as one or is at most two edits away from one.
+//Everything else in the environment, like PATH, is left alone.
+func (cfg Config) unknownSettings(environ []string) []string {
+	known := map[string]bool{}
+	prefixes := map[string]bool{}
+	for name := range cfg.effective {
+		known[name] = true
+	}
+	for _, name := range otherSettings {
+		known[name] = true
+	}
+	for name := range known {
+		prefixes[strings.SplitN(name, "_", 2)[0]+"_"] = true
+	}
+
+	var warnings []string
+	for _, variable := range environ {
+		name := strings.SplitN(variable, "=", 2)[0]
+		if known[name] {
+			continue
+		}
+		closest, distance := closestSetting(name, known, "")
+		if distance > 2 {
+			prefix := strings.SplitN(name, "_", 2)[0] + "_"
+			if !prefixes[prefix] {
+				continue
+			}
+			closest, _ = closestSetting(name, known, prefix)
+		}
+		warnings = append(warnings, name+" is not a setting and is ignored, did you mean "+closest+"?")
+	}
+	sort.Strings(warnings)
+	return warnings
+}
+
+//closestSetting returns the setting in known starting with prefix that is the fewest edits away from name, and the edit count
+func closestSetting(name string, known map[string]bool, prefix string) (string, int) {
+	closest, distance := "", -1
+	for candidate := range known {
+		if !strings.HasPrefix(candidate, prefix) {
+			continue
+		}
+		//ties go to the first name alphabetically so the suggestion doesn't change between runs
+		if d := editDistance(name, candidate); distance == -1 || d < distance || (d == distance && candidate < closest) {
+			closest, distance = candidate, d
+		}
+	}
+	return closest, distance
+}
+
+//editDistance is the number of single character insertions, deletions and substitutions turning a into b
+func editDistance(a string, b string) int {
+	previous := make([]int, len(b)+1)
+	current := make([]int, len(b)+1)
+	for j := range previous {
+		previous[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		current[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			current[j] = min3(previous[j]+1, current[j-1]+1, previous[j-1]+cost)
+		}
+		previous, current = current, previous
+	}
+	return previous[len(b)]
+}
+
+//min3 returns the smallest of three ints
+func min3(a int, b int, c int) int {
+	if b < a {
+		a = b
+	}
+	if c < a {
+		a = c
+	}
+	return a
+}
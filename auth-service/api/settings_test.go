@@ -0,0 +1,25 @@
+package api
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestUnknownSettingsWarnsAboutTypos(t *testing.T) {
+	cfg := LoadConfig()
+	warnings := cfg.unknownSettings([]string{
+		"ACESS_TOKEN_TTL=1h",
+		"RATE_LIMITS=5",
+		"JWT_SECRET=set",
+		"SEED_ADMIN_EMAIL=oski@berkeley.edu",
+		"PATH=/usr/bin",
+		"HOME=/root",
+	})
+	want := []string{
+		"ACESS_TOKEN_TTL is not a setting and is ignored, did you mean ACCESS_TOKEN_TTL?",
+		"RATE_LIMITS is not a setting and is ignored, did you mean RATE_LIMIT?",
+	}
+	if !reflect.DeepEqual(warnings, want) {
+		t.Fatalf("unknownSettings: got %q, want %q", warnings, want)
+	}
+}
@@ -0,0 +1,94 @@
+package api_test
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"reflect"
+	"testing"
+
+	"github.com/BearCloud/fa20-project-dev/backend/auth-service/api"
+	"github.com/BearCloud/fa20-project-dev/backend/auth-service/apitest"
+)
+
+func TestSignupLocation(t *testing.T) {
+	env := apitest.New(t)
+	res := env.Do(http.MethodPost, "/api/auth/signup", api.Credentials{Username: "bear", Email: "bear@berkeley.edu", Password: "pw"})
+	if res.Code != http.StatusCreated {
+		t.Fatalf("signup: got %d %s", res.Code, res.Body.String())
+	}
+	var body api.SignupResponse
+	json.NewDecoder(res.Body).Decode(&body)
+
+	var userID string
+	env.DB.QueryRow("SELECT userId FROM users WHERE username = ?;", "bear").Scan(&userID)
+	if body.UserID != userID {
+		t.Fatalf("signup body: got userId %q, want %q", body.UserID, userID)
+	}
+	if location := res.Header().Get("Location"); location != "/api/auth/users/"+userID {
+		t.Fatalf("signup: got Location %q, want /api/auth/users/%s", location, userID)
+	}
+}
+
+func TestSignupEmailDomains(t *testing.T) {
+	env := apitest.NewWithConfig(t, func(cfg *api.Config) {
+		cfg.AllowedDomains = []string{"berkeley.edu", "*.berkeley.edu"}
+		cfg.DeniedDomains = []string{"spam.berkeley.edu"}
+	})
+
+	for i, check := range []struct {
+		email string
+		want  int
+	}{
+		{"bear@berkeley.edu", http.StatusCreated},
+		{"bear@eecs.berkeley.edu", http.StatusCreated},
+		{"oski@BERKELEY.EDU", http.StatusCreated},
+		{"bear@spam.berkeley.edu", http.StatusForbidden},
+		{"bear@stanford.edu", http.StatusForbidden},
+		{"bear@notberkeley.edu", http.StatusForbidden},
+	} {
+		res := env.Do(http.MethodPost, "/api/auth/signup", api.Credentials{Username: fmt.Sprintf("bear%d", i), Email: check.email, Password: "pw"})
+		if res.Code != check.want {
+			t.Errorf("signup with %s: got %d, want %d", check.email, res.Code, check.want)
+		}
+	}
+}
+
+func TestSignupConflictNamesTakenFields(t *testing.T) {
+	env := apitest.New(t)
+	signUpVerified(t, env, api.Credentials{Username: "bear", Email: "bear@berkeley.edu", Password: "pw"})
+
+	for _, check := range []struct {
+		creds   api.Credentials
+		message string
+		fields  []string
+	}{
+		{api.Credentials{Username: "bear", Email: "tree@stanford.edu", Password: "pw"}, "this username is taken", []string{"username"}},
+		{api.Credentials{Username: "tree", Email: "bear@berkeley.edu", Password: "pw"}, "this email is taken", []string{"email"}},
+		{api.Credentials{Username: "bear", Email: "bear@berkeley.edu", Password: "pw"}, "this username and email are taken", []string{"username", "email"}},
+	} {
+		res := env.Do(http.MethodPost, "/api/auth/signup", check.creds)
+		var body api.ConflictResponse
+		json.NewDecoder(res.Body).Decode(&body)
+		if res.Code != http.StatusConflict || body.Message != check.message || !reflect.DeepEqual(body.Fields, check.fields) {
+			t.Fatalf("signup as %s, %s: got %d %+v, want 409 %q with fields %v", check.creds.Username, check.creds.Email, res.Code, body, check.message, check.fields)
+		}
+	}
+}
+
+func TestSignupRejectsBannedPassword(t *testing.T) {
+	env := apitest.NewWithConfig(t, func(cfg *api.Config) {
+		cfg.BannedPasswords = map[string]struct{}{"letmein": {}}
+	})
+
+	res := env.Do(http.MethodPost, "/api/auth/signup", api.Credentials{Username: "bear", Email: "bear@berkeley.edu", Password: "LetMeIn"})
+	var body api.ErrorResponse
+	json.NewDecoder(res.Body).Decode(&body)
+	if res.Code != http.StatusBadRequest || body.Message != "this password is too common, choose a different one" {
+		t.Fatalf("signup with a banned password: got %d %q, want 400 saying it's too common", res.Code, body.Message)
+	}
+	res = env.Do(http.MethodPost, "/api/auth/signup", api.Credentials{Username: "bear", Email: "bear@berkeley.edu", Password: "correct horse battery staple"})
+	if res.Code != http.StatusCreated {
+		t.Fatalf("signup with a password not on the list: got %d %s, want 201", res.Code, res.Body.String())
+	}
+}
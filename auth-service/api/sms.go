@@ -0,0 +1,143 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+	"time"
+)
+
+const (
+	//channelEmail delivers verification and reset links by email, the default
+	channelEmail = "email"
+	//channelSMS delivers them by text message to the account's phone number
+	channelSMS = "sms"
+	//phoneMaxLength matches the users.phone column, a + and at most 15 digits
+	phoneMaxLength = 16
+)
+
+var (
+	//twilioAccountSID, twilioAuthToken and twilioFromNumber configure sending through Twilio, SMS is off without them
+	twilioAccountSID string
+	twilioAuthToken  string
+	twilioFromNumber string
+	//twilioBaseURL is the Twilio API host, pointed elsewhere to use a mock server
+	twilioBaseURL = "https://api.twilio.com"
+	//smsSendTimeout bounds how long a single Twilio call may take
+	smsSendTimeout = 10 * time.Second
+
+	//phonePattern matches E.164 numbers: a +, a country code that doesn't start with 0 and up to 15 digits in all
+	phonePattern = regexp.MustCompile(`^\+[1-9][0-9]{6,14}$`)
+	//phoneSeparators are dropped from phone numbers, so "+1 (510) 555-0100" is stored as +15105550100
+	phoneSeparators = strings.NewReplacer(" ", "", "-", "", "(", "", ")", "", ".", "")
+)
+
+//SMSSender delivers a text message to a phone number in E.164 format
+type SMSSender interface {
+	SendSMS(ctx context.Context, phone string, message string) error
+}
+
+//smsSender delivers every text message the handlers send, nil while SMS delivery is off
+var smsSender SMSSender
+
+//SetSMSSender replaces the sender used by the handlers, e.g. with a mock in tests. nil turns SMS delivery off.
+func SetSMSSender(sender SMSSender) {
+	smsSender = sender
+}
+
+//InitSMS turns SMS delivery on through Twilio when TWILIO_ACCOUNT_SID is set
+func InitSMS() {
+	if twilioAccountSID == "" {
+		log.Println("TWILIO_ACCOUNT_SID is not set, verification and reset links are only sent by email")
+		return
+	}
+	smsSender = twilioSender{}
+}
+
+//cleanPhone drops separators from phone and checks it is an E.164 number
+func cleanPhone(phone string) (string, error) {
+	phone = phoneSeparators.Replace(strings.TrimSpace(phone))
+	if !phonePattern.MatchString(phone) {
+		return "", errors.New("phone must be a number in international format, like +15105550100")
+	}
+	return phone, nil
+}
+
+//signupChannel checks the phone and delivery channel of a signup and returns them cleaned, email unless
+//the signup asks for SMS
+func signupChannel(credentials Credentials) (string, string, error) {
+	channel := credentials.Channel
+	if channel == "" {
+		channel = channelEmail
+	}
+	if channel != channelEmail && channel != channelSMS {
+		return "", "", errors.New("channel must be \"" + channelEmail + "\" or \"" + channelSMS + "\"")
+	}
+	var phone string
+	if credentials.Phone != "" {
+		var err error
+		phone, err = cleanPhone(credentials.Phone)
+		if err != nil {
+			return "", "", err
+		}
+	}
+	if channel == channelSMS && phone == "" {
+		return "", "", errors.New("phone is required to verify by sms")
+	}
+	if channel == channelSMS && smsSender == nil {
+		return "", "", errors.New("verification by sms is not available")
+	}
+	return phone, channel, nil
+}
+
+//verifyLink builds the link that verifies an account's primary contact with token
+func verifyLink(token string) string {
+	return strings.TrimSuffix(frontendBaseURL, "/") + "/verify?token=" + url.QueryEscape(token)
+}
+
+//sendSMS sends message to phone with the configured sender, {brand} in message is replaced with brandName
+func sendSMS(ctx context.Context, phone string, message string) error {
+	if smsSender == nil {
+		return errors.New("sms delivery is not configured")
+	}
+	return smsSender.SendSMS(ctx, phone, strings.Replace(message, "{brand}", brandName, -1))
+}
+
+//twilioSender sends text messages through the Twilio Messages API
+type twilioSender struct{}
+
+//SendSMS posts the message to Twilio.
+//The call is abandoned when ctx is canceled or smsSendTimeout passes, whichever comes first.
+func (twilioSender) SendSMS(ctx context.Context, phone string, message string) error {
+	form := url.Values{"From": {twilioFromNumber}, "To": {phone}, "Body": {message}}
+	endpoint := strings.TrimSuffix(twilioBaseURL, "/") + "/2010-04-01/Accounts/" + url.PathEscape(twilioAccountSID) + "/Messages.json"
+
+	ctx, cancel := context.WithTimeout(ctx, smsSendTimeout)
+	defer cancel()
+	request, err := http.NewRequest(http.MethodPost, endpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return err
+	}
+	request = request.WithContext(ctx)
+	request.SetBasicAuth(twilioAccountSID, twilioAuthToken)
+	request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	response, err := http.DefaultClient.Do(request)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+
+	//Twilio reports rejected messages through the status code, like SendGrid
+	if response.StatusCode < 200 || response.StatusCode > 299 {
+		body, _ := ioutil.ReadAll(response.Body)
+		return fmt.Errorf("twilio responded with status %d: %s", response.StatusCode, body)
+	}
+	return nil
+}
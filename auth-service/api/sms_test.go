@@ -0,0 +1,86 @@
+package api_test
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/BearCloud/fa20-project-dev/backend/auth-service/api"
+	"github.com/BearCloud/fa20-project-dev/backend/auth-service/apitest"
+)
+
+func TestSignupVerifiedBySMS(t *testing.T) {
+	env := apitest.New(t)
+	creds := api.Credentials{Username: "bear", Email: "sms-signup@berkeley.edu", Password: "pw", Phone: "+1 (510) 555-0100", Channel: "sms"}
+
+	res := env.Do(http.MethodPost, "/api/auth/signup", creds)
+	expectSuccess(t, "signup by sms", res, http.StatusCreated, "account created, check your phone to verify it")
+	sms, ok := env.SMS.LastTo("+15105550100")
+	if !ok || sms.Token() == "" {
+		t.Fatalf("signup by sms: got messages %+v, want a verification link to +15105550100", env.SMS.Sent())
+	}
+	if _, ok := env.Mailer.LastFrom(creds.Email, "user-signup.html"); ok {
+		t.Fatalf("signup by sms also sent a verification email")
+	}
+
+	res = env.Do(http.MethodPost, "/api/auth/verify?token="+sms.Token(), nil)
+	if res.Code != http.StatusOK {
+		t.Fatalf("verify with the texted token: got %d %s", res.Code, res.Body.String())
+	}
+	signIn(t, env, creds)
+}
+
+func TestResetLinkBySMS(t *testing.T) {
+	env := apitest.New(t)
+	creds := api.Credentials{Username: "bear", Email: "sms-reset@berkeley.edu", Password: "pw", Phone: "+15105550101"}
+	signUpVerified(t, env, creds)
+
+	res := env.Do(http.MethodPost, "/api/auth/sendreset", api.Credentials{Email: creds.Email, Channel: "sms"})
+	expectSuccess(t, "sendreset by sms", res, http.StatusOK, "password reset email sent")
+	sms, ok := env.SMS.LastTo(creds.Phone)
+	if !ok || !strings.HasPrefix(sms.Message, "Reset your") || sms.Token() == "" {
+		t.Fatalf("sendreset by sms: got messages %+v, want a reset link to %s", env.SMS.Sent(), creds.Phone)
+	}
+	res = env.Do(http.MethodPost, "/api/auth/resetpw", api.PasswordReset{Token: sms.Token(), NewPassword: "new pw", ConfirmPassword: "new pw"})
+	expectSuccess(t, "resetpw with the texted token", res, http.StatusOK, "password reset")
+
+	//email stays the default
+	requestReset(t, env, creds.Email)
+	if sent := len(env.SMS.Sent()); sent != 1 {
+		t.Fatalf("sendreset without a channel: got %d text messages, want only the earlier one", sent)
+	}
+}
+
+func TestResetBySMSWithoutPhoneFallsBackToEmail(t *testing.T) {
+	env := apitest.New(t)
+	creds := api.Credentials{Username: "bear", Email: "sms-nophone@berkeley.edu", Password: "pw"}
+	signUpVerified(t, env, creds)
+
+	res := env.Do(http.MethodPost, "/api/auth/sendreset", api.Credentials{Email: creds.Email, Channel: "sms"})
+	expectSuccess(t, "sendreset by sms without a phone", res, http.StatusOK, "password reset email sent")
+	if _, ok := env.Mailer.LastFrom(creds.Email, "password-reset.html"); !ok {
+		t.Fatalf("sendreset by sms for an account without a phone sent no reset email")
+	}
+	if sent := env.SMS.Sent(); len(sent) != 0 {
+		t.Fatalf("sendreset by sms for an account without a phone: got text messages %+v", sent)
+	}
+}
+
+func TestSMSSignupValidated(t *testing.T) {
+	env := apitest.New(t)
+	for name, creds := range map[string]api.Credentials{
+		"no phone":        {Username: "bear", Email: "bear@berkeley.edu", Password: "pw", Channel: "sms"},
+		"a local number":  {Username: "bear", Email: "bear@berkeley.edu", Password: "pw", Phone: "510-555-0100", Channel: "sms"},
+		"unknown channel": {Username: "bear", Email: "bear@berkeley.edu", Password: "pw", Channel: "pigeon"},
+	} {
+		if res := env.Do(http.MethodPost, "/api/auth/signup", creds); res.Code != http.StatusBadRequest {
+			t.Errorf("signup with %s: got %d %s, want 400", name, res.Code, res.Body.String())
+		}
+	}
+
+	api.SetSMSSender(nil)
+	creds := api.Credentials{Username: "bear", Email: "bear@berkeley.edu", Password: "pw", Phone: "+15105550100", Channel: "sms"}
+	if res := env.Do(http.MethodPost, "/api/auth/signup", creds); res.Code != http.StatusBadRequest {
+		t.Fatalf("signup by sms while sms is off: got %d %s, want 400", res.Code, res.Body.String())
+	}
+}
@@ -0,0 +1,241 @@
+package api
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+)
+
+//ErrNotFound is returned by a UserStore when nothing matches. It is sql.ErrNoRows, so the SQL store passes
+//database/sql's answer straight through and callers can check either.
+var ErrNotFound = sql.ErrNoRows
+
+//ErrEmailTaken is returned by UserStore.MarkVerified when another account has already verified the email
+var ErrEmailTaken = errors.New("this email is already verified on another account")
+
+//User is an account as a UserStore stores it
+type User struct {
+	UserID         string
+	Username       string
+	DisplayName    string
+	Email          string
+	Phone          string
+	HashedPassword []byte
+	Role           string
+	Verified       bool
+	TokenVersion   int
+	CreatedAt      time.Time
+	DeletedAt      sql.NullTime
+}
+
+//ResetToken is a stored password reset token with the primary email of its account
+type ResetToken struct {
+	UserID    string
+	Email     string
+	ExpiresAt time.Time
+}
+
+//UserStore keeps the accounts and reset tokens the signup, signin, verification and password reset flows work on.
+//Lookups return ErrNotFound when nothing matches. Tokens are only ever passed in hashed.
+type UserStore interface {
+	//IdentityTaken reports whether username is taken, and whether email is verified on any account as its primary or
+	//a secondary address. Pending signups don't hold on to their email, so several of them can share one.
+	IdentityTaken(username string, email string) (bool, bool, error)
+	//CreateUser stores a new account with the hash of its verification token. A verification token hash that is
+	//already taken must fail with an error isDuplicateKey recognises, so a fresh token is tried.
+	CreateUser(user User, verifyTokenHash string) error
+	//GetByID returns the account with userID
+	GetByID(userID string) (User, error)
+	//GetByEmail returns the account whose primary address is email. When pending signups share it, the verified
+	//account wins, otherwise the newest signup.
+	GetByEmail(email string) (User, error)
+	//GetBySigninEmail returns the account email signs in to, by its primary address or a verified secondary one,
+	//preferring accounts like GetByEmail
+	GetBySigninEmail(email string) (User, error)
+	//GetByVerifyToken returns the account whose verification token hashes to tokenHash
+	GetByVerifyToken(tokenHash string) (User, error)
+	//MarkVerified verifies the account whose verification token hashes to tokenHash. It reports false when
+	//nothing changed, because the account was already verified or no account has the token. It fails with
+	//ErrEmailTaken, and changes nothing, when another account has already verified the email.
+	MarkVerified(tokenHash string) (bool, error)
+	//UpgradePasswordHash replaces the password hash of userID with hashed, unless it no longer is old
+	UpgradePasswordHash(userID string, old []byte, hashed []byte) error
+	//SetResetToken stores the hash of a reset token for userID
+	SetResetToken(tokenHash string, userID string, expiresAt time.Time) error
+	//ClearResetTokens removes every reset token of userID
+	ClearResetTokens(userID string) error
+	//GetResetToken returns the reset token hashing to tokenHash, expired or not
+	GetResetToken(tokenHash string) (ResetToken, error)
+	//ResetPassword sets the password hash of userID and clears its reset tokens in one step, but only while the
+	//token hashing to tokenHash still belongs to userID and hasn't expired; otherwise it returns ErrNotFound.
+	//Of two calls racing with the same token exactly one succeeds.
+	ResetPassword(tokenHash string, userID string, hashed []byte) error
+}
+
+//userStore is the store every handler works on, SQL unless replaced with SetUserStore
+var userStore UserStore = SQLUserStore{}
+
+//SetUserStore replaces the store used by the handlers, e.g. with an in-memory one in tests
+func SetUserStore(store UserStore) {
+	userStore = store
+}
+
+//SQLUserStore is the UserStore kept in DB
+type SQLUserStore struct{}
+
+//userColumns are the columns scanUser reads, in order
+const userColumns = "userId, username, displayName, email, phone, hashedPassword, role, verified, tokenVersion, createdAt, deletedAt"
+
+//preferVerified picks one account when pending signups share an email: the verified one, otherwise the newest
+const preferVerified = " ORDER BY verified DESC, createdAt DESC LIMIT 1"
+
+//scanUser scans a row of userColumns
+func scanUser(row *sql.Row) (User, error) {
+	var user User
+	var username, displayName, email, phone sql.NullString
+	var verified sql.NullBool
+	var createdAt sql.NullTime
+	err := row.Scan(&user.UserID, &username, &displayName, &email, &phone, &user.HashedPassword, &user.Role, &verified, &user.TokenVersion, &createdAt, &user.DeletedAt)
+	if err != nil {
+		return User{}, err
+	}
+	user.Username, user.DisplayName, user.Email, user.Phone = username.String, displayName.String, email.String, phone.String
+	user.Verified, user.CreatedAt = verified.Bool, createdAt.Time
+	return user, nil
+}
+
+//IdentityTaken checks the usernames and verified_emails in one round trip
+func (SQLUserStore) IdentityTaken(username string, email string) (bool, bool, error) {
+	var usernameTaken, emailTaken bool
+	err := DB.QueryRow("SELECT EXISTS(SELECT * FROM users WHERE username = ?), EXISTS(SELECT * FROM verified_emails WHERE email = ?);", username, email).
+		Scan(&usernameTaken, &emailTaken)
+	return usernameTaken, emailTaken, err
+}
+
+//CreateUser inserts the account, the unique verifiedToken column rejects a taken token hash
+func (SQLUserStore) CreateUser(user User, verifyTokenHash string) error {
+	_, err := DB.Exec("INSERT INTO users (username, displayName, email, phone, hashedPassword, verifiedToken, userId, role, createdAt) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?);", user.Username, nullableString(user.DisplayName), user.Email, nullableString(user.Phone), user.HashedPassword, verifyTokenHash, user.UserID, user.Role, user.CreatedAt)
+	return err
+}
+
+//GetByID looks the account up by its primary key
+func (SQLUserStore) GetByID(userID string) (User, error) {
+	return scanUser(DB.QueryRow("SELECT "+userColumns+" FROM users WHERE userId = ?;", userID))
+}
+
+//GetByEmail looks the account up by its primary address, see preferVerified
+func (SQLUserStore) GetByEmail(email string) (User, error) {
+	return scanUser(DB.QueryRow("SELECT "+userColumns+" FROM users WHERE email = ?"+preferVerified+";", email))
+}
+
+//GetBySigninEmail looks the account up by its primary address or a verified row in emails
+func (SQLUserStore) GetBySigninEmail(email string) (User, error) {
+	return scanUser(DB.QueryRow("SELECT "+userColumns+" FROM users WHERE email = ? OR userId = (SELECT userId FROM emails WHERE email = ? AND verified = ?)"+preferVerified+";", email, email, true))
+}
+
+//GetByVerifyToken looks the account up by the hash in verifiedToken
+func (SQLUserStore) GetByVerifyToken(tokenHash string) (User, error) {
+	return scanUser(DB.QueryRow("SELECT "+userColumns+" FROM users WHERE verifiedToken = ?;", tokenHash))
+}
+
+//MarkVerified claims the email in verified_emails and sets verified in one transaction, so of two signups sharing
+//an email only the first to verify gets it. Only unverified accounts match, so the update reports exactly the first
+//verification.
+func (SQLUserStore) MarkVerified(tokenHash string) (bool, error) {
+	tx, err := DB.Begin()
+	if err != nil {
+		return false, err
+	}
+
+	var userID, email string
+	err = tx.QueryRow("SELECT userId, email FROM users WHERE verifiedToken = ? AND (verified IS NULL OR verified = ?);", tokenHash, 0).Scan(&userID, &email)
+	if err == sql.ErrNoRows {
+		tx.Rollback()
+		return false, nil
+	}
+	if err != nil {
+		tx.Rollback()
+		return false, err
+	}
+	err = claimEmail(tx, email, userID)
+	if err != nil {
+		tx.Rollback()
+		return false, err
+	}
+	result, err := tx.Exec("UPDATE users SET verified = ? WHERE userId = ? AND (verified IS NULL OR verified = ?);", 1, userID, 0)
+	if err != nil {
+		tx.Rollback()
+		return false, err
+	}
+	affected, err := result.RowsAffected()
+	if err != nil || affected == 0 {
+		tx.Rollback()
+		return false, nil
+	}
+	return true, tx.Commit()
+}
+
+//UpgradePasswordHash guards the update with the old hash, so a password changed in the meantime is kept
+func (SQLUserStore) UpgradePasswordHash(userID string, old []byte, hashed []byte) error {
+	_, err := DB.Exec("UPDATE users SET hashedPassword = ? WHERE userId = ? AND hashedPassword = ?;", hashed, userID, old)
+	return err
+}
+
+//SetResetToken inserts into reset_tokens, whose primary key rejects a taken token hash
+func (SQLUserStore) SetResetToken(tokenHash string, userID string, expiresAt time.Time) error {
+	_, err := DB.Exec("INSERT INTO reset_tokens (tokenHash, userId, expiresAt) VALUES (?, ?, ?);", tokenHash, userID, expiresAt)
+	return err
+}
+
+//ClearResetTokens deletes from reset_tokens
+func (SQLUserStore) ClearResetTokens(userID string) error {
+	_, err := DB.Exec("DELETE FROM reset_tokens WHERE userId = ?;", userID)
+	return err
+}
+
+//GetResetToken joins the token with its account
+func (SQLUserStore) GetResetToken(tokenHash string) (ResetToken, error) {
+	var token ResetToken
+	err := DB.QueryRow("SELECT users.userId, users.email, reset_tokens.expiresAt FROM reset_tokens JOIN users ON users.userId = reset_tokens.userId WHERE reset_tokens.tokenHash = ?;", tokenHash).Scan(&token.UserID, &token.Email, &token.ExpiresAt)
+	return token, err
+}
+
+//ResetPassword runs in a transaction where deleting the token is the check
+func (SQLUserStore) ResetPassword(tokenHash string, userID string, hashed []byte) error {
+	tx, err := DB.Begin()
+	if err != nil {
+		return err
+	}
+
+	now := clock.Now()
+	result, err := tx.Exec("DELETE FROM reset_tokens WHERE tokenHash = ? AND userId = ? AND expiresAt > ?;", tokenHash, userID, now)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	consumed, err := result.RowsAffected()
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	if consumed == 0 {
+		tx.Rollback()
+		return ErrNotFound
+	}
+
+	statements := []struct {
+		query string
+		args  []interface{}
+	}{
+		{"UPDATE users SET hashedPassword = ?, passwordChangedAt = ? WHERE userId = ?;", []interface{}{hashed, now, userID}},
+		{"DELETE FROM reset_tokens WHERE userId = ?;", []interface{}{userID}},
+	}
+	for _, statement := range statements {
+		_, err = tx.Exec(statement.query, statement.args...)
+		if err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+	return tx.Commit()
+}
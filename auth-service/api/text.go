@@ -0,0 +1,40 @@
+package api
+
+import (
+	"errors"
+	"strconv"
+	"unicode"
+	"unicode/utf8"
+
+	"golang.org/x/text/unicode/norm"
+)
+
+const (
+	//usernameMaxLength matches the users.username column
+	usernameMaxLength = 20
+	//emailMaxLength matches the users.email column
+	emailMaxLength = 320
+)
+
+//cleanText checks a text input named field and returns it in Unicode NFC, so the same text typed on
+//different systems is stored the same way. It rejects invalid UTF-8, control characters and values
+//longer than maxLength characters.
+func cleanText(field string, value string, maxLength int) (string, error) {
+	if !utf8.ValidString(value) {
+		return "", errors.New(field + " must be valid UTF-8")
+	}
+	for _, r := range value {
+		//encoding/json swaps invalid bytes for the replacement character, so it is the trace of invalid UTF-8 in a body
+		if r == utf8.RuneError {
+			return "", errors.New(field + " must be valid UTF-8")
+		}
+		if unicode.IsControl(r) {
+			return "", errors.New(field + " can't contain control characters")
+		}
+	}
+	value = norm.NFC.String(value)
+	if utf8.RuneCountInString(value) > maxLength {
+		return "", errors.New(field + " can be at most " + strconv.Itoa(maxLength) + " characters")
+	}
+	return value, nil
+}
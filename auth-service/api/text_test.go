@@ -0,0 +1,76 @@
+package api_test
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/BearCloud/fa20-project-dev/backend/auth-service/api"
+	"github.com/BearCloud/fa20-project-dev/backend/auth-service/apitest"
+)
+
+func TestSignupRejectsInvalidText(t *testing.T) {
+	env := apitest.New(t)
+
+	for step, body := range map[string]string{
+		"invalid UTF-8 username":         `{"username":"bear` + "\xff" + `","email":"bear@berkeley.edu","password":"pw"}`,
+		"control character username":     `{"username":"bear\u0007","email":"bear@berkeley.edu","password":"pw"}`,
+		"invalid UTF-8 display name":     `{"username":"bear","email":"bear@berkeley.edu","password":"pw","displayName":"Oski` + "\xc3" + `"}`,
+		"control character display name": `{"username":"bear","email":"bear@berkeley.edu","password":"pw","displayName":"Oski\u001b[31m"}`,
+	} {
+		res := env.Do(http.MethodPost, "/api/auth/signup", body)
+		if res.Code != http.StatusBadRequest {
+			t.Errorf("signup with an %s: got %d %s, want 400", step, res.Code, res.Body.String())
+		}
+	}
+	var users int
+	env.DB.QueryRow("SELECT COUNT(*) FROM users;").Scan(&users)
+	if users != 0 {
+		t.Fatalf("signups with invalid text stored %d users", users)
+	}
+}
+
+func TestProfileEditRejectsInvalidText(t *testing.T) {
+	env := apitest.New(t)
+	creds := api.Credentials{Username: "bear", Email: "bear@berkeley.edu", Password: "pw", DisplayName: "Oski"}
+	signUpVerified(t, env, creds)
+	access, _ := signIn(t, env, creds)
+
+	for _, check := range []struct {
+		method string
+		path   string
+		body   string
+	}{
+		{http.MethodPut, "/api/auth/me/displayname", `{"displayName":"Oski` + "\xff" + `"}`},
+		{http.MethodPut, "/api/auth/me/displayname", `{"displayName":"Oski\u0000"}`},
+		{http.MethodPatch, "/api/auth/me", `{"displayName":"Oski\u0007Bear"}`},
+		{http.MethodPatch, "/api/auth/me", `{"username":"bear` + "\xfe" + `"}`},
+	} {
+		res := env.Do(check.method, check.path, check.body, access)
+		if res.Code != http.StatusBadRequest {
+			t.Errorf("%s %s with %q: got %d %s, want 400", check.method, check.path, check.body, res.Code, res.Body.String())
+		}
+	}
+	if got := meDisplayName(t, env, access); got != "Oski" {
+		t.Fatalf("display name after rejected edits: got %q, want Oski", got)
+	}
+}
+
+func TestTextStoredInNFC(t *testing.T) {
+	env := apitest.New(t)
+	//"e" followed by a combining acute accent is stored as the single character "é"
+	creds := api.Credentials{Username: "rene\u0301", Email: "rene@berkeley.edu", Password: "pw", DisplayName: "Rene\u0301e"}
+	signUpVerified(t, env, creds)
+
+	var username, displayName string
+	env.DB.QueryRow("SELECT username, displayName FROM users WHERE email = ?;", creds.Email).Scan(&username, &displayName)
+	if username != "ren\u00e9" || displayName != "Ren\u00e9e" {
+		t.Fatalf("decomposed accents: stored %q and %q, want them composed", username, displayName)
+	}
+
+	access, _ := signIn(t, env, creds)
+	res := env.Do(http.MethodPut, "/api/auth/me/displayname", api.DisplayNameUpdate{DisplayName: "Zoe\u0308"}, access)
+	expectSuccess(t, "setting a decomposed display name", res, http.StatusOK, "display name updated")
+	if got := meDisplayName(t, env, access); got != "Zo\u00eb" {
+		t.Fatalf("decomposed display name: got %q, want it composed", got)
+	}
+}
@@ -0,0 +1,57 @@
+package api
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/go-sql-driver/mysql"
+)
+
+func TestStoreUniqueTokenRetriesCollisions(t *testing.T) {
+	for name, collision := range map[string]error{
+		"MySQL":  &mysql.MySQLError{Number: 1062, Message: "Duplicate entry for key 'verifiedToken'"},
+		"SQLite": errors.New("UNIQUE constraint failed: users.verifiedToken"),
+	} {
+		var hashes []string
+		token, err := storeUniqueToken(tokenPurposeVerify, verifyTokenSize, func(tokenHash string) error {
+			hashes = append(hashes, tokenHash)
+			if len(hashes) < 3 {
+				return collision
+			}
+			return nil
+		})
+		if err != nil {
+			t.Fatalf("%s: two collisions then success: got %v", name, err)
+		}
+		if len(hashes) != 3 || hashes[0] == hashes[1] || hashes[2] != hashToken(token) {
+			t.Fatalf("%s: stored %v for token %q, want three different hashes ending with the returned token's", name, hashes, token)
+		}
+		if !strings.HasPrefix(token, tokenPurposeVerify+"_") {
+			t.Fatalf("%s: got token %q, want the purpose prefix", name, token)
+		}
+	}
+}
+
+func TestStoreUniqueTokenGivesUp(t *testing.T) {
+	collision := errors.New("UNIQUE constraint failed: users.verifiedToken")
+	attempts := 0
+	_, err := storeUniqueToken(tokenPurposeVerify, verifyTokenSize, func(tokenHash string) error {
+		attempts++
+		return collision
+	})
+	if err != collision || attempts != maxTokenAttempts {
+		t.Fatalf("every attempt colliding: got %v after %d attempts, want the collision after %d", err, attempts, maxTokenAttempts)
+	}
+
+	//other errors aren't retried
+	failure := errors.New("connection refused")
+	attempts = 0
+	_, err = storeUniqueToken(tokenPurposeVerify, verifyTokenSize, func(tokenHash string) error {
+		attempts++
+		return failure
+	})
+	if err != failure || attempts != 1 {
+		t.Fatalf("store failing: got %v after %d attempts, want the failure after 1", err, attempts)
+	}
+}
@@ -0,0 +1,130 @@
+package api_test
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+	"unicode"
+
+	"github.com/BearCloud/fa20-project-dev/backend/auth-service/api"
+	"github.com/BearCloud/fa20-project-dev/backend/auth-service/apitest"
+)
+
+func TestEmailedTokensStoredHashed(t *testing.T) {
+	env := apitest.New(t)
+	creds := api.Credentials{Username: "bear", Email: "bear@berkeley.edu", Password: "pw"}
+	env.Do(http.MethodPost, "/api/auth/signup", creds)
+	verification, _ := env.Mailer.LastFrom(creds.Email, "user-signup.html")
+
+	var stored string
+	err := env.DB.QueryRow("SELECT verifiedToken FROM users WHERE email = ?;", creds.Email).Scan(&stored)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sum := sha256.Sum256([]byte(verification.Token()))
+	if stored != hex.EncodeToString(sum[:]) {
+		t.Fatalf("stored verification token %q isn't the SHA-256 of the emailed one", stored)
+	}
+	res := env.Do(http.MethodPost, "/api/auth/verify?token="+stored, nil)
+	if res.Code == http.StatusOK {
+		t.Fatalf("verifying with the stored hash was accepted")
+	}
+	res = env.Do(http.MethodPost, "/api/auth/verify?token="+verification.Token(), nil)
+	if res.Code != http.StatusOK {
+		t.Fatalf("verify: got %d %s", res.Code, res.Body.String())
+	}
+
+	reset := requestReset(t, env, creds.Email)
+	err = env.DB.QueryRow("SELECT tokenHash FROM reset_tokens;").Scan(&stored)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sum = sha256.Sum256([]byte(reset))
+	if stored != hex.EncodeToString(sum[:]) {
+		t.Fatalf("stored reset token %q isn't the SHA-256 of the emailed one", stored)
+	}
+	if code := resetTokenStatus(env, stored); code != http.StatusNotFound {
+		t.Fatalf("validating the stored reset hash: got %d, want 404", code)
+	}
+}
+
+//swapCase swaps upper and lower case letters in the random part of token, as a user retyping it might
+func swapCase(token string) string {
+	parts := strings.SplitN(token, "_", 2)
+	return parts[0] + "_" + strings.Map(func(r rune) rune {
+		if unicode.IsUpper(r) {
+			return unicode.ToLower(r)
+		}
+		return unicode.ToUpper(r)
+	}, parts[1])
+}
+
+func TestTokenLookupTrimsButMatchesExactly(t *testing.T) {
+	clock := apitest.NewFakeClock(time.Now())
+	env := apitest.NewWithConfig(t, func(cfg *api.Config) {
+		cfg.Clock = clock
+	})
+	creds := api.Credentials{Username: "bear", Email: "bear@berkeley.edu", Password: "pw"}
+	env.Do(http.MethodPost, "/api/auth/signup", creds)
+	verification, _ := env.Mailer.LastFrom(creds.Email, "user-signup.html")
+
+	res := env.Do(http.MethodPost, "/api/auth/verify?token="+url.QueryEscape(swapCase(verification.Token())), nil)
+	if res.Code != http.StatusNotFound {
+		t.Fatalf("verify with the token's case changed: got %d, want 404", res.Code)
+	}
+	res = env.Do(http.MethodPost, "/api/auth/verify?token="+url.QueryEscape(" "+verification.Token()+"\n"), nil)
+	if res.Code != http.StatusOK {
+		t.Fatalf("verify with whitespace around the token: got %d %s", res.Code, res.Body.String())
+	}
+
+	reset := requestReset(t, env, creds.Email)
+	if code := resetTokenStatus(env, url.QueryEscape("  "+reset+" ")); code != http.StatusOK {
+		t.Fatalf("validating a reset token with whitespace around it: got %d, want 200", code)
+	}
+	if code := resetTokenStatus(env, url.QueryEscape(swapCase(reset))); code != http.StatusNotFound {
+		t.Fatalf("validating a reset token with its case changed: got %d, want 404", code)
+	}
+	clock.Advance(2 * time.Hour)
+	if code := resetTokenStatus(env, url.QueryEscape(" "+reset)); code != http.StatusGone {
+		t.Fatalf("validating an expired reset token with whitespace around it: got %d, want 410", code)
+	}
+}
+
+func TestTokensOnlyWorkForTheirPurpose(t *testing.T) {
+	env := apitest.New(t)
+	bear := api.Credentials{Username: "bear", Email: "bear@berkeley.edu", Password: "pw"}
+	signUpVerified(t, env, bear)
+	reset := requestReset(t, env, bear.Email)
+	tree := api.Credentials{Username: "tree", Email: "tree@stanford.edu", Password: "pw"}
+	env.Do(http.MethodPost, "/api/auth/signup", tree)
+	verification, _ := env.Mailer.LastFrom(tree.Email, "user-signup.html")
+
+	res := env.Do(http.MethodPost, "/api/auth/verify?token="+reset, nil)
+	if res.Code != http.StatusBadRequest {
+		t.Fatalf("verify with a reset token: got %d, want 400", res.Code)
+	}
+	res = env.Do(http.MethodPost, "/api/auth/emails/verify?token="+verification.Token(), nil)
+	if res.Code != http.StatusBadRequest {
+		t.Fatalf("verifying a secondary email with a signup token: got %d, want 400", res.Code)
+	}
+	res = env.Do(http.MethodPost, "/api/auth/resetpw", api.PasswordReset{Token: verification.Token(), NewPassword: "new pw", ConfirmPassword: "new pw"})
+	if res.Code != http.StatusBadRequest {
+		t.Fatalf("resetpw with a verification token: got %d, want 400", res.Code)
+	}
+	if code := resetTokenStatus(env, verification.Token()); code != http.StatusBadRequest {
+		t.Fatalf("validating a verification token as a reset token: got %d, want 400", code)
+	}
+
+	//both tokens still work where they belong
+	if code := resetTokenStatus(env, reset); code != http.StatusOK {
+		t.Fatalf("validating the reset token: got %d, want 200", code)
+	}
+	res = env.Do(http.MethodPost, "/api/auth/verify?token="+verification.Token(), nil)
+	if res.Code != http.StatusOK {
+		t.Fatalf("verify with the verification token: got %d %s", res.Code, res.Body.String())
+	}
+}
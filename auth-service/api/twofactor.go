@@ -0,0 +1,240 @@
+package api
+
+import (
+	"crypto/hmac"
+	"crypto/sha1"
+	"crypto/subtle"
+	"database/sql"
+	"encoding/base32"
+	"encoding/binary"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+const (
+	//totpPeriod is how long each authenticator app code lasts
+	totpPeriod = 30 * time.Second
+	//totpDigits is the length of an authenticator app code
+	totpDigits = 6
+	//totpSkew is how many periods before and after the current one are accepted, for clocks that drift a little
+	totpSkew = 1
+
+	//backupCodeCount is how many backup codes regeneration hands out
+	backupCodeCount = 10
+	//backupCodeSize is the number of random characters in a backup code
+	backupCodeSize = 10
+
+	//auditRegenerateBackupCodes is recorded when a user replaces their backup codes
+	auditRegenerateBackupCodes = "regenerate_backup_codes"
+)
+
+//BackupCodesResponse is the JSON body returned with a fresh set of backup codes. Only their hashes are stored,
+//so this is the one time the user gets to see them.
+type BackupCodesResponse struct {
+	SuccessResponse
+	BackupCodes []string `json:"backupCodes"`
+}
+
+//totpEncoding is how TOTP secrets are written down, base32 without padding as authenticator apps expect
+var totpEncoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+//totpCode is the RFC 6238 code of secret for the period numbered step, with HMAC-SHA1 as authenticator apps use
+func totpCode(secret []byte, step int64) string {
+	var counter [8]byte
+	binary.BigEndian.PutUint64(counter[:], uint64(step))
+	mac := hmac.New(sha1.New, secret)
+	mac.Write(counter[:])
+	sum := mac.Sum(nil)
+	offset := sum[len(sum)-1] & 0x0f
+	value := binary.BigEndian.Uint32(sum[offset:offset+4]) & 0x7fffffff
+	modulus := uint32(1)
+	for i := 0; i < totpDigits; i++ {
+		modulus *= 10
+	}
+	return fmt.Sprintf("%0*d", totpDigits, value%modulus)
+}
+
+//totpStep is the number of the period t falls in
+func totpStep(t time.Time) int64 {
+	return t.Unix() / int64(totpPeriod/time.Second)
+}
+
+//matchTOTP returns the step code is valid for, within totpSkew of now and after lastStep, or 0 if there is none.
+//Refusing steps up to lastStep keeps a code from being used twice.
+func matchTOTP(secret string, code string, lastStep int64) int64 {
+	key, err := totpEncoding.DecodeString(secret)
+	if err != nil {
+		return 0
+	}
+	now := totpStep(clock.Now())
+	for step := now - totpSkew; step <= now+totpSkew; step++ {
+		if step <= lastStep {
+			continue
+		}
+		if subtle.ConstantTimeCompare([]byte(totpCode(key, step)), []byte(code)) == 1 {
+			return step
+		}
+	}
+	return 0
+}
+
+//isTOTPCode reports whether code looks like an authenticator app code rather than a backup code
+func isTOTPCode(code string) bool {
+	if len(code) != totpDigits {
+		return false
+	}
+	for _, c := range code {
+		if c < '0' || c > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+//twoFactorEnabled reports whether signing in to userID needs a second factor
+func twoFactorEnabled(userID string) (bool, error) {
+	var enabled bool
+	err := withRetry(func() error {
+		return DB.QueryRow("SELECT EXISTS(SELECT * FROM users WHERE userId = ? AND twoFactorEnabledAt IS NOT NULL);", userID).Scan(&enabled)
+	})
+	return enabled, err
+}
+
+//acceptSecondFactor checks code, an authenticator app code or a backup code, for userID and uses it up.
+//Each code is only accepted once, even by two signins racing with it.
+func acceptSecondFactor(userID string, code string) (bool, error) {
+	code = strings.TrimSpace(code)
+	if isTOTPCode(code) {
+		var secret sql.NullString
+		var lastStep int64
+		err := withRetry(func() error {
+			return DB.QueryRow("SELECT totpSecret, totpLastStep FROM users WHERE userId = ? AND twoFactorEnabledAt IS NOT NULL;", userID).Scan(&secret, &lastStep)
+		})
+		if err == sql.ErrNoRows {
+			return false, nil
+		}
+		if err != nil {
+			return false, err
+		}
+		step := matchTOTP(secret.String, code, lastStep)
+		if step == 0 {
+			return false, nil
+		}
+		result, err := DB.Exec("UPDATE users SET totpLastStep = ? WHERE userId = ? AND totpLastStep < ?;", step, userID, step)
+		if err != nil {
+			return false, err
+		}
+		used, err := result.RowsAffected()
+		return used == 1, err
+	}
+
+	result, err := DB.Exec("UPDATE backup_codes SET usedAt = ? WHERE userId = ? AND codeHash = ? AND usedAt IS NULL;", clock.Now(), userID, hashToken(code))
+	if err != nil {
+		return false, err
+	}
+	used, err := result.RowsAffected()
+	return used == 1, err
+}
+
+//checkSecondFactor checks the code in credentials once their password for userID proved right, if the account has
+//two-factor authentication on. A missing code gets a 401 with the "2fa" hint, a wrong one counts as a failed signin.
+//It returns the authentication methods used, or false when it answered the request itself.
+func checkSecondFactor(w http.ResponseWriter, r *http.Request, userID string, credentials Credentials) ([]string, bool) {
+	amr := []string{amrPassword}
+	twoFactor, err := twoFactorEnabled(userID)
+	if err != nil {
+		internalError(w, r, "error checking two-factor authentication", err)
+		return nil, false
+	}
+	if !twoFactor {
+		return amr, true
+	}
+	if strings.TrimSpace(credentials.Code) == "" {
+		writeJSON(w, http.StatusUnauthorized, ErrorResponse{
+			Status:        "error",
+			Message:       "enter the code from your authenticator app or a backup code",
+			Hint:          "2fa",
+			CorrelationID: requestIDFromContext(r.Context()),
+		})
+		return nil, false
+	}
+	accepted, err := acceptSecondFactor(userID, credentials.Code)
+	if err != nil {
+		internalError(w, r, "error checking two-factor code", err)
+		return nil, false
+	}
+	if !accepted {
+		signinFailed(w, r, credentials.Email)
+		return nil, false
+	}
+	return append(amr, amrOTP), true
+}
+
+//replaceBackupCodes drops every backup code of userID, used or not, and stores the hashes of a fresh set in tx.
+//It returns the plaintext codes.
+func replaceBackupCodes(tx *sql.Tx, userID string) ([]string, error) {
+	_, err := tx.Exec("DELETE FROM backup_codes WHERE userId = ?;", userID)
+	if err != nil {
+		return nil, err
+	}
+	codes := make([]string, 0, backupCodeCount)
+	for len(codes) < backupCodeCount {
+		code := GetRandomBase62(backupCodeSize)
+		_, err = tx.Exec("INSERT INTO backup_codes (userId, codeHash, createdAt) VALUES (?, ?, ?);", userID, hashToken(code), clock.Now())
+		if err != nil {
+			//the same code came up twice, draw another
+			if isDuplicateKey(err) {
+				continue
+			}
+			return nil, err
+		}
+		codes = append(codes, code)
+	}
+	return codes, nil
+}
+
+//regenerateBackupCodes replaces the user's backup codes with a fresh set, so a user who used or lost some
+//can get new ones. The old codes, used or not, stop working.
+func regenerateBackupCodes(w http.ResponseWriter, r *http.Request) {
+
+	if (*r).Method == "OPTIONS" {
+		return
+	}
+
+	claims, _ := claimsFromContext(r.Context())
+
+	enabled, err := twoFactorEnabled(claims.UserID)
+	if err != nil {
+		internalError(w, r, "error checking two-factor authentication", err)
+		return
+	}
+	if !enabled {
+		writeJSONError(w, r, http.StatusConflict, "two-factor authentication is off")
+		return
+	}
+
+	tx, err := DB.Begin()
+	if err != nil {
+		internalError(w, r, "error creating backup codes", err)
+		return
+	}
+	codes, err := replaceBackupCodes(tx, claims.UserID)
+	if err != nil {
+		tx.Rollback()
+		internalError(w, r, "error creating backup codes", err)
+		return
+	}
+	err = tx.Commit()
+	if err != nil {
+		internalError(w, r, "error creating backup codes", err)
+		return
+	}
+	recordAudit(claims.UserID, auditRegenerateBackupCodes, claims.UserID)
+
+	writeJSON(w, http.StatusOK, BackupCodesResponse{
+		SuccessResponse: SuccessResponse{Status: "ok", Message: "backup codes replaced, the old ones no longer work"},
+		BackupCodes:     codes,
+	})
+}
@@ -0,0 +1,157 @@
+package api_test
+
+import (
+	"crypto/hmac"
+	"crypto/sha1"
+	"encoding/base32"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/BearCloud/fa20-project-dev/backend/auth-service/api"
+	"github.com/BearCloud/fa20-project-dev/backend/auth-service/apitest"
+)
+
+//authenticatorCode is what an authenticator app shows for secret at now, computed independently of the api
+func authenticatorCode(t *testing.T, secret string, now time.Time) string {
+	t.Helper()
+	key, err := base32.StdEncoding.WithPadding(base32.NoPadding).DecodeString(secret)
+	if err != nil {
+		t.Fatalf("decoding secret %q: %v", secret, err)
+	}
+	var counter [8]byte
+	binary.BigEndian.PutUint64(counter[:], uint64(now.Unix()/30))
+	mac := hmac.New(sha1.New, key)
+	mac.Write(counter[:])
+	sum := mac.Sum(nil)
+	offset := sum[len(sum)-1] & 0x0f
+	return fmt.Sprintf("%06d", (binary.BigEndian.Uint32(sum[offset:offset+4])&0x7fffffff)%1000000)
+}
+
+//testTOTPSecret is the authenticator app secret enableTwoFactor stores
+const testTOTPSecret = "JBSWY3DPEHPK3PXPJBSWY3DPEHPK3PXP"
+
+//enableTwoFactor turns on two-factor authentication for the signed in user the way an enrollment outside the
+//service would, and returns the secret and a first set of backup codes
+func enableTwoFactor(t *testing.T, env *apitest.Env, clock *apitest.FakeClock, access *http.Cookie) (string, []string) {
+	t.Helper()
+	_, err := env.DB.Exec("UPDATE users SET totpSecret = ?, twoFactorEnabledAt = ?", testTOTPSecret, clock.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	res := env.Do(http.MethodPost, "/api/auth/2fa/backup", nil, access)
+	if res.Code != http.StatusOK {
+		t.Fatalf("backup codes: got %d %s", res.Code, res.Body.String())
+	}
+	var backup api.BackupCodesResponse
+	json.NewDecoder(res.Body).Decode(&backup)
+	if len(backup.BackupCodes) == 0 {
+		t.Fatalf("no backup codes handed out")
+	}
+	return testTOTPSecret, backup.BackupCodes
+}
+
+func TestSigninWithBackupCode(t *testing.T) {
+	clock := apitest.NewFakeClock(time.Now())
+	env := apitest.NewWithClock(t, clock)
+	creds := api.Credentials{Username: "bear", Email: "bear@berkeley.edu", Password: "pw"}
+	signUpVerified(t, env, creds)
+	access, _ := signIn(t, env, creds)
+	_, codes := enableTwoFactor(t, env, clock, access)
+
+	var stored string
+	env.DB.QueryRow("SELECT codeHash FROM backup_codes LIMIT 1").Scan(&stored)
+	for _, code := range codes {
+		if stored == code {
+			t.Fatalf("backup code stored in plaintext")
+		}
+	}
+
+	res := env.Do(http.MethodPost, "/api/auth/signin", creds)
+	if res.Code != http.StatusUnauthorized {
+		t.Fatalf("signin without a code: got %d, want 401", res.Code)
+	}
+	var missing api.ErrorResponse
+	json.NewDecoder(res.Body).Decode(&missing)
+	if missing.Hint != "2fa" {
+		t.Fatalf("signin without a code: got hint %q, want 2fa", missing.Hint)
+	}
+
+	withCode := creds
+	withCode.Code = codes[0]
+	signIn(t, env, withCode)
+
+	res = env.Do(http.MethodPost, "/api/auth/signin", withCode)
+	if res.Code != http.StatusUnauthorized {
+		t.Fatalf("signin reusing a backup code: got %d, want 401", res.Code)
+	}
+
+	withCode.Code = codes[1]
+	signIn(t, env, withCode)
+}
+
+func TestSigninWithAuthenticatorCode(t *testing.T) {
+	clock := apitest.NewFakeClock(time.Now())
+	env := apitest.NewWithClock(t, clock)
+	creds := api.Credentials{Username: "bear", Email: "bear@berkeley.edu", Password: "pw"}
+	signUpVerified(t, env, creds)
+	access, _ := signIn(t, env, creds)
+	secret, _ := enableTwoFactor(t, env, clock, access)
+
+	withCode := creds
+	withCode.Code = authenticatorCode(t, secret, clock.Now())
+	signIn(t, env, withCode)
+	res := env.Do(http.MethodPost, "/api/auth/signin", withCode)
+	if res.Code != http.StatusUnauthorized {
+		t.Fatalf("signin reusing an authenticator code: got %d, want 401", res.Code)
+	}
+
+	wrong := creds
+	wrong.Password = "wrong"
+	clock.Advance(30 * time.Second)
+	wrong.Code = authenticatorCode(t, secret, clock.Now())
+	res = env.Do(http.MethodPost, "/api/auth/signin", wrong)
+	if res.Code != http.StatusUnauthorized {
+		t.Fatalf("signin with a wrong password and a right code: got %d, want 401", res.Code)
+	}
+}
+
+func TestRegenerateBackupCodes(t *testing.T) {
+	clock := apitest.NewFakeClock(time.Now())
+	env := apitest.NewWithClock(t, clock)
+	creds := api.Credentials{Username: "bear", Email: "bear@berkeley.edu", Password: "pw"}
+	signUpVerified(t, env, creds)
+	access, _ := signIn(t, env, creds)
+
+	res := env.Do(http.MethodPost, "/api/auth/2fa/backup", nil, access)
+	if res.Code != http.StatusConflict {
+		t.Fatalf("regenerating without two-factor authentication: got %d, want 409", res.Code)
+	}
+
+	_, old := enableTwoFactor(t, env, clock, access)
+	res = env.Do(http.MethodPost, "/api/auth/2fa/backup", nil, access)
+	if res.Code != http.StatusOK {
+		t.Fatalf("regenerate: got %d %s", res.Code, res.Body.String())
+	}
+	var fresh api.BackupCodesResponse
+	json.NewDecoder(res.Body).Decode(&fresh)
+
+	withCode := creds
+	withCode.Code = old[0]
+	res = env.Do(http.MethodPost, "/api/auth/signin", withCode)
+	if res.Code != http.StatusUnauthorized {
+		t.Fatalf("signin with a replaced backup code: got %d, want 401", res.Code)
+	}
+	withCode.Code = fresh.BackupCodes[0]
+	access, _ = signIn(t, env, withCode)
+
+	res = env.Do(http.MethodGet, "/api/auth/security", nil, access)
+	var summary api.SecurityResponse
+	json.NewDecoder(res.Body).Decode(&summary)
+	if !summary.TwoFactorEnabled || !summary.RecoveryCodesRemain {
+		t.Fatalf("security summary: got twoFactorEnabled %v, recoveryCodesRemain %v", summary.TwoFactorEnabled, summary.RecoveryCodesRemain)
+	}
+}
@@ -0,0 +1,133 @@
+package api
+
+import (
+	"database/sql"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+const (
+	//minUserSearchLength is the shortest prefix the admin user search accepts, shorter ones match too much of the table
+	minUserSearchLength = 3
+	//defaultUserPageSize is how many users a page holds when no limit is given
+	defaultUserPageSize = 50
+	//maxUserPageSize caps the limit a client can ask for
+	maxUserPageSize = 200
+	//likeEscape escapes LIKE wildcards in search input. "\" would need escaping itself in MySQL but not in SQLite.
+	likeEscape = "!"
+)
+
+//AdminUser is an account as listed to admins
+type AdminUser struct {
+	UserID    string     `json:"userId"`
+	Username  string     `json:"username"`
+	Email     string     `json:"email"`
+	Verified  bool       `json:"verified"`
+	Role      string     `json:"role"`
+	CreatedAt *time.Time `json:"createdAt,omitempty"`
+	DeletedAt *time.Time `json:"deletedAt,omitempty"`
+}
+
+//UserPageResponse is the JSON body returned when listing users.
+//NextCursor is empty on the last page.
+type UserPageResponse struct {
+	SuccessResponse
+	Users      []AdminUser `json:"users"`
+	NextCursor string      `json:"nextCursor,omitempty"`
+}
+
+//likePrefix turns prefix into a LIKE pattern matching values that start with it, with its own wildcards escaped
+func likePrefix(prefix string) string {
+	return strings.NewReplacer(likeEscape, likeEscape+likeEscape, "%", likeEscape+"%", "_", likeEscape+"_").Replace(prefix) + "%"
+}
+
+func listUsers(w http.ResponseWriter, r *http.Request) {
+
+	if (*r).Method == "OPTIONS" {
+		return
+	}
+
+	query := r.URL.Query()
+	conditions := []string{}
+	args := []interface{}{}
+
+	//q matches the start of the username or primary email, which the users_username and users_email indexes serve
+	if q := strings.TrimSpace(query.Get("q")); q != "" {
+		if utf8.RuneCountInString(q) < minUserSearchLength {
+			writeJSONError(w, r, http.StatusBadRequest, "q must be at least "+strconv.Itoa(minUserSearchLength)+" characters")
+			return
+		}
+		pattern := likePrefix(q)
+		conditions = append(conditions, "(username LIKE ? ESCAPE '"+likeEscape+"' OR email LIKE ? ESCAPE '"+likeEscape+"')")
+		args = append(args, pattern, pattern)
+	}
+
+	//the cursor is the id of the last user on the previous page, pages are ordered by username then id
+	if cursor := query.Get("cursor"); cursor != "" {
+		conditions = append(conditions, "(username, userId) > ((SELECT username FROM users WHERE userId = ?), ?)")
+		args = append(args, cursor, cursor)
+	}
+
+	limit := defaultUserPageSize
+	if value := query.Get("limit"); value != "" {
+		var err error
+		limit, err = strconv.Atoi(value)
+		if err != nil || limit < 1 {
+			writeJSONError(w, r, http.StatusBadRequest, "limit must be a positive number")
+			return
+		}
+		if limit > maxUserPageSize {
+			limit = maxUserPageSize
+		}
+	}
+
+	statement := "SELECT userId, username, email, verified, role, createdAt, deletedAt FROM users"
+	if len(conditions) > 0 {
+		statement += " WHERE " + strings.Join(conditions, " AND ")
+	}
+	//fetch one extra row to learn whether there is another page
+	statement += " ORDER BY username, userId LIMIT ?;"
+	args = append(args, limit+1)
+
+	rows, err := DB.Query(statement, args...)
+	if err != nil {
+		internalError(w, r, "error retrieving users", err)
+		return
+	}
+	defer rows.Close()
+
+	users := []AdminUser{}
+	for rows.Next() {
+		var user AdminUser
+		var verified sql.NullBool
+		var createdAt, deletedAt sql.NullTime
+		err = rows.Scan(&user.UserID, &user.Username, &user.Email, &verified, &user.Role, &createdAt, &deletedAt)
+		if err != nil {
+			internalError(w, r, "error retrieving users", err)
+			return
+		}
+		user.Verified = verified.Bool
+		if createdAt.Valid {
+			user.CreatedAt = &createdAt.Time
+		}
+		if deletedAt.Valid {
+			user.DeletedAt = &deletedAt.Time
+		}
+		users = append(users, user)
+	}
+	if err = rows.Err(); err != nil {
+		internalError(w, r, "error retrieving users", err)
+		return
+	}
+
+	response := UserPageResponse{SuccessResponse: SuccessResponse{Status: "ok", Message: "users retrieved"}}
+	if len(users) > limit {
+		users = users[:limit]
+		response.NextCursor = users[limit-1].UserID
+	}
+	response.Users = users
+	writeJSON(w, http.StatusOK, response)
+}
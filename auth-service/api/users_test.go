@@ -0,0 +1,62 @@
+package api_test
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"testing"
+
+	"github.com/BearCloud/fa20-project-dev/backend/auth-service/api"
+	"github.com/BearCloud/fa20-project-dev/backend/auth-service/apitest"
+)
+
+//searchUsers lists the users matching q as admin and returns their usernames
+func searchUsers(t *testing.T, env *apitest.Env, admin *http.Cookie, q string) []string {
+	t.Helper()
+	res := env.Do(http.MethodGet, "/api/auth/admin/users?q="+url.QueryEscape(q), nil, admin)
+	if res.Code != http.StatusOK {
+		t.Fatalf("searching users for %q: got %d %s", q, res.Code, res.Body.String())
+	}
+	var page api.UserPageResponse
+	json.NewDecoder(res.Body).Decode(&page)
+	usernames := []string{}
+	for _, user := range page.Users {
+		usernames = append(usernames, user.Username)
+	}
+	return usernames
+}
+
+func TestAdminUserSearchByPrefix(t *testing.T) {
+	env := apitest.New(t)
+	admin := signInAdmin(t, env, api.Credentials{Username: "oski", Email: "oski@berkeley.edu", Password: "pw"})
+	for _, creds := range []api.Credentials{
+		{Username: "bear", Email: "bear@berkeley.edu", Password: "pw"},
+		{Username: "beard", Email: "beard@stanford.edu", Password: "pw"},
+		{Username: "tree", Email: "golden@stanford.edu", Password: "pw"},
+		{Username: "b%ar", Email: "percent@berkeley.edu", Password: "pw"},
+	} {
+		signUpVerified(t, env, creds)
+	}
+
+	for q, want := range map[string]string{
+		"bea":    "[bear beard]",
+		"beard":  "[beard]",
+		"golden": "[tree]",
+		"ear":    "[]",
+		"b%a":    "[b%ar]",
+		"b_a":    "[]",
+		"nobody": "[]",
+	} {
+		if got := searchUsers(t, env, admin, q); fmt.Sprint(got) != want {
+			t.Errorf("searching for %q: got %v, want %s", q, got, want)
+		}
+	}
+
+	for _, q := range []string{"be", "  b  "} {
+		res := env.Do(http.MethodGet, "/api/auth/admin/users?q="+url.QueryEscape(q), nil, admin)
+		if res.Code != http.StatusBadRequest {
+			t.Fatalf("searching for %q: got %d, want 400", q, res.Code)
+		}
+	}
+}
@@ -0,0 +1,155 @@
+//Package apitest runs the auth api against an in-memory SQLite database, a mock mailer, a mock SMS sender and a mock
+//event publisher, so handlers can be tested end to end without MySQL, SendGrid, Twilio or a message queue. Failed
+//signins record their backoff delay in a MockSleeper instead of waiting it out.
+//
+//The api keeps its database, mailer and publisher in package variables, so tests using an Env must not run in parallel.
+//Env.UseMemoryStore swaps the users and reset token tables for a MemoryStore, for tests of the handler logic alone.
+//
+//	env := apitest.New(t)
+//	res := env.Do(http.MethodPost, "/api/auth/signup", api.Credentials{Username: "bear", Email: "bear@berkeley.edu", Password: "pw"})
+//	email, _ := env.Mailer.LastFrom("bear@berkeley.edu", "user-signup.html")
+//	res = env.Do(http.MethodPost, "/api/auth/verify?token="+email.Token(), nil)
+package apitest
+
+import (
+	"bytes"
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/BearCloud/fa20-project-dev/backend/auth-service/api"
+	"github.com/gorilla/mux"
+	"golang.org/x/crypto/bcrypt"
+)
+
+//Env is the auth api wired to an in-memory database, a mock mailer, a mock SMS sender and a mock event publisher
+type Env struct {
+	DB        *sql.DB
+	Mailer    *MockMailer
+	SMS       *MockSMSSender
+	Publisher *MockPublisher
+	Sleeper   *MockSleeper
+	//Clock is the Clock the api was configured with
+	Clock   api.Clock
+	Handler http.Handler
+}
+
+//NewDB opens an in-memory SQLite database, migrates it to the auth schema with db-server/migrations and closes it
+//when the test ends
+func NewDB(t testing.TB) *sql.DB {
+	t.Helper()
+	db, err := sql.Open(sqliteDriver, ":memory:")
+	if err != nil {
+		t.Fatalf("opening sqlite: %v", err)
+	}
+	//every connection to ":memory:" gets its own empty database, so keep just one
+	db.SetMaxOpenConns(1)
+	t.Cleanup(func() { db.Close() })
+
+	err = migrate(db)
+	if err != nil {
+		t.Fatalf("migrating schema: %v", err)
+	}
+	return db
+}
+
+//New configures the api for testing, points it at a fresh database, mock mailer, mock SMS sender and mock publisher
+//and registers its routes
+func New(t testing.TB) *Env {
+	t.Helper()
+	return NewWithClock(t, api.RealClock{})
+}
+
+//NewWithClock is New with the api reading the time from clock, usually a FakeClock, so a test can
+//move past expiries and cooldowns without waiting
+//
+//	clock := apitest.NewFakeClock(time.Now())
+//	env := apitest.NewWithClock(t, clock)
+//	clock.Advance(2 * time.Hour)
+func NewWithClock(t testing.TB, clock api.Clock) *Env {
+	t.Helper()
+	return NewWithConfig(t, func(cfg *api.Config) {
+		cfg.Clock = clock
+	})
+}
+
+//NewWithConfig is New with configure changing the test configuration before it is applied, to try out a setting
+//without touching the environment. Every Env starts from LoadConfig's defaults and the environment, whatever
+//an earlier Env applied.
+//
+//	env := apitest.NewWithConfig(t, func(cfg *api.Config) { cfg.RateLimit = 2 })
+func NewWithConfig(t testing.TB, configure func(cfg *api.Config)) *Env {
+	t.Helper()
+	cfg := api.LoadConfig()
+	cfg.JWTSecret = "apitest-secret"
+	cfg.SendGridKey = "apitest-key"
+	//the cheapest cost keeps signups fast in tests
+	cfg.BcryptCost = bcrypt.MinCost
+	cfg.Clock = api.RealClock{}
+	configure(&cfg)
+	err := api.ApplyConfig(cfg)
+	if err != nil {
+		t.Fatalf("configuring api: %v", err)
+	}
+
+	env := &Env{DB: NewDB(t), Mailer: &MockMailer{}, SMS: &MockSMSSender{}, Publisher: &MockPublisher{}, Sleeper: &MockSleeper{}, Clock: cfg.Clock}
+	api.DB = env.DB
+	api.SetMailer(env.Mailer)
+	api.SetSMSSender(env.SMS)
+	api.SetEventPublisher(env.Publisher)
+	api.SetSigninSleeper(env.Sleeper.Sleep)
+	api.SetUserStore(api.SQLUserStore{})
+
+	router := mux.NewRouter()
+	err = api.RegisterRoutes(router)
+	if err != nil {
+		t.Fatalf("registering routes: %v", err)
+	}
+	env.Handler = api.Middleware(router)
+	return env
+}
+
+//Do sends a request to the api, encoding body as JSON unless it is nil or a string, and returns the recorded response.
+//A string body is sent as it is, for malformed JSON and other text.
+func (env *Env) Do(method string, path string, body interface{}, cookies ...*http.Cookie) *httptest.ResponseRecorder {
+	return env.Send(env.Request(method, path, body, cookies...))
+}
+
+//Request builds the request Do would send, for a test that sets a header or the client address before it calls Send
+//
+//	req := env.Request(http.MethodPost, "/api/auth/signin", creds)
+//	req.Header.Set("Origin", "https://mixtape.example")
+//	res := env.Send(req)
+func (env *Env) Request(method string, path string, body interface{}, cookies ...*http.Cookie) *http.Request {
+	var buf bytes.Buffer
+	if text, ok := body.(string); ok {
+		buf.WriteString(text)
+	} else if body != nil {
+		json.NewEncoder(&buf).Encode(body)
+	}
+	req := httptest.NewRequest(method, path, &buf)
+	req.Header.Set("Content-Type", "application/json")
+	for _, cookie := range cookies {
+		req.AddCookie(cookie)
+	}
+	return req
+}
+
+//Send sends req to the api and returns the recorded response
+func (env *Env) Send(req *http.Request) *httptest.ResponseRecorder {
+	res := httptest.NewRecorder()
+	env.Handler.ServeHTTP(res, req)
+	return res
+}
+
+//Cookie returns the cookie named name set by res, or nil if there is none
+func Cookie(res *httptest.ResponseRecorder, name string) *http.Cookie {
+	for _, cookie := range res.Result().Cookies() {
+		if cookie.Name == name {
+			return cookie
+		}
+	}
+	return nil
+}
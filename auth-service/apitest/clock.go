@@ -0,0 +1,38 @@
+package apitest
+
+import (
+	"sync"
+	"time"
+)
+
+//FakeClock is an api.Clock that stands still until Advance or Set moves it
+type FakeClock struct {
+	mu  sync.Mutex
+	now time.Time
+}
+
+//NewFakeClock returns a FakeClock reading now
+func NewFakeClock(now time.Time) *FakeClock {
+	return &FakeClock{now: now}
+}
+
+//Now returns the time the clock was last set or advanced to
+func (c *FakeClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+//Advance moves the clock forward by d
+func (c *FakeClock) Advance(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.now = c.now.Add(d)
+}
+
+//Set moves the clock to now
+func (c *FakeClock) Set(now time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.now = now
+}
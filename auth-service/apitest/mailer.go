@@ -0,0 +1,107 @@
+package apitest
+
+import (
+	"context"
+	"net/url"
+	"sync"
+	"time"
+)
+
+//SentEmail is an email captured by MockMailer
+type SentEmail struct {
+	Recipient    string
+	FromName     string
+	Subject      string
+	TemplatePath string
+	Data         map[string]interface{}
+}
+
+//Token returns the verification or reset token the email carried, or from the token parameter of its link when
+//it only carried a link, or "" if it had neither
+func (email SentEmail) Token() string {
+	if token, ok := email.Data["Token"].(string); ok {
+		return token
+	}
+	link, _ := email.Data["Link"].(string)
+	parsed, err := url.Parse(link)
+	if err != nil {
+		return ""
+	}
+	return parsed.Query().Get("token")
+}
+
+//MockMailer records emails instead of sending them. Set Err to make every send fail.
+type MockMailer struct {
+	mu   sync.Mutex
+	sent []SentEmail
+	Err  error
+}
+
+//SendEmail records the email, or returns Err if it is set
+func (m *MockMailer) SendEmail(ctx context.Context, recipient string, fromName string, subject string, templatePath string, data map[string]interface{}) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.Err != nil {
+		return m.Err
+	}
+	m.sent = append(m.sent, SentEmail{Recipient: recipient, FromName: fromName, Subject: subject, TemplatePath: templatePath, Data: data})
+	return nil
+}
+
+//Sent returns every email recorded so far, oldest first
+func (m *MockMailer) Sent() []SentEmail {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return append([]SentEmail{}, m.sent...)
+}
+
+//LastTo returns the most recent email sent to recipient
+func (m *MockMailer) LastTo(recipient string) (SentEmail, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for i := len(m.sent) - 1; i >= 0; i-- {
+		if m.sent[i].Recipient == recipient {
+			return m.sent[i], true
+		}
+	}
+	return SentEmail{}, false
+}
+
+//LastFrom returns the most recent email rendered from templatePath sent to recipient. Use it rather than LastTo to
+//find a token, so a later email of another kind can't stand in for the one a test waits on.
+func (m *MockMailer) LastFrom(recipient string, templatePath string) (SentEmail, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for i := len(m.sent) - 1; i >= 0; i-- {
+		if m.sent[i].Recipient == recipient && m.sent[i].TemplatePath == templatePath {
+			return m.sent[i], true
+		}
+	}
+	return SentEmail{}, false
+}
+
+//Count returns how many emails rendered from templatePath were sent to recipient
+func (m *MockMailer) Count(recipient string, templatePath string) int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	count := 0
+	for _, email := range m.sent {
+		if email.Recipient == recipient && email.TemplatePath == templatePath {
+			count++
+		}
+	}
+	return count
+}
+
+//WaitFor returns the most recent email rendered from templatePath sent to recipient. Welcome emails are sent in the
+//background, so it keeps looking until timeout passes.
+func (m *MockMailer) WaitFor(recipient string, templatePath string, timeout time.Duration) (SentEmail, bool) {
+	deadline := time.Now().Add(timeout)
+	for {
+		email, ok := m.LastFrom(recipient, templatePath)
+		if ok || time.Now().After(deadline) {
+			return email, ok
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
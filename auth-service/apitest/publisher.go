@@ -0,0 +1,58 @@
+package apitest
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/BearCloud/fa20-project-dev/backend/auth-service/api"
+)
+
+//PublishedEvent is an event captured by MockPublisher
+type PublishedEvent struct {
+	Topic string
+	Event api.Event
+}
+
+//MockPublisher records events instead of publishing them. Set Err to make every publish fail.
+type MockPublisher struct {
+	mu        sync.Mutex
+	published []PublishedEvent
+	Err       error
+}
+
+//Publish records the event, or returns Err if it is set
+func (p *MockPublisher) Publish(ctx context.Context, topic string, event api.Event) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.Err != nil {
+		return p.Err
+	}
+	p.published = append(p.published, PublishedEvent{Topic: topic, Event: event})
+	return nil
+}
+
+//Published returns every event recorded so far, oldest first
+func (p *MockPublisher) Published() []PublishedEvent {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return append([]PublishedEvent{}, p.published...)
+}
+
+//WaitFor returns the most recent event of eventType about userID. Events are published in the background,
+//so it keeps looking until timeout passes.
+func (p *MockPublisher) WaitFor(eventType string, userID string, timeout time.Duration) (PublishedEvent, bool) {
+	deadline := time.Now().Add(timeout)
+	for {
+		published := p.Published()
+		for i := len(published) - 1; i >= 0; i-- {
+			if published[i].Event.Type == eventType && published[i].Event.UserID == userID {
+				return published[i], true
+			}
+		}
+		if time.Now().After(deadline) {
+			return PublishedEvent{}, false
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
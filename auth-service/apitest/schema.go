@@ -0,0 +1,253 @@
+package apitest
+
+import (
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"regexp"
+	"runtime"
+	"sort"
+	"strings"
+
+	"github.com/mattn/go-sqlite3"
+)
+
+//sqliteDriver is SQLite with the MySQL functions the migrations call
+const sqliteDriver = "sqlite3_mysql"
+
+func init() {
+	sql.Register(sqliteDriver, &sqlite3.SQLiteDriver{
+		ConnectHook: func(conn *sqlite3.SQLiteConn) error {
+			return conn.RegisterFunc("SHA2", sha2, true)
+		},
+	})
+}
+
+//sha2 is MySQL's SHA2, lowercase hex like hashToken in the api. Only SHA-256 is needed.
+func sha2(value string, bits int) (string, error) {
+	if bits != 256 {
+		return "", fmt.Errorf("SHA2 with %d bits isn't supported", bits)
+	}
+	sum := sha256.Sum256([]byte(value))
+	return hex.EncodeToString(sum[:]), nil
+}
+
+//originalUsers is the auth users table of the original db-server/initdb.sql, which 000_baseline.sql migrates from
+const originalUsers = `CREATE TABLE users (
+    username VARCHAR(20),
+    email VARCHAR(320),
+    hashedPassword TEXT,
+    verified boolean,
+    resetToken TEXT,
+    verifiedToken TEXT,
+    userId VARCHAR(128) PRIMARY KEY
+);`
+
+var (
+	commentPattern      = regexp.MustCompile(`(?m)^\s*--.*$`)
+	alterTablePattern   = regexp.MustCompile(`(?is)^ALTER TABLE (\w+) (.*)$`)
+	addColumnPattern    = regexp.MustCompile(`(?is)^ADD COLUMN (\w+ .*?)(?: AFTER \w+)?$`)
+	dropColumnPattern   = regexp.MustCompile(`(?i)^DROP COLUMN (\w+)$`)
+	modifyPattern       = regexp.MustCompile(`(?is)^MODIFY (\w+) (.*)$`)
+	addIndexPattern     = regexp.MustCompile(`(?is)^ADD (UNIQUE )?INDEX (\w+) (\(.*\))$`)
+	addPrimaryPattern   = regexp.MustCompile(`(?is)^ADD PRIMARY KEY \(.*\)$`)
+	dropPrimaryPattern  = regexp.MustCompile(`(?i)^DROP PRIMARY KEY$`)
+	columnPrimaryKey    = regexp.MustCompile(`(?i) PRIMARY KEY`)
+	autoIncrementColumn = regexp.MustCompile(`(?i)\bINT AUTO_INCREMENT PRIMARY KEY\b`)
+)
+
+//migrationsDir is db-server/migrations, found from the path of this file so any package's tests can use it
+func migrationsDir() string {
+	_, file, _, _ := runtime.Caller(0)
+	return filepath.Join(filepath.Dir(file), "..", "..", "db-server", "migrations")
+}
+
+//migrate creates the original users table on db and applies every migration in db-server/migrations to it in
+//order, so the tests run against the schema a deployed database has
+func migrate(db *sql.DB) error {
+	_, err := db.Exec(originalUsers)
+	if err != nil {
+		return err
+	}
+	files, err := filepath.Glob(filepath.Join(migrationsDir(), "*.sql"))
+	if err != nil {
+		return err
+	}
+	if len(files) == 0 {
+		return fmt.Errorf("no migrations in %s", migrationsDir())
+	}
+	sort.Strings(files)
+	for _, file := range files {
+		migration, err := ioutil.ReadFile(file)
+		if err != nil {
+			return err
+		}
+		for _, statement := range splitStatements(string(migration)) {
+			err = execMySQL(db, statement)
+			if err != nil {
+				return fmt.Errorf("%s: %v\n%s", filepath.Base(file), err, statement)
+			}
+		}
+	}
+	return nil
+}
+
+//splitStatements returns the statements of a migration without its comments and USE lines
+func splitStatements(migration string) []string {
+	var statements []string
+	for _, statement := range strings.Split(commentPattern.ReplaceAllString(migration, ""), ";") {
+		statement = strings.TrimSpace(statement)
+		if statement == "" || strings.HasPrefix(strings.ToUpper(statement), "USE ") {
+			continue
+		}
+		statements = append(statements, statement)
+	}
+	return statements
+}
+
+//splitTopLevel splits list on the commas outside parentheses
+func splitTopLevel(list string) []string {
+	var parts []string
+	depth, start := 0, 0
+	for i, c := range list {
+		switch c {
+		case '(':
+			depth++
+		case ')':
+			depth--
+		case ',':
+			if depth == 0 {
+				parts = append(parts, strings.TrimSpace(list[start:i]))
+				start = i + 1
+			}
+		}
+	}
+	return append(parts, strings.TrimSpace(list[start:]))
+}
+
+//execMySQL runs a MySQL statement of a migration on SQLite. ALTER TABLE is split into the one change at a time
+//SQLite allows, and the changes SQLite can't make in place rebuild the table.
+func execMySQL(db *sql.DB, statement string) error {
+	statement = strings.Replace(statement, "INSERT IGNORE", "INSERT OR IGNORE", -1)
+	statement = strings.Replace(statement, "NOW()", "CURRENT_TIMESTAMP", -1)
+	statement = autoIncrementColumn.ReplaceAllString(statement, "INTEGER PRIMARY KEY AUTOINCREMENT")
+
+	alter := alterTablePattern.FindStringSubmatch(statement)
+	if alter == nil {
+		_, err := db.Exec(statement)
+		return err
+	}
+	table := alter[1]
+	for _, change := range splitTopLevel(alter[2]) {
+		var err error
+		if match := addColumnPattern.FindStringSubmatch(change); match != nil {
+			_, err = db.Exec("ALTER TABLE " + table + " ADD COLUMN " + match[1] + ";")
+		} else if match := addIndexPattern.FindStringSubmatch(change); match != nil {
+			//index names are per table in MySQL but per database in SQLite
+			_, err = db.Exec("CREATE " + match[1] + "INDEX " + table + "_" + match[2] + " ON " + table + " " + match[3] + ";")
+		} else if match := dropColumnPattern.FindStringSubmatch(change); match != nil {
+			err = rebuildTable(db, table, func(definitions []string) []string {
+				return removeColumn(definitions, match[1])
+			})
+		} else if match := modifyPattern.FindStringSubmatch(change); match != nil {
+			err = rebuildTable(db, table, func(definitions []string) []string {
+				return append(removeColumn(definitions, match[1]), match[1]+" "+match[2])
+			})
+		} else if dropPrimaryPattern.MatchString(change) {
+			err = rebuildTable(db, table, func(definitions []string) []string {
+				var kept []string
+				for _, definition := range definitions {
+					if !strings.HasPrefix(strings.ToUpper(definition), "PRIMARY KEY") {
+						kept = append(kept, columnPrimaryKey.ReplaceAllString(definition, ""))
+					}
+				}
+				return kept
+			})
+		} else if addPrimaryPattern.MatchString(change) {
+			err = rebuildTable(db, table, func(definitions []string) []string {
+				return append(definitions, change[len("ADD "):])
+			})
+		} else {
+			err = fmt.Errorf("no SQLite translation for %q", change)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+//removeColumn returns the column and constraint definitions of a table without column
+func removeColumn(definitions []string, column string) []string {
+	var kept []string
+	for _, definition := range definitions {
+		if fields := strings.Fields(definition); !strings.EqualFold(fields[0], column) {
+			kept = append(kept, definition)
+		}
+	}
+	return kept
+}
+
+//rebuildTable recreates table with the definitions change returns, keeping its rows and indexes.
+//A modified column moves to the end of the table, which doesn't matter to queries naming their columns.
+func rebuildTable(db *sql.DB, table string, change func(definitions []string) []string) error {
+	var create string
+	err := db.QueryRow("SELECT sql FROM sqlite_master WHERE type = 'table' AND name = ?;", table).Scan(&create)
+	if err != nil {
+		return err
+	}
+	definitions := change(splitTopLevel(create[strings.Index(create, "(")+1 : strings.LastIndex(create, ")")]))
+
+	indexes, err := db.Query("SELECT sql FROM sqlite_master WHERE type = 'index' AND tbl_name = ? AND sql IS NOT NULL;", table)
+	if err != nil {
+		return err
+	}
+	var recreate []string
+	for indexes.Next() {
+		var index string
+		err = indexes.Scan(&index)
+		if err != nil {
+			indexes.Close()
+			return err
+		}
+		recreate = append(recreate, index)
+	}
+	indexes.Close()
+
+	rows, err := db.Query("SELECT * FROM " + table + " LIMIT 0;")
+	if err != nil {
+		return err
+	}
+	old, err := rows.Columns()
+	rows.Close()
+	if err != nil {
+		return err
+	}
+	var copied []string
+	for _, column := range old {
+		for _, definition := range definitions {
+			if strings.EqualFold(strings.Fields(definition)[0], column) {
+				copied = append(copied, column)
+				break
+			}
+		}
+	}
+
+	columns := strings.Join(copied, ", ")
+	statements := []string{
+		"CREATE TABLE " + table + "_rebuilt (\n    " + strings.Join(definitions, ",\n    ") + "\n);",
+		"INSERT INTO " + table + "_rebuilt (" + columns + ") SELECT " + columns + " FROM " + table + ";",
+		"DROP TABLE " + table + ";",
+		"ALTER TABLE " + table + "_rebuilt RENAME TO " + table + ";",
+	}
+	for _, statement := range append(statements, recreate...) {
+		_, err = db.Exec(statement)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,37 @@
+package apitest
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+//MockSleeper records the delays failed signins would wait, without waiting
+type MockSleeper struct {
+	mu     sync.Mutex
+	delays []time.Duration
+}
+
+//Sleep records d and returns at once
+func (m *MockSleeper) Sleep(ctx context.Context, d time.Duration) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.delays = append(m.delays, d)
+}
+
+//Delays returns every delay recorded so far, oldest first
+func (m *MockSleeper) Delays() []time.Duration {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return append([]time.Duration{}, m.delays...)
+}
+
+//Last returns the most recent delay, or 0 if none was recorded
+func (m *MockSleeper) Last() time.Duration {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if len(m.delays) == 0 {
+		return 0
+	}
+	return m.delays[len(m.delays)-1]
+}
@@ -0,0 +1,61 @@
+package apitest
+
+import (
+	"context"
+	"net/url"
+	"strings"
+	"sync"
+)
+
+//SentSMS is a text message captured by MockSMSSender
+type SentSMS struct {
+	Phone   string
+	Message string
+}
+
+//Token returns the verification or reset token in the message's link, or "" if it had none
+func (sms SentSMS) Token() string {
+	for _, word := range strings.Fields(sms.Message) {
+		if link, err := url.Parse(word); err == nil && link.Query().Get("token") != "" {
+			return link.Query().Get("token")
+		}
+	}
+	return ""
+}
+
+//MockSMSSender records text messages instead of sending them. Set Err to make every send fail.
+type MockSMSSender struct {
+	mu   sync.Mutex
+	sent []SentSMS
+	Err  error
+}
+
+//SendSMS records the message, or returns Err if it is set
+func (m *MockSMSSender) SendSMS(ctx context.Context, phone string, message string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.Err != nil {
+		return m.Err
+	}
+	m.sent = append(m.sent, SentSMS{Phone: phone, Message: message})
+	return nil
+}
+
+//Sent returns every message recorded so far, oldest first
+func (m *MockSMSSender) Sent() []SentSMS {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return append([]SentSMS{}, m.sent...)
+}
+
+//LastTo returns the most recent message sent to phone
+func (m *MockSMSSender) LastTo(phone string) (SentSMS, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for i := len(m.sent) - 1; i >= 0; i-- {
+		if m.sent[i].Phone == phone {
+			return m.sent[i], true
+		}
+	}
+	return SentSMS{}, false
+}
@@ -0,0 +1,216 @@
+package apitest
+
+import (
+	"errors"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/BearCloud/fa20-project-dev/backend/auth-service/api"
+)
+
+//errDuplicate reads like SQLite's unique constraint error, so the api retries with a fresh token
+var errDuplicate = errors.New("UNIQUE constraint failed")
+
+//MemoryStore is an api.UserStore kept in maps, for testing handler logic without the users and reset_tokens tables.
+//It has no secondary emails, so accounts sign in with their primary address only.
+type MemoryStore struct {
+	//Clock is what reset token expiries are checked against, RealClock unless set
+	Clock api.Clock
+
+	mu          sync.Mutex
+	users       map[string]*api.User
+	verifyToken map[string]string
+	resetTokens map[string]memoryResetToken
+}
+
+//memoryResetToken is a reset token stored by MemoryStore
+type memoryResetToken struct {
+	userID    string
+	expiresAt time.Time
+}
+
+//NewMemoryStore returns an empty MemoryStore
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{Clock: api.RealClock{}, users: map[string]*api.User{}, verifyToken: map[string]string{}, resetTokens: map[string]memoryResetToken{}}
+}
+
+//UseMemoryStore points the api at a new MemoryStore reading the env's clock and returns it. New puts the SQL
+//store back.
+func (env *Env) UseMemoryStore() *MemoryStore {
+	store := NewMemoryStore()
+	store.Clock = env.Clock
+	api.SetUserStore(store)
+	return store
+}
+
+//find returns a copy of the first account matching, or api.ErrNotFound
+func (store *MemoryStore) find(match func(user *api.User) bool) (api.User, error) {
+	for _, user := range store.users {
+		if match(user) {
+			return *user, nil
+		}
+	}
+	return api.User{}, api.ErrNotFound
+}
+
+//preferred returns a copy of the account with email that api.SQLUserStore would pick, or api.ErrNotFound
+func (store *MemoryStore) preferred(email string) (api.User, error) {
+	var best *api.User
+	for _, user := range store.users {
+		if !strings.EqualFold(user.Email, email) {
+			continue
+		}
+		if best == nil || user.Verified && !best.Verified || user.Verified == best.Verified && user.CreatedAt.After(best.CreatedAt) {
+			best = user
+		}
+	}
+	if best == nil {
+		return api.User{}, api.ErrNotFound
+	}
+	return *best, nil
+}
+
+//IdentityTaken checks the stored usernames and verified emails
+func (store *MemoryStore) IdentityTaken(username string, email string) (bool, bool, error) {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+	var usernameTaken, emailTaken bool
+	for _, user := range store.users {
+		usernameTaken = usernameTaken || user.Username == username
+		emailTaken = emailTaken || user.Verified && strings.EqualFold(user.Email, email)
+	}
+	return usernameTaken, emailTaken, nil
+}
+
+//CreateUser stores a copy of user, refusing a taken userId or verification token hash
+func (store *MemoryStore) CreateUser(user api.User, verifyTokenHash string) error {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+	if _, ok := store.users[user.UserID]; ok {
+		return errDuplicate
+	}
+	if _, ok := store.verifyToken[verifyTokenHash]; ok {
+		return errDuplicate
+	}
+	store.users[user.UserID] = &user
+	store.verifyToken[verifyTokenHash] = user.UserID
+	return nil
+}
+
+//GetByID returns the account with userID
+func (store *MemoryStore) GetByID(userID string) (api.User, error) {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+	user, ok := store.users[userID]
+	if !ok {
+		return api.User{}, api.ErrNotFound
+	}
+	return *user, nil
+}
+
+//GetByEmail returns the account whose email is email, the verified one or else the newest
+func (store *MemoryStore) GetByEmail(email string) (api.User, error) {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+	return store.preferred(email)
+}
+
+//GetBySigninEmail is GetByEmail, MemoryStore has no secondary emails
+func (store *MemoryStore) GetBySigninEmail(email string) (api.User, error) {
+	return store.GetByEmail(email)
+}
+
+//GetByVerifyToken returns the account the verification token hash was stored with
+func (store *MemoryStore) GetByVerifyToken(tokenHash string) (api.User, error) {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+	user, ok := store.users[store.verifyToken[tokenHash]]
+	if !ok {
+		return api.User{}, api.ErrNotFound
+	}
+	return *user, nil
+}
+
+//MarkVerified verifies the account the token hash was stored with, reporting whether it wasn't already, unless
+//another account has verified its email
+func (store *MemoryStore) MarkVerified(tokenHash string) (bool, error) {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+	user, ok := store.users[store.verifyToken[tokenHash]]
+	if !ok || user.Verified {
+		return false, nil
+	}
+	_, err := store.find(func(other *api.User) bool {
+		return other.UserID != user.UserID && other.Verified && strings.EqualFold(other.Email, user.Email)
+	})
+	if err == nil {
+		return false, api.ErrEmailTaken
+	}
+	user.Verified = true
+	return true, nil
+}
+
+//UpgradePasswordHash replaces the hash of userID if it still is old
+func (store *MemoryStore) UpgradePasswordHash(userID string, old []byte, hashed []byte) error {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+	if user, ok := store.users[userID]; ok && string(user.HashedPassword) == string(old) {
+		user.HashedPassword = hashed
+	}
+	return nil
+}
+
+//SetResetToken stores a reset token hash, refusing a taken one
+func (store *MemoryStore) SetResetToken(tokenHash string, userID string, expiresAt time.Time) error {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+	if _, ok := store.resetTokens[tokenHash]; ok {
+		return errDuplicate
+	}
+	store.resetTokens[tokenHash] = memoryResetToken{userID: userID, expiresAt: expiresAt}
+	return nil
+}
+
+//ClearResetTokens removes the reset tokens of userID
+func (store *MemoryStore) ClearResetTokens(userID string) error {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+	store.clearResetTokens(userID)
+	return nil
+}
+
+//clearResetTokens removes the reset tokens of userID, store.mu must be held
+func (store *MemoryStore) clearResetTokens(userID string) {
+	for tokenHash, token := range store.resetTokens {
+		if token.userID == userID {
+			delete(store.resetTokens, tokenHash)
+		}
+	}
+}
+
+//GetResetToken returns a stored reset token with its account's email
+func (store *MemoryStore) GetResetToken(tokenHash string) (api.ResetToken, error) {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+	token, ok := store.resetTokens[tokenHash]
+	user, exists := store.users[token.userID]
+	if !ok || !exists {
+		return api.ResetToken{}, api.ErrNotFound
+	}
+	return api.ResetToken{UserID: token.userID, Email: user.Email, ExpiresAt: token.expiresAt}, nil
+}
+
+//ResetPassword sets the hash of userID and clears its reset tokens while the token is still valid for it
+func (store *MemoryStore) ResetPassword(tokenHash string, userID string, hashed []byte) error {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+	token, ok := store.resetTokens[tokenHash]
+	user, exists := store.users[userID]
+	if !ok || !exists || token.userID != userID || !store.Clock.Now().Before(token.expiresAt) {
+		return api.ErrNotFound
+	}
+	user.HashedPassword = hashed
+	store.clearResetTokens(userID)
+	return nil
+}
@@ -0,0 +1,320 @@
+//Package authclient lets other Mixtape services verify access tokens locally with the public keys
+//the auth service publishes at /.well-known/jwks.json, instead of calling it on every request.
+//Only RS256 tokens can be verified this way, so the auth service must sign with JWT_PRIVATE_KEY_FILE.
+package authclient
+
+import (
+	"context"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+)
+
+const (
+	//DefaultKeyTTL is how long fetched keys are used before the JWK set is fetched again
+	DefaultKeyTTL = 5 * time.Minute
+	//DefaultMinRefreshInterval limits how often a token with an unknown kid can trigger a fetch
+	DefaultMinRefreshInterval = 30 * time.Second
+	//DefaultLeeway tolerates clock skew between services when checking token times
+	DefaultLeeway = 30 * time.Second
+	//DefaultCookieName is the access token cookie the auth service sets without a COOKIE_PREFIX
+	DefaultCookieName = "access_token"
+)
+
+//Claims are the claims of an auth service access token
+type Claims struct {
+	UserID     string
+	AuthTime   int64                  `json:"auth_time,omitempty"`
+	AMR        []string               `json:"amr,omitempty"`
+	Custom     map[string]interface{} `json:"custom,omitempty"`
+	Unverified bool                   `json:"unverified,omitempty"`
+	Verified   bool                   `json:"verified"`
+	jwt.StandardClaims
+
+	//leeway is set by the client verifying the claims
+	leeway time.Duration
+}
+
+//Valid checks the token's time claims, allowing leeway of clock skew either way
+func (claims Claims) Valid() error {
+	now := time.Now()
+	if !claims.VerifyExpiresAt(now.Add(-claims.leeway).Unix(), false) {
+		return errors.New("token is expired")
+	}
+	if !claims.VerifyIssuedAt(now.Add(claims.leeway).Unix(), false) {
+		return errors.New("token used before issued")
+	}
+	if !claims.VerifyNotBefore(now.Add(claims.leeway).Unix(), false) {
+		return errors.New("token is not valid yet")
+	}
+	return nil
+}
+
+//Client verifies access tokens against a cached copy of the auth service's JWK set.
+//Keys are fetched again once KeyTTL passes, or sooner when a token names a kid that isn't cached,
+//which is how a rotated key is picked up. Those early fetches happen at most once per MinRefreshInterval.
+//CookieName must match the auth service's COOKIE_PREFIX followed by "access_token".
+type Client struct {
+	JWKSURL            string
+	KeyTTL             time.Duration
+	MinRefreshInterval time.Duration
+	Leeway             time.Duration
+	CookieName         string
+	HTTPClient         *http.Client
+
+	mu          sync.Mutex
+	keys        map[string]*rsa.PublicKey
+	fetchedAt   time.Time
+	lastAttempt time.Time
+	//now is replaced in tests
+	now func() time.Time
+}
+
+//New returns a Client for the JWK set at jwksURL, e.g. "http://auth-service/.well-known/jwks.json"
+func New(jwksURL string) *Client {
+	return &Client{
+		JWKSURL:            jwksURL,
+		KeyTTL:             DefaultKeyTTL,
+		MinRefreshInterval: DefaultMinRefreshInterval,
+		Leeway:             DefaultLeeway,
+		CookieName:         DefaultCookieName,
+		HTTPClient:         &http.Client{Timeout: 10 * time.Second},
+		now:                time.Now,
+	}
+}
+
+//jwk is a public key in the JWK set, only the fields RSA keys need
+type jwk struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+}
+
+//fetchKeys downloads the JWK set and returns its RSA keys by kid
+func (client *Client) fetchKeys() (map[string]*rsa.PublicKey, error) {
+	response, err := client.HTTPClient.Get(client.JWKSURL)
+	if err != nil {
+		return nil, err
+	}
+	defer response.Body.Close()
+	if response.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("fetching %s: status %d", client.JWKSURL, response.StatusCode)
+	}
+
+	var set struct {
+		Keys []jwk `json:"keys"`
+	}
+	err = json.NewDecoder(response.Body).Decode(&set)
+	if err != nil {
+		return nil, err
+	}
+
+	keys := map[string]*rsa.PublicKey{}
+	for _, key := range set.Keys {
+		if key.Kty != "RSA" || key.Kid == "" {
+			continue
+		}
+		n, err := base64.RawURLEncoding.DecodeString(key.N)
+		if err != nil {
+			return nil, errors.New("key " + key.Kid + " has a malformed modulus")
+		}
+		e, err := base64.RawURLEncoding.DecodeString(key.E)
+		if err != nil {
+			return nil, errors.New("key " + key.Kid + " has a malformed exponent")
+		}
+		keys[key.Kid] = &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}
+	}
+	return keys, nil
+}
+
+//refresh replaces the cached keys with a fresh copy. If the fetch fails the old keys stay in use.
+//The caller must hold client.mu.
+func (client *Client) refresh() error {
+	client.lastAttempt = client.now()
+	keys, err := client.fetchKeys()
+	if err != nil {
+		return err
+	}
+	client.keys = keys
+	client.fetchedAt = client.lastAttempt
+	return nil
+}
+
+//key returns the public key named kid, fetching the JWK set when the cache is stale or doesn't know kid
+func (client *Client) key(kid string) (*rsa.PublicKey, error) {
+	client.mu.Lock()
+	defer client.mu.Unlock()
+
+	var err error
+	if client.keys == nil || client.now().Sub(client.fetchedAt) >= client.KeyTTL {
+		err = client.refresh()
+	}
+	if key, ok := client.keys[kid]; ok {
+		return key, nil
+	}
+	if err == nil && client.now().Sub(client.lastAttempt) >= client.MinRefreshInterval {
+		err = client.refresh()
+		if key, ok := client.keys[kid]; ok {
+			return key, nil
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+	return nil, errors.New("unknown signing key " + kid)
+}
+
+//Verify checks tokenString is an access token signed by the auth service and returns its claims
+func (client *Client) Verify(tokenString string) (Claims, error) {
+	claims := Claims{leeway: client.Leeway}
+	token, err := jwt.ParseWithClaims(tokenString, &claims, func(token *jwt.Token) (interface{}, error) {
+		if token.Method != jwt.SigningMethodRS256 {
+			return nil, errors.New("unexpected signing method " + token.Method.Alg())
+		}
+		kid, _ := token.Header["kid"].(string)
+		if kid == "" {
+			return nil, errors.New("token names no signing key")
+		}
+		return client.key(kid)
+	})
+	if err != nil {
+		return Claims{}, err
+	}
+	if !token.Valid {
+		return Claims{}, errors.New("the given token is not valid")
+	}
+	if claims.Subject != "access" {
+		return Claims{}, errors.New("not an access token")
+	}
+	return claims, nil
+}
+
+type contextKey struct{}
+
+//maxAuthorizationLength is the longest Authorization header accepted, as in the auth service
+const maxAuthorizationLength = 4096
+
+//bearerToken returns the token of an Authorization header reading exactly "Bearer <token>",
+//or an error saying what is wrong with the header, with the same messages as the auth service
+func bearerToken(values []string) (string, error) {
+	if len(values) > 1 {
+		return "", errors.New("send a single Authorization header")
+	}
+	header := values[0]
+	if len(header) > maxAuthorizationLength {
+		return "", errors.New("the Authorization header is too long")
+	}
+	scheme, token := header, ""
+	if i := strings.IndexByte(header, ' '); i >= 0 {
+		scheme, token = header[:i], header[i+1:]
+	}
+	if !strings.EqualFold(scheme, "Bearer") {
+		return "", errors.New("the Authorization header must read \"Bearer <token>\"")
+	}
+	if token == "" {
+		return "", errors.New("the Authorization header has no token")
+	}
+	if strings.TrimSpace(token) != token {
+		return "", errors.New("the Authorization header has extra whitespace around the token")
+	}
+	if strings.ContainsAny(token, " \t,") {
+		return "", errors.New("the Authorization header must hold a single token")
+	}
+	if !isBearerToken(token) {
+		return "", errors.New("the Authorization header token contains invalid characters")
+	}
+	return token, nil
+}
+
+//isBearerToken reports whether token only uses the characters RFC 6750 allows in bearer tokens
+func isBearerToken(token string) bool {
+	trimmed := strings.TrimRight(token, "=")
+	if trimmed == "" {
+		return false
+	}
+	for _, c := range trimmed {
+		if !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || strings.ContainsRune("-._~+/", c)) {
+			return false
+		}
+	}
+	return true
+}
+
+//tokenFromRequest returns the bearer token from the Authorization header,
+//falling back to the CookieName cookie, or "" if the request carries neither.
+//A malformed Authorization header is an error rather than a reason to fall back to the cookie.
+func (client *Client) tokenFromRequest(r *http.Request) (string, error) {
+	if values := r.Header.Values("Authorization"); len(values) > 1 || len(values) == 1 && values[0] != "" {
+		return bearerToken(values)
+	}
+	cookie, err := r.Cookie(client.CookieName)
+	if err != nil {
+		return "", nil
+	}
+	return cookie.Value, nil
+}
+
+//writeError answers with the same JSON error body the auth service uses
+func writeError(w http.ResponseWriter, statusCode int, message string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(statusCode)
+	json.NewEncoder(w).Encode(map[string]string{"status": "error", "message": message})
+}
+
+//RequireAuth rejects requests without a valid access token and stores its claims in the request context
+func (client *Client) RequireAuth(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodOptions {
+			next.ServeHTTP(w, r)
+			return
+		}
+		tokenString, err := client.tokenFromRequest(r)
+		if err != nil {
+			writeError(w, http.StatusUnauthorized, err.Error())
+			return
+		}
+		if tokenString == "" {
+			writeError(w, http.StatusUnauthorized, "missing access token")
+			return
+		}
+		claims, err := client.Verify(tokenString)
+		if err != nil {
+			writeError(w, http.StatusUnauthorized, "invalid access token")
+			return
+		}
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), contextKey{}, claims)))
+	})
+}
+
+//RequireVerified rejects tokens of accounts whose email wasn't verified when the token was issued with a 403,
+//like the auth service's own RequireVerified. It must run after RequireAuth.
+func (client *Client) RequireVerified(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodOptions {
+			next.ServeHTTP(w, r)
+			return
+		}
+		claims, _ := ClaimsFromContext(r.Context())
+		if !claims.Verified {
+			writeError(w, http.StatusForbidden, "verify your email address to continue")
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+//ClaimsFromContext returns the access token claims stored by RequireAuth
+func ClaimsFromContext(ctx context.Context) (Claims, bool) {
+	claims, ok := ctx.Value(contextKey{}).(Claims)
+	return claims, ok
+}
@@ -0,0 +1,212 @@
+package authclient
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+)
+
+//jwksServer serves a JWK set that tests can swap keys in and out of, counting fetches
+type jwksServer struct {
+	mu      sync.Mutex
+	keys    map[string]*rsa.PrivateKey
+	fetches int
+}
+
+func (s *jwksServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.fetches++
+	var set struct {
+		Keys []jwk `json:"keys"`
+	}
+	for kid, key := range s.keys {
+		set.Keys = append(set.Keys, jwk{
+			Kty: "RSA",
+			Kid: kid,
+			N:   base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+			E:   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+		})
+	}
+	json.NewEncoder(w).Encode(set)
+}
+
+//setKeys replaces the keys the server publishes
+func (s *jwksServer) setKeys(keys map[string]*rsa.PrivateKey) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.keys = keys
+}
+
+func (s *jwksServer) fetchCount() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.fetches
+}
+
+//newKey generates an RSA signing key
+func newKey(t *testing.T) *rsa.PrivateKey {
+	t.Helper()
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return key
+}
+
+//sign returns an access token for userID signed with key under kid
+func sign(t *testing.T, key *rsa.PrivateKey, kid string, userID string) string {
+	t.Helper()
+	token := jwt.NewWithClaims(jwt.SigningMethodRS256, Claims{
+		UserID:   userID,
+		Verified: true,
+		StandardClaims: jwt.StandardClaims{
+			Subject:   "access",
+			IssuedAt:  time.Now().Unix(),
+			ExpiresAt: time.Now().Add(time.Hour).Unix(),
+		},
+	})
+	token.Header["kid"] = kid
+	signed, err := token.SignedString(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return signed
+}
+
+//newTestClient returns a client of a JWK set server holding key as "first", and a clock the test can move
+func newTestClient(t *testing.T, key *rsa.PrivateKey) (*Client, *jwksServer, *time.Time) {
+	jwks := &jwksServer{keys: map[string]*rsa.PrivateKey{"first": key}}
+	server := httptest.NewServer(jwks)
+	t.Cleanup(server.Close)
+	client := New(server.URL)
+	now := time.Now()
+	client.now = func() time.Time { return now }
+	return client, jwks, &now
+}
+
+func TestVerifyUsesCachedKeys(t *testing.T) {
+	key := newKey(t)
+	client, jwks, _ := newTestClient(t, key)
+
+	for i := 0; i < 3; i++ {
+		claims, err := client.Verify(sign(t, key, "first", "bear"))
+		if err != nil || claims.UserID != "bear" {
+			t.Fatalf("verifying a token: got %+v, %v", claims, err)
+		}
+	}
+	if fetches := jwks.fetchCount(); fetches != 1 {
+		t.Fatalf("three tokens with a cached key: fetched the JWK set %d times, want once", fetches)
+	}
+
+	_, err := client.Verify(sign(t, newKey(t), "first", "bear"))
+	if err == nil {
+		t.Fatalf("token signed by another key under a known kid: got no error")
+	}
+}
+
+func TestUnknownKidRefreshesKeys(t *testing.T) {
+	key := newKey(t)
+	client, jwks, now := newTestClient(t, key)
+	if _, err := client.Verify(sign(t, key, "first", "bear")); err != nil {
+		t.Fatal(err)
+	}
+
+	rotated := newKey(t)
+	jwks.setKeys(map[string]*rsa.PrivateKey{"first": key, "second": rotated})
+	//the first fetch was just now, so an unknown kid has to wait out MinRefreshInterval
+	if _, err := client.Verify(sign(t, rotated, "second", "bear")); err == nil {
+		t.Fatalf("unknown kid within MinRefreshInterval: got no error")
+	}
+	if fetches := jwks.fetchCount(); fetches != 1 {
+		t.Fatalf("unknown kid within MinRefreshInterval: fetched the JWK set %d times, want once", fetches)
+	}
+
+	*now = now.Add(DefaultMinRefreshInterval)
+	claims, err := client.Verify(sign(t, rotated, "second", "bear"))
+	if err != nil || claims.UserID != "bear" {
+		t.Fatalf("token signed with a rotated key: got %+v, %v", claims, err)
+	}
+	if fetches := jwks.fetchCount(); fetches != 2 {
+		t.Fatalf("unknown kid: fetched the JWK set %d times, want twice", fetches)
+	}
+
+	*now = now.Add(DefaultMinRefreshInterval)
+	if _, err := client.Verify(sign(t, key, "gone", "bear")); err == nil {
+		t.Fatalf("kid the JWK set doesn't have: got no error")
+	}
+}
+
+func TestExpiredKeysRefreshed(t *testing.T) {
+	key := newKey(t)
+	client, jwks, now := newTestClient(t, key)
+	if _, err := client.Verify(sign(t, key, "first", "bear")); err != nil {
+		t.Fatal(err)
+	}
+
+	*now = now.Add(DefaultKeyTTL - time.Second)
+	if _, err := client.Verify(sign(t, key, "first", "bear")); err != nil {
+		t.Fatal(err)
+	}
+	if fetches := jwks.fetchCount(); fetches != 1 {
+		t.Fatalf("keys within KeyTTL: fetched the JWK set %d times, want once", fetches)
+	}
+
+	//the retired key stops working once the cached copy expires
+	jwks.setKeys(map[string]*rsa.PrivateKey{"second": newKey(t)})
+	*now = now.Add(time.Second)
+	if _, err := client.Verify(sign(t, key, "first", "bear")); err == nil {
+		t.Fatalf("key removed from the JWK set past KeyTTL: got no error")
+	}
+	if fetches := jwks.fetchCount(); fetches != 2 {
+		t.Fatalf("keys past KeyTTL: fetched the JWK set %d times, want twice", fetches)
+	}
+}
+
+func TestRequireAuth(t *testing.T) {
+	key := newKey(t)
+	client, _, _ := newTestClient(t, key)
+	handler := client.RequireAuth(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		claims, _ := ClaimsFromContext(r.Context())
+		w.Write([]byte(claims.UserID))
+	}))
+
+	token := sign(t, key, "first", "bear")
+	for _, check := range []struct {
+		step          string
+		authorization string
+		cookie        string
+		want          int
+	}{
+		{"bearer token", "Bearer " + token, "", http.StatusOK},
+		{"cookie", "", token, http.StatusOK},
+		{"no token", "", "", http.StatusUnauthorized},
+		{"invalid token", "Bearer not.a.token", "", http.StatusUnauthorized},
+		{"malformed header with a valid cookie", "Token " + token, token, http.StatusUnauthorized},
+	} {
+		req := httptest.NewRequest(http.MethodGet, "/songs", nil)
+		if check.authorization != "" {
+			req.Header.Set("Authorization", check.authorization)
+		}
+		if check.cookie != "" {
+			req.AddCookie(&http.Cookie{Name: DefaultCookieName, Value: check.cookie})
+		}
+		res := httptest.NewRecorder()
+		handler.ServeHTTP(res, req)
+		if res.Code != check.want {
+			t.Fatalf("%s: got %d %s, want %d", check.step, res.Code, res.Body.String(), check.want)
+		}
+		if check.want == http.StatusOK && res.Body.String() != "bear" {
+			t.Fatalf("%s: handler got claims for %q, want bear", check.step, res.Body.String())
+		}
+	}
+}
@@ -16,8 +16,16 @@ func main() {
 		log.Fatal(err.Error())
 	}
 
-	//Initialize the sendgrid client
+	//Load the service configuration
+	err = api.InitConfig()
+	if err != nil {
+		log.Fatal(err.Error())
+	}
+	api.CalibrateHashing()
+
+	//Initialize the sendgrid client, and Twilio if SMS delivery is configured
 	api.InitMailer()
+	api.InitSMS()
 
 	//Initialize our database connection
 	DB := api.InitDB()
@@ -29,35 +37,24 @@ func main() {
 		log.Println("pinging database")
 		panic(err.Error())
 	}
+
+	//Purge expired tokens, sessions and deleted accounts now and periodically from here on
+	api.StartCleanup()
+
+	//Create the initial admin account if one is configured
+	err = api.SeedAdmin()
+	if err != nil {
+		log.Fatal("Error seeding admin account: " + err.Error())
+	}
+
 	// Create a new mux for routing api calls
 	router := mux.NewRouter()
-	router.Use(CORS)
-	
+
 	err = api.RegisterRoutes(router)
 	if err != nil {
-		log.Fatal("Error registering API endpoints")
+		log.Fatal("Error registering API endpoints: " + err.Error())
 	}
 
 	log.Println("starting go server")
-	http.ListenAndServe(":80", router)
+	http.ListenAndServe(":80", api.Middleware(router))
 }
-
-func CORS(next http.Handler) http.Handler {
-	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-
-		// Set headers
-		w.Header().Set("Access-Control-Allow-Headers", "Content-Type")
-		w.Header().Set("Access-Control-Allow-Origin", "http://18.209.20.242:3000")
-		w.Header().Set("Access-Control-Allow-Methods", "GET, POST, DELETE, OPTIONS")
-		w.Header().Set("Access-Control-Allow-Credentials", "true")
-
-		if r.Method == "OPTIONS" {
-			w.WriteHeader(http.StatusOK)
-			return
-		}
-
-		// Next
-		next.ServeHTTP(w, r)
-		return
-	})
-}
\ No newline at end of file
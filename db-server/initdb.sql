@@ -6,12 +6,101 @@ USE auth;
 
 CREATE TABLE users (
     username VARCHAR(20),
+    displayName VARCHAR(255),
+    locale VARCHAR(35),
     email VARCHAR(320),
+    phone VARCHAR(16),
     hashedPassword TEXT,
     verified boolean,
-    resetToken TEXT,
-    verifiedToken TEXT,
-    userId VARCHAR(128) PRIMARY KEY
+    totpSecret VARCHAR(64),
+    totpLastStep BIGINT NOT NULL DEFAULT 0,
+    twoFactorEnabledAt DATETIME,
+    verifiedToken CHAR(64) UNIQUE,
+    userId VARCHAR(128) PRIMARY KEY,
+    role VARCHAR(20) NOT NULL DEFAULT 'user',
+    tokenVersion INT NOT NULL DEFAULT 0,
+    deletedAt DATETIME,
+    lastUsernameChangeAt DATETIME,
+    lastSigninAt DATETIME,
+    passwordChangedAt DATETIME,
+    createdAt DATETIME
+);
+
+CREATE INDEX users_username ON users (username);
+
+CREATE INDEX users_email ON users (email);
+
+CREATE TABLE emails (
+    email VARCHAR(320),
+    userId VARCHAR(128),
+    verified boolean NOT NULL DEFAULT FALSE,
+    verifiedToken CHAR(64) UNIQUE,
+    createdAt DATETIME,
+    PRIMARY KEY (email, userId)
+);
+
+CREATE TABLE verified_emails (
+    email VARCHAR(320) PRIMARY KEY,
+    userId VARCHAR(128)
+);
+
+CREATE TABLE reset_tokens (
+    tokenHash CHAR(64) PRIMARY KEY,
+    userId VARCHAR(128),
+    expiresAt DATETIME
+);
+
+CREATE TABLE invites (
+    code VARCHAR(32) PRIMARY KEY,
+    email VARCHAR(320),
+    role VARCHAR(20),
+    createdBy VARCHAR(128),
+    createdAt DATETIME,
+    expiresAt DATETIME,
+    usedBy VARCHAR(128),
+    usedAt DATETIME,
+    revokedAt DATETIME
+);
+
+CREATE TABLE sessions (
+    jti VARCHAR(36) PRIMARY KEY,
+    userId VARCHAR(128),
+    createdAt DATETIME,
+    expiresAt DATETIME,
+    revokedAt DATETIME,
+    ipAddress VARCHAR(45),
+    device VARCHAR(64)
+);
+
+CREATE TABLE login_attempts (
+    email VARCHAR(320) PRIMARY KEY,
+    failures INT NOT NULL DEFAULT 0,
+    lockedUntil DATETIME,
+    updatedAt DATETIME
+);
+
+CREATE TABLE email_deliveries (
+    email VARCHAR(320) PRIMARY KEY,
+    status VARCHAR(16) NOT NULL,
+    reason VARCHAR(255),
+    eventAt DATETIME,
+    updatedAt DATETIME
+);
+
+CREATE TABLE audit_log (
+    id INT AUTO_INCREMENT PRIMARY KEY,
+    actorId VARCHAR(128),
+    action VARCHAR(64),
+    targetId VARCHAR(128),
+    createdAt DATETIME
+);
+
+CREATE TABLE backup_codes (
+    userId VARCHAR(128),
+    codeHash CHAR(64),
+    createdAt DATETIME,
+    usedAt DATETIME,
+    PRIMARY KEY (userId, codeHash)
 );
 
 CREATE DATABASE postsDB;
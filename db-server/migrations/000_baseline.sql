@@ -0,0 +1,43 @@
+-- Bring a database created from the original initdb.sql up to the schema 001_hash_tokens.sql expects.
+-- The reset token expiry, two-factor authentication, roles, soft deletion, sessions, the audit log and invites were
+-- added before migrations were kept, so run this first on such databases; ones created from a newer initdb.sql skip it.
+
+USE auth;
+
+ALTER TABLE users ADD COLUMN resetTokenExpiry DATETIME AFTER resetToken, ADD COLUMN role VARCHAR(20) NOT NULL DEFAULT 'user' AFTER userId, ADD COLUMN deletedAt DATETIME AFTER role, ADD COLUMN totpSecret VARCHAR(64), ADD COLUMN totpLastStep BIGINT NOT NULL DEFAULT 0, ADD COLUMN twoFactorEnabledAt DATETIME;
+
+CREATE TABLE IF NOT EXISTS backup_codes (
+    userId VARCHAR(128),
+    codeHash CHAR(64),
+    createdAt DATETIME,
+    usedAt DATETIME,
+    PRIMARY KEY (userId, codeHash)
+);
+
+CREATE TABLE IF NOT EXISTS sessions (
+    jti VARCHAR(36) PRIMARY KEY,
+    userId VARCHAR(128),
+    createdAt DATETIME,
+    expiresAt DATETIME,
+    revokedAt DATETIME
+);
+
+CREATE TABLE IF NOT EXISTS audit_log (
+    id INT AUTO_INCREMENT PRIMARY KEY,
+    actorId VARCHAR(128),
+    action VARCHAR(64),
+    targetId VARCHAR(128),
+    createdAt DATETIME
+);
+
+-- 008_invite_role.sql adds the role column
+CREATE TABLE IF NOT EXISTS invites (
+    code VARCHAR(32) PRIMARY KEY,
+    email VARCHAR(320),
+    createdBy VARCHAR(128),
+    createdAt DATETIME,
+    expiresAt DATETIME,
+    usedBy VARCHAR(128),
+    usedAt DATETIME,
+    revokedAt DATETIME
+);
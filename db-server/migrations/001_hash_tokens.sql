@@ -0,0 +1,19 @@
+-- Store SHA-256 hashes of verification and reset tokens instead of the tokens themselves.
+-- MySQL's SHA2 returns lowercase hex, matching hashToken in auth-service.
+
+USE auth;
+
+CREATE TABLE reset_tokens (
+    tokenHash CHAR(64) PRIMARY KEY,
+    userId VARCHAR(128),
+    expiresAt DATETIME
+);
+
+INSERT INTO reset_tokens (tokenHash, userId, expiresAt)
+    SELECT SHA2(resetToken, 256), userId, resetTokenExpiry FROM users
+    WHERE resetToken IS NOT NULL AND resetToken <> '' AND resetTokenExpiry IS NOT NULL;
+
+UPDATE users SET verifiedToken = SHA2(verifiedToken, 256)
+    WHERE verifiedToken IS NOT NULL AND verifiedToken <> '';
+
+ALTER TABLE users DROP COLUMN resetToken, DROP COLUMN resetTokenExpiry;
@@ -0,0 +1,6 @@
+-- Enforce unique verification tokens so a collision is retried instead of verifying two accounts at once.
+-- Run after 001_hash_tokens.sql, every stored verifiedToken is a 64 character SHA-256 hash by then.
+
+USE auth;
+
+ALTER TABLE users MODIFY verifiedToken CHAR(64), ADD UNIQUE INDEX verifiedToken (verifiedToken);
@@ -0,0 +1,8 @@
+-- Record when each account was created so unverified accounts can be given a grace period.
+-- Existing accounts are treated as created now, so their grace period starts with this migration.
+
+USE auth;
+
+ALTER TABLE users ADD COLUMN createdAt DATETIME;
+
+UPDATE users SET createdAt = NOW() WHERE createdAt IS NULL;
@@ -0,0 +1,12 @@
+-- Let accounts hold secondary email addresses next to the primary one in users.email.
+-- A secondary address can be used to sign in once it is verified.
+
+USE auth;
+
+CREATE TABLE emails (
+    email VARCHAR(320) PRIMARY KEY,
+    userId VARCHAR(128),
+    verified boolean NOT NULL DEFAULT FALSE,
+    verifiedToken CHAR(64) UNIQUE,
+    createdAt DATETIME
+);
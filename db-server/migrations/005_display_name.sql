@@ -0,0 +1,6 @@
+-- Give accounts an optional display name, separate from the unique username.
+-- The column is sized for DISPLAY_NAME_MAX_LENGTH up to its 255 character limit.
+
+USE auth;
+
+ALTER TABLE users ADD COLUMN displayName VARCHAR(255) AFTER username;
@@ -0,0 +1,10 @@
+-- Count failed signins per email so repeated guessing locks the email out for a while.
+
+USE auth;
+
+CREATE TABLE login_attempts (
+    email VARCHAR(320) PRIMARY KEY,
+    failures INT NOT NULL DEFAULT 0,
+    lockedUntil DATETIME,
+    updatedAt DATETIME
+);
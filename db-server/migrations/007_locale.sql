@@ -0,0 +1,6 @@
+-- Let users pick the locale the frontend shows them, as a BCP 47 tag like "en-US".
+-- 35 characters fits any tag language.Parse accepts in practice.
+
+USE auth;
+
+ALTER TABLE users ADD COLUMN locale VARCHAR(35) AFTER displayName;
@@ -0,0 +1,6 @@
+-- Let an invite grant the account created with it a role other than the default, e.g. moderator.
+-- Invites without a role keep giving DEFAULT_ROLE.
+
+USE auth;
+
+ALTER TABLE invites ADD COLUMN role VARCHAR(20) AFTER email;
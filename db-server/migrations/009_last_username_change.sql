@@ -0,0 +1,6 @@
+-- Remember when an account last changed its username or primary email, so changes can be rate limited
+-- by IDENTITY_CHANGE_COOLDOWN. Existing accounts start with NULL and may change right away.
+
+USE auth;
+
+ALTER TABLE users ADD COLUMN lastUsernameChangeAt DATETIME AFTER deletedAt;
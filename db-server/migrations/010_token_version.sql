@@ -0,0 +1,6 @@
+-- Let users log out of every device at once. Tokens carry the account's tokenVersion and stop being accepted
+-- once POST /api/auth/logout-all bumps it. Tokens issued before this migration carry 0 and stay valid.
+
+USE auth;
+
+ALTER TABLE users ADD COLUMN tokenVersion INT NOT NULL DEFAULT 0 AFTER role;
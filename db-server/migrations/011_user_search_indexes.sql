@@ -0,0 +1,7 @@
+-- Index usernames and primary emails so the admin user search can find a prefix (LIKE 'prefix%')
+-- without scanning the whole users table.
+
+USE auth;
+
+CREATE INDEX users_username ON users (username);
+CREATE INDEX users_email ON users (email);
@@ -0,0 +1,6 @@
+-- Remember when each account last signed in and last changed its password, for GET /api/auth/security.
+-- Existing accounts start with NULL; the password change time then falls back to when the account was created.
+
+USE auth;
+
+ALTER TABLE users ADD COLUMN lastSigninAt DATETIME AFTER lastUsernameChangeAt, ADD COLUMN passwordChangedAt DATETIME AFTER lastSigninAt;
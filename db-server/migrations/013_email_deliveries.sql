@@ -0,0 +1,11 @@
+-- Keep the latest delivery status SendGrid reported for each email address, from its signed event webhook.
+
+USE auth;
+
+CREATE TABLE email_deliveries (
+    email VARCHAR(320) PRIMARY KEY,
+    status VARCHAR(16) NOT NULL,
+    reason VARCHAR(255),
+    eventAt DATETIME,
+    updatedAt DATETIME
+);
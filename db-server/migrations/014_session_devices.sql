@@ -0,0 +1,6 @@
+-- Remember the client IP and a coarse device name of each session, so a signin from a new device can be emailed about.
+-- Existing sessions start with NULL; accounts with no recorded device aren't alerted until they have one.
+
+USE auth;
+
+ALTER TABLE sessions ADD COLUMN ipAddress VARCHAR(45) AFTER revokedAt, ADD COLUMN device VARCHAR(64) AFTER ipAddress;
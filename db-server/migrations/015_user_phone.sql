@@ -0,0 +1,6 @@
+-- Give accounts an optional phone number in E.164 format, so verification and reset links can go out by SMS.
+-- Existing accounts start with NULL and keep getting them by email.
+
+USE auth;
+
+ALTER TABLE users ADD COLUMN phone VARCHAR(16) AFTER email;
@@ -0,0 +1,16 @@
+-- Record every verified address, primary or secondary, in verified_emails, whose primary key lets only one account
+-- verify an address. Unverified secondary addresses no longer reserve the address, so several accounts can have it
+-- pending. Where an earlier race left an address verified on two accounts, the primary address wins.
+
+USE auth;
+
+ALTER TABLE emails DROP PRIMARY KEY, ADD PRIMARY KEY (email, userId);
+
+CREATE TABLE verified_emails (
+    email VARCHAR(320) PRIMARY KEY,
+    userId VARCHAR(128)
+);
+
+INSERT IGNORE INTO verified_emails (email, userId) SELECT email, userId FROM users WHERE verified = TRUE;
+
+INSERT IGNORE INTO verified_emails (email, userId) SELECT email, userId FROM emails WHERE verified = TRUE;